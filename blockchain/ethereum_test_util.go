@@ -83,6 +83,52 @@ func GetSimulatedEthereumEnvironment() (env SimulatedEthereumEnvironment) {
 	return
 }
 
+// GetSimulatedEthereumEnvironmentWithStubToken returns the environment which
+// uses the chain id of the simulated backend, so the transactions can be
+// signed by bind.NewKeyedTransactorWithChainID and mined. FetchToken bytecode
+// shipped with snet-ecosystem-contracts is a runtime code which can't be
+// deployed, so the token is replaced by the stub contract returning true for
+// any call: transfers and approvals always succeed and MultiPartyEscrow keeps
+// the balances.
+func GetSimulatedEthereumEnvironmentWithStubToken() (env SimulatedEthereumEnvironment) {
+	var chainID = big.NewInt(simulatedChainID)
+	env.SingnetPrivateKey, env.SingnetWallet, _ = getTestWallet(chainID)
+	env.ClientPrivateKey, env.ClientWallet, _ = getTestWallet(chainID)
+	env.ServerPrivateKey, env.ServerWallet, _ = getTestWallet(chainID)
+
+	alloc := map[common.Address]types.Account{
+		env.SingnetWallet.From: {Balance: big.NewInt(1000000000000000000)},
+		env.ClientWallet.From:  {Balance: big.NewInt(1000000000000000000)},
+		env.ServerWallet.From:  {Balance: big.NewInt(1000000000000000000)},
+		stubTokenAddress:       {Code: stubTokenCode},
+	}
+
+	env.Backend = simulated.NewBackend(alloc)
+	token, err := NewFetchToken(stubTokenAddress, env.Backend.Client())
+	if err != nil {
+		panic(fmt.Sprintf("Unable to bind FetchToken contract, error: %v", err))
+	}
+	env.FetToken = token
+
+	mpeAddress, _, mpe, err := DeployMultiPartyEscrow(EstimateGas(env.SingnetWallet), env.Backend.Client(), stubTokenAddress)
+	if err != nil {
+		panic(fmt.Sprintf("Unable to deploy MultiPartyEscrow contract, error: %v", err))
+	}
+	env.Backend.Commit()
+	env.MultiPartyEscrow = mpe
+	env.MultiPartyEscrowAddress = mpeAddress
+	return
+}
+
+// simulatedChainID is the chain id used by the simulated backend
+const simulatedChainID = 1337
+
+var (
+	stubTokenAddress = common.HexToAddress("0x00000000000000000000000000000000000000fe")
+	// PUSH1 1 PUSH1 0 MSTORE PUSH1 32 PUSH1 0 RETURN
+	stubTokenCode = common.FromHex("0x600160005260206000f3")
+)
+
 func getTestWallet(chainID *big.Int) (privateKey *ecdsa.PrivateKey, wallet *bind.TransactOpts, err error) {
 	privateKey, err = crypto.GenerateKey()
 	if err != nil {
@@ -22,6 +22,9 @@ import (
 	"github.com/singnet/snet-daemon/v6/config"
 	"github.com/singnet/snet-daemon/v6/ipfsutils"
 	"go.uber.org/zap"
+	// registers google/api/annotations.proto resolved by findGoogleAPIFile
+	_ "google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/reflect/protoregistry"
 )
 
 /*
@@ -169,8 +172,11 @@ type ServiceMetadata struct {
 	DynamicPriceMethodMapping map[string]string `json:"dynamic_pricing"`
 	TrainingMethods           []string          `json:"training_methods"`
 	TrainingMetadata          map[string]any    `json:"training_metadata"`
-	ProtoDescriptors          linker.Files      `json:"-"`
-	ProtoFiles                map[string]string `json:"-"`
+	// ChargeOnError maps gRPC status codes returned by the service to the
+	// charge of the failed call, see handler.NewChargePolicy
+	ChargeOnError    map[string]any    `json:"charge_on_error,omitempty"`
+	ProtoDescriptors linker.Files      `json:"-"`
+	ProtoFiles       map[string]string `json:"-"`
 }
 
 type Tiers struct {
@@ -487,7 +493,10 @@ func (metaData *ServiceMetadata) IsModelTraining(methodFullName string) (useMode
 // getProtoDescriptors converts text of proto files to bufbuild linker
 func getProtoDescriptors(protoFiles map[string]string) (linker.Files, error) {
 	accessor := protocompile.SourceAccessorFromMap(protoFiles)
-	r := protocompile.WithStandardImports(&protocompile.SourceResolver{Accessor: accessor})
+	r := protocompile.WithStandardImports(protocompile.CompositeResolver{
+		&protocompile.SourceResolver{Accessor: accessor},
+		protocompile.ResolverFunc(findGoogleAPIFile),
+	})
 	compiler := protocompile.Compiler{
 		Resolver:       r,
 		SourceInfoMode: protocompile.SourceInfoStandard,
@@ -500,6 +509,20 @@ func getProtoDescriptors(protoFiles map[string]string) (linker.Files, error) {
 	return fds, nil
 }
 
+// findGoogleAPIFile resolves google/api imports of the service proto, i.e.
+// google/api/annotations.proto with the google.api.http option, if the
+// service doesn't publish them with its proto files
+func findGoogleAPIFile(path string) (protocompile.SearchResult, error) {
+	if !strings.HasPrefix(path, "google/api/") {
+		return protocompile.SearchResult{}, protoregistry.NotFound
+	}
+	file, err := protoregistry.GlobalFiles.FindFileByPath(path)
+	if err != nil {
+		return protocompile.SearchResult{}, err
+	}
+	return protocompile.SearchResult{Desc: file}, nil
+}
+
 func (metaData *ServiceMetadata) setServiceProto() (err error) {
 	metaData.DynamicPriceMethodMapping = make(map[string]string, 0)
 	metaData.TrainingMethods = make([]string, 0)
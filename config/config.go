@@ -34,6 +34,8 @@ const (
 	DaemonTypeKey             = "daemon_type" // http/grpc
 	DaemonEndpoint            = "daemon_endpoint"
 	ExecutablePathKey         = "executable_path"
+	ExecutableWorkersKey      = "executable_workers"
+	ExecutableCallTimeoutKey  = "executable_call_timeout"
 	EnableDynamicPricing      = "enable_dynamic_pricing"
 	IpfsEndpoint              = "ipfs_endpoint"
 	LighthouseEndpoint        = "lighthouse_endpoint"
@@ -58,20 +60,51 @@ const (
 	PaymentChannelStorageTypeKey   = "payment_channel_storage_type"
 	PaymentChannelStorageClientKey = "payment_channel_storage_client"
 	PaymentChannelStorageServerKey = "payment_channel_storage_server"
+	PaymentChannelStorageBoltKey   = "payment_channel_storage_bolt"
+	PaymentChannelStorageSQLKey    = "payment_channel_storage_sql"
+	StorageValueEncodingKey        = "storage_value_encoding"
+	LockTTLKey                     = "lock_ttl"
+	PaymentChannelCacheTTLKey      = "payment_channel_cache_ttl"
+	ChannelStateWatchIntervalKey   = "channel_state_watch_interval"
+	PaymentChannelLockPolicyKey    = "payment_channel_lock_policy"
+	PaymentChannelLockQueueKey     = "payment_channel_lock_queue_size"
+	PaymentChannelLockWaitKey      = "payment_channel_lock_wait_timeout"
+	StreamPaymentMessagesKey       = "stream_payment_messages"
+	StreamPaymentIntervalKey       = "stream_payment_interval"
+	StreamPaymentMethodsKey        = "stream_payment_methods"
+	ClaimEnabledKey                = "claim_enabled"
+	ClaimIntervalKey               = "claim_interval"
+	ClaimAmountThresholdKey        = "claim_amount_threshold"
+	ClaimExpirationBlocksKey       = "claim_expiration_blocks"
+	ClaimBatchSizeKey              = "claim_batch_size"
+	ClaimDryRunKey                 = "claim_dry_run"
+	ChannelExpiryIntervalKey       = "channel_expiry_check_interval"
+	ChannelExpiryAlertBlocksKey    = "channel_expiry_alert_blocks"
+	IncomeLedgerEnabledKey         = "income_ledger_enabled"
+	IncomeLedgerRetentionKey       = "income_ledger_retention"
+	ChargeOnErrorKey               = "charge_on_error"
+	HTTPStatusMappingKey           = "http_status_mapping"
+	HTTPErrorBodyEnabledKey        = "http_error_body_enabled"
+	ServiceHTTPClientKey           = "service_http_client"
 	BlockchainProviderApiKey       = "blockchain_provider_api_key"
 	FreeCallsPerAddress            = "free_calls_per_address"
 	TrustedFreeCallSigners         = "trusted_free_call_signers"
 	MinBalanceForFreeCall          = "min_balance_for_free_call"
+	FreeCallQuotasKey              = "free_call_quotas"
+	FreeCallSignerQuotasKey        = "free_call_signer_quotas"
 	// Monitoring and Notification
 	AlertsEMail                 = "alerts_email"
 	HeartbeatServiceEndpoint    = "heartbeat_endpoint"
 	MeteringEndpoint            = "metering_endpoint"
 	PvtKeyForMetering           = "private_key_for_metering"
 	PvtKeyForFreeCalls          = "private_key_for_free_calls"
+	PvtKeyForClaims             = "private_key_for_claims"
 	NotificationServiceEndpoint = "notification_endpoint"
 	ServiceHeartbeatType        = "service_heartbeat_type"
 	TokenExpiryInMinutes        = "token_expiry_in_minutes"
 	TokenSecretKey              = "token_secret_key"
+	TokenSigningAlgorithmKey    = "token_signing_algorithm"
+	TokenKeyRotationIntervalKey = "token_key_rotation_interval"
 	Experimental                = "experimental"
 	//This defaultConfigJson will eventually be replaced by DefaultDaemonConfigurationSchema
 	defaultConfigJson string = `
@@ -101,6 +134,16 @@ const (
 	"min_balance_for_free_call" : "10",
 	"trusted_free_call_signers": ["0x3Bb9b2499c283cec176e7C707Ecb495B7a961ebf", "0x7DF35C98f41F3Af0df1dc4c7F7D4C19a71Dd059F"],
 	"free_calls_per_address":{},
+	"channel_expiry_check_interval": "10m",
+	"channel_state_watch_interval": "10s",
+	"income_ledger_retention": "8760h",
+	"service_http_client": {
+		"timeout": "0s",
+		"dial_timeout": "30s",
+		"max_idle_conns": 100,
+		"max_idle_conns_per_host": 16,
+		"idle_conn_timeout": "90s"
+	},
 	"log":  {
 		"level": "info",
 		"timezone": "UTC",
@@ -141,6 +184,8 @@ const (
 	"service_heartbeat_type": "",
 	"heartbeat_endpoint": "",
     "token_expiry_in_minutes": 1440,
+    "token_signing_algorithm": "HS256",
+    "token_key_rotation_interval": "24h",
     "model_training_enabled": false
 }`
 	MinimumConfigJson string = `{
@@ -274,6 +319,9 @@ func Validate() error {
 	if err = allowedUserConfigurationChecks(); err != nil {
 		return err
 	}
+	if err = utils.SetValueEncoding(vip.GetString(StorageValueEncodingKey)); err != nil {
+		return err
+	}
 
 	if GetString(PvtKeyForFreeCalls) != "" {
 		if utils.ParsePrivateKey(GetString(PvtKeyForFreeCalls)) == nil {
@@ -281,6 +329,13 @@ func Validate() error {
 		}
 	}
 
+	if GetBool(ClaimEnabledKey) && utils.ParsePrivateKey(GetString(PvtKeyForClaims)) == nil {
+		return errors.New("valid " + PvtKeyForClaims + " is required when " + ClaimEnabledKey + " is true")
+	}
+	if err = streamPaymentChecks(); err != nil {
+		return err
+	}
+
 	return validateMeteringChecks()
 }
 
@@ -321,6 +376,18 @@ func allowedUserConfigurationChecks() error {
 	return nil
 }
 
+// streamPaymentChecks checks the streams aren't charged with the pipeline
+// lock policy, the pipelined payment is stored before the stream starts and
+// can't be increased
+func streamPaymentChecks() error {
+	streamPayments := GetInt(StreamPaymentMessagesKey) > 0 || GetDuration(StreamPaymentIntervalKey) > 0
+	if streamPayments && GetString(PaymentChannelLockPolicyKey) == "pipeline" {
+		return fmt.Errorf("%v and %v can't be used with %v 'pipeline'", StreamPaymentMessagesKey, StreamPaymentIntervalKey,
+			PaymentChannelLockPolicyKey)
+	}
+	return nil
+}
+
 func validateMeteringChecks() (err error) {
 	if GetBool(MeteringEnabled) && !IsValidUrl(GetString(MeteringEndpoint)) {
 		return errors.New("to Support Metering you need to have a valid Metering End point")
@@ -438,6 +505,8 @@ var DisplayKeys = map[string]bool{
 	strings.ToUpper(DaemonTypeKey):                  true,
 	strings.ToUpper(DaemonEndpoint):                 true,
 	strings.ToUpper(ExecutablePathKey):              true,
+	strings.ToUpper(ExecutableWorkersKey):           true,
+	strings.ToUpper(ExecutableCallTimeoutKey):       true,
 	strings.ToUpper(IpfsEndpoint):                   true,
 	strings.ToUpper(LighthouseEndpoint):             true,
 	strings.ToUpper(IpfsTimeout):                    false,
@@ -456,6 +525,36 @@ var DisplayKeys = map[string]bool{
 	strings.ToUpper(PaymentChannelStorageTypeKey):   true,
 	strings.ToUpper(PaymentChannelStorageClientKey): true,
 	strings.ToUpper(PaymentChannelStorageServerKey): true,
+	strings.ToUpper(PaymentChannelStorageBoltKey):   true,
+	strings.ToUpper(PaymentChannelStorageSQLKey):    false,
+	strings.ToUpper(StorageValueEncodingKey):        true,
+	strings.ToUpper(LockTTLKey):                     true,
+	strings.ToUpper(PaymentChannelCacheTTLKey):      true,
+	strings.ToUpper(ChannelStateWatchIntervalKey):   true,
+	strings.ToUpper(PaymentChannelLockPolicyKey):    true,
+	strings.ToUpper(PaymentChannelLockQueueKey):     true,
+	strings.ToUpper(PaymentChannelLockWaitKey):      true,
+	strings.ToUpper(StreamPaymentMessagesKey):       true,
+	strings.ToUpper(StreamPaymentIntervalKey):       true,
+	strings.ToUpper(StreamPaymentMethodsKey):        true,
+	strings.ToUpper(ClaimEnabledKey):                true,
+	strings.ToUpper(ClaimIntervalKey):               true,
+	strings.ToUpper(ClaimAmountThresholdKey):        true,
+	strings.ToUpper(ClaimExpirationBlocksKey):       true,
+	strings.ToUpper(ClaimBatchSizeKey):              true,
+	strings.ToUpper(ClaimDryRunKey):                 true,
+	strings.ToUpper(ChannelExpiryIntervalKey):       true,
+	strings.ToUpper(ChannelExpiryAlertBlocksKey):    true,
+	strings.ToUpper(IncomeLedgerEnabledKey):         true,
+	strings.ToUpper(IncomeLedgerRetentionKey):       true,
+	strings.ToUpper(ChargeOnErrorKey):               true,
+	strings.ToUpper(HTTPStatusMappingKey):           true,
+	strings.ToUpper(HTTPErrorBodyEnabledKey):        true,
+	strings.ToUpper(ServiceHTTPClientKey):           true,
+	strings.ToUpper(TokenSigningAlgorithmKey):       true,
+	strings.ToUpper(TokenKeyRotationIntervalKey):    true,
+	strings.ToUpper(FreeCallQuotasKey):              true,
+	strings.ToUpper(FreeCallSignerQuotasKey):        true,
 	strings.ToUpper(AlertsEMail):                    true,
 	strings.ToUpper(HeartbeatServiceEndpoint):       true,
 	strings.ToUpper(MeteringEnabled):                true,
@@ -193,3 +193,19 @@ func Test_validateMeteringChecks(t *testing.T) {
 		})
 	}
 }
+
+func Test_streamPaymentChecks(t *testing.T) {
+	defer func() {
+		vip.Set(StreamPaymentMessagesKey, 0)
+		vip.Set(PaymentChannelLockPolicyKey, "reject")
+	}()
+	vip.Set(PaymentChannelLockPolicyKey, "pipeline")
+	assert.NoError(t, streamPaymentChecks())
+
+	vip.Set(StreamPaymentMessagesKey, 10)
+	assert.EqualError(t, streamPaymentChecks(),
+		"stream_payment_messages and stream_payment_interval can't be used with payment_channel_lock_policy 'pipeline'")
+
+	vip.Set(PaymentChannelLockPolicyKey, "wait")
+	assert.NoError(t, streamPaymentChecks())
+}
@@ -0,0 +1,243 @@
+package escrow
+
+import (
+	"context"
+	"expvar"
+	"sync"
+	"time"
+
+	"github.com/singnet/snet-daemon/v6/metrics"
+	"github.com/singnet/snet-daemon/v6/storage"
+	"go.uber.org/zap"
+)
+
+// channelCacheStats is published as the payment_channel_cache variable and
+// is served by the daemon /metrics endpoint
+var channelCacheStats = metrics.NewVarsMap("payment_channel_cache")
+
+const (
+	statStorageHits           = "storage_hits"
+	statStorageMisses         = "storage_misses"
+	statBlockchainHits        = "blockchain_hits"
+	statBlockchainMisses      = "blockchain_misses"
+	statStorageInvalidated    = "storage_invalidations"
+	statBlockchainInvalidated = "blockchain_invalidations"
+)
+
+// ChannelCache keeps recently used payment channels in memory to avoid
+// reading the storage and the blockchain on each paid call. Storage entries
+// are invalidated when the storage reports a different value of the channel,
+// blockchain entries are invalidated when the channel nonce in the storage
+// differs from the cached one. All entries expire after ttl. The cache
+// doesn't make the payments less safe: channel state is written using
+// compare-and-swap against the value which was read.
+type ChannelCache struct {
+	ttl   time.Duration
+	stats *expvar.Map
+
+	mutex      sync.Mutex
+	storage    map[string]*cachedChannel
+	blockchain map[string]*cachedChannel
+	// changes counts storage change events per channel and generation counts
+	// restarts of the watch, a value read from the storage is not cached if
+	// the channel was changed or the watch was restarted during the read
+	changes    map[string]uint64
+	generation uint64
+	// watching is false while the storage is not watched, storage entries
+	// are not cached in this case
+	watching bool
+}
+
+type storageVersion struct {
+	generation uint64
+	changes    uint64
+}
+
+type cachedChannel struct {
+	// value is the serialized channel, entries are decoded on each hit, so
+	// callers can't modify the cached state
+	value   string
+	nonce   string
+	expires time.Time
+}
+
+// NewChannelCache returns a new cache which keeps entries for ttl
+func NewChannelCache(ttl time.Duration) *ChannelCache {
+	return newChannelCache(ttl, channelCacheStats)
+}
+
+func newChannelCache(ttl time.Duration, stats *expvar.Map) *ChannelCache {
+	return &ChannelCache{
+		ttl:        ttl,
+		stats:      stats,
+		storage:    make(map[string]*cachedChannel),
+		blockchain: make(map[string]*cachedChannel),
+		changes:    make(map[string]uint64),
+	}
+}
+
+func (cache *ChannelCache) get(entries map[string]*cachedChannel, key string, hits, misses string) (channel *PaymentChannelData, ok bool) {
+	cache.mutex.Lock()
+	entry, ok := entries[key]
+	if ok && time.Now().After(entry.expires) {
+		delete(entries, key)
+		ok = false
+	}
+	cache.mutex.Unlock()
+
+	if !ok {
+		cache.stats.Add(misses, 1)
+		return nil, false
+	}
+	channel = &PaymentChannelData{}
+	if err := deserialize(entry.value, channel); err != nil {
+		zap.L().Error("unable to decode cached channel", zap.String("key", key), zap.Error(err))
+		return nil, false
+	}
+	cache.stats.Add(hits, 1)
+	return channel, true
+}
+
+func (cache *ChannelCache) newEntry(channel *PaymentChannelData) (entry *cachedChannel, err error) {
+	value, err := serialize(channel)
+	if err != nil {
+		return nil, err
+	}
+	return &cachedChannel{value: value, nonce: channel.Nonce.String(), expires: time.Now().Add(cache.ttl)}, nil
+}
+
+func (cache *ChannelCache) getStorage(key string) (channel *PaymentChannelData, ok bool) {
+	return cache.get(cache.storage, key, statStorageHits, statStorageMisses)
+}
+
+// storageVersion returns the version of the channel in the cache, it should
+// be read before the channel is read from the storage
+func (cache *ChannelCache) storageVersion(key string) storageVersion {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	return storageVersion{generation: cache.generation, changes: cache.changes[key]}
+}
+
+// putStorage caches the channel read from the storage unless the channel was
+// changed since version was taken
+func (cache *ChannelCache) putStorage(key string, channel *PaymentChannelData, version storageVersion) {
+	entry, err := cache.newEntry(channel)
+	if err != nil {
+		return
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	current := storageVersion{generation: cache.generation, changes: cache.changes[key]}
+	if cache.watching && current == version {
+		cache.storage[key] = entry
+	}
+}
+
+// setStorage caches the channel just written into the storage
+func (cache *ChannelCache) setStorage(key string, channel *PaymentChannelData) {
+	entry, err := cache.newEntry(channel)
+	if err != nil {
+		return
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if cache.watching {
+		cache.storage[key] = entry
+	}
+}
+
+func (cache *ChannelCache) invalidateStorage(key string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.changes[key]++
+	if _, ok := cache.storage[key]; ok {
+		delete(cache.storage, key)
+		cache.stats.Add(statStorageInvalidated, 1)
+	}
+}
+
+// storageChanged handles the change of the channel in the storage
+func (cache *ChannelCache) storageChanged(key string, channel *PaymentChannelData) {
+	var value, nonce string
+	if channel != nil {
+		entry, err := cache.newEntry(channel)
+		if err != nil {
+			cache.invalidateStorage(key)
+			cache.invalidateBlockchain(key)
+			return
+		}
+		value, nonce = entry.value, entry.nonce
+	}
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.changes[key]++
+	if entry, ok := cache.storage[key]; ok && (channel == nil || entry.value != value) {
+		delete(cache.storage, key)
+		cache.stats.Add(statStorageInvalidated, 1)
+	}
+	if entry, ok := cache.blockchain[key]; ok && (channel == nil || entry.nonce != nonce) {
+		delete(cache.blockchain, key)
+		cache.stats.Add(statBlockchainInvalidated, 1)
+	}
+}
+
+// setWatching clears storage entries when the watch is started or stopped,
+// because changes could have been missed
+func (cache *ChannelCache) setWatching(watching bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.watching = watching
+	cache.generation++
+	cache.stats.Add(statStorageInvalidated, int64(len(cache.storage)))
+	cache.storage = make(map[string]*cachedChannel)
+}
+
+func (cache *ChannelCache) getBlockchain(key string) (channel *PaymentChannelData, ok bool) {
+	return cache.get(cache.blockchain, key, statBlockchainHits, statBlockchainMisses)
+}
+
+func (cache *ChannelCache) putBlockchain(key string, channel *PaymentChannelData) {
+	entry, err := cache.newEntry(channel)
+	if err != nil {
+		return
+	}
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	cache.blockchain[key] = entry
+}
+
+// invalidateBlockchain removes the blockchain entry, ok is true if the
+// channel was cached
+func (cache *ChannelCache) invalidateBlockchain(key string) (ok bool) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+	if _, ok = cache.blockchain[key]; ok {
+		delete(cache.blockchain, key)
+		cache.stats.Add(statBlockchainInvalidated, 1)
+	}
+	return
+}
+
+// watch invalidates entries changed in the storage until ctx is done. When
+// the watch is closed by the storage the storage is watched again.
+func (cache *ChannelCache) watch(ctx context.Context, typedStorage storage.TypedAtomicStorage) {
+	for ctx.Err() == nil {
+		events, err := typedStorage.Watch(ctx)
+		if err != nil {
+			zap.L().Error("unable to watch payment channel storage, cache is disabled until watch is restored", zap.Error(err))
+			select {
+			case <-ctx.Done():
+			case <-time.After(cache.ttl):
+			}
+			continue
+		}
+		cache.setWatching(true)
+		for event := range events {
+			key, _ := event.Key.(string)
+			channel, _ := event.Value.(*PaymentChannelData)
+			cache.storageChanged(key, channel)
+		}
+		cache.setWatching(false)
+	}
+}
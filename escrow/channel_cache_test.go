@@ -0,0 +1,301 @@
+package escrow
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"expvar"
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/singnet/snet-daemon/v6/blockchain"
+	"github.com/singnet/snet-daemon/v6/config"
+	"github.com/singnet/snet-daemon/v6/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+// channelServiceTestSuite is the base of the suites which test the payment
+// channel service using the cached channel storage, otherReplica writes to
+// the same storage bypassing the cache
+type channelServiceTestSuite struct {
+	suite.Suite
+
+	memoryStorage    *storage.MemoryStorage
+	cache            *ChannelCache
+	stats            *expvar.Map
+	storage          *PaymentChannelStorage
+	otherReplica     *PaymentChannelStorage
+	signerPrivateKey *ecdsa.PrivateKey
+	signerAddress    common.Address
+	recipientAddress common.Address
+	blockchainReads  atomic.Int32
+	fullAmount       atomic.Int64
+	cancel           context.CancelFunc
+}
+
+func (suite *channelServiceTestSuite) SetupTest() {
+	config.Vip().Set(config.AllowedUserFlag, false)
+	var ctx context.Context
+	ctx, suite.cancel = context.WithCancel(context.Background())
+
+	suite.memoryStorage = storage.NewMemStorage()
+	suite.stats = new(expvar.Map)
+	suite.signerPrivateKey = GenerateTestPrivateKey()
+	suite.signerAddress = crypto.PubkeyToAddress(suite.signerPrivateKey.PublicKey)
+	suite.recipientAddress = crypto.PubkeyToAddress(GenerateTestPrivateKey().PublicKey)
+	suite.blockchainReads.Store(0)
+	suite.fullAmount.Store(100)
+	suite.cache = newChannelCache(time.Minute, suite.stats)
+	suite.storage = NewCachedPaymentChannelStorage(ctx, suite.memoryStorage, suite.cache)
+	suite.otherReplica = NewPaymentChannelStorage(suite.memoryStorage)
+
+	require.Eventually(suite.T(), func() bool {
+		suite.cache.mutex.Lock()
+		defer suite.cache.mutex.Unlock()
+		return suite.cache.watching
+	}, 5*time.Second, time.Millisecond)
+}
+
+func (suite *channelServiceTestSuite) TearDownTest() {
+	suite.cancel()
+}
+
+func (suite *channelServiceTestSuite) key() *PaymentChannelKey {
+	return &PaymentChannelKey{ID: big.NewInt(42)}
+}
+
+func (suite *channelServiceTestSuite) channel(authorizedAmount int64) *PaymentChannelData {
+	return &PaymentChannelData{
+		ChannelID:        big.NewInt(42),
+		Nonce:            big.NewInt(3),
+		Sender:           suite.signerAddress,
+		Recipient:        suite.recipientAddress,
+		GroupID:          [32]byte{123},
+		FullAmount:       big.NewInt(suite.fullAmount.Load()),
+		Expiration:       big.NewInt(100),
+		Signer:           suite.signerAddress,
+		AuthorizedAmount: big.NewInt(authorizedAmount),
+	}
+}
+
+func (suite *channelServiceTestSuite) payment(amount int64) *Payment {
+	payment := &Payment{
+		Amount:       big.NewInt(amount),
+		ChannelID:    big.NewInt(42),
+		ChannelNonce: big.NewInt(3),
+	}
+	SignTestPayment(payment, suite.signerPrivateKey)
+	return payment
+}
+
+func (suite *channelServiceTestSuite) reader() *BlockchainChannelReader {
+	return &BlockchainChannelReader{
+		readChannelFromBlockchain: func(channelID *big.Int) (*blockchain.MultiPartyEscrowChannel, bool, error) {
+			suite.blockchainReads.Add(1)
+			return &blockchain.MultiPartyEscrowChannel{
+				Sender:     suite.signerAddress,
+				Recipient:  suite.recipientAddress,
+				GroupId:    [32]byte{123},
+				Value:      big.NewInt(suite.fullAmount.Load()),
+				Nonce:      big.NewInt(3),
+				Expiration: big.NewInt(100),
+				Signer:     suite.signerAddress,
+			}, true, nil
+		},
+		recipientPaymentAddress: func() common.Address { return suite.recipientAddress },
+		cache:                   suite.cache,
+	}
+}
+
+func (suite *channelServiceTestSuite) validator() *ChannelPaymentValidator {
+	return &ChannelPaymentValidator{
+		currentBlock:               func() (*big.Int, error) { return big.NewInt(99), nil },
+		paymentExpirationThreshold: func() *big.Int { return big.NewInt(0) },
+	}
+}
+
+func (suite *channelServiceTestSuite) service() *lockingPaymentChannelService {
+	return suite.serviceWithLockConf(DefaultChannelLockConf)
+}
+
+func (suite *channelServiceTestSuite) serviceWithLockConf(lockConf ChannelLockConf) *lockingPaymentChannelService {
+	return NewPaymentChannelServiceWithLockConf(suite.storage, NewPaymentStorage(suite.memoryStorage), suite.reader(),
+		NewEtcdLocker(suite.memoryStorage), suite.validator(), func() [32]byte { return [32]byte{123} },
+		lockConf,
+	).(*lockingPaymentChannelService)
+}
+
+func (suite *channelServiceTestSuite) stat(name string) int64 {
+	if value, ok := suite.stats.Get(name).(*expvar.Int); ok {
+		return value.Value()
+	}
+	return 0
+}
+
+// storedChannel returns the channel read from the storage bypassing the cache
+func (suite *channelServiceTestSuite) storedChannel() *PaymentChannelData {
+	channel, ok, err := suite.otherReplica.Get(suite.key())
+	require.NoError(suite.T(), err)
+	require.True(suite.T(), ok)
+	return channel
+}
+
+type ChannelCacheSuite struct {
+	channelServiceTestSuite
+}
+
+func TestChannelCacheSuite(t *testing.T) {
+	suite.Run(t, new(ChannelCacheSuite))
+}
+
+func (suite *ChannelCacheSuite) TestStorageHitAndMiss() {
+	require.NoError(suite.T(), suite.otherReplica.Put(suite.key(), suite.channel(10)))
+
+	channel, ok, err := suite.storage.Get(suite.key())
+	require.NoError(suite.T(), err)
+	require.True(suite.T(), ok)
+	assert.Equal(suite.T(), suite.channel(10), channel)
+	channel.AuthorizedAmount.SetInt64(1000)
+
+	channel, ok, err = suite.storage.Get(suite.key())
+	require.NoError(suite.T(), err)
+	require.True(suite.T(), ok)
+	assert.Equal(suite.T(), suite.channel(10), channel, "cached channel can't be modified by the caller")
+	assert.Equal(suite.T(), int64(1), suite.stat(statStorageMisses))
+	assert.Equal(suite.T(), int64(1), suite.stat(statStorageHits))
+
+	_, ok, err = suite.storage.Get(&PaymentChannelKey{ID: big.NewInt(43)})
+	require.NoError(suite.T(), err)
+	assert.False(suite.T(), ok)
+}
+
+func (suite *ChannelCacheSuite) TestInvalidatedByOtherReplica() {
+	require.NoError(suite.T(), suite.storage.Put(suite.key(), suite.channel(10)))
+	_, _, _ = suite.storage.Get(suite.key())
+	assert.Equal(suite.T(), int64(1), suite.stat(statStorageHits), "written channel is cached")
+
+	require.NoError(suite.T(), suite.otherReplica.Put(suite.key(), suite.channel(20)))
+	assert.Eventually(suite.T(), func() bool {
+		channel, _, _ := suite.storage.Get(suite.key())
+		return channel.AuthorizedAmount.Int64() == 20
+	}, 5*time.Second, time.Millisecond)
+	assert.Equal(suite.T(), int64(1), suite.stat(statStorageInvalidated))
+}
+
+func (suite *ChannelCacheSuite) TestCompareAndSwapFailureInvalidates() {
+	require.NoError(suite.T(), suite.storage.Put(suite.key(), suite.channel(10)))
+
+	ok, err := suite.storage.CompareAndSwap(suite.key(), suite.channel(5), suite.channel(30))
+	require.NoError(suite.T(), err)
+	assert.False(suite.T(), ok)
+	suite.cache.mutex.Lock()
+	assert.NotContains(suite.T(), suite.cache.storage, suite.key().String())
+	suite.cache.mutex.Unlock()
+}
+
+func (suite *ChannelCacheSuite) TestBlockchainInvalidatedByNonceChange() {
+	reader := suite.reader()
+
+	for range 2 {
+		_, ok, err := reader.GetChannelStateFromBlockchain(suite.key())
+		require.NoError(suite.T(), err)
+		require.True(suite.T(), ok)
+	}
+	assert.Equal(suite.T(), int32(1), suite.blockchainReads.Load())
+	assert.Equal(suite.T(), int64(1), suite.stat(statBlockchainHits))
+
+	require.NoError(suite.T(), suite.otherReplica.Put(suite.key(), suite.channel(10)))
+	time.Sleep(50 * time.Millisecond)
+	_, _, _ = reader.GetChannelStateFromBlockchain(suite.key())
+	assert.Equal(suite.T(), int32(1), suite.blockchainReads.Load(), "same nonce keeps the blockchain state")
+
+	claimed := suite.channel(0)
+	claimed.Nonce = big.NewInt(4)
+	require.NoError(suite.T(), suite.otherReplica.Put(suite.key(), claimed))
+	assert.Eventually(suite.T(), func() bool {
+		_, _, _ = reader.GetChannelStateFromBlockchain(suite.key())
+		return suite.blockchainReads.Load() == 2
+	}, 5*time.Second, time.Millisecond)
+}
+
+func (suite *ChannelCacheSuite) TestPaymentValidatedAgainstFreshBlockchainState() {
+	service := suite.service()
+
+	transaction, err := service.StartPaymentTransaction(context.Background(), suite.payment(50))
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), transaction.Commit())
+
+	// client adds funds to the channel, cached blockchain state is outdated
+	suite.fullAmount.Store(200)
+	transaction, err = service.StartPaymentTransaction(context.Background(), suite.payment(150))
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), transaction.Commit())
+
+	channel := suite.storedChannel()
+	assert.Equal(suite.T(), int64(150), channel.AuthorizedAmount.Int64())
+	assert.Equal(suite.T(), int64(200), channel.FullAmount.Int64())
+}
+
+func (suite *ChannelCacheSuite) TestPaymentValidatedAgainstStorageState() {
+	// the storage is not watched, so the change made by another replica is
+	// not received by the cache
+	suite.storage = NewPaymentChannelStorage(suite.memoryStorage)
+	suite.storage.cache = newChannelCache(time.Minute, suite.stats)
+	suite.storage.cache.setWatching(true)
+	service := suite.service()
+
+	require.NoError(suite.T(), suite.storage.Put(suite.key(), suite.channel(10)))
+	require.NoError(suite.T(), suite.otherReplica.Put(suite.key(), suite.channel(60)))
+	channel, _, err := suite.storage.Get(suite.key())
+	require.NoError(suite.T(), err)
+	require.Equal(suite.T(), int64(10), channel.AuthorizedAmount.Int64(), "cached state is outdated")
+
+	transaction, err := service.StartPaymentTransaction(context.Background(), suite.payment(70))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(60), transaction.Channel().AuthorizedAmount.Int64(), "state is read from the storage")
+	require.NoError(suite.T(), transaction.Commit())
+	assert.Equal(suite.T(), int64(70), suite.storedChannel().AuthorizedAmount.Int64())
+
+	channel, _, err = suite.storage.Get(suite.key())
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(70), channel.AuthorizedAmount.Int64(), "cache is refreshed")
+}
+
+func (suite *ChannelCacheSuite) TestCommitRetriesChangedState() {
+	service := suite.service()
+	require.NoError(suite.T(), suite.storage.Put(suite.key(), suite.channel(10)))
+
+	transaction, err := service.StartPaymentTransaction(context.Background(), suite.payment(70))
+	require.NoError(suite.T(), err)
+	// the channel is changed by the writer which doesn't hold the lock
+	require.NoError(suite.T(), suite.otherReplica.Put(suite.key(), suite.channel(60)))
+	require.NoError(suite.T(), transaction.Commit())
+	assert.Equal(suite.T(), int64(70), suite.storedChannel().AuthorizedAmount.Int64(), "served payment is stored")
+
+	transaction, err = service.StartPaymentTransaction(context.Background(), suite.payment(80))
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), suite.otherReplica.Put(suite.key(), suite.channel(90)))
+	require.NoError(suite.T(), transaction.Commit())
+	assert.Equal(suite.T(), int64(90), suite.storedChannel().AuthorizedAmount.Int64(), "greater payment is not overwritten")
+}
+
+func (suite *ChannelCacheSuite) TestCommitDoesNotOverwriteClaimedChannel() {
+	service := suite.service()
+	require.NoError(suite.T(), suite.storage.Put(suite.key(), suite.channel(10)))
+
+	transaction, err := service.StartPaymentTransaction(context.Background(), suite.payment(70))
+	require.NoError(suite.T(), err)
+	// the channel is claimed by the writer which doesn't hold the lock
+	claimed := suite.channel(0)
+	claimed.Nonce = big.NewInt(4)
+	require.NoError(suite.T(), suite.otherReplica.Put(suite.key(), claimed))
+	err = transaction.Commit()
+	require.Error(suite.T(), err)
+	assert.Equal(suite.T(), FailedPrecondition, err.(*PaymentError).Code)
+	assert.Equal(suite.T(), int64(4), suite.storedChannel().Nonce.Int64(), "claimed channel is not overwritten")
+}
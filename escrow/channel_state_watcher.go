@@ -0,0 +1,128 @@
+package escrow
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/singnet/snet-daemon/v6/storage"
+	"go.uber.org/zap"
+)
+
+// channelStateWatcher watches the payment channel storage once for all
+// WatchChannelState streams and passes the changes of each channel to the
+// streams subscribed to it. The storage is watched while there are
+// subscribers.
+type channelStateWatcher struct {
+	storage *PaymentChannelStorage
+	// retryInterval is the interval the storage is watched again with after
+	// the watch is closed by the storage
+	retryInterval time.Duration
+
+	mutex sync.Mutex
+	// subscribers are the channels of the streams by the serialized channel
+	// key
+	subscribers map[string]map[chan *PaymentChannelData]struct{}
+	count       int
+	cancel      context.CancelFunc
+}
+
+func newChannelStateWatcher(storage *PaymentChannelStorage, retryInterval time.Duration) *channelStateWatcher {
+	return &channelStateWatcher{
+		storage:       storage,
+		retryInterval: retryInterval,
+		subscribers:   make(map[string]map[chan *PaymentChannelData]struct{}),
+	}
+}
+
+// subscribe returns the states of the channel written after the call, nil
+// state means the channel is deleted. Only the latest state is kept if the
+// subscriber doesn't read the states in time. unsubscribe should be called
+// when the states are not needed anymore.
+func (watcher *channelStateWatcher) subscribe(key *PaymentChannelKey) (states <-chan *PaymentChannelData, unsubscribe func()) {
+	watchedKey, _ := serializeKey(key)
+	subscriber := make(chan *PaymentChannelData, 1)
+
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+	if watcher.subscribers[watchedKey] == nil {
+		watcher.subscribers[watchedKey] = make(map[chan *PaymentChannelData]struct{})
+	}
+	watcher.subscribers[watchedKey][subscriber] = struct{}{}
+	watcher.count++
+	if watcher.count == 1 {
+		var ctx context.Context
+		ctx, watcher.cancel = context.WithCancel(context.Background())
+		// the storage is watched before the call returns, so the states
+		// written after the call are not missed
+		go watcher.watch(ctx, watcher.watchStorage(ctx))
+	}
+
+	return subscriber, func() {
+		watcher.mutex.Lock()
+		defer watcher.mutex.Unlock()
+		if _, ok := watcher.subscribers[watchedKey][subscriber]; !ok {
+			return
+		}
+		delete(watcher.subscribers[watchedKey], subscriber)
+		if len(watcher.subscribers[watchedKey]) == 0 {
+			delete(watcher.subscribers, watchedKey)
+		}
+		watcher.count--
+		if watcher.count == 0 {
+			watcher.cancel()
+		}
+	}
+}
+
+// watch passes the changes of the storage to the subscribers until ctx is
+// done. When the watch is closed by the storage the storage is watched again,
+// the changes missed are seen by the streams on the next poll.
+func (watcher *channelStateWatcher) watch(ctx context.Context, events <-chan storage.TypedKeyValueData) {
+	for {
+		for events != nil {
+			event, ok := <-events
+			if !ok {
+				break
+			}
+			key, _ := event.Key.(string)
+			channel, _ := event.Value.(*PaymentChannelData)
+			watcher.publish(key, channel)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(watcher.retryInterval):
+		}
+		events = watcher.watchStorage(ctx)
+	}
+}
+
+// watchStorage returns the changes of the storage, nil is returned if the
+// storage can't be watched
+func (watcher *channelStateWatcher) watchStorage(ctx context.Context) <-chan storage.TypedKeyValueData {
+	events, err := watcher.storage.Watch(ctx)
+	if err != nil {
+		zap.L().Warn("Unable to watch payment channel storage, channel state is polled", zap.Error(err))
+		return nil
+	}
+	return events
+}
+
+func (watcher *channelStateWatcher) publish(key string, channel *PaymentChannelData) {
+	watcher.mutex.Lock()
+	defer watcher.mutex.Unlock()
+	for subscriber := range watcher.subscribers[key] {
+		select {
+		case subscriber <- channel:
+		default:
+			// the previous state is not read yet, it is replaced by the
+			// latest one
+			select {
+			case <-subscriber:
+			default:
+			}
+			subscriber <- channel
+		}
+	}
+}
@@ -0,0 +1,342 @@
+package escrow
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	"go.uber.org/zap"
+
+	"github.com/singnet/snet-daemon/v6/blockchain"
+	"github.com/singnet/snet-daemon/v6/storage"
+	"github.com/singnet/snet-daemon/v6/utils"
+)
+
+// claimSchedulerLockName is a name of the lock which is held by the replica
+// running the claim cycle
+const claimSchedulerLockName = "cycle"
+
+// ClaimSchedulerConf contains settings of claiming payments by the daemon
+// Interval         - how often channels are checked
+// AmountThreshold  - channel is claimed when the unclaimed amount exceeds the
+// threshold, nil or zero disables the check
+// ExpirationBlocks - channel is claimed when it expires within the number of
+// blocks, zero disables the check
+// BatchSize        - maximum number of channels claimed by one transaction
+// DryRun           - channels to claim are logged only, neither storage nor
+// blockchain is changed
+type ClaimSchedulerConf struct {
+	Interval         time.Duration
+	AmountThreshold  *big.Int
+	ExpirationBlocks uint64
+	BatchSize        int
+	DryRun           bool
+}
+
+// DefaultClaimSchedulerConf checks channels each hour
+var DefaultClaimSchedulerConf = ClaimSchedulerConf{
+	Interval:  time.Hour,
+	BatchSize: 20,
+}
+
+// ClaimScheduler claims payments using the MultiPartyEscrow contract. It
+// starts claims the same way ProviderControlService does and sends the claims
+// it started to the blockchain using multiChannelClaim transactions signed by
+// the payment address of the organization. Claims started by `snetd claim`
+// are left to their initiator. The claim cycle is run under the lock, so only
+// one replica claims at a time. Each decision is logged, so the log can be
+// used to audit the claims.
+type ClaimScheduler struct {
+	control      *ProviderControlService
+	conf         ClaimSchedulerConf
+	currentBlock func() (*big.Int, error)
+	mpe          *blockchain.MultiPartyEscrow
+	backend      bind.DeployBackend
+	transactor   *bind.TransactOpts
+	locker       Locker
+	claims       storage.AtomicStorage
+}
+
+// NewClaimScheduler returns new scheduler which signs claim transactions by
+// privateKey, the key should belong to the payment address of the
+// organization. atomicStorage keeps the lock of the claim cycle and the
+// claims started by the scheduler, it should be shared by the replicas.
+func NewClaimScheduler(control *ProviderControlService, processor blockchain.Processor, atomicStorage storage.AtomicStorage,
+	privateKey *ecdsa.PrivateKey, conf ClaimSchedulerConf) (scheduler *ClaimScheduler, err error) {
+	chainID, err := processor.GetEthHttpClient().ChainID(context.Background())
+	if err != nil {
+		return nil, fmt.Errorf("unable to get chain id: %v", err)
+	}
+	transactor, err := bind.NewKeyedTransactorWithChainID(privateKey, chainID)
+	if err != nil {
+		return nil, err
+	}
+	if paymentAddress := control.organizationMetaData.GetPaymentAddress(); transactor.From != paymentAddress {
+		return nil, fmt.Errorf("claims should be signed by the payment address %v, key of %v is given",
+			paymentAddress.Hex(), transactor.From.Hex())
+	}
+	return newClaimScheduler(control, atomicStorage, processor.MultiPartyEscrow(), processor.GetEthHttpClient(),
+		processor.CurrentBlock, transactor, conf), nil
+}
+
+func newClaimScheduler(control *ProviderControlService, atomicStorage storage.AtomicStorage, mpe *blockchain.MultiPartyEscrow,
+	backend bind.DeployBackend, currentBlock func() (*big.Int, error), transactor *bind.TransactOpts,
+	conf ClaimSchedulerConf) *ClaimScheduler {
+	if conf.Interval <= 0 {
+		conf.Interval = DefaultClaimSchedulerConf.Interval
+	}
+	if conf.BatchSize <= 0 {
+		conf.BatchSize = DefaultClaimSchedulerConf.BatchSize
+	}
+	return &ClaimScheduler{
+		control:      control,
+		conf:         conf,
+		currentBlock: currentBlock,
+		mpe:          mpe,
+		backend:      backend,
+		transactor:   transactor,
+		locker:       NewEtcdLocker(storage.NewPrefixedAtomicStorage(atomicStorage, "/claim-scheduler/lock")),
+		claims:       storage.NewPrefixedAtomicStorage(atomicStorage, "/claim-scheduler/claims"),
+	}
+}
+
+// Run claims payments each conf.Interval until ctx is done
+func (scheduler *ClaimScheduler) Run(ctx context.Context) {
+	zap.L().Info("Claim scheduler started", zap.Duration("interval", scheduler.conf.Interval),
+		zap.Stringer("amountThreshold", scheduler.conf.AmountThreshold),
+		zap.Uint64("expirationBlocks", scheduler.conf.ExpirationBlocks), zap.Bool("dryRun", scheduler.conf.DryRun))
+	ticker := time.NewTicker(scheduler.conf.Interval)
+	defer ticker.Stop()
+	for {
+		if err := scheduler.Claim(ctx); err != nil {
+			zap.L().Error("Claim scheduler is unable to claim payments", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			zap.L().Info("Claim scheduler stopped")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Claim starts claims on the channels which match the thresholds and sends
+// the claims started by the scheduler to the blockchain. The cycle is skipped
+// if another replica is claiming already.
+func (scheduler *ClaimScheduler) Claim(ctx context.Context) (err error) {
+	lock, ok, err := scheduler.locker.Lock(claimSchedulerLockName)
+	if err != nil {
+		return fmt.Errorf("unable to lock claim cycle: %v", err)
+	}
+	if !ok {
+		zap.L().Info("Claim audit: claim cycle skipped, another replica is claiming")
+		return nil
+	}
+	defer func() {
+		if unlockErr := lock.Unlock(); unlockErr != nil {
+			zap.L().Error("unable to unlock claim cycle", zap.Error(unlockErr))
+		}
+	}()
+
+	if err = scheduler.control.removeClaimedPayments(); err != nil {
+		return fmt.Errorf("unable to remove claimed payments: %v", err)
+	}
+	if err = scheduler.startClaims(); err != nil {
+		return
+	}
+
+	claims, err := scheduler.control.channelService.ListClaims()
+	if err != nil {
+		return fmt.Errorf("unable to list claims: %v", err)
+	}
+	started, err := scheduler.startedClaims(claims)
+	if err != nil {
+		return
+	}
+	payments := make([]*Payment, 0, len(claims))
+	for _, claim := range claims {
+		payment := claim.Payment()
+		if !started[payment.ID()] {
+			zap.L().Info("Claim audit: claim skipped, it is not started by the scheduler",
+				zap.Stringer("channelID", payment.ChannelID), zap.Stringer("nonce", payment.ChannelNonce))
+			continue
+		}
+		if payment.Signature == nil || payment.Amount.Sign() == 0 {
+			zap.L().Warn("Claim audit: claim skipped, payment is not signed",
+				zap.Stringer("channelID", payment.ChannelID), zap.Stringer("nonce", payment.ChannelNonce))
+			continue
+		}
+		if scheduler.conf.DryRun {
+			zap.L().Info("Claim audit: dry run, claim is not sent", zap.Stringer("channelID", payment.ChannelID),
+				zap.Stringer("nonce", payment.ChannelNonce), zap.Stringer("amount", payment.Amount))
+			continue
+		}
+		payments = append(payments, payment)
+	}
+
+	for start := 0; start < len(payments); start += scheduler.conf.BatchSize {
+		end := min(start+scheduler.conf.BatchSize, len(payments))
+		if err = scheduler.send(ctx, payments[start:end]); err != nil {
+			return
+		}
+	}
+	if len(payments) == 0 {
+		return nil
+	}
+	if err = scheduler.control.removeClaimedPayments(); err != nil {
+		return fmt.Errorf("unable to remove claimed payments: %v", err)
+	}
+	if claims, err = scheduler.control.channelService.ListClaims(); err != nil {
+		return fmt.Errorf("unable to list claims: %v", err)
+	}
+	_, err = scheduler.startedClaims(claims)
+	return
+}
+
+// startedClaims returns IDs of the claims in progress which are started by
+// the scheduler, records of the claims which are finished already are removed
+func (scheduler *ClaimScheduler) startedClaims(claims []Claim) (started map[string]bool, err error) {
+	inProgress := make(map[string]bool, len(claims))
+	for _, claim := range claims {
+		inProgress[claim.Payment().ID()] = true
+	}
+	records, err := scheduler.claims.GetKeyValuesByKeyPrefix("")
+	if err != nil {
+		return nil, fmt.Errorf("unable to list claims started by scheduler: %v", err)
+	}
+	started = make(map[string]bool, len(records))
+	for _, record := range records {
+		if inProgress[record.Key] {
+			started[record.Key] = true
+			continue
+		}
+		if err = scheduler.claims.Delete(record.Key); err != nil {
+			return nil, fmt.Errorf("unable to remove record of finished claim %v: %v", record.Key, err)
+		}
+	}
+	return started, nil
+}
+
+// startClaims starts claims on the channels which match the thresholds
+func (scheduler *ClaimScheduler) startClaims() error {
+	currentBlock, err := scheduler.currentBlock()
+	if err != nil {
+		return fmt.Errorf("unable to get current block: %v", err)
+	}
+	channels, err := scheduler.control.channelService.ListChannels()
+	if err != nil {
+		return fmt.Errorf("unable to list channels: %v", err)
+	}
+
+	for _, storageChannel := range channels {
+		if storageChannel.AuthorizedAmount == nil || storageChannel.AuthorizedAmount.Sign() == 0 {
+			continue
+		}
+		// expiration is read from the blockchain, the channel could be extended
+		channel, ok, err := scheduler.control.channelService.PaymentChannel(&PaymentChannelKey{ID: storageChannel.ChannelID})
+		if err != nil || !ok {
+			zap.L().Error("Claim audit: unable to read channel", zap.Stringer("channelID", storageChannel.ChannelID), zap.Error(err))
+			continue
+		}
+		reason := scheduler.claimReason(channel, currentBlock)
+		if reason == "" {
+			continue
+		}
+		fields := []zap.Field{zap.Stringer("channelID", channel.ChannelID), zap.Stringer("nonce", channel.Nonce),
+			zap.Stringer("amount", channel.AuthorizedAmount), zap.Stringer("expiration", channel.Expiration),
+			zap.Stringer("currentBlock", currentBlock), zap.String("reason", reason)}
+		if scheduler.conf.DryRun {
+			zap.L().Info("Claim audit: dry run, claim is not started", fields...)
+			continue
+		}
+		reply, err := scheduler.control.beginClaimOnChannel(channel.ChannelID)
+		if err != nil {
+			zap.L().Error("Claim audit: unable to start claim", append(fields, zap.Error(err))...)
+			continue
+		}
+		paymentID := PaymentID(bytesToBigInt(reply.ChannelId), bytesToBigInt(reply.ChannelNonce))
+		if err = scheduler.claims.Put(paymentID, time.Now().UTC().Format(time.RFC3339)); err != nil {
+			// the claim is left to be sent by `snetd claim`
+			zap.L().Error("Claim audit: unable to record started claim", append(fields, zap.Error(err))...)
+			continue
+		}
+		zap.L().Info("Claim audit: claim started", fields...)
+	}
+	return nil
+}
+
+// claimReason returns why the channel should be claimed or empty string if
+// it should not be claimed yet
+func (scheduler *ClaimScheduler) claimReason(channel *PaymentChannelData, currentBlock *big.Int) string {
+	threshold := scheduler.conf.AmountThreshold
+	if threshold != nil && threshold.Sign() > 0 && channel.AuthorizedAmount.Cmp(threshold) > 0 {
+		return fmt.Sprintf("unclaimed amount exceeds %v", threshold)
+	}
+	if scheduler.conf.ExpirationBlocks > 0 {
+		blocksLeft := new(big.Int).Sub(channel.Expiration, currentBlock)
+		if blocksLeft.Cmp(new(big.Int).SetUint64(scheduler.conf.ExpirationBlocks)) <= 0 {
+			return fmt.Sprintf("channel expires in %v blocks", blocksLeft)
+		}
+	}
+	return ""
+}
+
+// send claims the payments by one multiChannelClaim transaction and waits
+// until the transaction is mined
+func (scheduler *ClaimScheduler) send(ctx context.Context, payments []*Payment) error {
+	channelIDs := make([]*big.Int, 0, len(payments))
+	actualAmounts := make([]*big.Int, 0, len(payments))
+	plannedAmounts := make([]*big.Int, 0, len(payments))
+	sendbacks := make([]bool, 0, len(payments))
+	vs := make([]uint8, 0, len(payments))
+	rs := make([][32]byte, 0, len(payments))
+	ss := make([][32]byte, 0, len(payments))
+	for _, payment := range payments {
+		v, r, s, err := utils.ParseSignature(payment.Signature)
+		if err != nil {
+			zap.L().Error("Claim audit: claim skipped, signature is not valid",
+				zap.Stringer("channelID", payment.ChannelID), zap.Error(err))
+			continue
+		}
+		channelIDs = append(channelIDs, payment.ChannelID)
+		actualAmounts = append(actualAmounts, payment.Charged())
+		plannedAmounts = append(plannedAmounts, payment.Amount)
+		sendbacks = append(sendbacks, false)
+		vs = append(vs, v)
+		rs = append(rs, r)
+		ss = append(ss, s)
+	}
+	if len(channelIDs) == 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, scheduler.conf.Interval)
+	defer cancel()
+	opts := *scheduler.transactor
+	opts.Context = ctx
+	tx, err := scheduler.mpe.MultiChannelClaim(&opts, channelIDs, actualAmounts, plannedAmounts, sendbacks, vs, rs, ss)
+	if err != nil {
+		zap.L().Error("Claim audit: unable to send claim transaction", zap.Any("channelIDs", channelIDs), zap.Error(err))
+		return fmt.Errorf("unable to send claim transaction: %v", err)
+	}
+	zap.L().Info("Claim audit: claim transaction sent", zap.Stringer("tx", tx.Hash()),
+		zap.Any("channelIDs", channelIDs), zap.Any("amounts", actualAmounts))
+
+	receipt, err := bind.WaitMined(ctx, scheduler.backend, tx)
+	if err != nil {
+		zap.L().Error("Claim audit: claim transaction is not mined", zap.Stringer("tx", tx.Hash()), zap.Error(err))
+		return fmt.Errorf("claim transaction %v is not mined: %v", tx.Hash().Hex(), err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		zap.L().Error("Claim audit: claim transaction failed", zap.Stringer("tx", tx.Hash()),
+			zap.Stringer("block", receipt.BlockNumber))
+		return fmt.Errorf("claim transaction %v failed", tx.Hash().Hex())
+	}
+	zap.L().Info("Claim audit: claim transaction mined", zap.Stringer("tx", tx.Hash()),
+		zap.Stringer("block", receipt.BlockNumber), zap.Uint64("gasUsed", receipt.GasUsed))
+	return nil
+}
@@ -0,0 +1,204 @@
+package escrow
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/singnet/snet-daemon/v6/blockchain"
+	"github.com/singnet/snet-daemon/v6/storage"
+)
+
+type ClaimSchedulerSuite struct {
+	suite.Suite
+	env           blockchain.SimulatedEthereumEnvironment
+	memoryStorage *storage.MemoryStorage
+	storage       *PaymentChannelStorage
+	control       *ProviderControlService
+	groupID       [32]byte
+}
+
+func TestClaimSchedulerSuite(t *testing.T) {
+	suite.Run(t, new(ClaimSchedulerSuite))
+}
+
+// SetupTest opens the channel 0 on the simulated blockchain
+func (suite *ClaimSchedulerSuite) SetupTest() {
+	suite.env = blockchain.GetSimulatedEthereumEnvironmentWithStubToken()
+	suite.groupID = [32]byte{123}
+	env := &suite.env
+	env.SnetTransferTokens(env.ClientWallet, 1000).Commit().
+		SnetApproveMpe(env.ClientWallet, 1000).Commit().
+		MpeDeposit(env.ClientWallet, 1000).Commit().
+		MpeOpenChannel(env.ClientWallet, env.ServerWallet, 1000, 100, suite.groupID).Commit()
+
+	suite.memoryStorage = storage.NewMemStorage()
+	suite.storage = NewPaymentChannelStorage(suite.memoryStorage)
+	channelService := NewPaymentChannelService(
+		suite.storage,
+		NewPaymentStorage(suite.memoryStorage),
+		&BlockchainChannelReader{
+			readChannelFromBlockchain: func(channelID *big.Int) (*blockchain.MultiPartyEscrowChannel, bool, error) {
+				ch, err := env.MultiPartyEscrow.Channels(nil, channelID)
+				if err != nil || ch.Sender == (common.Address{}) {
+					return nil, false, err
+				}
+				return &blockchain.MultiPartyEscrowChannel{Sender: ch.Sender, Recipient: ch.Recipient, GroupId: ch.GroupId,
+					Value: ch.Value, Nonce: ch.Nonce, Expiration: ch.Expiration, Signer: ch.Signer}, true, nil
+			},
+			recipientPaymentAddress: func() common.Address { return env.ServerWallet.From },
+		},
+		NewEtcdLocker(suite.memoryStorage),
+		&ChannelPaymentValidator{},
+		func() [32]byte { return suite.groupID },
+	)
+	suite.control = &ProviderControlService{channelService: channelService}
+}
+
+// storePayment stores the payment of authorizedAmount signed by the client
+func (suite *ClaimSchedulerSuite) storePayment(authorizedAmount int64) {
+	env := &suite.env
+	payment := &Payment{
+		MpeContractAddress: env.MultiPartyEscrowAddress,
+		ChannelID:          big.NewInt(0),
+		ChannelNonce:       big.NewInt(0),
+		Amount:             big.NewInt(authorizedAmount),
+	}
+	SignTestPayment(payment, env.ClientPrivateKey)
+	require.NoError(suite.T(), suite.storage.Put(&PaymentChannelKey{ID: payment.ChannelID}, &PaymentChannelData{
+		ChannelID:        payment.ChannelID,
+		Nonce:            payment.ChannelNonce,
+		State:            Open,
+		Sender:           env.ClientWallet.From,
+		Recipient:        env.ServerWallet.From,
+		GroupID:          suite.groupID,
+		FullAmount:       big.NewInt(1000),
+		Expiration:       big.NewInt(100),
+		Signer:           env.ClientWallet.From,
+		AuthorizedAmount: payment.Amount,
+		Signature:        payment.Signature,
+	}))
+}
+
+func (suite *ClaimSchedulerSuite) scheduler(conf ClaimSchedulerConf) *ClaimScheduler {
+	client := suite.env.Backend.Client()
+	return newClaimScheduler(suite.control, suite.memoryStorage, suite.env.MultiPartyEscrow, client,
+		func() (*big.Int, error) {
+			number, err := client.BlockNumber(context.Background())
+			return new(big.Int).SetUint64(number), err
+		}, suite.env.ServerWallet, conf)
+}
+
+// claim runs the claim mining the blocks until it is finished
+func (suite *ClaimSchedulerSuite) claim(scheduler *ClaimScheduler) {
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		ticker := time.NewTicker(10 * time.Millisecond)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case <-ticker.C:
+				suite.env.Commit()
+			}
+		}
+	}()
+	require.NoError(suite.T(), scheduler.Claim(context.Background()))
+}
+
+func (suite *ClaimSchedulerSuite) blockchainNonce() int64 {
+	ch, err := suite.env.MultiPartyEscrow.Channels(nil, big.NewInt(0))
+	require.NoError(suite.T(), err)
+	return ch.Nonce.Int64()
+}
+
+func (suite *ClaimSchedulerSuite) claims() []Claim {
+	claims, err := suite.control.channelService.ListClaims()
+	require.NoError(suite.T(), err)
+	return claims
+}
+
+func (suite *ClaimSchedulerSuite) assertNotClaimed() {
+	assert.Equal(suite.T(), int64(0), suite.blockchainNonce())
+	channel, _, err := suite.storage.Get(&PaymentChannelKey{ID: big.NewInt(0)})
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(0), channel.Nonce.Int64())
+	assert.Empty(suite.T(), suite.claims())
+}
+
+func (suite *ClaimSchedulerSuite) TestClaimAmountThreshold() {
+	suite.storePayment(100)
+
+	suite.claim(suite.scheduler(ClaimSchedulerConf{AmountThreshold: big.NewInt(100)}))
+	suite.assertNotClaimed()
+
+	suite.claim(suite.scheduler(ClaimSchedulerConf{AmountThreshold: big.NewInt(99)}))
+
+	ch, err := suite.env.MultiPartyEscrow.Channels(nil, big.NewInt(0))
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(1), ch.Nonce.Int64())
+	assert.Equal(suite.T(), int64(900), ch.Value.Int64())
+	balance, err := suite.env.MultiPartyEscrow.Balances(nil, suite.env.ServerWallet.From)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(100), balance.Int64())
+
+	channel, _, err := suite.storage.Get(&PaymentChannelKey{ID: big.NewInt(0)})
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(1), channel.Nonce.Int64())
+	assert.Equal(suite.T(), int64(0), channel.AuthorizedAmount.Int64())
+	assert.Empty(suite.T(), suite.claims(), "claimed payments are removed")
+	started, err := suite.memoryStorage.GetByKeyPrefix("/claim-scheduler/claims/")
+	require.NoError(suite.T(), err)
+	assert.Empty(suite.T(), started, "records of finished claims are removed")
+}
+
+func (suite *ClaimSchedulerSuite) TestClaimExpiringChannel() {
+	suite.storePayment(100)
+
+	suite.claim(suite.scheduler(ClaimSchedulerConf{ExpirationBlocks: 10}))
+	suite.assertNotClaimed()
+
+	suite.claim(suite.scheduler(ClaimSchedulerConf{ExpirationBlocks: 100}))
+	assert.Equal(suite.T(), int64(1), suite.blockchainNonce())
+}
+
+func (suite *ClaimSchedulerSuite) TestDryRun() {
+	suite.storePayment(100)
+
+	suite.claim(suite.scheduler(ClaimSchedulerConf{AmountThreshold: big.NewInt(1), DryRun: true}))
+	suite.assertNotClaimed()
+}
+
+func (suite *ClaimSchedulerSuite) TestManualClaimIsNotSent() {
+	suite.storePayment(100)
+	_, err := suite.control.beginClaimOnChannel(big.NewInt(0))
+	require.NoError(suite.T(), err)
+
+	suite.claim(suite.scheduler(ClaimSchedulerConf{AmountThreshold: big.NewInt(1)}))
+
+	assert.Equal(suite.T(), int64(0), suite.blockchainNonce())
+	assert.Len(suite.T(), suite.claims(), 1, "manual claim is left to its initiator")
+}
+
+func (suite *ClaimSchedulerSuite) TestCycleIsSkippedWhileLocked() {
+	suite.storePayment(100)
+	scheduler := suite.scheduler(ClaimSchedulerConf{AmountThreshold: big.NewInt(1)})
+	lock, ok, err := scheduler.locker.Lock(claimSchedulerLockName)
+	require.NoError(suite.T(), err)
+	require.True(suite.T(), ok)
+
+	suite.claim(scheduler)
+	suite.assertNotClaimed()
+
+	require.NoError(suite.T(), lock.Unlock())
+	suite.claim(scheduler)
+	assert.Equal(suite.T(), int64(1), suite.blockchainNonce())
+}
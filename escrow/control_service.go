@@ -253,8 +253,9 @@ func (service *ProviderControlService) listChannels() (*PaymentsListReply, error
 		paymentReply := &PaymentReply{
 			ChannelId:     bigIntToBytes(channel.ChannelID),
 			ChannelNonce:  bigIntToBytes(channel.Nonce),
-			SignedAmount:  bigIntToBytes(channel.AuthorizedAmount),
+			SignedAmount:  bigIntToBytes(channel.signedAmount()),
 			ChannelExpiry: bigIntToBytes(channel.Expiration),
+			ActualAmount:  optionalBigIntToBytes(channel.actualAmount()),
 		}
 		output = append(output, paymentReply)
 	}
@@ -317,6 +318,7 @@ func (service *ProviderControlService) beginClaimOnChannel(channelId *big.Int) (
 		ChannelNonce: bigIntToBytes(payment.ChannelNonce),
 		Signature:    payment.Signature,
 		SignedAmount: bigIntToBytes(payment.Amount),
+		ActualAmount: optionalBigIntToBytes(payment.ActualAmount),
 	}
 	return paymentReply, nil
 }
@@ -367,6 +369,7 @@ func (service *ProviderControlService) listClaims() (*PaymentsListReply, error)
 			SignedAmount:  bigIntToBytes(payment.Amount),
 			Signature:     payment.Signature,
 			ChannelExpiry: bigIntToBytes(latestChannel.Expiration),
+			ActualAmount:  optionalBigIntToBytes(payment.ActualAmount),
 		}
 		output = append(output, paymentReply)
 	}
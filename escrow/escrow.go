@@ -1,9 +1,13 @@
 package escrow
 
 import (
+	"context"
 	"fmt"
+	"math/big"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
+	"google.golang.org/grpc"
 
 	"go.uber.org/zap"
 )
@@ -18,6 +22,8 @@ type lockingPaymentChannelService struct {
 	locker           Locker
 	validator        *ChannelPaymentValidator
 	replicaGroupID   func() [32]byte
+	lockQueue        *channelLockQueue
+	ledger           *IncomeLedger
 }
 
 // NewPaymentChannelService returns an instance of PaymentChannelService to work
@@ -29,6 +35,36 @@ func NewPaymentChannelService(
 	locker Locker,
 	channelPaymentValidator *ChannelPaymentValidator, groupIdReader func() [32]byte) PaymentChannelService {
 
+	return NewPaymentChannelServiceWithLockConf(storage, paymentStorage, blockchainReader, locker,
+		channelPaymentValidator, groupIdReader, DefaultChannelLockConf)
+}
+
+// NewPaymentChannelServiceWithLockConf returns an instance of
+// PaymentChannelService which handles concurrent calls on the same payment
+// channel according to lockConf.
+func NewPaymentChannelServiceWithLockConf(
+	storage *PaymentChannelStorage,
+	paymentStorage *PaymentStorage,
+	blockchainReader *BlockchainChannelReader,
+	locker Locker,
+	channelPaymentValidator *ChannelPaymentValidator, groupIdReader func() [32]byte,
+	lockConf ChannelLockConf) PaymentChannelService {
+
+	return NewPaymentChannelServiceWithIncomeLedger(storage, paymentStorage, blockchainReader, locker,
+		channelPaymentValidator, groupIdReader, lockConf, nil)
+}
+
+// NewPaymentChannelServiceWithIncomeLedger returns an instance of
+// PaymentChannelService which records the income of each committed payment
+// to ledger.
+func NewPaymentChannelServiceWithIncomeLedger(
+	storage *PaymentChannelStorage,
+	paymentStorage *PaymentStorage,
+	blockchainReader *BlockchainChannelReader,
+	locker Locker,
+	channelPaymentValidator *ChannelPaymentValidator, groupIdReader func() [32]byte,
+	lockConf ChannelLockConf, ledger *IncomeLedger) PaymentChannelService {
+
 	return &lockingPaymentChannelService{
 		storage:          storage,
 		paymentStorage:   paymentStorage,
@@ -36,6 +72,8 @@ func NewPaymentChannelService(
 		locker:           locker,
 		validator:        channelPaymentValidator,
 		replicaGroupID:   groupIdReader,
+		lockQueue:        newChannelLockQueue(locker, lockConf),
+		ledger:           ledger,
 	}
 }
 
@@ -44,7 +82,20 @@ func (h *lockingPaymentChannelService) PaymentChannelFromBlockChain(key *Payment
 }
 
 func (h *lockingPaymentChannelService) PaymentChannel(key *PaymentChannelKey) (channel *PaymentChannelData, ok bool, err error) {
-	storageChannel, storageOk, err := h.storage.Get(key)
+	channel, _, ok, err = h.paymentChannel(key, false)
+	return
+}
+
+// paymentChannel returns the channel state merged from the storage and the
+// blockchain and the state kept in the storage, storageChannel is nil when
+// the channel is not in the storage yet. If latest is true the storage state
+// is read bypassing the cache.
+func (h *lockingPaymentChannelService) paymentChannel(key *PaymentChannelKey, latest bool) (channel, storageChannel *PaymentChannelData, ok bool, err error) {
+	getStorage := h.storage.Get
+	if latest {
+		getStorage = h.storage.GetLatest
+	}
+	storageChannel, storageOk, err := getStorage(key)
 	if err != nil {
 		return
 	}
@@ -57,16 +108,16 @@ func (h *lockingPaymentChannelService) PaymentChannel(key *PaymentChannelKey) (c
 		if blockchainChannel != nil {
 			blockChainGroupID := h.replicaGroupID()
 			if err = h.verifyGroupId(blockChainGroupID, blockchainChannel.GroupID); err != nil {
-				return nil, false, err
+				return nil, nil, false, err
 			}
 		}
-		return blockchainChannel, blockchainOk, err
+		return blockchainChannel, nil, blockchainOk, err
 	}
 	if err != nil || !blockchainOk {
-		return storageChannel, storageOk, nil
+		return storageChannel, storageChannel, storageOk, nil
 	}
 
-	return MergeStorageAndBlockchainChannelState(storageChannel, blockchainChannel), true, nil
+	return MergeStorageAndBlockchainChannelState(storageChannel, blockchainChannel), storageChannel, true, nil
 }
 
 // Check if the channel belongs to the same group ID
@@ -170,16 +221,31 @@ func getPaymentFromChannel(channel *PaymentChannelData) *Payment {
 		//MpeContractAddress: channel.MpeContractAddress,
 		ChannelID:    channel.ChannelID,
 		ChannelNonce: channel.Nonce,
-		Amount:       channel.AuthorizedAmount,
+		Amount:       channel.signedAmount(),
 		Signature:    channel.Signature,
+		ActualAmount: channel.actualAmount(),
 	}
 }
 
 type paymentTransaction struct {
 	payment Payment
 	channel *PaymentChannelData
-	service *lockingPaymentChannelService
-	lock    Lock
+	// storageChannel is the channel state read from the storage, it is
+	// replaced by the new state using compare-and-swap
+	storageChannel *PaymentChannelData
+	service        *lockingPaymentChannelService
+	lock           Lock
+	// pipelined is true when the payment is stored and the channel is
+	// unlocked before the call is executed, see LockPolicyPipeline
+	pipelined bool
+	// method is a full name of the gRPC method paid
+	method string
+	// authorizedAmount is the amount authorized after the call when the call
+	// costs less than the client signed, nil means the amount signed is
+	// authorized
+	authorizedAmount *big.Int
+	// block is the block number the payment is validated against
+	block *big.Int
 }
 
 func (payment *paymentTransaction) GetSender() common.Address {
@@ -194,16 +260,40 @@ func (payment *paymentTransaction) Channel() *PaymentChannelData {
 	return payment.channel
 }
 
-func (h *lockingPaymentChannelService) StartPaymentTransaction(payment *Payment) (transaction PaymentTransaction, err error) {
+// SetActualCost charges the cost reported by the service instead of the
+// price signed if the cost is less. The amount of the pipelined payment is
+// authorized already, so the price signed is charged.
+func (payment *paymentTransaction) SetActualCost(cost *big.Int) {
+	price := new(big.Int).Sub(payment.payment.Amount, payment.channel.AuthorizedAmount)
+	if cost.Cmp(price) >= 0 {
+		return
+	}
+	if payment.pipelined {
+		zap.L().Warn("Actual cost is not charged because the payment is pipelined, the price signed is charged",
+			zap.Stringer("cost", cost), zap.Stringer("price", price))
+		return
+	}
+	payment.authorizedAmount = new(big.Int).Add(payment.channel.AuthorizedAmount, cost)
+}
+
+// chargePartially charges fraction of the price of the call, the rest of the
+// amount signed is not authorized, see SetActualCost
+func (payment *paymentTransaction) chargePartially(fraction float64) {
+	authorizedAmount := payment.payment.Amount
+	if payment.authorizedAmount != nil {
+		authorizedAmount = payment.authorizedAmount
+	}
+	price := new(big.Int).Sub(authorizedAmount, payment.channel.AuthorizedAmount)
+	cost := new(big.Rat).Mul(new(big.Rat).SetInt(price), new(big.Rat).SetFloat64(fraction))
+	payment.SetActualCost(new(big.Int).Quo(cost.Num(), cost.Denom()))
+}
+
+func (h *lockingPaymentChannelService) StartPaymentTransaction(ctx context.Context, payment *Payment) (transaction PaymentTransaction, err error) {
 	channelKey := &PaymentChannelKey{ID: payment.ChannelID}
 
-	lock, ok, err := h.locker.Lock(channelKey.String())
+	lock, err := h.lockQueue.lock(ctx, channelKey.String())
 	if err != nil {
-		zap.L().Error("StartPaymentTransaction, unable to get lock!", zap.Error(err), zap.Any("channelKey", channelKey))
-		return nil, NewPaymentError(Internal, "cannot get mutex for channel: %v", channelKey)
-	}
-	if !ok {
-		return nil, NewPaymentError(FailedPrecondition, "another transaction on channel: %v is in progress", channelKey)
+		return nil, err
 	}
 	defer func(lock Lock) {
 		if err != nil {
@@ -216,30 +306,94 @@ func (h *lockingPaymentChannelService) StartPaymentTransaction(payment *Payment)
 		}
 	}(lock)
 
-	channel, ok, err := h.PaymentChannel(channelKey)
+	channel, storageChannel, block, err := h.validatedPaymentChannel(channelKey, payment)
 	if err != nil {
-		zap.L().Error("StartPaymentTransaction, unable to get channel!", zap.Error(err), zap.Any("channelKey", channelKey))
-		return nil, NewPaymentError(Internal, "payment channel error: %s", err.Error())
+		return
 	}
-	if !ok {
-		zap.L().Warn("Payment channel not found")
-		return nil, NewPaymentError(Unauthenticated, "payment channel \"%v\" not found", channelKey)
+
+	method, _ := grpc.Method(ctx)
+	paymentTransaction := &paymentTransaction{
+		payment:        *payment,
+		channel:        channel,
+		storageChannel: storageChannel,
+		lock:           lock,
+		service:        h,
+		method:         method,
+		block:          block,
+	}
+	if h.lockQueue.conf.Policy == LockPolicyPipeline {
+		if err = paymentTransaction.pipeline(channelKey); err != nil {
+			return nil, err
+		}
+	}
+	return paymentTransaction, nil
+}
+
+// pipeline stores the payment before the call is executed and unlocks the
+// channel, so the next call on the channel doesn't wait for this one. The
+// payment should authorize a greater amount than the previous one.
+func (payment *paymentTransaction) pipeline(key *PaymentChannelKey) error {
+	if payment.payment.Amount.Cmp(payment.channel.AuthorizedAmount) <= 0 {
+		return NewPaymentError(Unauthenticated, "payment amount %v must be greater than the amount already authorized %v",
+			payment.payment.Amount, payment.channel.AuthorizedAmount)
 	}
 
-	err = h.validator.Validate(payment, channel)
+	ok, err := payment.store(key)
 	if err != nil {
-		return
+		zap.L().Error("Unable to store new payment channel state", zap.Error(err))
+		return NewPaymentError(Internal, "unable to store new payment channel state")
+	}
+	if !ok {
+		zap.L().Warn("Payment channel state was changed since it was read", zap.Any("payment", payment))
+		return NewPaymentError(FailedPrecondition, "payment channel state was changed concurrently, please retry the call")
 	}
 
-	return &paymentTransaction{
-		payment: *payment,
-		channel: channel,
-		lock:    lock,
-		service: h,
-	}, nil
+	payment.pipelined = true
+	if err = payment.lock.Unlock(); err != nil {
+		zap.L().Error("Channel cannot be unlocked because of error. All other transactions on this channel will be blocked until unlock. Please unlock channel manually.",
+			zap.Error(err), zap.Any("payment", payment))
+	}
+	return nil
+}
+
+// validatedPaymentChannel reads the channel and validates the payment. It is
+// called under the channel lock, so the storage state is read bypassing the
+// cache: the cache may not have received the change made by another replica
+// yet and the payment validated against it would be served without being
+// charged. If the payment is not valid for the cached blockchain state, i.e.
+// the client has just extended the channel, the state is read from the
+// blockchain again.
+func (h *lockingPaymentChannelService) validatedPaymentChannel(channelKey *PaymentChannelKey, payment *Payment) (
+	channel, storageChannel *PaymentChannelData, block *big.Int, err error) {
+	for attempt := 0; ; attempt++ {
+		channel, storageChannel, ok, err := h.paymentChannel(channelKey, true)
+		if err != nil {
+			zap.L().Error("StartPaymentTransaction, unable to get channel!", zap.Error(err), zap.Any("channelKey", channelKey))
+			return nil, nil, nil, NewPaymentError(Internal, "payment channel error: %s", err.Error())
+		}
+		if !ok {
+			zap.L().Warn("Payment channel not found")
+			return nil, nil, nil, NewPaymentError(Unauthenticated, "payment channel \"%v\" not found", channelKey)
+		}
+
+		block, err := h.validator.validate(payment, channel)
+		if err == nil {
+			return channel, storageChannel, block, nil
+		}
+		if attempt > 0 || !h.blockchainReader.invalidate(channelKey) {
+			return nil, nil, nil, err
+		}
+		zap.L().Debug("Payment is not valid for the cached channel state, reading channel again", zap.Error(err))
+	}
 }
 
 func (payment *paymentTransaction) Commit() error {
+	if payment.pipelined {
+		zap.L().Debug("Pipelined payment completed", zap.Uint64("payment.ChannelID", payment.payment.ChannelID.Uint64()))
+		payment.recordIncome()
+		return nil
+	}
+
 	defer func(payment *paymentTransaction) {
 		err := payment.lock.Unlock()
 		if err != nil {
@@ -250,32 +404,116 @@ func (payment *paymentTransaction) Commit() error {
 		}
 	}(payment)
 
-	err := payment.service.storage.Put(
-		&PaymentChannelKey{ID: payment.payment.ChannelID},
-		&PaymentChannelData{
-			ChannelID:        payment.channel.ChannelID,
-			Nonce:            payment.channel.Nonce,
-			State:            payment.channel.State,
-			Sender:           payment.channel.Sender,
-			Recipient:        payment.channel.Recipient,
-			FullAmount:       payment.channel.FullAmount,
-			Expiration:       payment.channel.Expiration,
-			Signer:           payment.channel.Signer,
-			AuthorizedAmount: payment.payment.Amount,
-			Signature:        payment.payment.Signature,
-			GroupID:          payment.channel.GroupID,
-		},
-	)
+	ok, err := payment.store(&PaymentChannelKey{ID: payment.payment.ChannelID})
 	if err != nil {
 		zap.L().Error("Unable to store new payment channel state", zap.Error(err))
 		return NewPaymentError(Internal, "unable to store new payment channel state")
 	}
+	if !ok {
+		// the channel was claimed by a writer which doesn't hold the channel
+		// lock, the cache entry is dropped by now
+		zap.L().Warn("Payment channel nonce was changed since it was read", zap.Any("payment", payment))
+		return NewPaymentError(FailedPrecondition, "payment channel state was changed concurrently, please retry the call")
+	}
 
 	zap.L().Debug("Payment completed", zap.Uint64("channel.ChannelID", payment.channel.ChannelID.Uint64()), zap.Uint64("payment.ChannelID", payment.payment.ChannelID.Uint64()))
+	payment.recordIncome()
 	return nil
 }
 
+// recordIncome writes the income of the committed payment to the ledger, an
+// error is logged only as the payment is committed already
+func (payment *paymentTransaction) recordIncome() {
+	ledger := payment.service.ledger
+	if ledger == nil {
+		return
+	}
+	authorized := payment.next().AuthorizedAmount
+	income := new(big.Int).Sub(authorized, payment.channel.AuthorizedAmount)
+	if income.Sign() <= 0 {
+		return
+	}
+	_ = ledger.Record(&IncomeRecord{
+		ChannelID:        payment.channel.ChannelID,
+		Nonce:            payment.channel.Nonce,
+		Sender:           payment.channel.Sender,
+		Method:           payment.method,
+		Amount:           income,
+		AuthorizedAmount: authorized,
+		Block:            payment.block,
+		Timestamp:        time.Now().UTC(),
+	})
+}
+
+// store replaces the channel state read by the state with the payment. If the
+// state is changed by a writer which doesn't hold the channel lock, e.g.
+// after the lock lease expired, the state is read again and the payment is
+// stored unless the channel is claimed since then, the call is served
+// already. ok is false if the nonce of the channel is changed.
+func (payment *paymentTransaction) store(key *PaymentChannelKey) (ok bool, err error) {
+	next := payment.next()
+	previous, nonce := payment.storageChannel, payment.channel.Nonce
+	if previous != nil {
+		nonce = previous.Nonce
+	}
+	for {
+		if previous == nil {
+			ok, err = payment.service.storage.PutIfAbsent(key, next)
+		} else {
+			ok, err = payment.service.storage.CompareAndSwap(key, previous, next)
+		}
+		if err != nil || ok {
+			return ok, err
+		}
+		current, found, err := payment.service.storage.GetLatest(key)
+		if err != nil {
+			return false, err
+		}
+		if !found {
+			previous = nil
+			continue
+		}
+		if current.Nonce.Cmp(nonce) != 0 {
+			return false, nil
+		}
+		if current.AuthorizedAmount.Cmp(payment.payment.Amount) >= 0 ||
+			(current.SignedAmount != nil && current.SignedAmount.Cmp(payment.payment.Amount) >= 0) {
+			// the payment is authorized by the greater one stored already
+			return true, nil
+		}
+		zap.L().Debug("Payment channel state was changed since it was read, storing payment again",
+			zap.Stringer("authorized", current.AuthorizedAmount), zap.Stringer("amount", payment.payment.Amount))
+		previous = current
+	}
+}
+
+// next returns the channel state with the payment applied
+func (payment *paymentTransaction) next() *PaymentChannelData {
+	authorizedAmount, signedAmount := payment.payment.Amount, (*big.Int)(nil)
+	if payment.authorizedAmount != nil {
+		authorizedAmount, signedAmount = payment.authorizedAmount, payment.payment.Amount
+	}
+	return &PaymentChannelData{
+		ChannelID:        payment.channel.ChannelID,
+		Nonce:            payment.channel.Nonce,
+		State:            payment.channel.State,
+		Sender:           payment.channel.Sender,
+		Recipient:        payment.channel.Recipient,
+		FullAmount:       payment.channel.FullAmount,
+		Expiration:       payment.channel.Expiration,
+		Signer:           payment.channel.Signer,
+		AuthorizedAmount: authorizedAmount,
+		Signature:        payment.payment.Signature,
+		SignedAmount:     signedAmount,
+		GroupID:          payment.channel.GroupID,
+	}
+}
+
 func (payment *paymentTransaction) Rollback() error {
+	if payment.pipelined {
+		return payment.revert()
+	}
+
 	defer func(payment *paymentTransaction) {
 		err := payment.lock.Unlock()
 		if err != nil {
@@ -288,3 +526,36 @@ func (payment *paymentTransaction) Rollback() error {
 	}(payment)
 	return nil
 }
+
+// revert restores the channel state stored by the pipelined payment. The
+// state is not restored if the next payment on the channel is already
+// started, because the next payment authorizes the amount of this one too.
+func (payment *paymentTransaction) revert() error {
+	key := &PaymentChannelKey{ID: payment.payment.ChannelID}
+	lock, err := payment.service.lockQueue.lock(context.Background(), key.String())
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if e := lock.Unlock(); e != nil {
+			zap.L().Error("Channel cannot be unlocked because of error. All other transactions on this channel will be blocked until unlock. Please unlock channel manually.",
+				zap.Error(e), zap.Any("payment", payment))
+		}
+	}()
+
+	previous := payment.storageChannel
+	if previous == nil {
+		previous = payment.channel
+	}
+	ok, err := payment.service.storage.CompareAndSwap(key, payment.next(), previous)
+	if err != nil {
+		zap.L().Error("Unable to restore payment channel state", zap.Error(err))
+		return NewPaymentError(Internal, "unable to restore payment channel state")
+	}
+	if !ok {
+		zap.L().Warn("Pipelined payment is not rolled back, the next payment on the channel is already started", zap.Any("payment", payment))
+		return nil
+	}
+	zap.L().Debug("Pipelined payment rolled back")
+	return nil
+}
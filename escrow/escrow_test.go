@@ -1,6 +1,7 @@
 package escrow
 
 import (
+	"context"
 	"crypto/ecdsa"
 	"errors"
 	"fmt"
@@ -9,6 +10,7 @@ import (
 
 	"github.com/singnet/snet-daemon/v6/blockchain"
 	"github.com/singnet/snet-daemon/v6/config"
+	"github.com/singnet/snet-daemon/v6/handler"
 	"github.com/singnet/snet-daemon/v6/storage"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -52,7 +54,7 @@ func (p *paymentChannelServiceMock) Clear() {
 	p.err = nil
 }
 
-func (p *paymentChannelServiceMock) StartPaymentTransaction(payment *Payment) (PaymentTransaction, error) {
+func (p *paymentChannelServiceMock) StartPaymentTransaction(ctx context.Context, payment *Payment) (PaymentTransaction, error) {
 	if p.err != nil {
 		return nil, p.err
 	}
@@ -195,7 +197,7 @@ func (suite *PaymentChannelServiceSuite) channelPlusPayment(payment *Payment) *P
 func (suite *PaymentChannelServiceSuite) TestPaymentTransaction() {
 	payment := suite.payment()
 
-	transaction, errA := suite.service.StartPaymentTransaction(payment)
+	transaction, errA := suite.service.StartPaymentTransaction(context.Background(), payment)
 	errB := transaction.Commit()
 	channel, ok, errC := suite.storage.Get(suite.channelKey())
 
@@ -214,8 +216,8 @@ func (suite *PaymentChannelServiceSuite) TestPaymentParallelTransaction() {
 	paymentB.Amount = big.NewInt(17)
 	SignTestPayment(paymentB, suite.signerPrivateKey)
 
-	transactionA, errA := suite.service.StartPaymentTransaction(paymentA)
-	transactionB, errB := suite.service.StartPaymentTransaction(paymentB)
+	transactionA, errA := suite.service.StartPaymentTransaction(context.Background(), paymentA)
+	transactionB, errB := suite.service.StartPaymentTransaction(context.Background(), paymentB)
 	errC := transactionA.Commit()
 	channel, ok, errD := suite.storage.Get(suite.channelKey())
 
@@ -236,9 +238,9 @@ func (suite *PaymentChannelServiceSuite) TestPaymentSequentialTransaction() {
 	paymentB.Amount = big.NewInt(17)
 	SignTestPayment(paymentB, suite.signerPrivateKey)
 
-	transactionA, errA := suite.service.StartPaymentTransaction(paymentA)
+	transactionA, errA := suite.service.StartPaymentTransaction(context.Background(), paymentA)
 	errAC := transactionA.Commit()
-	transactionB, errB := suite.service.StartPaymentTransaction(paymentB)
+	transactionB, errB := suite.service.StartPaymentTransaction(context.Background(), paymentB)
 	errBC := transactionB.Commit()
 	channel, ok, errD := suite.storage.Get(suite.channelKey())
 
@@ -259,9 +261,9 @@ func (suite *PaymentChannelServiceSuite) TestPaymentSequentialTransactionAfterRo
 	paymentB.Amount = big.NewInt(13)
 	SignTestPayment(paymentB, suite.signerPrivateKey)
 
-	transactionA, errA := suite.service.StartPaymentTransaction(paymentA)
+	transactionA, errA := suite.service.StartPaymentTransaction(context.Background(), paymentA)
 	errAC := transactionA.Rollback()
-	transactionB, errB := suite.service.StartPaymentTransaction(paymentB)
+	transactionB, errB := suite.service.StartPaymentTransaction(context.Background(), paymentB)
 	errBC := transactionB.Commit()
 	channel, ok, errD := suite.storage.Get(suite.channelKey())
 
@@ -275,7 +277,7 @@ func (suite *PaymentChannelServiceSuite) TestPaymentSequentialTransactionAfterRo
 }
 
 func (suite *PaymentChannelServiceSuite) TestStartClaim() {
-	transaction, _ := suite.service.StartPaymentTransaction(suite.payment())
+	transaction, _ := suite.service.StartPaymentTransaction(context.Background(), suite.payment())
 	transaction.Commit()
 
 	claim, errA := suite.service.StartClaim(suite.channelKey(), IncrementChannelNonce)
@@ -287,6 +289,60 @@ func (suite *PaymentChannelServiceSuite) TestStartClaim() {
 	assert.Equal(suite.T(), []*Payment{suite.payment()}, claims)
 }
 
+func (suite *PaymentChannelServiceSuite) TestPaymentTransactionActualCost() {
+	payment := suite.payment()
+
+	transaction, err := suite.service.StartPaymentTransaction(context.Background(), payment)
+	suite.Require().NoError(err)
+	transaction.(handler.ActualCostPayment).SetActualCost(big.NewInt(100))
+	suite.Require().NoError(transaction.Commit())
+	channel, ok, err := suite.storage.Get(suite.channelKey())
+
+	suite.Require().NoError(err)
+	assert.True(suite.T(), ok)
+	expected := suite.channelPlusPayment(payment)
+	expected.AuthorizedAmount = big.NewInt(100)
+	expected.SignedAmount = payment.Amount
+	assert.Equal(suite.T(), expected, channel)
+
+	claim, err := suite.service.StartClaim(suite.channelKey(), IncrementChannelNonce)
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), payment.Amount, claim.Payment().Amount, "amount signed is planned amount of the claim")
+	assert.Equal(suite.T(), big.NewInt(100), claim.Payment().Charged())
+	channel, _, err = suite.storage.Get(suite.channelKey())
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), big.NewInt(12245), channel.FullAmount)
+	assert.Nil(suite.T(), channel.SignedAmount)
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentTransactionActualCostAbovePrice() {
+	payment := suite.payment()
+
+	transaction, err := suite.service.StartPaymentTransaction(context.Background(), payment)
+	suite.Require().NoError(err)
+	transaction.(handler.ActualCostPayment).SetActualCost(big.NewInt(20000))
+	suite.Require().NoError(transaction.Commit())
+	channel, _, err := suite.storage.Get(suite.channelKey())
+
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), suite.channelPlusPayment(payment), channel, "amount signed is charged at most")
+}
+
+func (suite *PaymentChannelServiceSuite) TestPaymentHandlerCompletePartially() {
+	payment := suite.payment()
+	paymentHandler := &paymentChannelPaymentHandler{service: suite.service}
+
+	transaction, err := suite.service.StartPaymentTransaction(context.Background(), payment)
+	suite.Require().NoError(err)
+	transaction.(handler.ActualCostPayment).SetActualCost(big.NewInt(1000))
+	suite.Require().Nil(paymentHandler.CompletePartially(transaction, 0.25))
+	channel, _, err := suite.storage.Get(suite.channelKey())
+
+	suite.Require().NoError(err)
+	assert.Equal(suite.T(), big.NewInt(250), channel.AuthorizedAmount, "fraction of the actual cost is charged")
+	assert.Equal(suite.T(), payment.Amount, channel.SignedAmount)
+}
+
 func (suite *PaymentChannelServiceSuite) TestVerifyGroupId() {
 
 	service := suite.service
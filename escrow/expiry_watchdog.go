@@ -0,0 +1,379 @@
+package escrow
+
+import (
+	"context"
+	"expvar"
+	"fmt"
+	"math/big"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+
+	"github.com/singnet/snet-daemon/v6/config"
+	"github.com/singnet/snet-daemon/v6/metrics"
+	"github.com/singnet/snet-daemon/v6/storage"
+)
+
+var channelExpiryStats = metrics.NewVarsMap("payment_channel_expiry")
+
+const (
+	statAtRiskChannels  = "at_risk_channels"
+	statAtRiskAmount    = "at_risk_amount"
+	statExpiredChannels = "expired_channels"
+	statExpiredAmount   = "expired_amount"
+	statAlertsSent      = "alerts_sent"
+)
+
+// ExpiryWatchdogConf contains settings of the channel expiry watchdog
+// Interval - how often channels are checked
+// Horizons - numbers of blocks before the channel expiration when alerts are
+// sent, the channel is reported at risk when it expires within the greatest
+// horizon
+type ExpiryWatchdogConf struct {
+	Interval time.Duration
+	Horizons []uint64
+}
+
+// DefaultExpiryWatchdogConf alerts a day and two hours before expiration
+// assuming 12 seconds per block
+var DefaultExpiryWatchdogConf = ExpiryWatchdogConf{
+	Interval: 10 * time.Minute,
+	Horizons: []uint64{7200, 600},
+}
+
+// ExpiringChannel is a channel with unclaimed income which expires soon.
+// AuthorizedAmount is authorized at the current nonce, ClaimsAmount is the
+// amount of the claims started but not claimed on the blockchain yet.
+// BlocksLeft is negative when the channel is expired already, the sender can
+// withdraw the channel funds since then. Horizon is the smallest alert
+// horizon the channel is within.
+type ExpiringChannel struct {
+	ChannelID        *big.Int
+	Nonce            *big.Int
+	Sender           string
+	AuthorizedAmount *big.Int
+	ClaimsAmount     *big.Int
+	Expiration       *big.Int
+	BlocksLeft       *big.Int
+	Horizon          uint64
+}
+
+// Unclaimed returns the income of the channel at risk
+func (channel *ExpiringChannel) Unclaimed() *big.Int {
+	return new(big.Int).Add(channel.AuthorizedAmount, channel.ClaimsAmount)
+}
+
+// Expired returns true if the sender can withdraw the channel funds already
+func (channel *ExpiringChannel) Expired() bool {
+	return channel.BlocksLeft.Sign() <= 0
+}
+
+func (channel *ExpiringChannel) String() string {
+	unclaimed := fmt.Sprintf("%v cogs unclaimed", channel.Unclaimed())
+	if channel.ClaimsAmount.Sign() > 0 {
+		unclaimed += fmt.Sprintf(" (%v cogs in claims in progress)", channel.ClaimsAmount)
+	}
+	if channel.Expired() {
+		return fmt.Sprintf("channel %v (nonce %v, sender %v): %v, expired at block %v",
+			channel.ChannelID, channel.Nonce, channel.Sender, unclaimed, channel.Expiration)
+	}
+	return fmt.Sprintf("channel %v (nonce %v, sender %v): %v, expires at block %v in %v blocks",
+		channel.ChannelID, channel.Nonce, channel.Sender, unclaimed, channel.Expiration, channel.BlocksLeft)
+}
+
+// ExpiryWatchdog periodically looks for channels which have unclaimed income
+// and expire soon. It publishes metrics and sends a notification each time
+// the channel passes an alert horizon. The horizons alerted are kept in the
+// shared storage, so the replicas send each alert once.
+type ExpiryWatchdog struct {
+	channelService PaymentChannelService
+	currentBlock   func() (*big.Int, error)
+	conf           ExpiryWatchdogConf
+	stats          *expvar.Map
+	notify         func(channel *ExpiringChannel, currentBlock *big.Int)
+	// alerted keeps the smallest horizon alerted by the channel and nonce
+	alerted storage.AtomicStorage
+
+	mutex sync.Mutex
+}
+
+// NewExpiryWatchdog returns new watchdog of the channels kept by
+// channelService, atomicStorage keeps the alerts sent
+func NewExpiryWatchdog(channelService PaymentChannelService, atomicStorage storage.AtomicStorage,
+	currentBlock func() (*big.Int, error), conf ExpiryWatchdogConf) *ExpiryWatchdog {
+	return newExpiryWatchdog(channelService, atomicStorage, currentBlock, conf, channelExpiryStats, sendExpiryNotification)
+}
+
+func newExpiryWatchdog(channelService PaymentChannelService, atomicStorage storage.AtomicStorage,
+	currentBlock func() (*big.Int, error), conf ExpiryWatchdogConf, stats *expvar.Map,
+	notify func(channel *ExpiringChannel, currentBlock *big.Int)) *ExpiryWatchdog {
+	if conf.Interval <= 0 {
+		conf.Interval = DefaultExpiryWatchdogConf.Interval
+	}
+	if len(conf.Horizons) == 0 {
+		conf.Horizons = DefaultExpiryWatchdogConf.Horizons
+	}
+	conf.Horizons = slices.Clone(conf.Horizons)
+	slices.Sort(conf.Horizons)
+	return &ExpiryWatchdog{
+		channelService: channelService,
+		currentBlock:   currentBlock,
+		conf:           conf,
+		stats:          stats,
+		notify:         notify,
+		alerted:        storage.NewPrefixedAtomicStorage(atomicStorage, "/channel-expiry/alerts"),
+	}
+}
+
+// Horizon returns the greatest alert horizon
+func (watchdog *ExpiryWatchdog) Horizon() uint64 {
+	return watchdog.conf.Horizons[len(watchdog.conf.Horizons)-1]
+}
+
+// Run checks channels each conf.Interval until ctx is done
+func (watchdog *ExpiryWatchdog) Run(ctx context.Context) {
+	zap.L().Info("Channel expiry watchdog started", zap.Duration("interval", watchdog.conf.Interval),
+		zap.Uint64s("horizons", watchdog.conf.Horizons))
+	ticker := time.NewTicker(watchdog.conf.Interval)
+	defer ticker.Stop()
+	for {
+		if err := watchdog.Check(); err != nil {
+			zap.L().Error("Channel expiry watchdog is unable to check channels", zap.Error(err))
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// Check updates the metrics and sends alerts for the channels which passed
+// an alert horizon since the previous check
+func (watchdog *ExpiryWatchdog) Check() error {
+	channels, currentBlock, err := watchdog.Report(watchdog.Horizon())
+	if err != nil {
+		return err
+	}
+
+	atRiskAmount, expiredAmount := big.NewInt(0), big.NewInt(0)
+	expired := 0
+	for _, channel := range channels {
+		if channel.Expired() {
+			expired++
+			expiredAmount.Add(expiredAmount, channel.Unclaimed())
+		} else {
+			atRiskAmount.Add(atRiskAmount, channel.Unclaimed())
+		}
+	}
+	watchdog.setStat(statAtRiskChannels, int64(len(channels)-expired))
+	watchdog.setStat(statExpiredChannels, int64(expired))
+	watchdog.stats.Set(statAtRiskAmount, bigIntVar{atRiskAmount})
+	watchdog.stats.Set(statExpiredAmount, bigIntVar{expiredAmount})
+
+	watchdog.mutex.Lock()
+	defer watchdog.mutex.Unlock()
+	atRisk := make(map[string]bool, len(channels))
+	for _, channel := range channels {
+		key := PaymentID(channel.ChannelID, channel.Nonce)
+		atRisk[key] = true
+		alert, err := watchdog.markAlerted(key, channel.Horizon)
+		if err != nil {
+			return err
+		}
+		if !alert {
+			continue
+		}
+		zap.L().Warn("Unclaimed income is at risk", zap.Stringer("channel", channel), zap.Uint64("horizon", channel.Horizon))
+		watchdog.stats.Add(statAlertsSent, 1)
+		watchdog.notify(channel, currentBlock)
+	}
+	return watchdog.forgetAlerts(atRisk)
+}
+
+// markAlerted records the horizon alerted for the channel, it returns false
+// if the horizon is alerted already by this or another replica
+func (watchdog *ExpiryWatchdog) markAlerted(key string, horizon uint64) (alert bool, err error) {
+	value := strconv.FormatUint(horizon, 10)
+	previous, ok, err := watchdog.alerted.Get(key)
+	if err != nil {
+		return false, fmt.Errorf("unable to read alerts sent: %v", err)
+	}
+	if !ok {
+		alert, err = watchdog.alerted.PutIfAbsent(key, value)
+	} else if alerted, e := strconv.ParseUint(previous, 10, 64); e == nil && alerted <= horizon {
+		return false, nil
+	} else {
+		alert, err = watchdog.alerted.CompareAndSwap(key, previous, value)
+	}
+	if err != nil {
+		return false, fmt.Errorf("unable to record alert sent: %v", err)
+	}
+	return alert, nil
+}
+
+// forgetAlerts removes the alerts of the channels which are claimed or
+// extended, so they are alerted again when they are at risk
+func (watchdog *ExpiryWatchdog) forgetAlerts(atRisk map[string]bool) error {
+	alerts, err := watchdog.alerted.GetKeyValuesByKeyPrefix("")
+	if err != nil {
+		return fmt.Errorf("unable to read alerts sent: %v", err)
+	}
+	for _, alert := range alerts {
+		if atRisk[alert.Key] {
+			continue
+		}
+		if err = watchdog.alerted.Delete(alert.Key); err != nil {
+			return fmt.Errorf("unable to remove alert sent: %v", err)
+		}
+	}
+	return nil
+}
+
+func (watchdog *ExpiryWatchdog) setStat(key string, value int64) {
+	stat := new(expvar.Int)
+	stat.Set(value)
+	watchdog.stats.Set(key, stat)
+}
+
+// Report returns channels with unclaimed income which expire within the
+// given number of blocks or are expired already, sorted by expiration. The
+// income includes the claims which are started but not claimed on the
+// blockchain yet.
+func (watchdog *ExpiryWatchdog) Report(blocks uint64) (channels []*ExpiringChannel, currentBlock *big.Int, err error) {
+	currentBlock, err = watchdog.currentBlock()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to get current block: %v", err)
+	}
+	storageChannels, err := watchdog.channelService.ListChannels()
+	if err != nil {
+		return nil, nil, fmt.Errorf("unable to list channels: %v", err)
+	}
+	claimsAmounts, err := watchdog.claimsInProgress()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	limit := new(big.Int).SetUint64(blocks)
+	for _, storageChannel := range storageChannels {
+		claimsAmount := claimsAmounts[storageChannel.ChannelID.String()]
+		if claimsAmount == nil {
+			claimsAmount = big.NewInt(0)
+		}
+		if (storageChannel.AuthorizedAmount == nil || storageChannel.AuthorizedAmount.Sign() == 0) && claimsAmount.Sign() == 0 {
+			continue
+		}
+		if new(big.Int).Sub(storageChannel.Expiration, currentBlock).Cmp(limit) > 0 {
+			continue
+		}
+		// the channel could be extended or claimed on the blockchain
+		channel, ok, err := watchdog.channelService.PaymentChannel(&PaymentChannelKey{ID: storageChannel.ChannelID})
+		if err != nil || !ok {
+			zap.L().Warn("Unable to read expiring channel, storage state is used",
+				zap.Stringer("channelID", storageChannel.ChannelID), zap.Error(err))
+			channel = storageChannel
+		}
+		if channel.AuthorizedAmount.Sign() == 0 && claimsAmount.Sign() == 0 {
+			continue
+		}
+		blocksLeft := new(big.Int).Sub(channel.Expiration, currentBlock)
+		if blocksLeft.Cmp(limit) > 0 {
+			continue
+		}
+		channels = append(channels, &ExpiringChannel{
+			ChannelID:        channel.ChannelID,
+			Nonce:            channel.Nonce,
+			Sender:           channel.Sender.Hex(),
+			AuthorizedAmount: channel.AuthorizedAmount,
+			ClaimsAmount:     claimsAmount,
+			Expiration:       channel.Expiration,
+			BlocksLeft:       blocksLeft,
+			Horizon:          watchdog.horizon(blocksLeft),
+		})
+	}
+	slices.SortFunc(channels, func(a, b *ExpiringChannel) int {
+		return a.Expiration.Cmp(b.Expiration)
+	})
+	return channels, currentBlock, nil
+}
+
+// claimsInProgress returns the amounts of the claims which are started but
+// not claimed on the blockchain yet by the channel id
+func (watchdog *ExpiryWatchdog) claimsInProgress() (amounts map[string]*big.Int, err error) {
+	claims, err := watchdog.channelService.ListClaims()
+	if err != nil {
+		return nil, fmt.Errorf("unable to list claims: %v", err)
+	}
+	amounts = make(map[string]*big.Int)
+	blockchainNonces := make(map[string]*big.Int)
+	for _, claim := range claims {
+		payment := claim.Payment()
+		channelID := payment.ChannelID.String()
+		nonce, ok := blockchainNonces[channelID]
+		if !ok {
+			channel, found, err := watchdog.channelService.PaymentChannelFromBlockChain(&PaymentChannelKey{ID: payment.ChannelID})
+			if err != nil || !found {
+				zap.L().Warn("Unable to read channel of claim in progress", zap.String("channelID", channelID), zap.Error(err))
+			} else {
+				nonce = channel.Nonce
+			}
+			blockchainNonces[channelID] = nonce
+		}
+		if nonce != nil && nonce.Cmp(payment.ChannelNonce) > 0 {
+			// claimed already
+			continue
+		}
+		if amounts[channelID] == nil {
+			amounts[channelID] = big.NewInt(0)
+		}
+		amounts[channelID].Add(amounts[channelID], payment.Charged())
+	}
+	return amounts, nil
+}
+
+// horizon returns the smallest alert horizon which is not less than
+// blocksLeft, 0 if the channel is expired
+func (watchdog *ExpiryWatchdog) horizon(blocksLeft *big.Int) uint64 {
+	if blocksLeft.Sign() <= 0 {
+		return 0
+	}
+	for _, horizon := range watchdog.conf.Horizons {
+		if blocksLeft.Cmp(new(big.Int).SetUint64(horizon)) <= 0 {
+			return horizon
+		}
+	}
+	return watchdog.Horizon()
+}
+
+func sendExpiryNotification(channel *ExpiringChannel, currentBlock *big.Int) {
+	if config.GetString(config.AlertsEMail) == "" {
+		return
+	}
+	message := fmt.Sprintf("Unclaimed income on channel %v expires in %v blocks, claim it before the sender withdraws the funds.",
+		channel.ChannelID, channel.BlocksLeft)
+	if channel.Expired() {
+		message = fmt.Sprintf("Channel %v with unclaimed income is expired, the sender can withdraw the funds.", channel.ChannelID)
+	}
+	notification := &metrics.Notification{
+		Recipient: config.GetString(config.AlertsEMail),
+		Details:   channel.String(),
+		Timestamp: time.Now().String(),
+		Message:   message,
+		Component: "Daemon",
+		DaemonID:  metrics.GetDaemonID(),
+		Level:     "WARNING",
+	}
+	go notification.Send(currentBlock)
+}
+
+// bigIntVar publishes big.Int as expvar.Var
+type bigIntVar struct {
+	value *big.Int
+}
+
+func (v bigIntVar) String() string {
+	return v.value.String()
+}
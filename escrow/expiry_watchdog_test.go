@@ -0,0 +1,149 @@
+package escrow
+
+import (
+	"expvar"
+	"math/big"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+type ExpiryWatchdogSuite struct {
+	channelServiceTestSuite
+	currentBlock  int64
+	alerts        []*ExpiringChannel
+	watchdogStats *expvar.Map
+	watchdog      *ExpiryWatchdog
+}
+
+func TestExpiryWatchdogSuite(t *testing.T) {
+	suite.Run(t, new(ExpiryWatchdogSuite))
+}
+
+func (suite *ExpiryWatchdogSuite) SetupTest() {
+	suite.channelServiceTestSuite.SetupTest()
+	suite.currentBlock = 0
+	suite.alerts = nil
+	suite.watchdogStats = new(expvar.Map)
+	suite.watchdog = suite.newWatchdog()
+}
+
+// newWatchdog returns the watchdog sharing the storage with the watchdog of
+// the suite
+func (suite *ExpiryWatchdogSuite) newWatchdog() *ExpiryWatchdog {
+	return newExpiryWatchdog(suite.service(), suite.memoryStorage,
+		func() (*big.Int, error) { return big.NewInt(suite.currentBlock), nil },
+		ExpiryWatchdogConf{Horizons: []uint64{10, 50}}, suite.watchdogStats,
+		func(channel *ExpiringChannel, currentBlock *big.Int) {
+			suite.alerts = append(suite.alerts, channel)
+		})
+}
+
+// storeChannel stores the channel which expires at block 100
+func (suite *ExpiryWatchdogSuite) storeChannel(authorizedAmount int64) {
+	require.NoError(suite.T(), suite.otherReplica.Put(suite.key(), suite.channel(authorizedAmount)))
+}
+
+func (suite *ExpiryWatchdogSuite) check(currentBlock int64) {
+	suite.currentBlock = currentBlock
+	require.NoError(suite.T(), suite.watchdog.Check())
+}
+
+func (suite *ExpiryWatchdogSuite) watchdogStat(name string) string {
+	if value := suite.watchdogStats.Get(name); value != nil {
+		return value.String()
+	}
+	return ""
+}
+
+func (suite *ExpiryWatchdogSuite) TestAlertsOnEachHorizon() {
+	suite.storeChannel(30)
+
+	suite.check(40)
+	assert.Empty(suite.T(), suite.alerts)
+	assert.Equal(suite.T(), "0", suite.watchdogStat(statAtRiskChannels))
+
+	suite.check(55)
+	require.Len(suite.T(), suite.alerts, 1)
+	assert.Equal(suite.T(), uint64(50), suite.alerts[0].Horizon)
+	assert.Equal(suite.T(), int64(45), suite.alerts[0].BlocksLeft.Int64())
+	assert.Equal(suite.T(), "1", suite.watchdogStat(statAtRiskChannels))
+	assert.Equal(suite.T(), "30", suite.watchdogStat(statAtRiskAmount))
+
+	suite.check(60)
+	assert.Len(suite.T(), suite.alerts, 1, "horizon is alerted once")
+
+	suite.check(92)
+	require.Len(suite.T(), suite.alerts, 2)
+	assert.Equal(suite.T(), uint64(10), suite.alerts[1].Horizon)
+
+	suite.check(101)
+	require.Len(suite.T(), suite.alerts, 3)
+	assert.True(suite.T(), suite.alerts[2].Expired())
+	assert.Equal(suite.T(), "0", suite.watchdogStat(statAtRiskChannels))
+	assert.Equal(suite.T(), "1", suite.watchdogStat(statExpiredChannels))
+	assert.Equal(suite.T(), "30", suite.watchdogStat(statExpiredAmount))
+	assert.Equal(suite.T(), "3", suite.watchdogStat(statAlertsSent))
+}
+
+func (suite *ExpiryWatchdogSuite) TestReport() {
+	suite.storeChannel(30)
+	suite.currentBlock = 20
+
+	channels, currentBlock, err := suite.watchdog.Report(suite.watchdog.Horizon())
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(20), currentBlock.Int64())
+	assert.Empty(suite.T(), channels)
+
+	channels, _, err = suite.watchdog.Report(100)
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), channels, 1)
+	assert.Equal(suite.T(), int64(42), channels[0].ChannelID.Int64())
+	assert.Equal(suite.T(), int64(30), channels[0].AuthorizedAmount.Int64())
+	assert.Equal(suite.T(), uint64(50), channels[0].Horizon)
+}
+
+func (suite *ExpiryWatchdogSuite) TestNothingToClaim() {
+	suite.storeChannel(0)
+
+	suite.check(95)
+	assert.Empty(suite.T(), suite.alerts)
+	assert.Equal(suite.T(), "0", suite.watchdogStat(statAtRiskChannels))
+}
+
+func (suite *ExpiryWatchdogSuite) TestClaimInProgressIsAtRisk() {
+	suite.storeChannel(30)
+	_, err := suite.service().StartClaim(suite.key(), IncrementChannelNonce)
+	require.NoError(suite.T(), err)
+
+	suite.check(95)
+	require.Len(suite.T(), suite.alerts, 1)
+	assert.Equal(suite.T(), int64(0), suite.alerts[0].AuthorizedAmount.Int64())
+	assert.Equal(suite.T(), int64(30), suite.alerts[0].ClaimsAmount.Int64())
+	assert.Equal(suite.T(), int64(30), suite.alerts[0].Unclaimed().Int64())
+	assert.Equal(suite.T(), "30", suite.watchdogStat(statAtRiskAmount))
+}
+
+func (suite *ExpiryWatchdogSuite) TestReplicasAlertOnce() {
+	suite.storeChannel(30)
+	otherReplica := suite.newWatchdog()
+
+	suite.check(55)
+	require.NoError(suite.T(), otherReplica.Check())
+	assert.Len(suite.T(), suite.alerts, 1, "horizon is alerted by one replica")
+
+	require.NoError(suite.T(), otherReplica.Check())
+	suite.check(92)
+	assert.Len(suite.T(), suite.alerts, 2)
+	suite.check(92)
+	assert.Len(suite.T(), suite.alerts, 2)
+
+	// claimed channel is forgotten
+	suite.storeChannel(0)
+	suite.check(93)
+	alerts, err := suite.watchdog.alerted.GetKeyValuesByKeyPrefix("")
+	require.NoError(suite.T(), err)
+	assert.Empty(suite.T(), alerts)
+}
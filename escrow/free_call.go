@@ -16,19 +16,26 @@ type lockingFreeCallUserService struct {
 	locker          Locker
 	replicaGroupID  func() ([32]byte, error)
 	serviceMetadata *blockchain.ServiceMetadata
+	// quotas replace the lifetime limit of the service metadata if they are
+	// configured
+	quotas *FreeCallQuotas
 }
 
+// NewFreeCallUserService returns the service which limits the free calls by
+// quotas if they are not nil, by the lifetime limit of the service metadata
+// otherwise
 func NewFreeCallUserService(
 	storage *FreeCallUserStorage,
 
 	locker Locker,
-	groupIdReader func() ([32]byte, error), metadata *blockchain.ServiceMetadata) FreeCallUserService {
+	groupIdReader func() ([32]byte, error), metadata *blockchain.ServiceMetadata, quotas *FreeCallQuotas) FreeCallUserService {
 
 	return &lockingFreeCallUserService{
 		storage:         storage,
 		locker:          locker,
 		replicaGroupID:  groupIdReader,
 		serviceMetadata: metadata,
+		quotas:          quotas,
 	}
 }
 
@@ -53,6 +60,10 @@ type freeCallTransaction struct {
 	freeCallUserKey *FreeCallUserKey
 	service         *lockingFreeCallUserService
 	lock            Lock
+	// quotas count the call on commit, nil if the quotas are not applied
+	quotas *FreeCallQuotas
+	// granted is true if the call uses a granted free call
+	granted bool
 }
 
 func (transaction *freeCallTransaction) GetSender() common.Address {
@@ -67,15 +78,41 @@ func (transaction *freeCallTransaction) FreeCallUser() *FreeCallUserData {
 	return transaction.freeCallUser
 }
 
+// GetFreeCallUserKey returns the key of the user of the free call. The
+// address is kept in the checksum form, so the user doesn't depend on the
+// case the client sent the address in. The user stored under the address as
+// it was sent is used if there is no user with the checksum address yet.
 func (h *lockingFreeCallUserService) GetFreeCallUserKey(payment *FreeCallPayment) (userKey *FreeCallUserKey, err error) {
 	groupId, err := h.replicaGroupID()
-	return &FreeCallUserKey{UserId: payment.UserID, Address: payment.Address, OrganizationId: payment.OrganizationId,
-		ServiceId: payment.ServiceId, GroupID: utils.BytesToBase64(groupId[:])}, err
+	userKey = &FreeCallUserKey{UserId: payment.UserID, Address: NormalizeFreeCallUserAddress(payment.Address),
+		OrganizationId: payment.OrganizationId, ServiceId: payment.ServiceId, GroupID: utils.BytesToBase64(groupId[:])}
+	if err != nil || userKey.Address == payment.Address {
+		return userKey, err
+	}
+	if _, ok, e := h.storage.Get(userKey); e != nil || ok {
+		return userKey, nil
+	}
+	sentKey := *userKey
+	sentKey.Address = payment.Address
+	if _, ok, e := h.storage.Get(&sentKey); e == nil && ok {
+		return &sentKey, nil
+	}
+	return userKey, nil
+}
+
+// NormalizeFreeCallUserAddress returns the address of the free call user in
+// the checksum form, the address which is not hex is returned as is
+func NormalizeFreeCallUserAddress(address string) string {
+	if !common.IsHexAddress(address) {
+		return address
+	}
+	return common.HexToAddress(address).Hex()
 }
 
 // StartFreeCallUserTransaction acquires a user-level lock and returns a transaction
 // handle for free-call. It validates the per-address/global free-call
 // limits (where -1 means unlimited) and fails if the limit is exceeded.
+// The quotas replace the global limit for the methods they are applied to.
 // The returned transaction keeps the lock and must be finalized elsewhere.
 func (h *lockingFreeCallUserService) StartFreeCallUserTransaction(payment *FreeCallPayment) (transaction FreeCallTransaction, err error) {
 
@@ -120,6 +157,12 @@ func (h *lockingFreeCallUserService) StartFreeCallUserTransaction(payment *FreeC
 
 	// Check if free calls are allowed for this user
 	allowed := config.GetFreeCallsAllowed(userKey.Address)
+	var quotas *FreeCallQuotas
+	var limitErr error
+	if allowed == 0 && h.quotas.Applies(payment.Method) {
+		limitErr = h.quotas.Check(freeCallUserData, payment.Method)
+		quotas, allowed = h.quotas, -1
+	}
 	if allowed == 0 {
 		allowed = h.serviceMetadata.GetFreeCallsAllowed() // meta is >= 0 by contract
 	}
@@ -127,19 +170,30 @@ func (h *lockingFreeCallUserService) StartFreeCallUserTransaction(payment *FreeC
 	if allowed != -1 {
 		made := freeCallUserData.FreeCallsMade
 		if made >= allowed {
-			return nil, fmt.Errorf(
+			limitErr = fmt.Errorf(
 				"free call limit has been exceeded, calls made = %d, total free calls eligible = %d",
 				made, allowed,
 			)
 		}
 	}
 
+	// the granted calls are used once the limits are exhausted
+	granted := false
+	if limitErr != nil {
+		if freeCallUserData.FreeCallsGranted <= 0 {
+			return nil, limitErr
+		}
+		granted = true
+	}
+
 	return &freeCallTransaction{
 		payment:         *payment,
 		freeCallUserKey: userKey,
 		freeCallUser:    freeCallUserData,
 		lock:            lock,
 		service:         h,
+		quotas:          quotas,
+		granted:         granted,
 	}, nil
 }
 
@@ -156,15 +210,21 @@ func (transaction *freeCallTransaction) Commit() error {
 		}
 	}(transaction)
 
-	IncrementFreeCallCount(transaction.FreeCallUser())
-	err := transaction.service.storage.Put(
-		transaction.freeCallUserKey,
-		transaction.FreeCallUser(),
-	)
+	// the call is counted on top of the latest data, so the changes made by
+	// 'snetd freecall' commands while the call is in progress are kept
+	user, err := transaction.service.storage.Update(transaction.freeCallUserKey, transaction.FreeCallUser(),
+		func(user *FreeCallUserData) {
+			IncrementFreeCallCount(user)
+			if transaction.granted {
+				user.FreeCallsGranted = max(user.FreeCallsGranted-1, 0)
+			}
+			transaction.quotas.Record(user, transaction.payment.Method)
+		})
 	if err != nil {
 		zap.L().Error("Unable to store new transaction free call user state")
 		return NewPaymentError(Internal, "unable to store new transaction free call user state")
 	}
+	transaction.freeCallUser = user
 
 	zap.L().Debug("Free Call Payment completed")
 	return nil
@@ -29,6 +29,10 @@ type FreeCallPayment struct {
 
 	// Token expiration date in blocks
 	AuthTokenExpiryBlockNumber *big.Int
+
+	// Method is a full gRPC method name called, the quotas of the method are
+	// applied
+	Method string
 }
 
 func (key *FreeCallPayment) String() string {
@@ -56,6 +60,12 @@ type FreeCallUserData struct {
 	OrganizationId string
 	ServiceId      string
 	GroupID        string
+	// Quotas keeps the calls counted by each quota rule by the rule id, see
+	// FreeCallQuotaRule.ID
+	Quotas map[string]*FreeCallQuotaUsage `json:",omitempty"`
+	// FreeCallsGranted is the number of free calls granted by 'snetd freecall
+	// grant' which are left, they are used once the limits are exhausted
+	FreeCallsGranted int `json:",omitempty"`
 }
 
 func (data *FreeCallUserData) String() string {
@@ -0,0 +1,351 @@
+package escrow
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/singnet/snet-daemon/v6/storage"
+)
+
+// FreeCallSignerQuotaConf is a quota rule from free_call_signer_quotas
+// configuration
+type FreeCallSignerQuotaConf struct {
+	// Signer is an address of the trusted free call signer, the rule applies
+	// to each trusted signer if it is empty
+	Signer string
+	// Tokens is the number of free call tokens the signer can get in the
+	// window
+	Tokens int
+	// Window is one of lifetime, daily, weekly, monthly, rolling
+	Window string
+	// Period is a duration of the rolling window, i.e. "1h"
+	Period string
+}
+
+// FreeCallSignerQuotaRule limits the number of free call tokens issued to
+// the trusted signer in the window
+type FreeCallSignerQuotaRule struct {
+	// Signer is an address of the trusted free call signer, the rule applies
+	// to each trusted signer if it is empty
+	Signer string
+	Tokens int
+	freeCallWindow
+}
+
+// ID identifies the usage of the rule in FreeCallSignerIssuance.Quotas
+func (rule *FreeCallSignerQuotaRule) ID() string {
+	return rule.Signer + "/" + rule.id()
+}
+
+func (rule *FreeCallSignerQuotaRule) String() string {
+	if rule.Window == FreeCallWindowRolling {
+		return fmt.Sprintf("%v tokens per %v", rule.Tokens, rule.Period)
+	}
+	return fmt.Sprintf("%v %v tokens", rule.Tokens, rule.Window)
+}
+
+func (rule *FreeCallSignerQuotaRule) appliesTo(signer string) bool {
+	return rule.Signer == "" || rule.Signer == signer
+}
+
+// FreeCallSignerQuotaState is the state of the quota rule of the trusted
+// signer
+type FreeCallSignerQuotaState struct {
+	Rule      FreeCallSignerQuotaRule
+	Available int
+	ResetsAt  time.Time
+}
+
+// FreeCallSignerQuotas limits the number of free call tokens issued to each
+// trusted signer. The nil quotas don't limit the tokens.
+type FreeCallSignerQuotas struct {
+	rules []FreeCallSignerQuotaRule
+	now   func() time.Time
+}
+
+// NewFreeCallSignerQuotas validates the configured rules and returns the
+// quotas, nil is returned if no rule is configured
+func NewFreeCallSignerQuotas(conf []FreeCallSignerQuotaConf) (quotas *FreeCallSignerQuotas, err error) {
+	if len(conf) == 0 {
+		return nil, nil
+	}
+	quotas = &FreeCallSignerQuotas{rules: make([]FreeCallSignerQuotaRule, 0, len(conf)), now: time.Now}
+	ids := make(map[string]bool)
+	for i, ruleConf := range conf {
+		rule := FreeCallSignerQuotaRule{Tokens: ruleConf.Tokens}
+		if ruleConf.Signer != "" {
+			if !common.IsHexAddress(ruleConf.Signer) {
+				return nil, fmt.Errorf("free call signer quota %v: invalid signer address %v", i, ruleConf.Signer)
+			}
+			rule.Signer = common.HexToAddress(ruleConf.Signer).Hex()
+		}
+		if rule.Tokens < 0 {
+			return nil, fmt.Errorf("free call signer quota %v: tokens should be >= 0, got %v", i, rule.Tokens)
+		}
+		if rule.freeCallWindow, err = newFreeCallWindow(ruleConf.Window, ruleConf.Period); err != nil {
+			return nil, fmt.Errorf("free call signer quota %v: %v", i, err)
+		}
+		if ids[rule.ID()] {
+			return nil, fmt.Errorf("free call signer quota %v: duplicate rule %v", i, rule.ID())
+		}
+		ids[rule.ID()] = true
+		quotas.rules = append(quotas.rules, rule)
+	}
+	return quotas, nil
+}
+
+// Check returns an error if a quota applied to the signer is exhausted
+func (signerQuotas *FreeCallSignerQuotas) Check(issuance *FreeCallSignerIssuance) error {
+	if signerQuotas == nil {
+		return nil
+	}
+	now := signerQuotas.now()
+	for _, rule := range signerQuotas.rules {
+		if !rule.appliesTo(issuance.Signer) {
+			continue
+		}
+		if used := rule.used(issuance.Quotas[rule.ID()], now); used >= rule.Tokens {
+			return NewPaymentError(ResourceExhausted, "free call token quota of %v has been exceeded, tokens issued = %v",
+				rule.String(), used)
+		}
+	}
+	return nil
+}
+
+// Record counts the token issued in each quota applied to the signer
+func (signerQuotas *FreeCallSignerQuotas) Record(issuance *FreeCallSignerIssuance) {
+	issuance.TokensIssued++
+	signerQuotas.update(issuance, func(rule *FreeCallSignerQuotaRule, usage *FreeCallQuotaUsage, now time.Time) *FreeCallQuotaUsage {
+		return rule.record(usage, now)
+	})
+}
+
+// Release removes the token which was not issued from each quota applied to
+// the signer
+func (signerQuotas *FreeCallSignerQuotas) Release(issuance *FreeCallSignerIssuance) {
+	issuance.TokensIssued = max(issuance.TokensIssued-1, 0)
+	signerQuotas.update(issuance, func(rule *FreeCallSignerQuotaRule, usage *FreeCallQuotaUsage, now time.Time) *FreeCallQuotaUsage {
+		return rule.release(usage, now)
+	})
+}
+
+// update replaces the usage of each quota applied to the signer
+func (signerQuotas *FreeCallSignerQuotas) update(issuance *FreeCallSignerIssuance,
+	update func(rule *FreeCallSignerQuotaRule, usage *FreeCallQuotaUsage, now time.Time) *FreeCallQuotaUsage) {
+	if signerQuotas == nil {
+		return
+	}
+	now := signerQuotas.now()
+	usages := make(map[string]*FreeCallQuotaUsage, len(signerQuotas.rules))
+	for i, rule := range signerQuotas.rules {
+		usage := issuance.Quotas[rule.ID()]
+		if rule.appliesTo(issuance.Signer) {
+			usage = update(&signerQuotas.rules[i], usage, now)
+		}
+		if usage != nil {
+			usages[rule.ID()] = usage
+		}
+	}
+	// the usage of the rules removed from configuration is dropped
+	issuance.Quotas = usages
+}
+
+// States returns the state of each quota applied to the signer
+func (signerQuotas *FreeCallSignerQuotas) States(issuance *FreeCallSignerIssuance) []FreeCallSignerQuotaState {
+	if signerQuotas == nil {
+		return nil
+	}
+	now := signerQuotas.now()
+	states := make([]FreeCallSignerQuotaState, 0, len(signerQuotas.rules))
+	for _, rule := range signerQuotas.rules {
+		if !rule.appliesTo(issuance.Signer) {
+			continue
+		}
+		usage := issuance.Quotas[rule.ID()]
+		state := FreeCallSignerQuotaState{Rule: rule, Available: max(rule.Tokens-rule.used(usage, now), 0)}
+		if state.Available < rule.Tokens {
+			state.ResetsAt = rule.resetsAt(usage, now)
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// FreeCallSignerIssuance counts the free call tokens issued to the trusted
+// signer
+type FreeCallSignerIssuance struct {
+	Signer       string
+	TokensIssued int
+	// Quotas is the usage of free_call_signer_quotas by the rule id
+	Quotas map[string]*FreeCallQuotaUsage
+}
+
+func (issuance *FreeCallSignerIssuance) String() string {
+	return fmt.Sprintf("{Signer:%v,TokensIssued:%v}", issuance.Signer, issuance.TokensIssued)
+}
+
+// FreeCallTokenRecord is an audit record of the free call token issued to
+// the trusted signer, the token itself is not kept, it is identified by
+// FreeCallTokenID
+type FreeCallTokenRecord struct {
+	ID              string
+	Signer          string
+	UserID          string
+	IssuedAt        time.Time
+	ExpirationBlock *big.Int
+}
+
+func (record *FreeCallTokenRecord) String() string {
+	return fmt.Sprintf("{ID:%v,Signer:%v,UserID:%v,IssuedAt:%v,ExpirationBlock:%v}",
+		record.ID, record.Signer, record.UserID, record.IssuedAt, record.ExpirationBlock)
+}
+
+// FreeCallIssuanceStorage keeps the number of tokens issued to each trusted
+// signer and the audit records of the tokens
+type FreeCallIssuanceStorage struct {
+	signers storage.TypedAtomicStorage
+	records storage.TypedAtomicStorage
+	quotas  *FreeCallSignerQuotas
+}
+
+// NewFreeCallIssuanceStorage returns new instance of FreeCallIssuanceStorage,
+// quotas can be nil
+func NewFreeCallIssuanceStorage(atomicStorage storage.AtomicStorage, quotas *FreeCallSignerQuotas) *FreeCallIssuanceStorage {
+	return &FreeCallIssuanceStorage{
+		signers: storage.NewTypedAtomicStorageImpl(
+			storage.NewPrefixedAtomicStorage(atomicStorage, "/free-call-user/issuance"),
+			serializeFreeCallStringKey, reflect.TypeOf(""),
+			serialize, deserialize, reflect.TypeOf(FreeCallSignerIssuance{})),
+		records: storage.NewTypedAtomicStorageImpl(
+			storage.NewPrefixedAtomicStorage(atomicStorage, "/free-call-user/tokens"),
+			serializeFreeCallStringKey, reflect.TypeOf(""),
+			serialize, deserialize, reflect.TypeOf(FreeCallTokenRecord{})),
+		quotas: quotas,
+	}
+}
+
+// Quotas returns the quotas applied to the signers, nil if they are not
+// configured
+func (issuance *FreeCallIssuanceStorage) Quotas() *FreeCallSignerQuotas {
+	return issuance.quotas
+}
+
+// Reserve counts the token to be issued to the signer, it returns an error
+// if a quota of the signer is exhausted. The reservation is released by
+// Release if the token is not issued.
+func (issuance *FreeCallIssuanceStorage) Reserve(signer common.Address) error {
+	return issuance.updateSigner(signer, func(next *FreeCallSignerIssuance) error {
+		if err := issuance.quotas.Check(next); err != nil {
+			return err
+		}
+		issuance.quotas.Record(next)
+		return nil
+	})
+}
+
+// Release removes the token reserved by Reserve which is not issued
+func (issuance *FreeCallIssuanceStorage) Release(signer common.Address) error {
+	return issuance.updateSigner(signer, func(next *FreeCallSignerIssuance) error {
+		issuance.quotas.Release(next)
+		return nil
+	})
+}
+
+// updateSigner atomically applies update to the tokens issued to the signer
+func (issuance *FreeCallIssuanceStorage) updateSigner(signer common.Address, update func(next *FreeCallSignerIssuance) error) error {
+	for {
+		prev, ok, err := issuance.signers.Get(signer.Hex())
+		if err != nil {
+			return err
+		}
+		next := &FreeCallSignerIssuance{Signer: signer.Hex()}
+		if ok {
+			current := prev.(*FreeCallSignerIssuance)
+			next.TokensIssued, next.Quotas = current.TokensIssued, current.Quotas
+		}
+		if err = update(next); err != nil {
+			return err
+		}
+		if ok {
+			ok, err = issuance.signers.CompareAndSwap(signer.Hex(), prev, next)
+		} else {
+			ok, err = issuance.signers.PutIfAbsent(signer.Hex(), next)
+		}
+		if err != nil {
+			return err
+		}
+		if ok {
+			return nil
+		}
+	}
+}
+
+// Record keeps the audit record of the token issued
+func (issuance *FreeCallIssuanceStorage) Record(record *FreeCallTokenRecord) error {
+	return issuance.records.Put(record.ID, record)
+}
+
+// Get returns the audit record of the token by id
+func (issuance *FreeCallIssuanceStorage) Get(id string) (record *FreeCallTokenRecord, ok bool, err error) {
+	value, ok, err := issuance.records.Get(id)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	return value.(*FreeCallTokenRecord), true, nil
+}
+
+// Records returns the audit records of the tokens issued to the signer, the
+// records of all signers are returned if signer is empty. The records are
+// kept for FreeCallTokenLifetime blocks after the token is expired, they are
+// removed when currentBlock is passed.
+func (issuance *FreeCallIssuanceStorage) Records(signer string, currentBlock *big.Int) (records []*FreeCallTokenRecord, err error) {
+	values, err := issuance.records.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	records = make([]*FreeCallTokenRecord, 0)
+	for _, record := range values.([]*FreeCallTokenRecord) {
+		if currentBlock != nil &&
+			new(big.Int).Add(record.ExpirationBlock, big.NewInt(FreeCallTokenLifetime)).Cmp(currentBlock) < 0 {
+			if err = issuance.records.Delete(record.ID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if signer == "" || strings.EqualFold(record.Signer, signer) {
+			records = append(records, record)
+		}
+	}
+	slices.SortFunc(records, func(a, b *FreeCallTokenRecord) int {
+		if c := a.IssuedAt.Compare(b.IssuedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return records, nil
+}
+
+// Signers returns the number of tokens issued to the signer, the numbers of
+// all signers are returned if signer is empty
+func (issuance *FreeCallIssuanceStorage) Signers(signer string) (signers []*FreeCallSignerIssuance, err error) {
+	values, err := issuance.signers.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	signers = make([]*FreeCallSignerIssuance, 0)
+	for _, value := range values.([]*FreeCallSignerIssuance) {
+		if signer == "" || strings.EqualFold(value.Signer, signer) {
+			signers = append(signers, value)
+		}
+	}
+	slices.SortFunc(signers, func(a, b *FreeCallSignerIssuance) int {
+		return strings.Compare(a.Signer, b.Signer)
+	})
+	return signers, nil
+}
@@ -22,15 +22,17 @@ type freeCallPaymentHandler struct {
 	serviceMetadata          *blockchain.ServiceMetadata
 }
 
+// FreeCallPaymentHandler returns the handler which rejects the free calls
+// revoked, revocations are not checked if they are nil
 func FreeCallPaymentHandler(
 	freeCallService FreeCallUserService, processor blockchain.Processor, metadata *blockchain.OrganizationMetaData,
-	pServiceMetaData *blockchain.ServiceMetadata) handler.StreamPaymentHandler {
+	pServiceMetaData *blockchain.ServiceMetadata, revocations *FreeCallRevocationStorage) handler.StreamPaymentHandler {
 	return &freeCallPaymentHandler{
 		service:         freeCallService,
 		orgMetadata:     metadata,
 		serviceMetadata: pServiceMetaData,
 		freeCallPaymentValidator: NewFreeCallPaymentValidator(processor.CurrentBlock,
-			pServiceMetaData.FreeCallSignerAddress(), nil, config.GetTrustedFreeCallSignersAddresses()),
+			pServiceMetaData.FreeCallSignerAddress(), nil, config.GetTrustedFreeCallSignersAddresses(), revocations),
 	}
 }
 
@@ -87,7 +89,13 @@ func (h *freeCallPaymentHandler) getPaymentFromContext(context *handler.GrpcStre
 		return nil, handler.NewGrpcErrorf(codes.InvalidArgument, "invalid token: %v", err2)
 	}
 
+	method := ""
+	if context.Info != nil {
+		method = context.Info.FullMethod
+	}
+
 	return &FreeCallPayment{
+		Method:                     method,
 		OrganizationId:             config.GetString(config.OrganizationId),
 		ServiceId:                  config.GetString(config.ServiceId),
 		UserID:                     userID,
@@ -72,8 +72,8 @@ func (suite *FreeCallPaymentHandlerTestSuite) SetupSuite() {
 		serviceMetadata: suite.metadata,
 		freeCallPaymentValidator: NewFreeCallPaymentValidator(func() (*big.Int, error) {
 			return big.NewInt(99), nil
-		}, crypto.PubkeyToAddress(suite.ownerPrivateKey.PublicKey), suite.ownerPrivateKey, []common.Address{}),
-		service: NewFreeCallUserService(suite.storage, NewEtcdLocker(suite.memoryStorage), func() ([32]byte, error) { return suite.orgMetadata.GetGroupId(), nil }, suite.metadata),
+		}, crypto.PubkeyToAddress(suite.ownerPrivateKey.PublicKey), suite.ownerPrivateKey, []common.Address{}, nil),
+		service: NewFreeCallUserService(suite.storage, NewEtcdLocker(suite.memoryStorage), func() ([32]byte, error) { return suite.orgMetadata.GetGroupId(), nil }, suite.metadata, nil),
 	}
 }
 
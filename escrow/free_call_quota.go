@@ -0,0 +1,347 @@
+package escrow
+
+import (
+	"fmt"
+	"slices"
+	"time"
+)
+
+// FreeCallQuotaWindow defines when the free calls counted by the quota are
+// available again
+type FreeCallQuotaWindow string
+
+const (
+	// FreeCallWindowLifetime never resets the counter
+	FreeCallWindowLifetime FreeCallQuotaWindow = "lifetime"
+	// FreeCallWindowDaily resets the counter at 00:00 UTC
+	FreeCallWindowDaily FreeCallQuotaWindow = "daily"
+	// FreeCallWindowWeekly resets the counter on Monday 00:00 UTC
+	FreeCallWindowWeekly FreeCallQuotaWindow = "weekly"
+	// FreeCallWindowMonthly resets the counter on the first day of the month
+	// 00:00 UTC
+	FreeCallWindowMonthly FreeCallQuotaWindow = "monthly"
+	// FreeCallWindowRolling counts the calls made during the last period
+	FreeCallWindowRolling FreeCallQuotaWindow = "rolling"
+)
+
+// FreeCallQuotaConf is a quota rule from free_call_quotas configuration
+type FreeCallQuotaConf struct {
+	// Method is a full gRPC method name, the rule applies to all methods if
+	// it is empty
+	Method string
+	// Calls is the number of free calls allowed in the window
+	Calls int
+	// Window is one of lifetime, daily, weekly, monthly, rolling
+	Window string
+	// Period is a duration of the rolling window, i.e. "1h"
+	Period string
+}
+
+// freeCallWindow counts the calls limited by the quota rule in the window
+type freeCallWindow struct {
+	Window FreeCallQuotaWindow
+	// Period is a duration of the rolling window
+	Period time.Duration
+}
+
+// newFreeCallWindow validates the configured window
+func newFreeCallWindow(window string, period string) (freeCallWindow, error) {
+	result := freeCallWindow{Window: FreeCallQuotaWindow(window)}
+	switch result.Window {
+	case FreeCallWindowLifetime, FreeCallWindowDaily, FreeCallWindowWeekly, FreeCallWindowMonthly:
+		if period != "" {
+			return result, fmt.Errorf("period is allowed for %v window only", FreeCallWindowRolling)
+		}
+	case FreeCallWindowRolling:
+		var err error
+		if result.Period, err = time.ParseDuration(period); err != nil || result.Period <= 0 {
+			return result, fmt.Errorf("invalid period %q of %v window", period, FreeCallWindowRolling)
+		}
+	default:
+		return result, fmt.Errorf("unknown window %q, expected one of '%v','%v','%v','%v','%v'", window,
+			FreeCallWindowLifetime, FreeCallWindowDaily, FreeCallWindowWeekly, FreeCallWindowMonthly, FreeCallWindowRolling)
+	}
+	return result, nil
+}
+
+// id identifies the window in the id of the rule
+func (window *freeCallWindow) id() string {
+	if window.Window == FreeCallWindowRolling {
+		return fmt.Sprintf("%v/%v", window.Window, window.Period)
+	}
+	return string(window.Window)
+}
+
+// windowStart returns the start of the calendar window which contains now
+func (window *freeCallWindow) windowStart(now time.Time) time.Time {
+	now = now.UTC()
+	day := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	switch window.Window {
+	case FreeCallWindowDaily:
+		return day
+	case FreeCallWindowWeekly:
+		return day.AddDate(0, 0, -(int(day.Weekday())+6)%7)
+	case FreeCallWindowMonthly:
+		return time.Date(now.Year(), now.Month(), 1, 0, 0, 0, 0, time.UTC)
+	}
+	return time.Time{}
+}
+
+func (window *freeCallWindow) windowEnd(start time.Time) time.Time {
+	switch window.Window {
+	case FreeCallWindowDaily:
+		return start.AddDate(0, 0, 1)
+	case FreeCallWindowWeekly:
+		return start.AddDate(0, 0, 7)
+	case FreeCallWindowMonthly:
+		return start.AddDate(0, 1, 0)
+	}
+	return time.Time{}
+}
+
+// used returns the number of calls counted in the window which contains now
+func (window *freeCallWindow) used(usage *FreeCallQuotaUsage, now time.Time) int {
+	if usage == nil {
+		return 0
+	}
+	switch window.Window {
+	case FreeCallWindowLifetime:
+		return usage.Calls
+	case FreeCallWindowRolling:
+		since := now.Add(-window.Period)
+		used := 0
+		for _, call := range usage.CallTimes {
+			if call.After(since) {
+				used++
+			}
+		}
+		return used
+	}
+	if usage.WindowStart.Equal(window.windowStart(now)) {
+		return usage.Calls
+	}
+	return 0
+}
+
+// record returns the usage with the call made at now counted
+func (window *freeCallWindow) record(usage *FreeCallQuotaUsage, now time.Time) *FreeCallQuotaUsage {
+	used := window.used(usage, now)
+	switch window.Window {
+	case FreeCallWindowLifetime:
+		return &FreeCallQuotaUsage{Calls: used + 1}
+	case FreeCallWindowRolling:
+		// only the calls within the window are kept, so the list is never
+		// longer than the number of calls allowed
+		since := now.Add(-window.Period)
+		callTimes := make([]time.Time, 0, used+1)
+		for _, call := range usage.GetCallTimes() {
+			if call.After(since) {
+				callTimes = append(callTimes, call)
+			}
+		}
+		callTimes = append(callTimes, now.UTC())
+		return &FreeCallQuotaUsage{Calls: len(callTimes), CallTimes: callTimes}
+	}
+	return &FreeCallQuotaUsage{WindowStart: window.windowStart(now), Calls: used + 1}
+}
+
+// release returns the usage with the last call recorded not counted
+func (window *freeCallWindow) release(usage *FreeCallQuotaUsage, now time.Time) *FreeCallQuotaUsage {
+	used := window.used(usage, now)
+	if used == 0 {
+		return usage
+	}
+	switch window.Window {
+	case FreeCallWindowLifetime:
+		return &FreeCallQuotaUsage{Calls: used - 1}
+	case FreeCallWindowRolling:
+		callTimes := slices.Clone(usage.CallTimes[:len(usage.CallTimes)-1])
+		return &FreeCallQuotaUsage{Calls: len(callTimes), CallTimes: callTimes}
+	}
+	return &FreeCallQuotaUsage{WindowStart: usage.WindowStart, Calls: used - 1}
+}
+
+// resetsAt returns the time when the next call is available again, it is
+// zero if the calls are never reset or no call is counted
+func (window *freeCallWindow) resetsAt(usage *FreeCallQuotaUsage, now time.Time) time.Time {
+	switch window.Window {
+	case FreeCallWindowLifetime:
+		return time.Time{}
+	case FreeCallWindowRolling:
+		since := now.Add(-window.Period)
+		for _, call := range usage.GetCallTimes() {
+			if call.After(since) {
+				return call.Add(window.Period)
+			}
+		}
+		return time.Time{}
+	}
+	return window.windowEnd(window.windowStart(now))
+}
+
+// FreeCallQuotaRule limits the number of free calls of the user made in the
+// window
+type FreeCallQuotaRule struct {
+	Method string
+	Calls  int
+	freeCallWindow
+}
+
+// ID identifies the usage of the rule in FreeCallUserData.Quotas
+func (rule *FreeCallQuotaRule) ID() string {
+	return rule.Method + "/" + rule.id()
+}
+
+func (rule *FreeCallQuotaRule) String() string {
+	method := rule.Method
+	if method == "" {
+		method = "all methods"
+	}
+	if rule.Window == FreeCallWindowRolling {
+		return fmt.Sprintf("%v calls per %v for %v", rule.Calls, rule.Period, method)
+	}
+	return fmt.Sprintf("%v %v calls for %v", rule.Calls, rule.Window, method)
+}
+
+func (rule *FreeCallQuotaRule) appliesTo(method string) bool {
+	return rule.Method == "" || rule.Method == method
+}
+
+// FreeCallQuotaUsage is the number of free calls counted by the quota rule
+type FreeCallQuotaUsage struct {
+	// WindowStart is the start of the calendar window the calls are counted in
+	WindowStart time.Time
+	Calls       int
+	// CallTimes are the times of the calls made during the rolling window
+	CallTimes []time.Time
+}
+
+// GetCallTimes returns nil if usage is nil
+func (usage *FreeCallQuotaUsage) GetCallTimes() []time.Time {
+	if usage == nil {
+		return nil
+	}
+	return usage.CallTimes
+}
+
+// FreeCallQuotaState is the state of the quota rule reported to the user
+type FreeCallQuotaState struct {
+	Rule      FreeCallQuotaRule
+	Available int
+	ResetsAt  time.Time
+}
+
+// FreeCallQuotas applies the quota rules to the free calls of the user. The
+// nil quotas don't limit the calls.
+type FreeCallQuotas struct {
+	rules []FreeCallQuotaRule
+	now   func() time.Time
+}
+
+// NewFreeCallQuotas validates the configured rules and returns the quotas,
+// nil is returned if no rule is configured
+func NewFreeCallQuotas(conf []FreeCallQuotaConf) (quotas *FreeCallQuotas, err error) {
+	if len(conf) == 0 {
+		return nil, nil
+	}
+	quotas = &FreeCallQuotas{rules: make([]FreeCallQuotaRule, 0, len(conf)), now: time.Now}
+	ids := make(map[string]bool)
+	for i, ruleConf := range conf {
+		rule := FreeCallQuotaRule{Method: ruleConf.Method, Calls: ruleConf.Calls}
+		if rule.Calls < 0 {
+			return nil, fmt.Errorf("free call quota %v: calls should be >= 0, got %v", i, rule.Calls)
+		}
+		if rule.freeCallWindow, err = newFreeCallWindow(ruleConf.Window, ruleConf.Period); err != nil {
+			return nil, fmt.Errorf("free call quota %v: %v", i, err)
+		}
+		if ids[rule.ID()] {
+			return nil, fmt.Errorf("free call quota %v: duplicate rule %v", i, rule.ID())
+		}
+		ids[rule.ID()] = true
+		quotas.rules = append(quotas.rules, rule)
+	}
+	return quotas, nil
+}
+
+// Applies returns true if a quota rule is applied to the method, the calls of
+// the other methods are limited by the lifetime limit of the service metadata
+func (quotas *FreeCallQuotas) Applies(method string) bool {
+	if quotas == nil {
+		return false
+	}
+	return slices.ContainsFunc(quotas.rules, func(rule FreeCallQuotaRule) bool {
+		return rule.appliesTo(method)
+	})
+}
+
+// Check returns an error if a quota applied to the method is exhausted
+func (quotas *FreeCallQuotas) Check(user *FreeCallUserData, method string) error {
+	if quotas == nil {
+		return nil
+	}
+	now := quotas.now()
+	for _, rule := range quotas.rules {
+		if !rule.appliesTo(method) {
+			continue
+		}
+		if used := rule.used(user.Quotas[rule.ID()], now); used >= rule.Calls {
+			return NewPaymentError(ResourceExhausted, "free call quota of %v has been exceeded, calls made = %v",
+				rule.String(), used)
+		}
+	}
+	return nil
+}
+
+// Record counts the call of the method in each quota applied to it
+func (quotas *FreeCallQuotas) Record(user *FreeCallUserData, method string) {
+	if quotas == nil {
+		return
+	}
+	now := quotas.now()
+	usages := make(map[string]*FreeCallQuotaUsage, len(quotas.rules))
+	for _, rule := range quotas.rules {
+		usage := user.Quotas[rule.ID()]
+		if rule.appliesTo(method) {
+			usage = rule.record(usage, now)
+		}
+		if usage != nil {
+			usages[rule.ID()] = usage
+		}
+	}
+	// the usage of the rules removed from configuration is dropped
+	user.Quotas = usages
+}
+
+// States returns the state of each quota applied to the method, the quotas
+// applied to all methods are returned if method is empty
+func (quotas *FreeCallQuotas) States(user *FreeCallUserData, method string) []FreeCallQuotaState {
+	if quotas == nil {
+		return nil
+	}
+	now := quotas.now()
+	states := make([]FreeCallQuotaState, 0, len(quotas.rules))
+	for _, rule := range quotas.rules {
+		if !rule.appliesTo(method) {
+			continue
+		}
+		usage := user.Quotas[rule.ID()]
+		state := FreeCallQuotaState{Rule: rule, Available: max(rule.Calls-rule.used(usage, now), 0)}
+		if state.Available < rule.Calls {
+			state.ResetsAt = rule.resetsAt(usage, now)
+		}
+		states = append(states, state)
+	}
+	return states
+}
+
+// Available returns the number of free calls of the method available
+// according to all quotas applied to it, -1 means no quota is applied
+func (quotas *FreeCallQuotas) Available(user *FreeCallUserData, method string) int {
+	states := quotas.States(user, method)
+	if len(states) == 0 {
+		return -1
+	}
+	return slices.MinFunc(states, func(a, b FreeCallQuotaState) int {
+		return a.Available - b.Available
+	}).Available
+}
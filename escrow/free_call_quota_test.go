@@ -0,0 +1,150 @@
+package escrow
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/singnet/snet-daemon/v6/blockchain"
+	"github.com/singnet/snet-daemon/v6/storage"
+)
+
+func newTestFreeCallQuotas(t *testing.T, now *time.Time, conf ...FreeCallQuotaConf) *FreeCallQuotas {
+	quotas, err := NewFreeCallQuotas(conf)
+	require.NoError(t, err)
+	quotas.now = func() time.Time { return *now }
+	return quotas
+}
+
+func TestNewFreeCallQuotas(t *testing.T) {
+	quotas, err := NewFreeCallQuotas(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, quotas)
+
+	_, err = NewFreeCallQuotas([]FreeCallQuotaConf{{Calls: 1, Window: "yearly"}})
+	assert.EqualError(t, err, "free call quota 0: unknown window \"yearly\", expected one of 'lifetime','daily','weekly','monthly','rolling'")
+	_, err = NewFreeCallQuotas([]FreeCallQuotaConf{{Calls: 1, Window: "rolling"}})
+	assert.EqualError(t, err, "free call quota 0: invalid period \"\" of rolling window")
+	_, err = NewFreeCallQuotas([]FreeCallQuotaConf{{Calls: 1, Window: "daily", Period: "1h"}})
+	assert.EqualError(t, err, "free call quota 0: period is allowed for rolling window only")
+	_, err = NewFreeCallQuotas([]FreeCallQuotaConf{{Calls: -1, Window: "daily"}})
+	assert.EqualError(t, err, "free call quota 0: calls should be >= 0, got -1")
+	_, err = NewFreeCallQuotas([]FreeCallQuotaConf{{Calls: 1, Window: "daily"}, {Calls: 2, Window: "daily"}})
+	assert.EqualError(t, err, "free call quota 1: duplicate rule /daily")
+}
+
+func TestFreeCallQuotas_CalendarWindows(t *testing.T) {
+	// Wednesday
+	now := time.Date(2025, 1, 15, 23, 0, 0, 0, time.UTC)
+	quotas := newTestFreeCallQuotas(t, &now,
+		FreeCallQuotaConf{Calls: 2, Window: "daily"},
+		FreeCallQuotaConf{Calls: 3, Window: "weekly"},
+		FreeCallQuotaConf{Calls: 4, Window: "monthly"})
+	user := &FreeCallUserData{}
+
+	quotas.Record(user, "/service/method")
+	quotas.Record(user, "/service/method")
+	assert.Equal(t, NewPaymentError(ResourceExhausted, "free call quota of 2 daily calls for all methods has been exceeded, calls made = 2"),
+		quotas.Check(user, "/service/method"))
+	states := quotas.States(user, "")
+	require.Len(t, states, 3)
+	assert.Equal(t, time.Date(2025, 1, 16, 0, 0, 0, 0, time.UTC), states[0].ResetsAt)
+	assert.Equal(t, time.Date(2025, 1, 20, 0, 0, 0, 0, time.UTC), states[1].ResetsAt)
+	assert.Equal(t, time.Date(2025, 2, 1, 0, 0, 0, 0, time.UTC), states[2].ResetsAt)
+
+	// next day the daily quota is reset, the weekly one is not
+	now = now.Add(2 * time.Hour)
+	assert.NoError(t, quotas.Check(user, "/service/method"))
+	quotas.Record(user, "/service/method")
+	assert.Equal(t, 0, quotas.Available(user, ""))
+	assert.Equal(t, NewPaymentError(ResourceExhausted, "free call quota of 3 weekly calls for all methods has been exceeded, calls made = 3"),
+		quotas.Check(user, "/service/method"))
+
+	// next Monday
+	now = time.Date(2025, 1, 20, 0, 0, 0, 0, time.UTC)
+	assert.Equal(t, 1, quotas.Available(user, ""))
+}
+
+func TestFreeCallQuotas_RollingWindowPerMethod(t *testing.T) {
+	now := time.Date(2025, 1, 15, 12, 0, 0, 0, time.UTC)
+	quotas := newTestFreeCallQuotas(t, &now,
+		FreeCallQuotaConf{Method: "/service/expensive", Calls: 2, Window: "rolling", Period: "1h"},
+		FreeCallQuotaConf{Calls: 10, Window: "lifetime"})
+	user := &FreeCallUserData{}
+
+	quotas.Record(user, "/service/expensive")
+	now = now.Add(30 * time.Minute)
+	quotas.Record(user, "/service/expensive")
+	quotas.Record(user, "/service/cheap")
+
+	assert.Error(t, quotas.Check(user, "/service/expensive"))
+	assert.NoError(t, quotas.Check(user, "/service/cheap"))
+	assert.Equal(t, 7, quotas.Available(user, "/service/cheap"))
+	states := quotas.States(user, "/service/expensive")
+	require.Len(t, states, 2)
+	assert.Equal(t, 0, states[0].Available)
+	assert.Equal(t, time.Date(2025, 1, 15, 13, 0, 0, 0, time.UTC), states[0].ResetsAt)
+	assert.True(t, states[1].ResetsAt.IsZero())
+
+	// the first call leaves the window
+	now = now.Add(31 * time.Minute)
+	assert.NoError(t, quotas.Check(user, "/service/expensive"))
+	quotas.Record(user, "/service/expensive")
+	assert.Len(t, user.Quotas["/service/expensive/rolling/1h0m0s"].CallTimes, 2)
+	assert.Equal(t, 4, user.Quotas["/lifetime"].Calls)
+}
+
+func TestFreeCallQuotas_RemovedRuleUsageIsDropped(t *testing.T) {
+	now := time.Now()
+	user := &FreeCallUserData{Quotas: map[string]*FreeCallQuotaUsage{"/removed/daily": {Calls: 1}}}
+	quotas := newTestFreeCallQuotas(t, &now, FreeCallQuotaConf{Calls: 1, Window: "daily"})
+	quotas.Record(user, "/service/method")
+	assert.Len(t, user.Quotas, 1)
+	assert.Contains(t, user.Quotas, "/daily")
+}
+
+func TestFreeCallUserService_Quotas(t *testing.T) {
+	now := time.Now()
+	quotas := newTestFreeCallQuotas(t, &now, FreeCallQuotaConf{Method: "/service/method", Calls: 1, Window: "daily"})
+	metadata, err := blockchain.InitServiceMetaDataFromJson([]byte(`{"mpe_address": "0x7E6366Fbe3bdfCE3C906667911FC5237Cc96BD08",
+		"groups": [{"group_name": "default_group", "group_id": "88ybRIg2wAx55mqVsA6sB4S7WxPQHNKqa4BPu/bhj+U=", "free_calls": 2,
+		"free_call_signer_address": "0x7DF35C98f41F3Af0df1dc4c7F7D4C19a71Dd059F",
+		"endpoints": ["http://127.0.0.1:8080"], "pricing": [{"price_model": "fixed_price", "price_in_cogs": 1, "default": true}]}]}`))
+	require.NoError(t, err)
+	memoryStorage := storage.NewMemStorage()
+	service := NewFreeCallUserService(NewFreeCallUserStorage(memoryStorage), NewEtcdLocker(memoryStorage),
+		func() ([32]byte, error) { return [32]byte{1}, nil }, metadata, quotas)
+	payment := &FreeCallPayment{Address: "0x1", Method: "/service/method"}
+
+	transaction, err := service.StartFreeCallUserTransaction(payment)
+	require.NoError(t, err)
+	require.NoError(t, transaction.Commit())
+
+	_, err = service.StartFreeCallUserTransaction(payment)
+	assert.Equal(t, NewPaymentError(ResourceExhausted,
+		"free call quota of 1 daily calls for /service/method has been exceeded, calls made = 1"), err)
+
+	// other methods are limited by the lifetime limit of the metadata
+	other := &FreeCallPayment{Address: "0x1", Method: "/service/other"}
+	transaction, err = service.StartFreeCallUserTransaction(other)
+	require.NoError(t, err)
+	require.NoError(t, transaction.Commit())
+	assert.Equal(t, 2, transaction.FreeCallUser().FreeCallsMade)
+	_, err = service.StartFreeCallUserTransaction(other)
+	assert.EqualError(t, err, "free call limit has been exceeded, calls made = 2, total free calls eligible = 2")
+
+	stateService := NewFreeCallStateService(nil, metadata, service, nil, nil, nil, quotas, nil)
+	reply, err := stateService.checkForFreeCalls(payment)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), reply.FreeCallsAvailable)
+	require.Len(t, reply.Quotas, 1)
+	assert.Equal(t, &FreeCallQuota{Method: "/service/method", Window: "daily", CallsAllowed: 1,
+		ResetsAt: quotas.rules[0].windowEnd(quotas.rules[0].windowStart(now)).Unix()}, reply.Quotas[0])
+
+	reply, err = stateService.checkForFreeCalls(other)
+	require.NoError(t, err)
+	assert.Equal(t, uint64(0), reply.FreeCallsAvailable)
+	assert.Empty(t, reply.Quotas)
+}
@@ -0,0 +1,193 @@
+package escrow
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+
+	"github.com/singnet/snet-daemon/v6/storage"
+)
+
+// FreeCallTokenID returns the id of the free call token issued by
+// FreeCallStateService.GetFreeCallToken, the id can be published without
+// disclosing the token
+func FreeCallTokenID(token []byte) string {
+	hash := sha256.Sum256(token)
+	return hex.EncodeToString(hash[:16])
+}
+
+// FreeCallRevocation rejects the free calls made with the token given by
+// TokenID or all free calls of the Address. The calls of the Address made
+// on behalf of any user are rejected if UserID is empty.
+type FreeCallRevocation struct {
+	TokenID string
+	Address string
+	UserID  string
+	Reason  string
+	// RevokedBy is the address of the RPC signer, it is empty if the
+	// revocation is made by snetd command
+	RevokedBy string
+	RevokedAt time.Time
+	// ExpirationBlock is the expiration block of the token revoked if the
+	// token is known, the revocation is removed after the token is expired
+	ExpirationBlock *big.Int
+}
+
+// NewFreeCallRevocation checks that either token id or address is set and
+// returns the revocation
+func NewFreeCallRevocation(tokenID, address, userID, reason string) (*FreeCallRevocation, error) {
+	switch {
+	case tokenID == "" && address == "":
+		return nil, fmt.Errorf("either token id or address should be set")
+	case tokenID != "" && (address != "" || userID != ""):
+		return nil, fmt.Errorf("token id cannot be combined with address or user id")
+	case address != "" && !common.IsHexAddress(address):
+		return nil, fmt.Errorf("invalid address %v", address)
+	}
+	revocation := &FreeCallRevocation{TokenID: tokenID, UserID: userID, Reason: reason}
+	if address != "" {
+		revocation.Address = common.HexToAddress(address).Hex()
+	}
+	return revocation, nil
+}
+
+// Key identifies the revocation in the storage
+func (revocation *FreeCallRevocation) Key() string {
+	if revocation.TokenID != "" {
+		return freeCallTokenRevocationKey(revocation.TokenID)
+	}
+	return freeCallAddressRevocationKey(revocation.Address, revocation.UserID)
+}
+
+func freeCallTokenRevocationKey(tokenID string) string {
+	return "token/" + tokenID
+}
+
+func freeCallAddressRevocationKey(address, userID string) string {
+	return "address/" + common.HexToAddress(address).Hex() + "/" + userID
+}
+
+// Message returns the message the revoked free calls are rejected with
+func (revocation *FreeCallRevocation) Message() string {
+	switch {
+	case revocation.TokenID != "":
+		return fmt.Sprintf("free call token %v is revoked", revocation.TokenID)
+	case revocation.UserID != "":
+		return fmt.Sprintf("free calls of user %v of %v are revoked", revocation.UserID, revocation.Address)
+	}
+	return fmt.Sprintf("free calls of %v are revoked", revocation.Address)
+}
+
+func (revocation *FreeCallRevocation) String() string {
+	return fmt.Sprintf("{TokenID:%v,Address:%v,UserID:%v,Reason:%v,RevokedBy:%v,RevokedAt:%v,ExpirationBlock:%v}",
+		revocation.TokenID, revocation.Address, revocation.UserID, revocation.Reason, revocation.RevokedBy,
+		revocation.RevokedAt, revocation.ExpirationBlock)
+}
+
+// FreeCallRevocationStorage keeps the revocation list checked by
+// FreeCallPaymentValidator
+type FreeCallRevocationStorage struct {
+	storage storage.TypedAtomicStorage
+}
+
+// NewFreeCallRevocationStorage returns new instance of
+// FreeCallRevocationStorage
+func NewFreeCallRevocationStorage(atomicStorage storage.AtomicStorage) *FreeCallRevocationStorage {
+	prefixedStorage := storage.NewPrefixedAtomicStorage(atomicStorage, "/free-call-user/revocations")
+	return &FreeCallRevocationStorage{
+		storage: storage.NewTypedAtomicStorageImpl(prefixedStorage, serializeFreeCallStringKey, reflect.TypeOf(""),
+			serialize, deserialize, reflect.TypeOf(FreeCallRevocation{})),
+	}
+}
+
+func serializeFreeCallStringKey(key any) (serialized string, err error) {
+	return fmt.Sprintf("%v", key), nil
+}
+
+// Revoke records the revocation, the revocation with the same key is
+// replaced
+func (revocations *FreeCallRevocationStorage) Revoke(revocation *FreeCallRevocation) error {
+	revocation.RevokedAt = time.Now().UTC()
+	return revocations.storage.Put(revocation.Key(), revocation)
+}
+
+// Get returns the revocation by key
+func (revocations *FreeCallRevocationStorage) Get(key string) (revocation *FreeCallRevocation, ok bool, err error) {
+	value, ok, err := revocations.storage.Get(key)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	return value.(*FreeCallRevocation), true, nil
+}
+
+// Restore removes the revocation with the same key, it returns the
+// revocation removed
+func (revocations *FreeCallRevocationStorage) Restore(revocation *FreeCallRevocation) (*FreeCallRevocation, error) {
+	removed, ok, err := revocations.Get(revocation.Key())
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, fmt.Errorf("revocation %v is not found", revocation.Key())
+	}
+	return removed, revocations.storage.Delete(revocation.Key())
+}
+
+// Find returns the revocation of the token or of the address and user, nil
+// is returned if the free calls are not revoked
+func (revocations *FreeCallRevocationStorage) Find(tokenID, address, userID string) (*FreeCallRevocation, error) {
+	keys := make([]string, 0, 3)
+	if tokenID != "" {
+		keys = append(keys, freeCallTokenRevocationKey(tokenID))
+	}
+	if address != "" {
+		keys = append(keys, freeCallAddressRevocationKey(address, ""))
+		if userID != "" {
+			keys = append(keys, freeCallAddressRevocationKey(address, userID))
+		}
+	}
+	for _, key := range keys {
+		revocation, ok, err := revocations.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return revocation, nil
+		}
+	}
+	return nil, nil
+}
+
+// List returns all revocations. The revocations of the tokens expired before
+// currentBlock are removed from the storage, nothing is removed if
+// currentBlock is nil.
+func (revocations *FreeCallRevocationStorage) List(currentBlock *big.Int) (list []*FreeCallRevocation, err error) {
+	values, err := revocations.storage.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	list = make([]*FreeCallRevocation, 0)
+	for _, revocation := range values.([]*FreeCallRevocation) {
+		if currentBlock != nil && revocation.ExpirationBlock != nil && revocation.ExpirationBlock.Cmp(currentBlock) < 0 {
+			if err = revocations.storage.Delete(revocation.Key()); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		list = append(list, revocation)
+	}
+	slices.SortFunc(list, func(a, b *FreeCallRevocation) int {
+		if c := a.RevokedAt.Compare(b.RevokedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.Key(), b.Key())
+	})
+	return list, nil
+}
@@ -0,0 +1,201 @@
+package escrow
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/singnet/snet-daemon/v6/blockchain"
+	"github.com/singnet/snet-daemon/v6/config"
+	"github.com/singnet/snet-daemon/v6/storage"
+)
+
+const testFreeCallAddress = "0x7DF35C98f41F3Af0df1dc4c7F7D4C19a71Dd059F"
+
+func TestNewFreeCallRevocation(t *testing.T) {
+	_, err := NewFreeCallRevocation("", "", "", "")
+	assert.EqualError(t, err, "either token id or address should be set")
+	_, err = NewFreeCallRevocation("id", testFreeCallAddress, "", "")
+	assert.EqualError(t, err, "token id cannot be combined with address or user id")
+	_, err = NewFreeCallRevocation("", "0x1x", "", "")
+	assert.EqualError(t, err, "invalid address 0x1x")
+
+	revocation, err := NewFreeCallRevocation("", "0x7df35c98f41f3af0df1dc4c7f7d4c19a71dd059f", "user", "abuse")
+	require.NoError(t, err)
+	assert.Equal(t, testFreeCallAddress, revocation.Address)
+	assert.Equal(t, "address/"+testFreeCallAddress+"/user", revocation.Key())
+	assert.Equal(t, "free calls of user user of "+testFreeCallAddress+" are revoked", revocation.Message())
+}
+
+func TestFreeCallRevocationStorage(t *testing.T) {
+	revocations := NewFreeCallRevocationStorage(storage.NewMemStorage())
+	token := []byte("token_100")
+
+	byToken, err := NewFreeCallRevocation(FreeCallTokenID(token), "", "", "leaked")
+	require.NoError(t, err)
+	byToken.ExpirationBlock = big.NewInt(100)
+	require.NoError(t, revocations.Revoke(byToken))
+	byUser, err := NewFreeCallRevocation("", testFreeCallAddress, "user", "")
+	require.NoError(t, err)
+	require.NoError(t, revocations.Revoke(byUser))
+
+	found, err := revocations.Find(FreeCallTokenID(token), "", "")
+	require.NoError(t, err)
+	assert.Equal(t, byToken.Key(), found.Key())
+	found, err = revocations.Find("", testFreeCallAddress, "user")
+	require.NoError(t, err)
+	assert.Equal(t, byUser.Key(), found.Key())
+	found, err = revocations.Find(FreeCallTokenID([]byte("another")), testFreeCallAddress, "another user")
+	require.NoError(t, err)
+	assert.Nil(t, found)
+
+	byAddress, err := NewFreeCallRevocation("", testFreeCallAddress, "", "")
+	require.NoError(t, err)
+	require.NoError(t, revocations.Revoke(byAddress))
+	found, err = revocations.Find("", testFreeCallAddress, "another user")
+	require.NoError(t, err)
+	assert.Equal(t, byAddress.Key(), found.Key())
+
+	list, err := revocations.List(big.NewInt(100))
+	require.NoError(t, err)
+	assert.Len(t, list, 3)
+	list, err = revocations.List(big.NewInt(101))
+	require.NoError(t, err)
+	assert.Len(t, list, 2, "revocation of expired token should be removed")
+
+	removed, err := revocations.Restore(byAddress)
+	require.NoError(t, err)
+	assert.Equal(t, byAddress.Key(), removed.Key())
+	_, err = revocations.Restore(byAddress)
+	assert.EqualError(t, err, "revocation address/"+testFreeCallAddress+"/ is not found")
+}
+
+func TestFreeCallPaymentValidator_CheckRevocation(t *testing.T) {
+	validator := NewFreeCallPaymentValidator(nil, common.Address{}, nil, nil, nil)
+	assert.NoError(t, validator.CheckRevocation([]byte("token"), testFreeCallAddress, ""))
+
+	revocations := NewFreeCallRevocationStorage(storage.NewMemStorage())
+	validator = NewFreeCallPaymentValidator(nil, common.Address{}, nil, nil, revocations)
+	revocation, err := NewFreeCallRevocation(FreeCallTokenID([]byte("token")), "", "", "")
+	require.NoError(t, err)
+	require.NoError(t, revocations.Revoke(revocation))
+
+	assert.Equal(t, NewPaymentError(Unauthenticated, "free call token %v is revoked", FreeCallTokenID([]byte("token"))),
+		validator.CheckRevocation([]byte("token"), testFreeCallAddress, ""))
+	assert.NoError(t, validator.CheckRevocation([]byte("another token"), testFreeCallAddress, ""))
+}
+
+func TestNewFreeCallSignerQuotas(t *testing.T) {
+	quotas, err := NewFreeCallSignerQuotas(nil)
+	assert.NoError(t, err)
+	assert.Nil(t, quotas)
+
+	_, err = NewFreeCallSignerQuotas([]FreeCallSignerQuotaConf{{Signer: "0x1x", Tokens: 1, Window: "daily"}})
+	assert.EqualError(t, err, "free call signer quota 0: invalid signer address 0x1x")
+	_, err = NewFreeCallSignerQuotas([]FreeCallSignerQuotaConf{{Tokens: 1, Window: "yearly"}})
+	assert.EqualError(t, err, "free call signer quota 0: unknown window \"yearly\", expected one of 'lifetime','daily','weekly','monthly','rolling'")
+}
+
+func TestFreeCallIssuanceStorage(t *testing.T) {
+	now := time.Date(2025, 1, 15, 23, 0, 0, 0, time.UTC)
+	quotas, err := NewFreeCallSignerQuotas([]FreeCallSignerQuotaConf{
+		{Tokens: 3, Window: "lifetime"},
+		{Signer: testFreeCallAddress, Tokens: 1, Window: "daily"},
+	})
+	require.NoError(t, err)
+	quotas.now = func() time.Time { return now }
+	issuance := NewFreeCallIssuanceStorage(storage.NewMemStorage(), quotas)
+	signer := common.HexToAddress(testFreeCallAddress)
+	another := common.HexToAddress("0x0000000000000000000000000000000000000001")
+
+	require.NoError(t, issuance.Reserve(signer))
+	assert.Equal(t, NewPaymentError(ResourceExhausted, "free call token quota of 1 daily tokens has been exceeded, tokens issued = 1"),
+		issuance.Reserve(signer))
+	now = now.Add(time.Hour)
+	require.NoError(t, issuance.Reserve(signer))
+	require.NoError(t, issuance.Reserve(another))
+	require.NoError(t, issuance.Reserve(another))
+	require.NoError(t, issuance.Reserve(another))
+	assert.Equal(t, NewPaymentError(ResourceExhausted, "free call token quota of 3 lifetime tokens has been exceeded, tokens issued = 3"),
+		issuance.Reserve(another), "lifetime quota is counted per signer")
+
+	signers, err := issuance.Signers("")
+	require.NoError(t, err)
+	require.Len(t, signers, 2)
+	assert.Equal(t, another.Hex(), signers[0].Signer)
+	assert.Equal(t, 2, signers[1].TokensIssued)
+	states := quotas.States(signers[1])
+	require.Len(t, states, 2)
+	assert.Equal(t, 1, states[0].Available)
+	assert.Equal(t, 0, states[1].Available)
+	assert.Equal(t, signer.Hex(), states[1].Rule.Signer)
+
+	// the token which is not issued is released
+	require.NoError(t, issuance.Release(another))
+	require.NoError(t, issuance.Reserve(another))
+	assert.Error(t, issuance.Reserve(another))
+
+	require.NoError(t, issuance.Record(&FreeCallTokenRecord{ID: "a", Signer: signer.Hex(), UserID: "user",
+		IssuedAt: now, ExpirationBlock: big.NewInt(10)}))
+	require.NoError(t, issuance.Record(&FreeCallTokenRecord{ID: "b", Signer: another.Hex(),
+		IssuedAt: now, ExpirationBlock: big.NewInt(FreeCallTokenLifetime + 100)}))
+	records, err := issuance.Records(testFreeCallAddress, nil)
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "user", records[0].UserID)
+
+	records, err = issuance.Records("", big.NewInt(FreeCallTokenLifetime+11))
+	require.NoError(t, err)
+	require.Len(t, records, 1)
+	assert.Equal(t, "b", records[0].ID)
+	_, ok, err := issuance.Get("a")
+	require.NoError(t, err)
+	assert.False(t, ok, "record of expired token should be removed")
+}
+
+func TestFreeCallStateService_ReleasesReservationWithoutToken(t *testing.T) {
+	signerKey := GenerateTestPrivateKey()
+	signer := crypto.PubkeyToAddress(signerKey.PublicKey)
+	orgMetadata, err := blockchain.InitOrganizationMetaDataFromJson([]byte(testJsonOrgGroupData))
+	require.NoError(t, err)
+	blocks := 0
+	validator := NewFreeCallPaymentValidator(func() (*big.Int, error) {
+		if blocks++; blocks > 1 {
+			return nil, errors.New("blockchain is unavailable")
+		}
+		return big.NewInt(99), nil
+	}, common.Address{}, GenerateTestPrivateKey(), []common.Address{signer}, nil)
+	quotas, err := NewFreeCallSignerQuotas([]FreeCallSignerQuotaConf{{Tokens: 1, Window: "lifetime"}})
+	require.NoError(t, err)
+	issuance := NewFreeCallIssuanceStorage(storage.NewMemStorage(), quotas)
+	service := &FreeCallStateService{orgMetadata: orgMetadata, freeCallValidator: validator, issuance: issuance}
+
+	userID := "user"
+	request := &GetFreeCallTokenRequest{Address: signer.Hex(), UserId: &userID, CurrentBlock: 99}
+	request.Signature = getSignature(bytes.Join([][]byte{
+		[]byte(FreeCallPrefixSignature),
+		[]byte(signer.Hex()),
+		[]byte(request.GetUserId()),
+		[]byte(config.GetString(config.OrganizationId)),
+		[]byte(config.GetString(config.ServiceId)),
+		[]byte(orgMetadata.GetGroupIdString()),
+		bigIntToBytes(big.NewInt(99)),
+	}, nil), signerKey)
+
+	_, err = service.GetFreeCallToken(context.Background(), request)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	signers, err := issuance.Signers("")
+	require.NoError(t, err)
+	require.Len(t, signers, 1)
+	assert.Equal(t, 0, signers[0].TokensIssued, "reservation is released")
+}
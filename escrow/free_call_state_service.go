@@ -1,17 +1,20 @@
 package escrow
 
 import (
+	"bytes"
 	"context"
 	"encoding/hex"
 	"fmt"
 	"math/big"
 	"slices"
+	"time"
 
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/singnet/snet-daemon/v6/blockchain"
 	"github.com/singnet/snet-daemon/v6/config"
 	"github.com/singnet/snet-daemon/v6/handler"
+	"github.com/singnet/snet-daemon/v6/utils"
 	"go.uber.org/zap"
 	"google.golang.org/grpc/codes"
 )
@@ -23,6 +26,13 @@ type FreeCallStateService struct {
 	freeCallValidator     *FreeCallPaymentValidator
 	tokenInstance         ERC20
 	minBalanceForFreeCall *big.Int // in asi, not aasi
+	// quotas replace the lifetime limit of the service metadata if they are
+	// configured
+	quotas *FreeCallQuotas
+	// issuance limits and records the tokens issued to the trusted signers,
+	// revocations are managed only if it is set
+	issuance    *FreeCallIssuanceStorage
+	revocations *FreeCallRevocationStorage
 }
 
 type ERC20 interface {
@@ -64,11 +74,13 @@ func (service *FreeCallStateService) GetFreeCallToken(ctx context.Context, reque
 		return nil, err
 	}
 
+	if err = service.freeCallValidator.CheckRevocation(nil, request.Address, request.GetUserId()); err != nil {
+		return nil, paymentErrorToGrpcError(err).Err()
+	}
+
 	// If address is not trusted we can't allow user-id in request
-	if !slices.ContainsFunc(service.freeCallValidator.trustedFreeCallSignerAddresses,
-		func(addr common.Address) bool {
-			return *signer == addr
-		}) {
+	trusted := service.freeCallValidator.IsTrustedSigner(*signer)
+	if !trusted {
 		if request.GetUserId() != "" {
 			return nil, fmt.Errorf("your address is not trusted by this service provider, the use of user_id is not allowed")
 		}
@@ -76,33 +88,79 @@ func (service *FreeCallStateService) GetFreeCallToken(ctx context.Context, reque
 		if err != nil {
 			return nil, err
 		}
+	} else if service.issuance != nil {
+		if err = service.issuance.Reserve(*signer); err != nil {
+			return nil, paymentErrorToGrpcError(err).Err()
+		}
 	}
+	reserved := trusted && service.issuance != nil
 
 	token, block := service.freeCallValidator.NewFreeCallToken(request.Address, request.UserId, request.TokenLifetimeInBlocks)
+	if token == nil {
+		if reserved {
+			service.releaseIssuance(*signer)
+		}
+		return nil, handler.NewGrpcErrorf(codes.Unavailable, "unable to create free call token, try again later").Err()
+	}
+	if reserved {
+		err = service.issuance.Record(&FreeCallTokenRecord{
+			ID:              FreeCallTokenID(token),
+			Signer:          signer.Hex(),
+			UserID:          request.GetUserId(),
+			IssuedAt:        time.Now().UTC(),
+			ExpirationBlock: block,
+		})
+		if err != nil {
+			zap.L().Error("unable to record free call token", zap.Error(err))
+			service.releaseIssuance(*signer)
+			return nil, handler.NewGrpcErrorf(codes.Internal, "unable to record free call token").Err()
+		}
+	}
 	return &FreeCallToken{
 		TokenHex:             hex.EncodeToString(token), // string
 		Token:                token,                     // bytes
 		TokenExpirationBlock: block.Uint64(),
+		TokenId:              FreeCallTokenID(token),
 	}, nil
 }
 
+// releaseIssuance releases the token reserved for the signer which is not
+// issued
+func (service *FreeCallStateService) releaseIssuance(signer common.Address) {
+	if err := service.issuance.Release(signer); err != nil {
+		zap.L().Error("unable to release free call token reserved", zap.Error(err))
+	}
+}
+
 func (service *FreeCallStateService) mustEmbedUnimplementedFreeCallStateServiceServer() {
 	//TODO implement me
 	panic("implement me")
 }
 
+// NewFreeCallStateService returns the service which reports the free calls
+// available according to quotas, limits and records the tokens issued to the
+// trusted signers in issuance and manages the revocations checked by
+// validator. quotas and issuance are optional.
 func NewFreeCallStateService(orgMetadata *blockchain.OrganizationMetaData,
 	srvMetaData *blockchain.ServiceMetadata,
 	service FreeCallUserService,
 	validator *FreeCallPaymentValidator,
-	tokenInstance ERC20, minBalanceForFreeCall *big.Int) *FreeCallStateService {
-	return &FreeCallStateService{
+	tokenInstance ERC20, minBalanceForFreeCall *big.Int, quotas *FreeCallQuotas,
+	issuance *FreeCallIssuanceStorage) *FreeCallStateService {
+	stateService := &FreeCallStateService{
 		orgMetadata:           orgMetadata,
 		serviceMetadata:       srvMetaData,
 		freeCallService:       service,
 		freeCallValidator:     validator,
 		minBalanceForFreeCall: minBalanceForFreeCall,
-		tokenInstance:         tokenInstance}
+		tokenInstance:         tokenInstance,
+		quotas:                quotas,
+		issuance:              issuance,
+	}
+	if validator != nil {
+		stateService.revocations = validator.revocations
+	}
+	return stateService
 }
 
 func (service *FreeCallStateService) GetFreeCallsAvailable(context context.Context,
@@ -118,11 +176,7 @@ func (service *FreeCallStateService) GetFreeCallsAvailable(context context.Conte
 		return nil, err
 	}
 
-	availableCalls, err := service.checkForFreeCalls(payment)
-	if err != nil {
-		return &FreeCallStateReply{}, err
-	}
-	return &FreeCallStateReply{FreeCallsAvailable: availableCalls}, nil
+	return service.checkForFreeCalls(payment)
 }
 
 func (service *FreeCallStateService) verify(payment *FreeCallPayment) (err error) {
@@ -132,38 +186,75 @@ func (service *FreeCallStateService) verify(payment *FreeCallPayment) (err error
 	return nil
 }
 
-func (service *FreeCallStateService) checkForFreeCalls(payment *FreeCallPayment) (callsAvailable uint64, err error) {
+func (service *FreeCallStateService) checkForFreeCalls(payment *FreeCallPayment) (reply *FreeCallStateReply, err error) {
 	//Now get the state from etcd for this user, if there are no records, then return the free calls
 	key, err := service.freeCallService.GetFreeCallUserKey(payment)
 	if err != nil {
-		return 0, err
+		return &FreeCallStateReply{}, err
 	}
 	data, ok, err := service.freeCallService.FreeCallUser(key)
 	if err != nil {
-		return 0, err
+		return &FreeCallStateReply{}, err
 	}
 	if !ok {
-		return 0, fmt.Errorf("error in retrieving free call details from storage")
+		return &FreeCallStateReply{}, fmt.Errorf("error in retrieving free call details from storage")
 	}
 
 	freeCallsAllowed := config.GetFreeCallsAllowed(key.Address)
 	if freeCallsAllowed == -1 {
-		return 99999999, nil
+		return &FreeCallStateReply{FreeCallsAvailable: 99999999}, nil
 	}
 
+	// the granted calls are available on top of the limits
+	granted := max(data.FreeCallsGranted, 0)
 	if freeCallsAllowed > 0 {
-		if freeCallsAllowed-data.FreeCallsMade < 0 {
-			return 0, nil
-		}
-		return uint64(freeCallsAllowed - data.FreeCallsMade), err
+		return &FreeCallStateReply{FreeCallsAvailable: uint64(max(freeCallsAllowed-data.FreeCallsMade, 0) + granted)}, err
+	}
+
+	if service.quotas.Applies(payment.Method) {
+		return service.quotaState(data, payment.Method), nil
+	}
+
+	freeCallsAllowed = max(service.serviceMetadata.GetFreeCallsAllowed()-data.FreeCallsMade, 0)
+	return &FreeCallStateReply{FreeCallsAvailable: uint64(freeCallsAllowed + granted)}, nil
+}
+
+func (service *FreeCallStateService) quotaState(data *FreeCallUserData, method string) *FreeCallStateReply {
+	reply := &FreeCallStateReply{FreeCallsAvailable: 99999999}
+	if available := service.quotas.Available(data, method); available != -1 {
+		reply.FreeCallsAvailable = uint64(available + max(data.FreeCallsGranted, 0))
+	}
+	for _, state := range service.quotas.States(data, method) {
+		reply.Quotas = append(reply.Quotas, freeCallQuota(state))
 	}
+	return reply
+}
 
-	freeCallsAllowed = service.serviceMetadata.GetFreeCallsAllowed() - data.FreeCallsMade
-	if freeCallsAllowed < 0 {
-		return 0, nil
+func freeCallQuota(state FreeCallQuotaState) *FreeCallQuota {
+	quota := &FreeCallQuota{
+		Method:         state.Rule.Method,
+		Window:         string(state.Rule.Window),
+		Period:         int64(state.Rule.Period.Seconds()),
+		CallsAllowed:   uint64(state.Rule.Calls),
+		CallsAvailable: uint64(state.Available),
+	}
+	if !state.ResetsAt.IsZero() {
+		quota.ResetsAt = state.ResetsAt.Unix()
 	}
+	return quota
+}
 
-	return uint64(freeCallsAllowed), nil
+func freeCallSignerQuota(state FreeCallSignerQuotaState) *FreeCallQuota {
+	quota := &FreeCallQuota{
+		Window:         string(state.Rule.Window),
+		Period:         int64(state.Rule.Period.Seconds()),
+		CallsAllowed:   uint64(state.Rule.Tokens),
+		CallsAvailable: uint64(state.Available),
+	}
+	if !state.ResetsAt.IsZero() {
+		quota.ResetsAt = state.ResetsAt.Unix()
+	}
+	return quota
 }
 
 func (service *FreeCallStateService) getFreeCallPayment(request *FreeCallStateRequest) (*FreeCallPayment, error) {
@@ -182,9 +273,209 @@ func (service *FreeCallStateService) getFreeCallPayment(request *FreeCallStateRe
 		AuthToken:                  request.GetFreeCallToken(),
 		AuthTokenParsed:            parsedToken,
 		AuthTokenExpiryBlockNumber: block,
+		Method:                     request.GetMethod(),
 	}, nil
 }
 
+const (
+	RevokeFreeCallsPrefixSignature         = "__revoke_free_calls"
+	RestoreFreeCallsPrefixSignature        = "__restore_free_calls"
+	ListFreeCallRevocationsPrefixSignature = "__list_free_call_revocations"
+	ListFreeCallTokensPrefixSignature      = "__list_free_call_tokens"
+)
+
+// verifyControlRequest returns the signer of the request and true if the
+// signer is the provider, only the provider and the trusted signers are
+// allowed
+func (service *FreeCallStateService) verifyControlRequest(prefix string, params []string, currentBlock uint64,
+	signature []byte) (signer *common.Address, provider bool, err error) {
+	if service.issuance == nil || service.revocations == nil {
+		return nil, false, handler.NewGrpcErrorf(codes.Unimplemented, "free call revocations are not enabled").Err()
+	}
+	message := [][]byte{
+		[]byte(prefix),
+		[]byte(config.GetString(config.OrganizationId)),
+		[]byte(config.GetString(config.ServiceId)),
+		[]byte(service.orgMetadata.GetGroupIdString()),
+	}
+	for _, param := range params {
+		message = append(message, []byte(param))
+	}
+	message = append(message, bigIntToBytes(new(big.Int).SetUint64(currentBlock)))
+	signer, err = utils.GetSignerAddressFromMessage(bytes.Join(message, nil), signature)
+	if err != nil {
+		return nil, false, handler.NewGrpcErrorf(codes.Unauthenticated, "incorrect signature").Err()
+	}
+	if err = service.freeCallValidator.compareWithLatestBlockNumber(new(big.Int).SetUint64(currentBlock)); err != nil {
+		return nil, false, handler.NewGrpcErrorf(codes.Unauthenticated, "%v", err).Err()
+	}
+	if *signer == service.orgMetadata.GetPaymentAddress() {
+		return signer, true, nil
+	}
+	if !service.freeCallValidator.IsTrustedSigner(*signer) {
+		return nil, false, handler.NewGrpcErrorf(codes.PermissionDenied,
+			"only service provider or trusted signer can manage free calls").Err()
+	}
+	return signer, false, nil
+}
+
+// checkRevocationAllowed returns an error if the trusted signer revokes the
+// token not issued to it or the calls of another address
+func (service *FreeCallStateService) checkRevocationAllowed(signer common.Address, revocation *FreeCallRevocation) error {
+	if revocation.TokenID == "" {
+		if common.HexToAddress(revocation.Address) != signer {
+			return handler.NewGrpcErrorf(codes.PermissionDenied, "trusted signer can revoke free calls of its users only").Err()
+		}
+		return nil
+	}
+	record, ok, err := service.issuance.Get(revocation.TokenID)
+	if err != nil {
+		return handler.NewGrpcErrorf(codes.Internal, "unable to read free call token: %v", err).Err()
+	}
+	if !ok || common.HexToAddress(record.Signer) != signer {
+		return handler.NewGrpcErrorf(codes.PermissionDenied, "trusted signer can revoke tokens issued to it only").Err()
+	}
+	return nil
+}
+
+func (service *FreeCallStateService) RevokeFreeCalls(ctx context.Context, request *RevokeFreeCallsRequest) (*FreeCallRevocationsReply, error) {
+	signer, provider, err := service.verifyControlRequest(RevokeFreeCallsPrefixSignature,
+		[]string{request.GetTokenId(), request.GetAddress(), request.GetUserId()}, request.GetCurrentBlock(), request.GetSignature())
+	if err != nil {
+		return nil, err
+	}
+	revocation, err := NewFreeCallRevocation(request.GetTokenId(), request.GetAddress(), request.GetUserId(), request.GetReason())
+	if err != nil {
+		return nil, handler.NewGrpcErrorf(codes.InvalidArgument, "%v", err).Err()
+	}
+	if !provider {
+		if err = service.checkRevocationAllowed(*signer, revocation); err != nil {
+			return nil, err
+		}
+	}
+	revocation.RevokedBy = signer.Hex()
+	if revocation.TokenID != "" {
+		record, ok, err := service.issuance.Get(revocation.TokenID)
+		if err != nil {
+			return nil, handler.NewGrpcErrorf(codes.Internal, "unable to read free call token: %v", err).Err()
+		}
+		if ok {
+			revocation.ExpirationBlock = record.ExpirationBlock
+		}
+	}
+	if err = service.revocations.Revoke(revocation); err != nil {
+		return nil, handler.NewGrpcErrorf(codes.Internal, "unable to revoke free calls: %v", err).Err()
+	}
+	zap.L().Info("Free calls revoked", zap.Stringer("revocation", revocation))
+	return freeCallRevocationsReply([]*FreeCallRevocation{revocation}), nil
+}
+
+func (service *FreeCallStateService) RestoreFreeCalls(ctx context.Context, request *RevokeFreeCallsRequest) (*FreeCallRevocationsReply, error) {
+	signer, provider, err := service.verifyControlRequest(RestoreFreeCallsPrefixSignature,
+		[]string{request.GetTokenId(), request.GetAddress(), request.GetUserId()}, request.GetCurrentBlock(), request.GetSignature())
+	if err != nil {
+		return nil, err
+	}
+	revocation, err := NewFreeCallRevocation(request.GetTokenId(), request.GetAddress(), request.GetUserId(), "")
+	if err != nil {
+		return nil, handler.NewGrpcErrorf(codes.InvalidArgument, "%v", err).Err()
+	}
+	if !provider {
+		if err = service.checkRevocationAllowed(*signer, revocation); err != nil {
+			return nil, err
+		}
+	}
+	removed, err := service.revocations.Restore(revocation)
+	if err != nil {
+		return nil, handler.NewGrpcErrorf(codes.NotFound, "%v", err).Err()
+	}
+	zap.L().Info("Free calls restored", zap.Stringer("revocation", removed))
+	return freeCallRevocationsReply([]*FreeCallRevocation{removed}), nil
+}
+
+func (service *FreeCallStateService) ListFreeCallRevocations(ctx context.Context, request *FreeCallControlRequest) (*FreeCallRevocationsReply, error) {
+	signer, provider, err := service.verifyControlRequest(ListFreeCallRevocationsPrefixSignature,
+		[]string{request.GetSigner()}, request.GetCurrentBlock(), request.GetSignature())
+	if err != nil {
+		return nil, err
+	}
+	currentBlock, err := service.freeCallValidator.currentBlock()
+	if err != nil {
+		return nil, handler.NewGrpcErrorf(codes.Internal, "unable to read current block: %v", err).Err()
+	}
+	revocations, err := service.revocations.List(currentBlock)
+	if err != nil {
+		return nil, handler.NewGrpcErrorf(codes.Internal, "unable to list free call revocations: %v", err).Err()
+	}
+	if !provider {
+		revocations = slices.DeleteFunc(revocations, func(revocation *FreeCallRevocation) bool {
+			return revocation.RevokedBy != signer.Hex()
+		})
+	}
+	return freeCallRevocationsReply(revocations), nil
+}
+
+func (service *FreeCallStateService) ListFreeCallTokens(ctx context.Context, request *FreeCallControlRequest) (*FreeCallTokensReply, error) {
+	signer, provider, err := service.verifyControlRequest(ListFreeCallTokensPrefixSignature,
+		[]string{request.GetSigner()}, request.GetCurrentBlock(), request.GetSignature())
+	if err != nil {
+		return nil, err
+	}
+	filter := request.GetSigner()
+	if !provider {
+		filter = signer.Hex()
+	}
+	currentBlock, err := service.freeCallValidator.currentBlock()
+	if err != nil {
+		return nil, handler.NewGrpcErrorf(codes.Internal, "unable to read current block: %v", err).Err()
+	}
+	records, err := service.issuance.Records(filter, currentBlock)
+	if err != nil {
+		return nil, handler.NewGrpcErrorf(codes.Internal, "unable to list free call tokens: %v", err).Err()
+	}
+	signers, err := service.issuance.Signers(filter)
+	if err != nil {
+		return nil, handler.NewGrpcErrorf(codes.Internal, "unable to list free call signers: %v", err).Err()
+	}
+
+	reply := &FreeCallTokensReply{
+		Tokens:  make([]*FreeCallTokenInfo, 0, len(records)),
+		Signers: make([]*FreeCallSignerInfo, 0, len(signers)),
+	}
+	for _, record := range records {
+		reply.Tokens = append(reply.Tokens, &FreeCallTokenInfo{
+			TokenId:              record.ID,
+			Signer:               record.Signer,
+			UserId:               record.UserID,
+			IssuedAt:             record.IssuedAt.Unix(),
+			TokenExpirationBlock: record.ExpirationBlock.Uint64(),
+		})
+	}
+	for _, issuance := range signers {
+		signerIssuance := &FreeCallSignerInfo{Signer: issuance.Signer, TokensIssued: uint64(issuance.TokensIssued)}
+		for _, state := range service.issuance.Quotas().States(issuance) {
+			signerIssuance.Quotas = append(signerIssuance.Quotas, freeCallSignerQuota(state))
+		}
+		reply.Signers = append(reply.Signers, signerIssuance)
+	}
+	return reply, nil
+}
+
+func freeCallRevocationsReply(revocations []*FreeCallRevocation) *FreeCallRevocationsReply {
+	reply := &FreeCallRevocationsReply{Revocations: make([]*FreeCallRevocationInfo, 0, len(revocations))}
+	for _, revocation := range revocations {
+		reply.Revocations = append(reply.Revocations, &FreeCallRevocationInfo{
+			TokenId:   revocation.TokenID,
+			Address:   revocation.Address,
+			UserId:    revocation.UserID,
+			Reason:    revocation.Reason,
+			RevokedBy: revocation.RevokedBy,
+			RevokedAt: revocation.RevokedAt.Unix(),
+		})
+	}
+	return reply
+}
+
 type BlockChainDisabledFreeCallStateService struct {
 }
 
@@ -200,3 +491,19 @@ func (service *BlockChainDisabledFreeCallStateService) mustEmbedUnimplementedFre
 func (service *BlockChainDisabledFreeCallStateService) GetFreeCallsAvailable(context.Context, *FreeCallStateRequest) (*FreeCallStateReply, error) {
 	return &FreeCallStateReply{FreeCallsAvailable: 0}, fmt.Errorf("error in determining free calls because blockchain is disabled, contact service provider")
 }
+
+func (service *BlockChainDisabledFreeCallStateService) RevokeFreeCalls(context.Context, *RevokeFreeCallsRequest) (*FreeCallRevocationsReply, error) {
+	return nil, fmt.Errorf("error in revoking free calls because blockchain is disabled, contact service provider")
+}
+
+func (service *BlockChainDisabledFreeCallStateService) RestoreFreeCalls(context.Context, *RevokeFreeCallsRequest) (*FreeCallRevocationsReply, error) {
+	return nil, fmt.Errorf("error in restoring free calls because blockchain is disabled, contact service provider")
+}
+
+func (service *BlockChainDisabledFreeCallStateService) ListFreeCallRevocations(context.Context, *FreeCallControlRequest) (*FreeCallRevocationsReply, error) {
+	return nil, fmt.Errorf("error in listing free call revocations because blockchain is disabled, contact service provider")
+}
+
+func (service *BlockChainDisabledFreeCallStateService) ListFreeCallTokens(context.Context, *FreeCallControlRequest) (*FreeCallTokensReply, error) {
+	return nil, fmt.Errorf("error in listing free call tokens because blockchain is disabled, contact service provider")
+}
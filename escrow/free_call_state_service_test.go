@@ -75,9 +75,10 @@ func (suite *FreeCallStateServiceSuite) SetupSuite() {
 	suite.storage = NewFreeCallUserStorage(suite.memoryStorage)
 	suite.service = NewFreeCallUserService(suite.storage,
 		NewEtcdLocker(suite.memoryStorage), func() ([32]byte, error) { return suite.orgMetaData.GetGroupId(), nil },
-		suite.serviceMetaData)
+		suite.serviceMetaData, nil)
 	erc20 := MockedERC20{}
-	suite.stateService = NewFreeCallStateService(suite.orgMetaData, suite.serviceMetaData, suite.service, suite.freeCallPaymentValidator, erc20, big.NewInt(1))
+	suite.stateService = NewFreeCallStateService(suite.orgMetaData, suite.serviceMetaData, suite.service, suite.freeCallPaymentValidator, erc20, big.NewInt(1),
+		nil, nil)
 }
 
 func (suite *FreeCallStateServiceSuite) TestGetFreeCallsAvailable() {
@@ -65,3 +65,36 @@ func (storage *FreeCallUserStorage) PutIfAbsent(key *FreeCallUserKey, state *Fre
 func (storage *FreeCallUserStorage) CompareAndSwap(key *FreeCallUserKey, prevState *FreeCallUserData, newState *FreeCallUserData) (ok bool, err error) {
 	return storage.delegate.CompareAndSwap(key, prevState, newState)
 }
+
+// Update applies the change to a copy of the stored user data and stores it
+// with compare-and-swap, the change is applied again if the data is modified
+// concurrently. The change gets a copy of initial if the user is not stored
+// yet. The change must not modify the maps of the data in place.
+func (storage *FreeCallUserStorage) Update(key *FreeCallUserKey, initial *FreeCallUserData,
+	change func(user *FreeCallUserData)) (updated *FreeCallUserData, err error) {
+	for {
+		prev, ok, err := storage.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			next := *prev
+			updated = &next
+		} else {
+			next := *initial
+			updated = &next
+		}
+		change(updated)
+		if ok {
+			ok, err = storage.CompareAndSwap(key, prev, updated)
+		} else {
+			ok, err = storage.PutIfAbsent(key, updated)
+		}
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return updated, nil
+		}
+	}
+}
@@ -1,6 +1,7 @@
 package escrow
 
 import (
+	"strings"
 	"testing"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -43,7 +44,7 @@ func (suite *FreeCallServiceSuite) SetupSuite() {
 	suite.storage = NewFreeCallUserStorage(suite.memoryStorage)
 	suite.service = NewFreeCallUserService(suite.storage,
 		NewEtcdLocker(suite.memoryStorage), func() ([32]byte, error) { return suite.groupId, nil },
-		suite.metadata)
+		suite.metadata, nil)
 
 	ecdsa, err := crypto.HexToECDSA("aeaa9fb59c0dd868260af55ea65be077dbcaa063c067dfc0865845a0af5de84c")
 	assert.Nil(suite.T(), err)
@@ -70,6 +71,22 @@ func (suite *FreeCallServiceSuite) payment(addr string) *FreeCallPayment {
 	return payment
 }
 
+func (suite *FreeCallServiceSuite) TestGetFreeCallUserKeyNormalizesAddress() {
+	payment := suite.payment(strings.ToLower(suite.userAddr.Hex()))
+	payment.UserID = "normalized"
+	userKey, err := suite.service.GetFreeCallUserKey(payment)
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), suite.userAddr.Hex(), userKey.Address)
+
+	sentKey := *userKey
+	sentKey.Address = payment.Address
+	err = suite.storage.Put(&sentKey, suite.FreeCallUserData(3))
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	userKey, err = suite.service.GetFreeCallUserKey(payment)
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), payment.Address, userKey.Address, "user stored under the address sent is kept")
+}
+
 func (suite *FreeCallServiceSuite) TestFreeCallUserTransaction() {
 	payment := suite.payment(suite.userAddr.Hex())
 	userKey, err := suite.service.GetFreeCallUserKey(payment)
@@ -99,6 +116,35 @@ func (suite *FreeCallServiceSuite) TestFreeCallUserTransaction() {
 	assert.Equal(suite.T(), "free call limit has been exceeded, calls made = 10, total free calls eligible = 10", errA.Error())
 }
 
+func (suite *FreeCallServiceSuite) TestFreeCallUserTransactionGranted() {
+	payment := suite.payment(suite.userAddr.Hex())
+	userKey, err := suite.service.GetFreeCallUserKey(payment)
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	user := suite.FreeCallUserData(10)
+	user.FreeCallsGranted = 1
+	err = suite.storage.Put(userKey, user)
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+
+	transaction, err := suite.service.StartFreeCallUserTransaction(payment)
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	// the calls granted while the call is in progress are kept
+	_, err = suite.storage.Update(userKey, user, func(user *FreeCallUserData) { user.FreeCallsGranted += 5 })
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	err = transaction.Commit()
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+
+	userAfter, _, err := suite.storage.Get(userKey)
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	assert.Equal(suite.T(), 11, userAfter.FreeCallsMade)
+	assert.Equal(suite.T(), 5, userAfter.FreeCallsGranted)
+
+	userAfter.FreeCallsGranted = 0
+	err = suite.storage.Put(userKey, userAfter)
+	assert.Nil(suite.T(), err, "Unexpected error: %v", err)
+	_, err = suite.service.StartFreeCallUserTransaction(payment)
+	assert.Equal(suite.T(), "free call limit has been exceeded, calls made = 11, total free calls eligible = 10", err.Error())
+}
+
 func (suite *FreeCallServiceSuite) TestFreeCallUserTransactionTestLock() {
 	payment := suite.payment(suite.userAddr.Hex())
 	userKey, err := suite.service.GetFreeCallUserKey(suite.payment(suite.userAddr.Hex()))
@@ -0,0 +1,254 @@
+package escrow
+
+import (
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+
+	"github.com/singnet/snet-daemon/v6/storage"
+)
+
+// IncomeRecord is an increment of the amount authorized on the channel which
+// is committed after the call
+type IncomeRecord struct {
+	ChannelID *big.Int
+	Nonce     *big.Int
+	Sender    common.Address
+	// Method is a full name of the gRPC method called, it is empty when the
+	// method is unknown
+	Method string
+	// Amount is the income of the call in cogs
+	Amount *big.Int
+	// AuthorizedAmount is the amount authorized on the channel after the call
+	AuthorizedAmount *big.Int
+	// Block is the block number the payment was validated against
+	Block     *big.Int
+	Timestamp time.Time
+}
+
+// key returns unique key of the record, the authorized amount is increased
+// by each payment on the channel with the same nonce
+func (record *IncomeRecord) key() string {
+	return fmt.Sprintf("%v/%v/%v", record.ChannelID, record.Nonce, record.AuthorizedAmount)
+}
+
+// IncomeGroupBy is the way the income is aggregated
+type IncomeGroupBy string
+
+const (
+	IncomeGroupByNone    IncomeGroupBy = ""
+	IncomeGroupByDay     IncomeGroupBy = "day"
+	IncomeGroupByMonth   IncomeGroupBy = "month"
+	IncomeGroupBySender  IncomeGroupBy = "sender"
+	IncomeGroupByMethod  IncomeGroupBy = "method"
+	IncomeGroupByChannel IncomeGroupBy = "channel"
+)
+
+// ParseIncomeGroupBy returns IncomeGroupBy by its name
+func ParseIncomeGroupBy(groupBy string) (IncomeGroupBy, error) {
+	switch value := IncomeGroupBy(strings.ToLower(groupBy)); value {
+	case IncomeGroupByNone, IncomeGroupByDay, IncomeGroupByMonth, IncomeGroupBySender, IncomeGroupByMethod, IncomeGroupByChannel:
+		return value, nil
+	default:
+		return "", fmt.Errorf("unknown income grouping %q, expected one of '%v','%v','%v','%v','%v'", groupBy,
+			IncomeGroupByDay, IncomeGroupByMonth, IncomeGroupBySender, IncomeGroupByMethod, IncomeGroupByChannel)
+	}
+}
+
+func (groupBy IncomeGroupBy) key(record *IncomeRecord) string {
+	switch groupBy {
+	case IncomeGroupByDay:
+		return record.Timestamp.UTC().Format(time.DateOnly)
+	case IncomeGroupByMonth:
+		return record.Timestamp.UTC().Format("2006-01")
+	case IncomeGroupBySender:
+		return record.Sender.Hex()
+	case IncomeGroupByMethod:
+		return record.Method
+	case IncomeGroupByChannel:
+		return record.ChannelID.String()
+	default:
+		return "total"
+	}
+}
+
+// IncomeQuery selects the income recorded in [From, To) interval, zero
+// time means the interval is not limited
+type IncomeQuery struct {
+	From    time.Time
+	To      time.Time
+	GroupBy IncomeGroupBy
+}
+
+func (query *IncomeQuery) matches(record *IncomeRecord) bool {
+	return (query.From.IsZero() || !record.Timestamp.Before(query.From)) &&
+		(query.To.IsZero() || record.Timestamp.Before(query.To))
+}
+
+// IncomeSummary is the income aggregated by the key
+type IncomeSummary struct {
+	Key      string
+	Amount   *big.Int
+	Payments int
+}
+
+// IncomeLedger keeps the income of the daemon in the shared storage. The
+// records are kept in the buckets by day, so the income of the interval is
+// read without scanning the whole ledger, and the buckets older than the
+// retention period are removed.
+type IncomeLedger struct {
+	storage   storage.AtomicStorage
+	retention time.Duration
+
+	mutex     sync.Mutex
+	prunedDay string
+}
+
+// NewIncomeLedger returns new instance of IncomeLedger, the records older
+// than retention are removed, zero retention keeps the records forever
+func NewIncomeLedger(atomicStorage storage.AtomicStorage, retention time.Duration) *IncomeLedger {
+	return &IncomeLedger{
+		storage:   storage.NewPrefixedAtomicStorage(atomicStorage, "/income/ledger"),
+		retention: retention,
+	}
+}
+
+func incomeDay(timestamp time.Time) string {
+	return timestamp.UTC().Format(time.DateOnly)
+}
+
+// Record writes the income to the ledger, the record is written once
+func (ledger *IncomeLedger) Record(record *IncomeRecord) (err error) {
+	value, err := serialize(record)
+	if err != nil {
+		return
+	}
+	day := incomeDay(record.Timestamp)
+	if _, err = ledger.storage.PutIfAbsent(day+"/"+record.key(), value); err != nil {
+		zap.L().Error("Unable to write income record", zap.Error(err), zap.String("key", record.key()))
+		return
+	}
+	ledger.pruneOnce(day)
+	return
+}
+
+// pruneOnce removes the expired records in background once a day
+func (ledger *IncomeLedger) pruneOnce(day string) {
+	if ledger.retention <= 0 {
+		return
+	}
+	ledger.mutex.Lock()
+	defer ledger.mutex.Unlock()
+	if ledger.prunedDay >= day {
+		return
+	}
+	ledger.prunedDay = day
+	go func() {
+		if err := ledger.Prune(time.Now().Add(-ledger.retention)); err != nil {
+			zap.L().Error("Unable to remove expired income records", zap.Error(err))
+		}
+	}()
+}
+
+// Prune removes the buckets of the days before the day of the given time
+func (ledger *IncomeLedger) Prune(before time.Time) (err error) {
+	keyValues, err := ledger.storage.GetKeyValuesByKeyPrefix("")
+	if err != nil {
+		return
+	}
+	cutoff := incomeDay(before)
+	for _, keyValue := range keyValues {
+		day, _, _ := strings.Cut(keyValue.Key, "/")
+		if day >= cutoff {
+			continue
+		}
+		if err = ledger.storage.Delete(keyValue.Key); err != nil {
+			return
+		}
+	}
+	return nil
+}
+
+// Records returns the records matched by the query sorted by time
+func (ledger *IncomeLedger) Records(query *IncomeQuery) (records []*IncomeRecord, err error) {
+	values, err := ledger.values(query)
+	if err != nil {
+		return
+	}
+	for _, value := range values {
+		record := &IncomeRecord{}
+		if err = deserialize(value, record); err != nil {
+			return nil, err
+		}
+		if query.matches(record) {
+			records = append(records, record)
+		}
+	}
+	slices.SortFunc(records, func(a, b *IncomeRecord) int {
+		return a.Timestamp.Compare(b.Timestamp)
+	})
+	return records, nil
+}
+
+// values reads the buckets of the days queried, the whole ledger is read
+// when the beginning of the interval is not limited
+func (ledger *IncomeLedger) values(query *IncomeQuery) (values []string, err error) {
+	if query.From.IsZero() {
+		return ledger.storage.GetByKeyPrefix("")
+	}
+	from := query.From
+	if ledger.retention > 0 {
+		from = later(from, time.Now().Add(-ledger.retention))
+	}
+	to := time.Now()
+	if !query.To.IsZero() && query.To.Before(to) {
+		to = query.To
+	}
+	last := incomeDay(to)
+	for day := from.UTC().Truncate(24 * time.Hour); incomeDay(day) <= last; day = day.AddDate(0, 0, 1) {
+		bucket, err := ledger.storage.GetByKeyPrefix(incomeDay(day) + "/")
+		if err != nil {
+			return nil, err
+		}
+		values = append(values, bucket...)
+	}
+	return values, nil
+}
+
+func later(a, b time.Time) time.Time {
+	if a.After(b) {
+		return a
+	}
+	return b
+}
+
+// Income returns the income matched by the query aggregated according to
+// query.GroupBy, groups are sorted by the key
+func (ledger *IncomeLedger) Income(query *IncomeQuery) (groups []*IncomeSummary, err error) {
+	records, err := ledger.Records(query)
+	if err != nil {
+		return
+	}
+	byKey := make(map[string]*IncomeSummary)
+	for _, record := range records {
+		key := query.GroupBy.key(record)
+		group, ok := byKey[key]
+		if !ok {
+			group = &IncomeSummary{Key: key, Amount: big.NewInt(0)}
+			byKey[key] = group
+			groups = append(groups, group)
+		}
+		group.Amount.Add(group.Amount, record.Amount)
+		group.Payments++
+	}
+	slices.SortFunc(groups, func(a, b *IncomeSummary) int {
+		return strings.Compare(a.Key, b.Key)
+	})
+	return groups, nil
+}
@@ -0,0 +1,126 @@
+package escrow
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+
+	"github.com/singnet/snet-daemon/v6/storage"
+)
+
+func incomeRecord(channelID int64, authorizedAmount int64, amount int64, method string, timestamp string) *IncomeRecord {
+	parsed, _ := time.Parse(time.RFC3339, timestamp)
+	return &IncomeRecord{
+		ChannelID:        big.NewInt(channelID),
+		Nonce:            big.NewInt(0),
+		Sender:           common.BigToAddress(big.NewInt(channelID)),
+		Method:           method,
+		Amount:           big.NewInt(amount),
+		AuthorizedAmount: big.NewInt(authorizedAmount),
+		Timestamp:        parsed,
+	}
+}
+
+func TestIncomeLedger_Income(t *testing.T) {
+	ledger := NewIncomeLedger(storage.NewMemStorage(), 0)
+	require.NoError(t, ledger.Record(incomeRecord(1, 10, 10, "/svc/A", "2025-01-30T10:00:00Z")))
+	require.NoError(t, ledger.Record(incomeRecord(1, 25, 15, "/svc/B", "2025-01-31T23:59:59Z")))
+	require.NoError(t, ledger.Record(incomeRecord(2, 5, 5, "/svc/A", "2025-02-01T00:00:00Z")))
+	// the same payment is recorded once
+	require.NoError(t, ledger.Record(incomeRecord(2, 5, 5, "/svc/A", "2025-02-01T00:00:00Z")))
+
+	records, err := ledger.Records(&IncomeQuery{})
+	require.NoError(t, err)
+	require.Len(t, records, 3)
+
+	groups, err := ledger.Income(&IncomeQuery{})
+	require.NoError(t, err)
+	assert.Equal(t, []*IncomeSummary{{Key: "total", Amount: big.NewInt(30), Payments: 3}}, groups)
+
+	groups, err = ledger.Income(&IncomeQuery{GroupBy: IncomeGroupByMonth})
+	require.NoError(t, err)
+	assert.Equal(t, []*IncomeSummary{
+		{Key: "2025-01", Amount: big.NewInt(25), Payments: 2},
+		{Key: "2025-02", Amount: big.NewInt(5), Payments: 1},
+	}, groups)
+
+	groups, err = ledger.Income(&IncomeQuery{GroupBy: IncomeGroupByMethod})
+	require.NoError(t, err)
+	assert.Equal(t, []*IncomeSummary{
+		{Key: "/svc/A", Amount: big.NewInt(15), Payments: 2},
+		{Key: "/svc/B", Amount: big.NewInt(15), Payments: 1},
+	}, groups)
+
+	from, _ := time.Parse(time.DateOnly, "2025-01-31")
+	to, _ := time.Parse(time.DateOnly, "2025-02-01")
+	groups, err = ledger.Income(&IncomeQuery{From: from, To: to, GroupBy: IncomeGroupByChannel})
+	require.NoError(t, err)
+	assert.Equal(t, []*IncomeSummary{{Key: "1", Amount: big.NewInt(15), Payments: 1}}, groups)
+}
+
+func TestIncomeLedger_Retention(t *testing.T) {
+	memoryStorage := storage.NewMemStorage()
+	ledger := NewIncomeLedger(memoryStorage, 48*time.Hour)
+	now := time.Now().UTC()
+	old := incomeRecord(1, 10, 10, "/svc/A", "2025-01-30T10:00:00Z")
+	recent := incomeRecord(1, 25, 15, "/svc/A", now.Add(-time.Hour).Format(time.RFC3339))
+	require.NoError(t, ledger.Record(old))
+	require.NoError(t, ledger.Record(recent))
+
+	records, err := ledger.Records(&IncomeQuery{From: now.Add(-24 * time.Hour)})
+	require.NoError(t, err)
+	require.Len(t, records, 1, "only the buckets of the days queried are read")
+	assert.Equal(t, int64(25), records[0].AuthorizedAmount.Int64())
+
+	require.NoError(t, ledger.Prune(now.Add(-48*time.Hour)))
+	records, err = ledger.Records(&IncomeQuery{})
+	require.NoError(t, err)
+	require.Len(t, records, 1, "expired records are removed")
+	assert.Equal(t, int64(25), records[0].AuthorizedAmount.Int64())
+}
+
+func TestParseIncomeGroupBy(t *testing.T) {
+	groupBy, err := ParseIncomeGroupBy("Day")
+	require.NoError(t, err)
+	assert.Equal(t, IncomeGroupByDay, groupBy)
+
+	_, err = ParseIncomeGroupBy("year")
+	assert.Error(t, err)
+}
+
+type IncomeRecordingSuite struct {
+	channelServiceTestSuite
+}
+
+func TestIncomeRecordingSuite(t *testing.T) {
+	suite.Run(t, new(IncomeRecordingSuite))
+}
+
+func (suite *IncomeRecordingSuite) TestCommitRecordsIncome() {
+	require.NoError(suite.T(), suite.otherReplica.Put(suite.key(), suite.channel(20)))
+	ledger := NewIncomeLedger(suite.memoryStorage, 0)
+	service := NewPaymentChannelServiceWithIncomeLedger(suite.storage, NewPaymentStorage(suite.memoryStorage), suite.reader(),
+		NewEtcdLocker(suite.memoryStorage),
+		suite.validator(), func() [32]byte { return [32]byte{123} },
+		DefaultChannelLockConf, ledger,
+	)
+
+	transaction, err := service.StartPaymentTransaction(context.Background(), suite.payment(50))
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), transaction.Commit())
+
+	records, err := ledger.Records(&IncomeQuery{})
+	require.NoError(suite.T(), err)
+	require.Len(suite.T(), records, 1, "income is recorded on commit")
+	assert.Equal(suite.T(), int64(42), records[0].ChannelID.Int64())
+	assert.Equal(suite.T(), int64(30), records[0].Amount.Int64())
+	assert.Equal(suite.T(), int64(50), records[0].AuthorizedAmount.Int64())
+	assert.Equal(suite.T(), suite.signerAddress, records[0].Sender)
+	assert.Equal(suite.T(), int64(99), records[0].Block.Int64(), "block the payment is validated against")
+}
@@ -0,0 +1,94 @@
+//go:generate protoc -I . ./income_service.proto --go-grpc_out=. --go_out=.
+
+package escrow
+
+import (
+	"bytes"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common/math"
+	"go.uber.org/zap"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// IncomeService is an implementation of IncomeServiceServer gRPC interface
+type IncomeService struct {
+	UnimplementedIncomeServiceServer
+	ledger *IncomeLedger
+	// control verifies the requests the same way as ProviderControlService
+	// does
+	control *ProviderControlService
+}
+
+// NewIncomeService returns new instance of IncomeService which reports the
+// income kept by ledger
+func NewIncomeService(ledger *IncomeLedger, control *ProviderControlService) *IncomeService {
+	return &IncomeService{ledger: ledger, control: control}
+}
+
+type BlockChainDisabledIncomeService struct {
+	UnimplementedIncomeServiceServer
+}
+
+func (service *BlockChainDisabledIncomeService) GetIncome(ctx context.Context, request *GetIncomeRequest) (reply *IncomeReply, err error) {
+	return &IncomeReply{}, nil
+}
+
+// GetIncome returns the income received in the requested interval.
+// Verify that mpe_address is correct
+// Verify that actual block_number is not very different (+-5 blocks) from the current_block_number from the signature
+// Verify that message was signed by the service provider (“payment_address” in metadata should match to the signer).
+func (service *IncomeService) GetIncome(ctx context.Context, request *GetIncomeRequest) (reply *IncomeReply, err error) {
+	if err = service.control.checkMpeAddress(request.GetMpeAddress()); err != nil {
+		return nil, err
+	}
+	if err = service.control.blockchain.CompareWithLatestBlockNumber(big.NewInt(int64(request.CurrentBlock)), AllowedBlockDifference); err != nil {
+		return nil, err
+	}
+	if err = service.verifySignerForGetIncome(request); err != nil {
+		return nil, err
+	}
+
+	groupBy, err := ParseIncomeGroupBy(request.GetGroupBy())
+	if err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	query := &IncomeQuery{GroupBy: groupBy}
+	if request.GetFrom() != 0 {
+		query.From = time.Unix(request.GetFrom(), 0)
+	}
+	if request.GetTo() != 0 {
+		query.To = time.Unix(request.GetTo(), 0)
+	}
+
+	groups, err := service.ledger.Income(query)
+	if err != nil {
+		zap.L().Error("Unable to read income", zap.Error(err))
+		return nil, status.Error(codes.Internal, "unable to read income")
+	}
+	reply = &IncomeReply{Groups: make([]*IncomeGroup, 0, len(groups))}
+	total := big.NewInt(0)
+	for _, group := range groups {
+		total.Add(total, group.Amount)
+		reply.Groups = append(reply.Groups, &IncomeGroup{
+			Key:      group.Key,
+			Amount:   bigIntToBytes(group.Amount),
+			Payments: uint64(group.Payments),
+		})
+	}
+	reply.Total = bigIntToBytes(total)
+	return reply, nil
+}
+
+// message used to sign is of the form ("__get_income", mpe_address, current_block_number)
+func (service *IncomeService) verifySignerForGetIncome(request *GetIncomeRequest) error {
+	message := bytes.Join([][]byte{
+		[]byte("__get_income"),
+		service.control.serviceMetaData.GetMpeAddress().Bytes(),
+		math.U256Bytes(big.NewInt(int64(request.CurrentBlock))),
+	}, nil)
+	return service.control.verifySigner(message, request.GetSignature())
+}
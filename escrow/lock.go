@@ -1,6 +1,14 @@
 package escrow
 
 import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
 	"github.com/singnet/snet-daemon/v6/storage"
 	"go.uber.org/zap"
 )
@@ -17,53 +25,234 @@ type Locker interface {
 	Lock(name string) (lock Lock, ok bool, err error)
 }
 
+// DefaultLockTTL is a time after which the lock of the disappeared holder
+// can be reclaimed by other replicas
+const DefaultLockTTL = time.Minute
+
 // NewEtcdLocker returns new lock which is based on etcd storage.
-func NewEtcdLocker(storage storage.AtomicStorage) Locker {
+func NewEtcdLocker(atomicStorage storage.AtomicStorage) Locker {
+	return NewEtcdLockerWithTTL(atomicStorage, DefaultLockTTL)
+}
+
+// NewEtcdLockerWithTTL returns new lock which is based on etcd storage. Locks
+// are leased for ttl and refreshed in the background while they are held, so
+// a lock of the replica which died is released after ttl. If the storage
+// supports leases (etcd) the key is removed by the storage itself, otherwise
+// the expiration time is kept in the value and expired locks are reclaimed on
+// the next Lock call.
+func NewEtcdLockerWithTTL(atomicStorage storage.AtomicStorage, ttl time.Duration) Locker {
+	if ttl <= 0 {
+		ttl = DefaultLockTTL
+	}
 	return &etcdLocker{
-		storage: storage,
+		storage: atomicStorage,
+		leases:  storage.LeaseStorageOf(atomicStorage),
+		owner:   newLockOwner(),
+		ttl:     ttl,
 	}
 }
 
 type etcdLocker struct {
 	storage storage.AtomicStorage
+	leases  storage.LeaseStorage
+	owner   string
+	ttl     time.Duration
+
+	mutex sync.Mutex
+	// stale keeps the leases assigned to the lock values which cannot be
+	// parsed by the lock name
+	stale map[string]staleLease
+}
+
+type staleLease struct {
+	value string
+	lease *lockLease
 }
 
 const (
+	// locked is written by the daemons which don't support lock leases, such a
+	// lock is treated as the lease which expires ttl after it is seen first
 	locked   = "locked"
 	unlocked = "unlocked"
 )
 
+// lockLease is the value of the acquired lock. Expires is empty when the key
+// expiration is handled by the storage lease.
+type lockLease struct {
+	Owner   string    `json:"owner"`
+	Expires time.Time `json:"expires,omitzero"`
+}
+
+func (lease *lockLease) String() string {
+	data, _ := json.Marshal(lease)
+	return string(data)
+}
+
+func parseLockLease(value string) (lease *lockLease, err error) {
+	lease = &lockLease{}
+	if err = json.Unmarshal([]byte(value), lease); err != nil {
+		return nil, fmt.Errorf("unexpected lock value %q: %w", value, err)
+	}
+	return lease, nil
+}
+
+func newLockOwner() string {
+	hostname, _ := os.Hostname()
+	random := make([]byte, 4)
+	_, _ = rand.Read(random)
+	return fmt.Sprintf("%v-%v-%v", hostname, os.Getpid(), hex.EncodeToString(random))
+}
+
+func (locker *etcdLocker) newLease() *lockLease {
+	lease := &lockLease{Owner: locker.owner}
+	if locker.leases == nil {
+		lease.Expires = time.Now().Add(locker.ttl).UTC()
+	}
+	return lease
+}
+
 func (locker *etcdLocker) Lock(name string) (lock Lock, ok bool, err error) {
-	value, ok, err := locker.storage.Get(name)
+	value, present, err := locker.storage.Get(name)
 	if err != nil {
 		return
 	}
-	if ok {
-		if value == locked {
+	if present && value != unlocked {
+		current, err := parseLockLease(value)
+		if err != nil {
+			current = locker.staleLease(name, value)
+		}
+		if current.Expires.IsZero() || time.Now().Before(current.Expires) {
 			return nil, false, nil
 		}
-		ok, err = locker.storage.CompareAndSwap(name, unlocked, locked)
-	} else {
-		ok, err = locker.storage.PutIfAbsent(name, locked)
+		zap.L().Warn("lock holder didn't refresh the lock in time, reclaiming the lock",
+			zap.String("name", name), zap.String("previousOwner", current.Owner), zap.Time("expired", current.Expires))
 	}
 
+	lease := locker.newLease().String()
+	var storageLease storage.Lease
+	switch {
+	case locker.leases != nil:
+		storageLease, ok, err = locker.leases.CompareAndSwapWithLease(name, value, present, lease, locker.ttl)
+	case present:
+		ok, err = locker.storage.CompareAndSwap(name, value, lease)
+	default:
+		ok, err = locker.storage.PutIfAbsent(name, lease)
+	}
 	if err != nil || !ok {
 		return
 	}
 
-	return &lockType{
+	acquired := &lockType{
 		name:   name,
 		locker: locker,
-	}, true, nil
+		value:  lease,
+		lease:  storageLease,
+		stop:   make(chan struct{}),
+	}
+	locker.forgetStaleLease(name)
+	go acquired.refresh()
+	return acquired, true, nil
+}
+
+// staleLease returns the lease of the lock value which cannot be parsed, e.g.
+// written by the daemon without leases support. The lease expires ttl after
+// the value is seen first, so the lock of the disappeared holder is reclaimed
+// eventually.
+func (locker *etcdLocker) staleLease(name string, value string) *lockLease {
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+	if stale, ok := locker.stale[name]; ok && stale.value == value {
+		return stale.lease
+	}
+	zap.L().Warn("lock has value without lease, it is reclaimed if not changed in time",
+		zap.String("name", name), zap.String("value", value), zap.Duration("ttl", locker.ttl))
+	if locker.stale == nil {
+		locker.stale = make(map[string]staleLease)
+	}
+	lease := &lockLease{Owner: value, Expires: time.Now().Add(locker.ttl).UTC()}
+	locker.stale[name] = staleLease{value: value, lease: lease}
+	return lease
+}
+
+func (locker *etcdLocker) forgetStaleLease(name string) {
+	locker.mutex.Lock()
+	defer locker.mutex.Unlock()
+	delete(locker.stale, name)
 }
 
 type lockType struct {
 	name   string
 	locker *etcdLocker
+	lease  storage.Lease
+
+	mutex    sync.Mutex
+	value    string
+	released bool
+	stop     chan struct{}
+	stopOnce sync.Once
+}
+
+// refresh extends the lock until it is unlocked
+func (lock *lockType) refresh() {
+	ticker := time.NewTicker(lock.locker.ttl / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-lock.stop:
+			return
+		case <-ticker.C:
+			if !lock.extend() {
+				return
+			}
+		}
+	}
+}
+
+func (lock *lockType) extend() bool {
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+	if lock.released {
+		return false
+	}
+
+	if lock.lease != nil {
+		if err := lock.lease.KeepAlive(); err != nil {
+			zap.L().Error("unable to keep lock lease alive, lock is lost", zap.String("name", lock.name), zap.Error(err))
+			lock.released = true
+			return false
+		}
+		return true
+	}
+
+	value := lock.locker.newLease().String()
+	ok, err := lock.locker.storage.CompareAndSwap(lock.name, lock.value, value)
+	if err != nil {
+		zap.L().Error("unable to refresh lock", zap.String("name", lock.name), zap.Error(err))
+		return true
+	}
+	if !ok {
+		zap.L().Error("lock was reclaimed or unlocked by someone else", zap.String("name", lock.name))
+		lock.released = true
+		return false
+	}
+	lock.value = value
+	return true
 }
 
 func (lock *lockType) Unlock() (err error) {
-	ok, err := lock.locker.storage.CompareAndSwap(lock.name, locked, unlocked)
+	lock.mutex.Lock()
+	defer lock.mutex.Unlock()
+	lock.stopOnce.Do(func() { close(lock.stop) })
+	if lock.released {
+		zap.L().Error("lock is unlocked already", zap.String("lock.name", lock.name))
+		return nil
+	}
+	lock.released = true
+
+	if lock.lease != nil {
+		return lock.lease.Revoke()
+	}
+	ok, err := lock.locker.storage.CompareAndSwap(lock.name, lock.value, unlocked)
 	if err != nil {
 		return
 	}
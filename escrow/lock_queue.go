@@ -0,0 +1,193 @@
+package escrow
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// ChannelLockPolicy defines what happens with a call when the payment channel
+// is locked by another call
+type ChannelLockPolicy string
+
+const (
+	// LockPolicyReject fails the call immediately
+	LockPolicyReject ChannelLockPolicy = "reject"
+	// LockPolicyWait queues the call until the channel is unlocked or the call
+	// deadline is exceeded
+	LockPolicyWait ChannelLockPolicy = "wait"
+	// LockPolicyPipeline locks the channel only while the payment is
+	// validated and stored, so calls on the same channel are executed
+	// concurrently. Each payment must authorize a greater amount than the
+	// previous one.
+	LockPolicyPipeline ChannelLockPolicy = "pipeline"
+)
+
+// ParseChannelLockPolicy converts the configuration value into
+// ChannelLockPolicy, empty value means LockPolicyReject
+func ParseChannelLockPolicy(value string) (policy ChannelLockPolicy, err error) {
+	switch policy = ChannelLockPolicy(value); policy {
+	case "":
+		return LockPolicyReject, nil
+	case LockPolicyReject, LockPolicyWait, LockPolicyPipeline:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown payment channel lock policy: %q, expected one of: %v, %v, %v",
+		value, LockPolicyReject, LockPolicyWait, LockPolicyPipeline)
+}
+
+// ChannelLockConf contains settings of waiting for the payment channel lock
+// QueueSize   - how many calls of the replica can wait for the same channel
+// WaitTimeout - how long a call waits if its context has no deadline
+type ChannelLockConf struct {
+	Policy      ChannelLockPolicy
+	QueueSize   int
+	WaitTimeout time.Duration
+}
+
+// DefaultChannelLockConf rejects concurrent calls on the same channel
+var DefaultChannelLockConf = ChannelLockConf{
+	Policy:      LockPolicyReject,
+	QueueSize:   16,
+	WaitTimeout: 30 * time.Second,
+}
+
+const (
+	lockPollMinInterval = 10 * time.Millisecond
+	lockPollMaxInterval = 500 * time.Millisecond
+)
+
+// channelLockQueue acquires channel locks according to the lock policy.
+// Calls of the replica waiting for the same channel are served in order:
+// only the first one polls the locker, so the others are not competing for
+// the storage, and it is woken up as soon as the previous call of the replica
+// releases the lock.
+type channelLockQueue struct {
+	locker Locker
+	conf   ChannelLockConf
+
+	mutex    sync.Mutex
+	channels map[string]*channelWaiters
+}
+
+type channelWaiters struct {
+	// turn is taken by the call which holds the channel lock or polls for it
+	turn chan struct{}
+	// count is a number of calls holding or waiting for the turn
+	count int
+}
+
+func newChannelLockQueue(locker Locker, conf ChannelLockConf) *channelLockQueue {
+	if conf.QueueSize <= 0 {
+		conf.QueueSize = DefaultChannelLockConf.QueueSize
+	}
+	if conf.WaitTimeout <= 0 {
+		conf.WaitTimeout = DefaultChannelLockConf.WaitTimeout
+	}
+	return &channelLockQueue{
+		locker:   locker,
+		conf:     conf,
+		channels: make(map[string]*channelWaiters),
+	}
+}
+
+// lock acquires the channel lock, the error returned is PaymentError
+func (queue *channelLockQueue) lock(ctx context.Context, name string) (lock Lock, err error) {
+	if queue.conf.Policy == LockPolicyReject {
+		lock, ok, err := queue.locker.Lock(name)
+		if err != nil {
+			zap.L().Error("unable to get lock!", zap.Error(err), zap.String("name", name))
+			return nil, NewPaymentError(Internal, "cannot get mutex for channel: %v", name)
+		}
+		if !ok {
+			return nil, NewPaymentError(FailedPrecondition, "another transaction on channel: %v is in progress", name)
+		}
+		return lock, nil
+	}
+
+	waiters, err := queue.enter(name)
+	if err != nil {
+		return nil, err
+	}
+	ctx, cancel := context.WithTimeout(ctx, queue.conf.WaitTimeout)
+	defer cancel()
+
+	select {
+	case waiters.turn <- struct{}{}:
+	case <-ctx.Done():
+		queue.leave(name, waiters, false)
+		return nil, waitError(ctx, name)
+	}
+
+	for interval := lockPollMinInterval; ; interval = min(2*interval, lockPollMaxInterval) {
+		lock, ok, err := queue.locker.Lock(name)
+		if err != nil {
+			queue.leave(name, waiters, true)
+			zap.L().Error("unable to get lock!", zap.Error(err), zap.String("name", name))
+			return nil, NewPaymentError(Internal, "cannot get mutex for channel: %v", name)
+		}
+		if ok {
+			return &queuedLock{Lock: lock, queue: queue, name: name, waiters: waiters}, nil
+		}
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+			queue.leave(name, waiters, true)
+			return nil, waitError(ctx, name)
+		}
+	}
+}
+
+func waitError(ctx context.Context, name string) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return NewPaymentError(DeadlineExceeded, "timed out waiting for another transaction on channel: %v", name)
+	}
+	return NewPaymentError(FailedPrecondition, "call is canceled while waiting for another transaction on channel: %v", name)
+}
+
+func (queue *channelLockQueue) enter(name string) (waiters *channelWaiters, err error) {
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+
+	waiters, ok := queue.channels[name]
+	if !ok {
+		waiters = &channelWaiters{turn: make(chan struct{}, 1)}
+		queue.channels[name] = waiters
+	}
+	// the call holding the lock is not counted as waiting
+	if waiters.count > queue.conf.QueueSize {
+		return nil, NewPaymentError(ResourceExhausted, "too many calls are waiting for channel: %v", name)
+	}
+	waiters.count++
+	return waiters, nil
+}
+
+func (queue *channelLockQueue) leave(name string, waiters *channelWaiters, hasTurn bool) {
+	if hasTurn {
+		<-waiters.turn
+	}
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	if waiters.count--; waiters.count == 0 {
+		delete(queue.channels, name)
+	}
+}
+
+// queuedLock passes the turn to the next waiting call when unlocked
+type queuedLock struct {
+	Lock
+	queue   *channelLockQueue
+	name    string
+	waiters *channelWaiters
+	once    sync.Once
+}
+
+func (lock *queuedLock) Unlock() (err error) {
+	err = lock.Lock.Unlock()
+	lock.once.Do(func() { lock.queue.leave(lock.name, lock.waiters, true) })
+	return
+}
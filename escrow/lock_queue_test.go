@@ -0,0 +1,173 @@
+package escrow
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/singnet/snet-daemon/v6/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+)
+
+func newTestChannelLockQueue(policy ChannelLockPolicy, queueSize int) *channelLockQueue {
+	return newChannelLockQueue(NewEtcdLocker(storage.NewMemStorage()), ChannelLockConf{
+		Policy:      policy,
+		QueueSize:   queueSize,
+		WaitTimeout: 5 * time.Second,
+	})
+}
+
+func assertPaymentErrorCode(t *testing.T, code PaymentErrorCode, err error) {
+	require.Error(t, err)
+	paymentError, ok := err.(*PaymentError)
+	require.True(t, ok, "unexpected error: %v", err)
+	assert.Equal(t, code, paymentError.Code, paymentError.Message)
+}
+
+func TestParseChannelLockPolicy(t *testing.T) {
+	policy, err := ParseChannelLockPolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, LockPolicyReject, policy)
+
+	policy, err = ParseChannelLockPolicy("pipeline")
+	require.NoError(t, err)
+	assert.Equal(t, LockPolicyPipeline, policy)
+
+	_, err = ParseChannelLockPolicy("queue")
+	assert.Error(t, err)
+}
+
+func TestChannelLockQueue_Reject(t *testing.T) {
+	queue := newTestChannelLockQueue(LockPolicyReject, 0)
+
+	lock, err := queue.lock(context.Background(), "channel")
+	require.NoError(t, err)
+	_, err = queue.lock(context.Background(), "channel")
+	assertPaymentErrorCode(t, FailedPrecondition, err)
+
+	require.NoError(t, lock.Unlock())
+	lock, err = queue.lock(context.Background(), "channel")
+	require.NoError(t, err)
+	require.NoError(t, lock.Unlock())
+}
+
+func TestChannelLockQueue_WaitForUnlock(t *testing.T) {
+	queue := newTestChannelLockQueue(LockPolicyWait, 0)
+	lock, err := queue.lock(context.Background(), "channel")
+	require.NoError(t, err)
+
+	locked := make(chan error)
+	go func() {
+		lock, err := queue.lock(context.Background(), "channel")
+		if err == nil {
+			err = lock.Unlock()
+		}
+		locked <- err
+	}()
+
+	select {
+	case err = <-locked:
+		t.Fatalf("channel is locked while another call holds the lock, err: %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+	require.NoError(t, lock.Unlock())
+	require.NoError(t, <-locked)
+
+	queue.mutex.Lock()
+	defer queue.mutex.Unlock()
+	assert.Empty(t, queue.channels)
+}
+
+func TestChannelLockQueue_QueueIsFull(t *testing.T) {
+	queue := newTestChannelLockQueue(LockPolicyWait, 1)
+	lock, err := queue.lock(context.Background(), "channel")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	waiting := make(chan error)
+	go func() {
+		_, err := queue.lock(ctx, "channel")
+		waiting <- err
+	}()
+	require.Eventually(t, func() bool {
+		queue.mutex.Lock()
+		defer queue.mutex.Unlock()
+		return queue.channels["channel"].count == 2
+	}, 5*time.Second, time.Millisecond)
+
+	_, err = queue.lock(context.Background(), "channel")
+	assertPaymentErrorCode(t, ResourceExhausted, err)
+	_, err = queue.lock(context.Background(), "another-channel")
+	assert.NoError(t, err)
+
+	cancel()
+	assertPaymentErrorCode(t, FailedPrecondition, <-waiting)
+	require.NoError(t, lock.Unlock())
+}
+
+func TestChannelLockQueue_DeadlineExceeded(t *testing.T) {
+	queue := newTestChannelLockQueue(LockPolicyWait, 0)
+	lock, err := queue.lock(context.Background(), "channel")
+	require.NoError(t, err)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err = queue.lock(ctx, "channel")
+	assertPaymentErrorCode(t, DeadlineExceeded, err)
+
+	queue.conf.WaitTimeout = 50 * time.Millisecond
+	_, err = queue.lock(context.Background(), "channel")
+	assertPaymentErrorCode(t, DeadlineExceeded, err)
+	require.NoError(t, lock.Unlock())
+}
+
+type PipelinedPaymentSuite struct {
+	channelServiceTestSuite
+}
+
+func TestPipelinedPaymentSuite(t *testing.T) {
+	suite.Run(t, new(PipelinedPaymentSuite))
+}
+
+func (suite *PipelinedPaymentSuite) TestPayments() {
+	service := suite.serviceWithLockConf(ChannelLockConf{Policy: LockPolicyPipeline})
+
+	first, err := service.StartPaymentTransaction(context.Background(), suite.payment(10))
+	require.NoError(suite.T(), err)
+	second, err := service.StartPaymentTransaction(context.Background(), suite.payment(20))
+	require.NoError(suite.T(), err, "channel is not locked by the call in progress")
+	assert.Equal(suite.T(), int64(10), second.Channel().AuthorizedAmount.Int64())
+
+	_, err = service.StartPaymentTransaction(context.Background(), suite.payment(20))
+	assertPaymentErrorCode(suite.T(), Unauthenticated, err)
+
+	require.NoError(suite.T(), second.Commit())
+	require.NoError(suite.T(), first.Commit())
+	channel, ok, err := suite.otherReplica.Get(suite.key())
+	require.NoError(suite.T(), err)
+	require.True(suite.T(), ok)
+	assert.Equal(suite.T(), int64(20), channel.AuthorizedAmount.Int64())
+}
+
+func (suite *PipelinedPaymentSuite) TestRollback() {
+	service := suite.serviceWithLockConf(ChannelLockConf{Policy: LockPolicyPipeline})
+
+	first, err := service.StartPaymentTransaction(context.Background(), suite.payment(10))
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), first.Rollback())
+	channel, _, err := suite.otherReplica.Get(suite.key())
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(0), channel.AuthorizedAmount.Int64(), "payment is rolled back")
+
+	first, err = service.StartPaymentTransaction(context.Background(), suite.payment(10))
+	require.NoError(suite.T(), err)
+	second, err := service.StartPaymentTransaction(context.Background(), suite.payment(20))
+	require.NoError(suite.T(), err)
+	require.NoError(suite.T(), first.Rollback())
+	require.NoError(suite.T(), second.Commit())
+	channel, _, err = suite.otherReplica.Get(suite.key())
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(20), channel.AuthorizedAmount.Int64(), "payment followed by the next one is kept")
+}
@@ -1,5 +1,15 @@
 package escrow
 
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/singnet/snet-daemon/v6/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
 type lockerMock struct {
 }
 
@@ -13,3 +23,155 @@ type lockMock struct {
 func (mock *lockMock) Unlock() (err error) {
 	return nil
 }
+
+func TestEtcdLocker_LockUnlock(t *testing.T) {
+	memoryStorage := storage.NewMemStorage()
+	locker := NewEtcdLocker(memoryStorage)
+
+	lock, ok, err := locker.Lock("channel")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	_, ok, err = NewEtcdLocker(memoryStorage).Lock("channel")
+	assert.NoError(t, err)
+	assert.False(t, ok, "lock held by another owner must not be acquired")
+
+	value, _, _ := memoryStorage.Get("channel")
+	lease, err := parseLockLease(value)
+	require.NoError(t, err)
+	assert.Equal(t, locker.(*etcdLocker).owner, lease.Owner)
+	assert.True(t, lease.Expires.After(time.Now()))
+
+	assert.NoError(t, lock.Unlock())
+	assert.NoError(t, lock.Unlock(), "second unlock is ignored")
+	value, _, _ = memoryStorage.Get("channel")
+	assert.Equal(t, unlocked, value)
+
+	lock, ok, err = NewEtcdLocker(memoryStorage).Lock("channel")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.NoError(t, lock.Unlock())
+}
+
+func TestEtcdLocker_ReclaimExpiredLock(t *testing.T) {
+	memoryStorage := storage.NewMemStorage()
+	expired := &lockLease{Owner: "crashed-replica", Expires: time.Now().Add(-time.Second)}
+	_ = memoryStorage.Put("channel", expired.String())
+	_ = memoryStorage.Put("legacy", locked)
+
+	lock, ok, err := NewEtcdLocker(memoryStorage).Lock("channel")
+	assert.NoError(t, err)
+	assert.True(t, ok, "expired lock must be reclaimed")
+	assert.NoError(t, lock.Unlock())
+
+	ttl := 30 * time.Millisecond
+	locker := NewEtcdLockerWithTTL(memoryStorage, ttl)
+	_, ok, err = locker.Lock("legacy")
+	assert.NoError(t, err)
+	assert.False(t, ok, "lock of the daemon without leases is held for ttl")
+
+	time.Sleep(2 * ttl)
+	lock, ok, err = locker.Lock("legacy")
+	assert.NoError(t, err)
+	assert.True(t, ok, "lock of the daemon without leases is reclaimed after ttl")
+	assert.NoError(t, lock.Unlock())
+}
+
+func TestEtcdLocker_RefreshWhileHeld(t *testing.T) {
+	memoryStorage := storage.NewMemStorage()
+	ttl := 60 * time.Millisecond
+
+	lock, ok, err := NewEtcdLockerWithTTL(memoryStorage, ttl).Lock("channel")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	time.Sleep(3 * ttl)
+	_, ok, err = NewEtcdLockerWithTTL(memoryStorage, ttl).Lock("channel")
+	assert.NoError(t, err)
+	assert.False(t, ok, "held lock must be refreshed")
+
+	// holder disappears without unlocking
+	acquired := lock.(*lockType)
+	acquired.stopOnce.Do(func() { close(acquired.stop) })
+	time.Sleep(2 * ttl)
+
+	other, ok, err := NewEtcdLockerWithTTL(memoryStorage, ttl).Lock("channel")
+	assert.NoError(t, err)
+	assert.True(t, ok, "lock must be reclaimed after the holder stopped refreshing it")
+	assert.NoError(t, other.Unlock())
+
+	// previous holder can't unlock the lock it lost
+	assert.NoError(t, lock.Unlock())
+	_, ok, _ = NewEtcdLockerWithTTL(memoryStorage, ttl).Lock("channel")
+	assert.True(t, ok)
+}
+
+type leaseStorageMock struct {
+	*storage.MemoryStorage
+	revoked      int
+	keptAlive    int
+	keepAliveErr error
+}
+
+func (mock *leaseStorageMock) CompareAndSwapWithLease(key string, prevValue string, prevPresent bool, newValue string,
+	ttl time.Duration) (storage.Lease, bool, error) {
+	var ok bool
+	var err error
+	if prevPresent {
+		ok, err = mock.CompareAndSwap(key, prevValue, newValue)
+	} else {
+		ok, err = mock.PutIfAbsent(key, newValue)
+	}
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	return &leaseMock{storage: mock, key: key}, true, nil
+}
+
+type leaseMock struct {
+	storage *leaseStorageMock
+	key     string
+}
+
+func (lease *leaseMock) KeepAlive() error {
+	lease.storage.keptAlive++
+	return lease.storage.keepAliveErr
+}
+
+func (lease *leaseMock) Revoke() error {
+	lease.storage.revoked++
+	return lease.storage.Delete(lease.key)
+}
+
+func TestEtcdLocker_StorageLease(t *testing.T) {
+	leaseStorage := &leaseStorageMock{MemoryStorage: storage.NewMemStorage()}
+	locker := NewEtcdLockerWithTTL(storage.NewPrefixedAtomicStorage(leaseStorage, "/lock"), 30*time.Millisecond)
+
+	lock, ok, err := locker.Lock("channel")
+	require.NoError(t, err)
+	require.True(t, ok)
+	value, _, _ := leaseStorage.Get("/lock/channel")
+	lease, err := parseLockLease(value)
+	require.NoError(t, err)
+	assert.True(t, lease.Expires.IsZero(), "expiration is handled by the storage lease")
+
+	time.Sleep(50 * time.Millisecond)
+	assert.NoError(t, lock.Unlock())
+	assert.Equal(t, 1, leaseStorage.revoked)
+	assert.Positive(t, leaseStorage.keptAlive)
+	_, ok, _ = leaseStorage.Get("/lock/channel")
+	assert.False(t, ok)
+}
+
+func TestEtcdLocker_StorageLeaseLost(t *testing.T) {
+	leaseStorage := &leaseStorageMock{MemoryStorage: storage.NewMemStorage(), keepAliveErr: errors.New("lease not found")}
+	lock, ok, err := NewEtcdLockerWithTTL(leaseStorage, 30*time.Millisecond).Lock("channel")
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	acquired := lock.(*lockType)
+	assert.False(t, acquired.extend(), "refresh is stopped when the lease is lost")
+	assert.True(t, acquired.released)
+	assert.NoError(t, lock.Unlock())
+	assert.Equal(t, 0, leaseStorage.revoked, "lost lease is not revoked")
+}
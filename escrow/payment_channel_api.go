@@ -1,6 +1,7 @@
 package escrow
 
 import (
+	"context"
 	"fmt"
 	"math/big"
 
@@ -21,11 +22,23 @@ type Payment struct {
 	Amount *big.Int
 	// Signature is a signature of the payment.
 	Signature []byte
+	// ActualAmount is the amount charged when the calls cost less than the
+	// amount signed, see handler.ActualCostTrailer; nil means the Amount is
+	// charged. It is claimed as the actual amount of the planned Amount.
+	ActualAmount *big.Int
 }
 
 func (p *Payment) String() string {
-	return fmt.Sprintf("{MpeContractAddress: %v, ChannelID: %v, ChannelNonce: %v, Amount: %v, Signature: %v}",
-		utils.AddressToHex(&p.MpeContractAddress), p.ChannelID, p.ChannelNonce, p.Amount, utils.BytesToBase64(p.Signature))
+	return fmt.Sprintf("{MpeContractAddress: %v, ChannelID: %v, ChannelNonce: %v, Amount: %v, Signature: %v, ActualAmount: %v}",
+		utils.AddressToHex(&p.MpeContractAddress), p.ChannelID, p.ChannelNonce, p.Amount, utils.BytesToBase64(p.Signature), p.ActualAmount)
+}
+
+// Charged returns the amount charged by the payment
+func (p *Payment) Charged() *big.Int {
+	if p.ActualAmount != nil {
+		return p.ActualAmount
+	}
+	return p.Amount
 }
 
 func (p *Payment) ID() string {
@@ -99,11 +112,33 @@ type PaymentChannelData struct {
 	// Signature is a signature of last message containing Authorized amount.
 	// It is required to claim tokens from channel.
 	Signature []byte
+	// SignedAmount is the amount signed by Signature when it is greater than
+	// AuthorizedAmount, because the calls cost less than the client signed,
+	// see handler.ActualCostTrailer. It is nil when the amount signed is
+	// authorized.
+	SignedAmount *big.Int
+}
+
+// signedAmount returns the amount signed by the Signature
+func (data *PaymentChannelData) signedAmount() *big.Int {
+	if data.SignedAmount != nil {
+		return data.SignedAmount
+	}
+	return data.AuthorizedAmount
+}
+
+// actualAmount returns the amount authorized if it is less than the amount
+// signed, nil otherwise
+func (data *PaymentChannelData) actualAmount() *big.Int {
+	if data.SignedAmount != nil {
+		return data.AuthorizedAmount
+	}
+	return nil
 }
 
 func (data *PaymentChannelData) String() string {
-	return fmt.Sprintf("{ChannelID: %v, Nonce: %v, State: %v, Sender: %v, Recipient: %v, GroupId: %v, FullAmount: %v, Expiration: %v, Signer: %v, AuthorizedAmount: %v, Signature: %v",
-		data.ChannelID, data.Nonce, data.State, utils.AddressToHex(&data.Sender), utils.AddressToHex(&data.Recipient), utils.BytesToBase64(data.GroupID[:]), data.FullAmount, data.Expiration, utils.AddressToHex(&data.Signer), data.AuthorizedAmount, utils.BytesToBase64(data.Signature))
+	return fmt.Sprintf("{ChannelID: %v, Nonce: %v, State: %v, Sender: %v, Recipient: %v, GroupId: %v, FullAmount: %v, Expiration: %v, Signer: %v, AuthorizedAmount: %v, Signature: %v, SignedAmount: %v",
+		data.ChannelID, data.Nonce, data.State, utils.AddressToHex(&data.Sender), utils.AddressToHex(&data.Recipient), utils.BytesToBase64(data.GroupID[:]), data.FullAmount, data.Expiration, utils.AddressToHex(&data.Signer), data.AuthorizedAmount, utils.BytesToBase64(data.Signature), data.SignedAmount)
 }
 
 // PaymentChannelService interface is API for payment channel functionality.
@@ -122,8 +157,10 @@ type PaymentChannelService interface {
 	// ListClaims returns list of payment claims in progress
 	ListClaims() (claim []Claim, err error)
 
-	// StartPaymentTransaction validates payment and starts payment transaction
-	StartPaymentTransaction(payment *Payment) (transaction PaymentTransaction, err error)
+	// StartPaymentTransaction validates payment and starts payment
+	// transaction. Depending on the lock policy it may wait for the channel
+	// which is used by another call until ctx is done.
+	StartPaymentTransaction(ctx context.Context, payment *Payment) (transaction PaymentTransaction, err error)
 
 	//Get Channel from BlockChain
 	PaymentChannelFromBlockChain(key *PaymentChannelKey) (channel *PaymentChannelData, ok bool, err error)
@@ -145,6 +182,12 @@ const (
 	FailedPrecondition PaymentErrorCode = 3
 	// IncorrectNonce is returned when nonce value sent by client is incorrect.
 	IncorrectNonce PaymentErrorCode = 4
+	// ResourceExhausted means that too many calls are waiting for the same
+	// payment channel.
+	ResourceExhausted PaymentErrorCode = 5
+	// DeadlineExceeded means that call deadline is exceeded while waiting for
+	// the payment channel.
+	DeadlineExceeded PaymentErrorCode = 6
 )
 
 // PaymentError contains error code and message and implements Error interface.
@@ -211,5 +254,6 @@ var (
 		channel.FullAmount = (&big.Int{}).Sub(channel.FullAmount, channel.AuthorizedAmount)
 		channel.AuthorizedAmount = big.NewInt(0)
 		channel.Signature = nil
+		channel.SignedAmount = nil
 	}
 )
@@ -1,14 +1,14 @@
 package escrow
 
 import (
-	"bytes"
-	"encoding/gob"
+	"context"
 	"fmt"
 	"math/big"
 	"reflect"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/singnet/snet-daemon/v6/storage"
+	"github.com/singnet/snet-daemon/v6/utils"
 	"github.com/spf13/viper"
 	"go.uber.org/zap"
 
@@ -19,6 +19,7 @@ import (
 // PaymentChannelKey based on TypedAtomicStorage implementation
 type PaymentChannelStorage struct {
 	delegate storage.TypedAtomicStorage
+	cache    *ChannelCache
 }
 
 // NewPaymentChannelStorage returns new instance of PaymentChannelStorage
@@ -33,29 +34,60 @@ func NewPaymentChannelStorage(atomicStorage storage.AtomicStorage) *PaymentChann
 
 }
 
+// NewCachedPaymentChannelStorage returns PaymentChannelStorage which reads
+// channels through the cache. The storage is watched until ctx is done to
+// invalidate channels changed by other replicas.
+func NewCachedPaymentChannelStorage(ctx context.Context, atomicStorage storage.AtomicStorage, cache *ChannelCache) *PaymentChannelStorage {
+	channelStorage := NewPaymentChannelStorage(atomicStorage)
+	channelStorage.cache = cache
+	go cache.watch(ctx, channelStorage.delegate)
+	return channelStorage
+}
+
 func serializeKey(key any) (slice string, err error) {
 	return fmt.Sprintf("%v", key), nil
 }
 func serialize(value any) (slice string, err error) {
-	var b bytes.Buffer
-	e := gob.NewEncoder(&b)
-	err = e.Encode(value)
-	if err != nil {
-		return
-	}
-
-	return b.String(), err
+	return utils.Serialize(value)
 }
 
 func deserialize(slice string, value any) (err error) {
-	b := bytes.NewBuffer([]byte(slice))
-	d := gob.NewDecoder(b)
-	err = d.Decode(value)
-	return
+	return utils.Deserialize(slice, value)
 }
 
 // Get returns payment channel by key
 func (storage *PaymentChannelStorage) Get(key *PaymentChannelKey) (state *PaymentChannelData, ok bool, err error) {
+	if storage.cache == nil {
+		return storage.get(key)
+	}
+
+	cacheKey, _ := serializeKey(key)
+	if state, ok = storage.cache.getStorage(cacheKey); ok {
+		return state, true, nil
+	}
+	version := storage.cache.storageVersion(cacheKey)
+	if state, ok, err = storage.get(key); err == nil && ok {
+		storage.cache.putStorage(cacheKey, state, version)
+	}
+	return
+}
+
+// GetLatest returns payment channel by key reading it from the storage
+// instead of the cache, the cache is refreshed with the state read
+func (storage *PaymentChannelStorage) GetLatest(key *PaymentChannelKey) (state *PaymentChannelData, ok bool, err error) {
+	if storage.cache == nil {
+		return storage.get(key)
+	}
+
+	cacheKey, _ := serializeKey(key)
+	version := storage.cache.storageVersion(cacheKey)
+	if state, ok, err = storage.get(key); err == nil && ok {
+		storage.cache.putStorage(cacheKey, state, version)
+	}
+	return
+}
+
+func (storage *PaymentChannelStorage) get(key *PaymentChannelKey) (state *PaymentChannelData, ok bool, err error) {
 	value, ok, err := storage.delegate.Get(key)
 	if err != nil || !ok {
 		return nil, ok, err
@@ -63,6 +95,20 @@ func (storage *PaymentChannelStorage) Get(key *PaymentChannelKey) (state *Paymen
 	return value.(*PaymentChannelData), ok, err
 }
 
+// written updates the cache after the channel is written, ok is false when
+// the write was rejected because the channel in the storage is different
+func (storage *PaymentChannelStorage) written(key *PaymentChannelKey, state *PaymentChannelData, ok bool, err error) {
+	if storage.cache == nil {
+		return
+	}
+	cacheKey, _ := serializeKey(key)
+	if err == nil && ok {
+		storage.cache.setStorage(cacheKey, state)
+	} else {
+		storage.cache.invalidateStorage(cacheKey)
+	}
+}
+
 // GetAll returns all channels from the storage
 func (storage *PaymentChannelStorage) GetAll() (states []*PaymentChannelData, err error) {
 	values, err := storage.delegate.GetAll()
@@ -75,23 +121,38 @@ func (storage *PaymentChannelStorage) GetAll() (states []*PaymentChannelData, er
 
 // Put stores payment channel by key
 func (storage *PaymentChannelStorage) Put(key *PaymentChannelKey, state *PaymentChannelData) (err error) {
-	return storage.delegate.Put(key, state)
+	err = storage.delegate.Put(key, state)
+	storage.written(key, state, true, err)
+	return
 }
 
 // PutIfAbsent storage payment channel by key if key is absent
 func (storage *PaymentChannelStorage) PutIfAbsent(key *PaymentChannelKey, state *PaymentChannelData) (ok bool, err error) {
-	return storage.delegate.PutIfAbsent(key, state)
+	ok, err = storage.delegate.PutIfAbsent(key, state)
+	storage.written(key, state, ok, err)
+	return
 }
 
 // CompareAndSwap compares previous storage value and set new value by key
 func (storage *PaymentChannelStorage) CompareAndSwap(key *PaymentChannelKey, prevState *PaymentChannelData, newState *PaymentChannelData) (ok bool, err error) {
-	return storage.delegate.CompareAndSwap(key, prevState, newState)
+	ok, err = storage.delegate.CompareAndSwap(key, prevState, newState)
+	storage.written(key, newState, ok, err)
+	return
+}
+
+// Watch returns the changes of the channels written after the call, Key of
+// the event is the serialized PaymentChannelKey, Value is nil if the channel
+// is deleted. The returned channel is closed when ctx is done or the watch is
+// closed by the storage.
+func (storage *PaymentChannelStorage) Watch(ctx context.Context) (events <-chan storage.TypedKeyValueData, err error) {
+	return storage.delegate.Watch(ctx)
 }
 
 // BlockchainChannelReader reads channel state from blockchain
 type BlockchainChannelReader struct {
 	readChannelFromBlockchain func(channelID *big.Int) (channel *blockchain.MultiPartyEscrowChannel, ok bool, err error)
 	recipientPaymentAddress   func() common.Address
+	cache                     *ChannelCache
 }
 
 // NewBlockchainChannelReader returns a new instance of blockchain channel reader
@@ -106,9 +167,43 @@ func NewBlockchainChannelReader(processor blockchain.Processor, cfg *viper.Viper
 	}
 }
 
+// NewCachedBlockchainChannelReader returns a new instance of blockchain
+// channel reader which keeps found channels in the cache
+func NewCachedBlockchainChannelReader(processor blockchain.Processor, cfg *viper.Viper,
+	orgMetadata *blockchain.OrganizationMetaData, cache *ChannelCache) *BlockchainChannelReader {
+	reader := NewBlockchainChannelReader(processor, cfg, orgMetadata)
+	reader.cache = cache
+	return reader
+}
+
 // GetChannelStateFromBlockchain returns channel state from Ethereum
 // blockchain. ok is false if channel was not found.
 func (reader *BlockchainChannelReader) GetChannelStateFromBlockchain(key *PaymentChannelKey) (channel *PaymentChannelData, ok bool, err error) {
+	if reader.cache == nil {
+		return reader.read(key)
+	}
+
+	cacheKey, _ := serializeKey(key)
+	if channel, ok = reader.cache.getBlockchain(cacheKey); ok {
+		return channel, true, nil
+	}
+	if channel, ok, err = reader.read(key); err == nil && ok {
+		reader.cache.putBlockchain(cacheKey, channel)
+	}
+	return
+}
+
+// invalidate removes the channel from the cache, ok is false if the channel
+// wasn't cached
+func (reader *BlockchainChannelReader) invalidate(key *PaymentChannelKey) (ok bool) {
+	if reader.cache == nil {
+		return false
+	}
+	cacheKey, _ := serializeKey(key)
+	return reader.cache.invalidateBlockchain(cacheKey)
+}
+
+func (reader *BlockchainChannelReader) read(key *PaymentChannelKey) (channel *PaymentChannelData, ok bool, err error) {
 	ch, ok, err := reader.readChannelFromBlockchain(key.ID)
 	if err != nil || !ok {
 		zap.L().Warn("Unsuccessful GetChannelStateFromBlockchain", zap.Error(err), zap.Bool("ok", ok))
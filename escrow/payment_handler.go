@@ -1,6 +1,7 @@
 package escrow
 
 import (
+	"context"
 	"math/big"
 
 	"github.com/ethereum/go-ethereum/common"
@@ -26,6 +27,8 @@ type paymentChannelPaymentHandler struct {
 	mpeContractAddress func() common.Address
 	incomeValidator    IncomeStreamValidator
 	currentBlock       func() (*big.Int, error)
+	streams            *StreamPayments
+	streamConf         StreamPaymentConf
 }
 
 // NewPaymentHandler returns new MultiPartyEscrow contract payment handler.
@@ -41,6 +44,25 @@ func NewPaymentHandler(
 	}
 }
 
+// NewPaymentHandlerWithStreamPayments returns new MultiPartyEscrow contract
+// payment handler which charges streams while they are in progress according
+// to streamConf. Payments of the streams in progress are kept in streams.
+func NewPaymentHandlerWithStreamPayments(
+	service PaymentChannelService,
+	processor blockchain.Processor,
+	incomeValidator IncomeStreamValidator,
+	streams *StreamPayments,
+	streamConf StreamPaymentConf) handler.StreamPaymentHandler {
+	return &paymentChannelPaymentHandler{
+		service:            service,
+		mpeContractAddress: processor.EscrowContractAddress,
+		currentBlock:       processor.CurrentBlock,
+		incomeValidator:    incomeValidator,
+		streams:            streams,
+		streamConf:         streamConf,
+	}
+}
+
 func (h *paymentChannelPaymentHandler) Type() (typ string) {
 	return EscrowPaymentType
 }
@@ -51,7 +73,7 @@ func (h *paymentChannelPaymentHandler) Payment(context *handler.GrpcStreamContex
 		return
 	}
 
-	transaction, e := h.service.StartPaymentTransaction(internalPayment)
+	transaction, e := h.service.StartPaymentTransaction(streamContext(context), internalPayment)
 	if e != nil {
 		return nil, paymentErrorToGrpcError(e)
 	}
@@ -65,9 +87,41 @@ func (h *paymentChannelPaymentHandler) Payment(context *handler.GrpcStreamContex
 		return nil, paymentErrorToGrpcError(e)
 	}
 
+	if paymentTransaction, ok := transaction.(*paymentTransaction); ok && h.chargesStream(context) {
+		// the income is validated to be equal to the price, so it pays for
+		// the first unit of the stream
+		metered, e := newMeteredPayment(paymentTransaction, h.streamConf, h.streams, income)
+		if e != nil {
+			transaction.Rollback()
+			return nil, paymentErrorToGrpcError(e)
+		}
+		return metered, nil
+	}
+
 	return transaction, nil
 }
 
+func (h *paymentChannelPaymentHandler) chargesStream(context *handler.GrpcStreamContext) bool {
+	return h.streams != nil && context.Info != nil && h.streamConf.charges(context.Info.FullMethod)
+}
+
+// streamContext returns the context of the call which is used to wait for
+// the payment channel
+func streamContext(grpcContext *handler.GrpcStreamContext) context.Context {
+	if grpcContext.InStream == nil {
+		return context.Background()
+	}
+	return grpcContext.InStream.Context()
+}
+
+// unaryContext returns the context of the unary call
+func unaryContext(grpcContext *handler.GrpcUnaryContext) context.Context {
+	if grpcContext.Context == nil {
+		return context.Background()
+	}
+	return grpcContext.Context
+}
+
 func (h *paymentChannelPaymentHandler) getPaymentFromContext(context *handler.GrpcStreamContext) (payment *Payment, err *handler.GrpcError) {
 	channelID, err := handler.GetBigInt(context.MD, handler.PaymentChannelIDHeader)
 	if err != nil {
@@ -99,12 +153,22 @@ func (h *paymentChannelPaymentHandler) getPaymentFromContext(context *handler.Gr
 }
 
 func (h *paymentChannelPaymentHandler) Complete(payment handler.Payment) (err *handler.GrpcError) {
-	if err = paymentErrorToGrpcError(payment.(*paymentTransaction).Commit()); err == nil {
-		go PublishChannelStats(payment, h.currentBlock)
+	transaction := completedTransaction(payment)
+	if err = paymentErrorToGrpcError(transaction.Commit()); err == nil {
+		go PublishChannelStats(transaction, h.currentBlock)
 	}
 	return err
 }
 
+// completedTransaction returns the transaction of the payment, the payment
+// of the stream can't be increased after that
+func completedTransaction(payment handler.Payment) *paymentTransaction {
+	if metered, ok := payment.(*meteredPayment); ok {
+		return metered.complete()
+	}
+	return payment.(*paymentTransaction)
+}
+
 func PublishChannelStats(payment handler.Payment, currentBlock func() (*big.Int, error)) (grpcErr *handler.GrpcError) {
 	if !config.GetBool(config.MeteringEnabled) {
 		return nil
@@ -142,7 +206,14 @@ func PublishChannelStats(payment handler.Payment, currentBlock func() (*big.Int,
 }
 
 func (h *paymentChannelPaymentHandler) CompleteAfterError(payment handler.Payment, result error) (err *handler.GrpcError) {
-	return paymentErrorToGrpcError(payment.(*paymentTransaction).Rollback())
+	return paymentErrorToGrpcError(completedTransaction(payment).Rollback())
+}
+
+// CompletePartially charges fraction of the price of the failed call, the
+// rest of the amount signed stays with the client
+func (h *paymentChannelPaymentHandler) CompletePartially(payment handler.Payment, fraction float64) (err *handler.GrpcError) {
+	completedTransaction(payment).chargePartially(fraction)
+	return h.Complete(payment)
 }
 
 func paymentErrorToGrpcError(err error) *handler.GrpcError {
@@ -164,6 +235,10 @@ func paymentErrorToGrpcError(err error) *handler.GrpcError {
 		grpcCode = codes.FailedPrecondition
 	case IncorrectNonce:
 		grpcCode = handler.IncorrectNonce
+	case ResourceExhausted:
+		grpcCode = codes.ResourceExhausted
+	case DeadlineExceeded:
+		grpcCode = codes.DeadlineExceeded
 	default:
 		grpcCode = codes.Internal
 	}
@@ -9,6 +9,8 @@ import (
 type PrePaidService interface {
 	GetUsage(key PrePaidDataKey) (*PrePaidData, bool, error)
 	UpdateUsage(channelId *big.Int, revisedAmount *big.Int, updateUsageType string) error
+	// GetUsageData returns planned, used and refunded amounts of the channel
+	GetUsageData(channelId *big.Int) (*PrePaidUsageData, error)
 }
 
 type PrePaidTransaction interface {
@@ -1,6 +1,8 @@
 package escrow
 
 import (
+	"math/big"
+
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/singnet/snet-daemon/v6/blockchain"
 	"github.com/singnet/snet-daemon/v6/config"
@@ -17,12 +19,18 @@ const (
 type PrePaidPaymentValidator struct {
 	priceStrategy *pricing.PricingStrategy
 	tokenManager  token.Manager
+	// tokens keeps the revoked tokens, revocation is not checked if it is nil
+	tokens *PrePaidTokenStorage
 }
 
-func NewPrePaidPaymentValidator(pricing *pricing.PricingStrategy, manager token.Manager) *PrePaidPaymentValidator {
+// NewPrePaidPaymentValidator returns the validator which rejects the tokens
+// revoked in tokens storage, revocation is not checked if tokens is nil
+func NewPrePaidPaymentValidator(pricing *pricing.PricingStrategy, manager token.Manager,
+	tokens *PrePaidTokenStorage) *PrePaidPaymentValidator {
 	return &PrePaidPaymentValidator{
 		priceStrategy: pricing,
 		tokenManager:  manager,
+		tokens:        tokens,
 	}
 }
 
@@ -39,18 +47,28 @@ func (validator *PrePaidPaymentValidator) Validate(payment *PrePaidPayment) (add
 	if err != nil {
 		return [20]byte{}, err
 	}
+	if validator.tokens != nil {
+		revoked, err := validator.tokens.IsRevoked(payment.AuthToken)
+		if err != nil {
+			return [20]byte{}, NewPaymentError(Internal, "unable to check token revocation: %v", err)
+		}
+		if revoked {
+			return [20]byte{}, NewPaymentError(Unauthenticated, "token %v is revoked", PrePaidTokenID(payment.AuthToken))
+		}
+	}
 	return common.HexToAddress(userAddress), err
 }
 
-// NewPrePaidPaymentHandler returns new MultiPartyEscrow contract payment handler.
+// NewPrePaidPaymentHandler returns new prepaid payment handler which validates
+// the tokens by validator
 func NewPrePaidPaymentHandler(
 	PrePaidService PrePaidService, metadata *blockchain.OrganizationMetaData,
-	pServiceMetaData *blockchain.ServiceMetadata, pricing *pricing.PricingStrategy, manager token.Manager) handler.StreamPaymentHandler {
+	pServiceMetaData *blockchain.ServiceMetadata, validator *PrePaidPaymentValidator) handler.StreamPaymentHandler {
 	return &PrePaidPaymentHandler{
 		service:                 PrePaidService,
 		orgMetadata:             metadata,
 		serviceMetadata:         pServiceMetaData,
-		PrePaidPaymentValidator: NewPrePaidPaymentValidator(pricing, manager),
+		PrePaidPaymentValidator: validator,
 	}
 }
 
@@ -128,3 +146,16 @@ func (h *PrePaidPaymentHandler) CompleteAfterError(payment handler.Payment, resu
 		zap.Any("usage", prePaidTransaction.Price()), zap.Any("channelID", prePaidTransaction.ChannelId()))
 	return err
 }
+
+// CompletePartially charges fraction of the price of the failed call and
+// refunds the rest
+func (h *PrePaidPaymentHandler) CompletePartially(payment handler.Payment, fraction float64) (err *handler.GrpcError) {
+	prePaidTransaction := payment.(PrePaidTransaction)
+	charged := new(big.Rat).Mul(new(big.Rat).SetInt(prePaidTransaction.Price()), new(big.Rat).SetFloat64(fraction))
+	refund := new(big.Int).Sub(prePaidTransaction.Price(), new(big.Int).Quo(charged.Num(), charged.Denom()))
+	if err = paymentErrorToGrpcError(h.service.UpdateUsage(prePaidTransaction.ChannelId(), refund, REFUND_AMOUNT)); err != nil {
+		zap.L().Error("usage INCONSISTENT state on Channel, usage wrongly increased", zap.Error(err.Err()),
+			zap.Any("refund", refund), zap.Any("ChannelID", prePaidTransaction.ChannelId()))
+	}
+	return err
+}
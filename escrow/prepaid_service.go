@@ -33,6 +33,19 @@ func (h *lockingPrepaidService) GetUsage(key PrePaidDataKey) (data *PrePaidData,
 	return value.(*PrePaidData), ok, err
 }
 
+func (h *lockingPrepaidService) GetUsageData(channelId *big.Int) (data *PrePaidUsageData, err error) {
+	keys := getAllKeys(channelId)
+	values := make([]storage.TypedKeyValueData, len(keys))
+	for i, key := range keys {
+		value, ok, err := h.storage.Get(key)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = storage.TypedKeyValueData{Key: key, Value: value, Present: ok}
+	}
+	return convertTypedDataToPrePaidUsage(values)
+}
+
 // ConditionFunc Defines the condition that needs to be met, it generates the respective typed Data when
 // conditions are satisfied; you define your own validations in here
 // It takes in the latest typed values read.
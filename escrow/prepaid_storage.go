@@ -59,6 +59,13 @@ func (data *PrePaidUsageData) String() string {
 		data.ChannelID, data.PlannedAmount, data.UsedAmount, data.RefundAmount, data.UpdateUsageType)
 }
 
+// RemainingAmount returns the amount which can be used by the calls, the
+// refunded amount can be used again
+func (data *PrePaidUsageData) RemainingAmount() *big.Int {
+	remaining := new(big.Int).Add(data.PlannedAmount, data.RefundAmount)
+	return remaining.Sub(remaining, data.UsedAmount)
+}
+
 func (data *PrePaidUsageData) GetAmountForUsageType() (*big.Int, error) {
 	switch data.UpdateUsageType {
 	case PLANNED_AMOUNT:
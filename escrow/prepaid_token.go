@@ -0,0 +1,147 @@
+package escrow
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"math/big"
+	"reflect"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/singnet/snet-daemon/v6/storage"
+)
+
+// PrePaidToken is a token issued by TokenService.GetToken, the token itself
+// is not kept, it is identified by PrePaidTokenID
+type PrePaidToken struct {
+	ID          string
+	ChannelID   *big.Int
+	UserAddress string
+	IssuedAt    time.Time
+	ExpiresAt   time.Time
+	Revoked     bool
+	RevokedAt   time.Time
+}
+
+func (token *PrePaidToken) String() string {
+	return fmt.Sprintf("{ID:%v,ChannelID:%v,UserAddress:%v,IssuedAt:%v,ExpiresAt:%v,Revoked:%v,RevokedAt:%v}",
+		token.ID, token.ChannelID, token.UserAddress, token.IssuedAt, token.ExpiresAt, token.Revoked, token.RevokedAt)
+}
+
+// PrePaidTokenID returns the id of the token, the id can be published
+// without disclosing the token
+func PrePaidTokenID(token string) string {
+	hash := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(hash[:16])
+}
+
+// PrePaidTokenStorage keeps the tokens issued until they are expired, so the
+// outstanding tokens can be listed and revoked
+type PrePaidTokenStorage struct {
+	storage storage.TypedAtomicStorage
+}
+
+// NewPrePaidTokenStorage returns new instance of PrePaidTokenStorage
+func NewPrePaidTokenStorage(atomicStorage storage.AtomicStorage) *PrePaidTokenStorage {
+	prefixedStorage := storage.NewPrefixedAtomicStorage(atomicStorage, "/PrePaid/tokens")
+	return &PrePaidTokenStorage{
+		storage: storage.NewTypedAtomicStorageImpl(prefixedStorage, serializePrePaidTokenKey, reflect.TypeOf(""),
+			serialize, deserialize, reflect.TypeOf(PrePaidToken{})),
+	}
+}
+
+func serializePrePaidTokenKey(key any) (serialized string, err error) {
+	return fmt.Sprintf("%v", key), nil
+}
+
+// Put records the issued token
+func (tokenStorage *PrePaidTokenStorage) Put(token *PrePaidToken) error {
+	return tokenStorage.storage.Put(token.ID, token)
+}
+
+// PutIfAbsent records the issued token unless it is recorded already, the
+// token issued again within the same second is the same string
+func (tokenStorage *PrePaidTokenStorage) PutIfAbsent(token *PrePaidToken) (ok bool, err error) {
+	return tokenStorage.storage.PutIfAbsent(token.ID, token)
+}
+
+// Get returns the token by id
+func (tokenStorage *PrePaidTokenStorage) Get(id string) (token *PrePaidToken, ok bool, err error) {
+	value, ok, err := tokenStorage.storage.Get(id)
+	if err != nil || !ok {
+		return nil, ok, err
+	}
+	return value.(*PrePaidToken), true, nil
+}
+
+// IsRevoked returns true if the token is revoked, the tokens issued before
+// the storage was enabled are never revoked
+func (tokenStorage *PrePaidTokenStorage) IsRevoked(token string) (bool, error) {
+	record, ok, err := tokenStorage.Get(PrePaidTokenID(token))
+	if err != nil || !ok {
+		return false, err
+	}
+	return record.Revoked, nil
+}
+
+// List returns the tokens of the channel which are not expired yet, the
+// tokens of all channels are returned when channelID is nil. Expired tokens
+// are removed from the storage.
+func (tokenStorage *PrePaidTokenStorage) List(channelID *big.Int) (tokens []*PrePaidToken, err error) {
+	values, err := tokenStorage.storage.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	tokens = make([]*PrePaidToken, 0)
+	for _, token := range values.([]*PrePaidToken) {
+		if !now.Before(token.ExpiresAt) {
+			if err = tokenStorage.storage.Delete(token.ID); err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if channelID == nil || token.ChannelID.Cmp(channelID) == 0 {
+			tokens = append(tokens, token)
+		}
+	}
+	slices.SortFunc(tokens, func(a, b *PrePaidToken) int {
+		if c := a.ChannelID.Cmp(b.ChannelID); c != 0 {
+			return c
+		}
+		if c := a.IssuedAt.Compare(b.IssuedAt); c != 0 {
+			return c
+		}
+		return strings.Compare(a.ID, b.ID)
+	})
+	return tokens, nil
+}
+
+// Revoke revokes the token of the channel by id, all outstanding tokens of
+// the channel are revoked when id is empty. It returns the tokens revoked.
+func (tokenStorage *PrePaidTokenStorage) Revoke(channelID *big.Int, id string) (revoked []*PrePaidToken, err error) {
+	tokens, err := tokenStorage.List(channelID)
+	if err != nil {
+		return nil, err
+	}
+	revoked = make([]*PrePaidToken, 0)
+	now := time.Now().UTC()
+	for _, token := range tokens {
+		if id != "" && token.ID != id {
+			continue
+		}
+		if !token.Revoked {
+			token.Revoked, token.RevokedAt = true, now
+			if err = tokenStorage.Put(token); err != nil {
+				return nil, err
+			}
+		}
+		revoked = append(revoked, token)
+	}
+	if id != "" && len(revoked) == 0 {
+		return nil, fmt.Errorf("token %v is not found in channel %v", id, channelID)
+	}
+	return revoked, nil
+}
@@ -0,0 +1,189 @@
+package escrow
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/math"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/singnet/snet-daemon/v6/blockchain"
+	"github.com/singnet/snet-daemon/v6/storage"
+	"github.com/singnet/snet-daemon/v6/token"
+)
+
+func putTestPrePaidToken(t *testing.T, tokens *PrePaidTokenStorage, id string, channelID int64, expiresIn time.Duration) {
+	now := time.Now().UTC()
+	require.NoError(t, tokens.Put(&PrePaidToken{ID: id, ChannelID: big.NewInt(channelID), UserAddress: "0x1",
+		IssuedAt: now, ExpiresAt: now.Add(expiresIn)}))
+}
+
+func TestPrePaidTokenStorage(t *testing.T) {
+	tokens := NewPrePaidTokenStorage(storage.NewMemStorage())
+	putTestPrePaidToken(t, tokens, "a", 1, time.Hour)
+	putTestPrePaidToken(t, tokens, "b", 1, time.Hour)
+	putTestPrePaidToken(t, tokens, "c", 2, time.Hour)
+	putTestPrePaidToken(t, tokens, "expired", 1, -time.Second)
+
+	all, err := tokens.List(nil)
+	require.NoError(t, err)
+	assert.Len(t, all, 3)
+	_, ok, err := tokens.Get("expired")
+	require.NoError(t, err)
+	assert.False(t, ok, "expired token should be removed")
+
+	revoked, err := tokens.Revoke(big.NewInt(1), "b")
+	require.NoError(t, err)
+	require.Len(t, revoked, 1)
+	assert.True(t, revoked[0].Revoked)
+	assert.False(t, revoked[0].RevokedAt.IsZero())
+
+	_, err = tokens.Revoke(big.NewInt(2), "a")
+	assert.EqualError(t, err, "token a is not found in channel 2")
+
+	revoked, err = tokens.Revoke(big.NewInt(1), "")
+	require.NoError(t, err)
+	assert.Len(t, revoked, 2)
+	channelTokens, err := tokens.List(big.NewInt(2))
+	require.NoError(t, err)
+	require.Len(t, channelTokens, 1)
+	assert.False(t, channelTokens[0].Revoked)
+
+	// the token reissued within the same second stays revoked
+	now := time.Now().UTC()
+	ok, err = tokens.PutIfAbsent(&PrePaidToken{ID: "a", ChannelID: big.NewInt(1), UserAddress: "0x1",
+		IssuedAt: now, ExpiresAt: now.Add(time.Hour)})
+	require.NoError(t, err)
+	assert.False(t, ok)
+	token, _, err := tokens.Get("a")
+	require.NoError(t, err)
+	assert.True(t, token.Revoked)
+}
+
+func TestPrePaidPaymentValidator_RejectsRevokedToken(t *testing.T) {
+	orgMetadata, err := blockchain.InitOrganizationMetaDataFromJson([]byte(testJsonOrgGroupData))
+	require.NoError(t, err)
+	manager := token.NewJWTTokenService(*orgMetadata, nil)
+	tokens := NewPrePaidTokenStorage(storage.NewMemStorage())
+	validator := NewPrePaidPaymentValidator(nil, manager, tokens)
+
+	authToken, err := manager.CreateToken(big.NewInt(1), "0x1")
+	require.NoError(t, err)
+	payment := &PrePaidPayment{ChannelID: big.NewInt(1), AuthToken: authToken.(string)}
+	putTestPrePaidToken(t, tokens, PrePaidTokenID(payment.AuthToken), 1, time.Hour)
+
+	_, err = validator.Validate(payment)
+	assert.NoError(t, err)
+
+	_, err = tokens.Revoke(big.NewInt(1), PrePaidTokenID(payment.AuthToken))
+	require.NoError(t, err)
+	_, err = validator.Validate(payment)
+	assert.Equal(t, NewPaymentError(Unauthenticated, "token %v is revoked", PrePaidTokenID(payment.AuthToken)), err)
+}
+
+type testPrePaidTokenService struct {
+	service    *TokenService
+	tokens     *PrePaidTokenStorage
+	signer     *ecdsa.PrivateKey
+	provider   *ecdsa.PrivateKey
+	mpeAddress common.Address
+}
+
+func newTestPrePaidTokenService(t *testing.T) *testPrePaidTokenService {
+	test := &testPrePaidTokenService{
+		tokens:   NewPrePaidTokenStorage(storage.NewMemStorage()),
+		signer:   GenerateTestPrivateKey(),
+		provider: GenerateTestPrivateKey(),
+	}
+	orgJson := strings.Replace(testJsonOrgGroupData, "0x671276c61943A35D5F230d076bDFd91B0c47bF09",
+		crypto.PubkeyToAddress(test.provider.PublicKey).Hex(), -1)
+	orgMetadata, err := blockchain.InitOrganizationMetaDataFromJson([]byte(orgJson))
+	require.NoError(t, err)
+	serviceMetadata := &blockchain.ServiceMetadata{}
+	test.mpeAddress = serviceMetadata.GetMpeAddress()
+
+	channelService := &paymentChannelServiceMock{}
+	channelService.Put(&PaymentChannelKey{ID: big.NewInt(1)}, &PaymentChannelData{
+		ChannelID: big.NewInt(1),
+		Sender:    common.HexToAddress("0x2"),
+		Signer:    crypto.PubkeyToAddress(test.signer.PublicKey),
+	})
+	usage := NewPrePaidService(NewPrepaidStorage(storage.NewMemStorage()), nil, nil)
+	require.NoError(t, usage.UpdateUsage(big.NewInt(1), big.NewInt(100), PLANNED_AMOUNT))
+	require.NoError(t, usage.UpdateUsage(big.NewInt(1), big.NewInt(30), USED_AMOUNT))
+	require.NoError(t, usage.UpdateUsage(big.NewInt(1), big.NewInt(10), REFUND_AMOUNT))
+
+	test.service = NewTokenService(channelService, usage, nil, nil, serviceMetadata, orgMetadata, test.tokens)
+	test.service.allowedBlockNumberCheck = func(blockNumber *big.Int) error { return nil }
+	return test
+}
+
+func (test *testPrePaidTokenService) sign(prefix string, channelID uint64, params string, privateKey *ecdsa.PrivateKey) []byte {
+	message := bytes.Join([][]byte{
+		[]byte(prefix),
+		test.mpeAddress.Bytes(),
+		math.U256Bytes(new(big.Int).SetUint64(channelID)),
+		[]byte(params),
+		math.U256Bytes(big.NewInt(99)),
+	}, nil)
+	return getSignature(message, privateKey)
+}
+
+func TestTokenService_GetPrePaidUsage(t *testing.T) {
+	test := newTestPrePaidTokenService(t)
+
+	reply, err := test.service.GetPrePaidUsage(context.Background(), &PrePaidUsageRequest{ChannelId: 1, CurrentBlock: 99,
+		Signature: test.sign("__get_prepaid_usage", 1, "", test.signer)})
+	require.NoError(t, err)
+	assert.Equal(t, &PrePaidUsageReply{ChannelId: 1, PlannedAmount: 100, UsedAmount: 30, RefundAmount: 10, RemainingAmount: 80}, reply)
+
+	_, err = test.service.GetPrePaidUsage(context.Background(), &PrePaidUsageRequest{ChannelId: 1, CurrentBlock: 99,
+		Signature: test.sign("__get_prepaid_usage", 1, "", GenerateTestPrivateKey())})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+}
+
+func TestTokenService_ListAndRevokeTokens(t *testing.T) {
+	test := newTestPrePaidTokenService(t)
+	putTestPrePaidToken(t, test.tokens, "a", 1, time.Hour)
+	putTestPrePaidToken(t, test.tokens, "b", 1, time.Hour)
+	putTestPrePaidToken(t, test.tokens, "c", 2, time.Hour)
+
+	list, err := test.service.ListTokens(context.Background(), &ListTokensRequest{ChannelId: 1, CurrentBlock: 99,
+		Signature: test.sign("__list_prepaid_tokens", 1, "", test.signer)})
+	require.NoError(t, err)
+	require.Len(t, list.Tokens, 2)
+	assert.Equal(t, "a", list.Tokens[0].TokenId)
+
+	revoked, err := test.service.RevokeToken(context.Background(), &RevokeTokenRequest{ChannelId: 1, TokenId: "a", CurrentBlock: 99,
+		Signature: test.sign("__revoke_prepaid_token", 1, "a", test.signer)})
+	require.NoError(t, err)
+	require.Len(t, revoked.Tokens, 1)
+	assert.True(t, revoked.Tokens[0].Revoked)
+	assert.NotZero(t, revoked.Tokens[0].RevokedAt)
+
+	// the token id is a part of the signed message
+	_, err = test.service.RevokeToken(context.Background(), &RevokeTokenRequest{ChannelId: 1, TokenId: "b", CurrentBlock: 99,
+		Signature: test.sign("__revoke_prepaid_token", 1, "a", test.signer)})
+	assert.Equal(t, codes.PermissionDenied, status.Code(err))
+
+	// the provider manages the tokens of any channel
+	revoked, err = test.service.RevokeToken(context.Background(), &RevokeTokenRequest{ChannelId: 2, CurrentBlock: 99,
+		Signature: test.sign("__revoke_prepaid_token", 2, "", test.provider)})
+	require.NoError(t, err)
+	require.Len(t, revoked.Tokens, 1)
+	assert.Equal(t, "c", revoked.Tokens[0].TokenId)
+
+	_, err = test.service.RevokeToken(context.Background(), &RevokeTokenRequest{ChannelId: 1, TokenId: "c", CurrentBlock: 99,
+		Signature: test.sign("__revoke_prepaid_token", 1, "c", test.provider)})
+	assert.Equal(t, codes.NotFound, status.Code(err))
+}
@@ -7,6 +7,7 @@ import (
 	"errors"
 	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/singnet/snet-daemon/v6/blockchain"
 	"github.com/singnet/snet-daemon/v6/utils"
@@ -15,6 +16,7 @@ import (
 	"github.com/ethereum/go-ethereum/common/math"
 	"go.uber.org/zap"
 	"golang.org/x/net/context"
+	"google.golang.org/protobuf/proto"
 )
 
 const (
@@ -27,6 +29,12 @@ type PaymentChannelStateService struct {
 	paymentStorage               *PaymentStorage
 	mpeAddress                   func() (address common.Address)
 	compareWithLatestBlockNumber func(*big.Int) error
+	// watcher passes the changes made by payments and claims to
+	// WatchChannelState streams, the state is only polled if it is nil
+	watcher *channelStateWatcher
+	// watchInterval is the interval WatchChannelState polls the state with
+	// to see the changes made in the blockchain
+	watchInterval time.Duration
 }
 
 func (service *PaymentChannelStateService) mustEmbedUnimplementedPaymentChannelStateServiceServer() {
@@ -46,31 +54,54 @@ func (service *BlockChainDisabledStateService) GetChannelState(context context.C
 	return &ChannelStateReply{}, nil
 }
 
+func (service *BlockChainDisabledStateService) WatchChannelState(request *ChannelStateRequest, stream PaymentChannelStateService_WatchChannelStateServer) error {
+	return stream.Send(&ChannelStateUpdate{State: &ChannelStateReply{}})
+}
+
 // verifies whether storage channel nonce is equal to blockchain nonce or not
 func (service *PaymentChannelStateService) StorageNonceMatchesWithBlockchainNonce(storageChannel *PaymentChannelData) (equal bool, err error) {
-	h := service.channelService
-
-	blockchainChannel, ok, err := h.PaymentChannelFromBlockChain(&PaymentChannelKey{ID: storageChannel.ChannelID})
+	blockchainNonce, err := service.blockchainNonce(storageChannel)
 	if err != nil {
-		return false, errors.New("channel error:" + err.Error())
-	}
-	if !ok {
-		return false, errors.New("unable to read channel details from blockchain.")
+		return false, err
 	}
+	return storageChannel.Nonce.Cmp(blockchainNonce) == 0, nil
+}
 
-	return storageChannel.Nonce.Cmp(blockchainChannel.Nonce) == 0, nil
+func (service *PaymentChannelStateService) blockchainNonce(storageChannel *PaymentChannelData) (nonce *big.Int, err error) {
+	blockchainChannel, err := service.blockchainChannel(&PaymentChannelKey{ID: storageChannel.ChannelID})
+	if err != nil {
+		return nil, err
+	}
+	return blockchainChannel.Nonce, nil
 }
 
 // NewPaymentChannelStateService returns new instance of PaymentChannelStateService
 func NewPaymentChannelStateService(channelService PaymentChannelService, paymentStorage *PaymentStorage, processor blockchain.Processor) *PaymentChannelStateService {
-	return &PaymentChannelStateService{
+	return NewPaymentChannelStateServiceWithWatch(channelService, paymentStorage, nil, processor, DefaultChannelStateWatchInterval)
+}
+
+// DefaultChannelStateWatchInterval is the default interval WatchChannelState
+// polls the channel state with
+const DefaultChannelStateWatchInterval = 10 * time.Second
+
+// NewPaymentChannelStateServiceWithWatch returns the service which pushes
+// the changes of channelStorage to WatchChannelState streams as soon as they
+// are written and polls the blockchain state every watchInterval
+func NewPaymentChannelStateServiceWithWatch(channelService PaymentChannelService, paymentStorage *PaymentStorage,
+	channelStorage *PaymentChannelStorage, processor blockchain.Processor, watchInterval time.Duration) *PaymentChannelStateService {
+	service := &PaymentChannelStateService{
 		channelService: channelService,
 		paymentStorage: paymentStorage,
 		mpeAddress:     processor.EscrowContractAddress,
 		compareWithLatestBlockNumber: func(blockNumberPassed *big.Int) error {
 			return processor.CompareWithLatestBlockNumber(blockNumberPassed, AllowedBlockDifference)
 		},
+		watchInterval: watchInterval,
+	}
+	if channelStorage != nil {
+		service.watcher = newChannelStateWatcher(channelStorage, watchInterval)
 	}
+	return service
 }
 
 // GetChannelState returns the latest state of the channel which id is passed
@@ -91,6 +122,17 @@ func (service *PaymentChannelStateService) GetChannelState(context context.Conte
 		zap.Any("context", context),
 		zap.Any("request", request))
 
+	channel, err := service.authorize(request)
+	if err != nil {
+		return nil, err
+	}
+	reply, _, err = service.channelState(channel)
+	return reply, err
+}
+
+// authorize checks the signature of the request and returns the channel
+// requested, only channel signer/sender/receiver can get the channel state
+func (service *PaymentChannelStateService) authorize(request *ChannelStateRequest) (channel *PaymentChannelData, err error) {
 	channelID := bytesToBigInt(request.GetChannelId())
 	// signature verification
 	message := bytes.Join([][]byte{
@@ -120,14 +162,26 @@ func (service *PaymentChannelStateService) GetChannelState(context context.Conte
 	if err := service.compareWithLatestBlockNumber(big.NewInt(int64(request.CurrentBlock))); err != nil {
 		return nil, err
 	}
+	return channel, nil
+}
 
+// channelState returns the reply of GetChannelState for the channel and the
+// nonce of the channel in the blockchain
+func (service *PaymentChannelStateService) channelState(channel *PaymentChannelData) (reply *ChannelStateReply, blockchainNonce *big.Int, err error) {
 	// check if nonce matches with blockchain or not
-	nonceEqual, err := service.StorageNonceMatchesWithBlockchainNonce(channel)
+	blockchainNonce, err = service.blockchainNonce(channel)
 	if err != nil {
 		zap.L().Info("payment data not available in payment storage.", zap.Error(err))
-		return nil, err
+		return nil, nil, err
+	}
+	reply, err = service.channelStateWithNonce(channel, blockchainNonce)
+	return reply, blockchainNonce, err
+}
 
-	} else if !nonceEqual {
+// channelStateWithNonce returns the reply of GetChannelState for the channel
+// which has blockchainNonce in the blockchain
+func (service *PaymentChannelStateService) channelStateWithNonce(channel *PaymentChannelData, blockchainNonce *big.Int) (reply *ChannelStateReply, err error) {
+	if channel.Nonce.Cmp(blockchainNonce) != 0 {
 		// check for payments in the payment storage with current nonce - 1, this will happen  cli has issues in claiming process
 
 		paymentID := PaymentID(channel.ChannelID, (&big.Int{}).Sub(channel.Nonce, big.NewInt(1)))
@@ -137,15 +191,21 @@ func (service *PaymentChannelStateService) GetChannelState(context context.Conte
 			return nil, err
 		}
 		if !ok {
-			zap.L().Error("old payment is not found in storage, nevertheless local channel nonce is not equal to the blockchain one", zap.Any("ChannelID", channelID))
+			zap.L().Error("old payment is not found in storage, nevertheless local channel nonce is not equal to the blockchain one", zap.Any("ChannelID", channel.ChannelID))
 			return nil, errors.New("channel has different nonce in local storage and blockchain and old payment is not found in storage")
 		}
+		var oldNonceSignatureAmount []byte
+		if payment.ActualAmount != nil {
+			oldNonceSignatureAmount = bigIntToBytes(payment.Amount)
+		}
 		return &ChannelStateReply{
-			CurrentNonce:         bigIntToBytes(channel.Nonce),
-			CurrentSignedAmount:  bigIntToBytes(channel.AuthorizedAmount),
-			CurrentSignature:     channel.Signature,
-			OldNonceSignedAmount: bigIntToBytes(payment.Amount),
-			OldNonceSignature:    payment.Signature,
+			CurrentNonce:            bigIntToBytes(channel.Nonce),
+			CurrentSignedAmount:     bigIntToBytes(channel.AuthorizedAmount),
+			CurrentSignature:        channel.Signature,
+			CurrentSignatureAmount:  optionalBigIntToBytes(channel.SignedAmount),
+			OldNonceSignedAmount:    bigIntToBytes(payment.Charged()),
+			OldNonceSignature:       payment.Signature,
+			OldNonceSignatureAmount: oldNonceSignatureAmount,
 		}, nil
 	}
 
@@ -156,8 +216,128 @@ func (service *PaymentChannelStateService) GetChannelState(context context.Conte
 	}
 
 	return &ChannelStateReply{
-		CurrentNonce:        bigIntToBytes(channel.Nonce),
-		CurrentSignedAmount: bigIntToBytes(channel.AuthorizedAmount),
-		CurrentSignature:    channel.Signature,
+		CurrentNonce:           bigIntToBytes(channel.Nonce),
+		CurrentSignedAmount:    bigIntToBytes(channel.AuthorizedAmount),
+		CurrentSignature:       channel.Signature,
+		CurrentSignatureAmount: optionalBigIntToBytes(channel.SignedAmount),
 	}, nil
 }
+
+// WatchChannelState sends the channel state when the stream is opened and
+// then each time the state changes until the stream is closed by the client.
+// The request is authorized the same way as GetChannelState request. The
+// changes written to the storage by payments and claims are sent as soon as
+// they are seen, the blockchain state is polled every watchInterval.
+func (service *PaymentChannelStateService) WatchChannelState(request *ChannelStateRequest, stream PaymentChannelStateService_WatchChannelStateServer) error {
+	channel, err := service.authorize(request)
+	if err != nil {
+		return err
+	}
+	key := &PaymentChannelKey{ID: channel.ChannelID}
+	ctx := stream.Context()
+
+	var changes <-chan *PaymentChannelData
+	if service.watcher != nil {
+		var unsubscribe func()
+		changes, unsubscribe = service.watcher.subscribe(key)
+		defer unsubscribe()
+	}
+	ticker := time.NewTicker(service.watchInterval)
+	defer ticker.Stop()
+
+	blockchainChannel, err := service.blockchainChannel(key)
+	if err != nil {
+		return err
+	}
+	var last *ChannelStateUpdate
+	for {
+		update, err := service.channelStateUpdate(channel, blockchainChannel, last)
+		if err != nil {
+			// the initial state is sent the same way as GetChannelState
+			// reply, the errors after it are transient, i.e. the claim
+			// payment is not written yet when the nonce is incremented
+			if last == nil {
+				return err
+			}
+			zap.L().Debug("Unable to get channel state to watch", zap.Any("channelKey", key), zap.Error(err))
+		} else if update != nil {
+			if err = stream.Send(update); err != nil {
+				return err
+			}
+			last = update
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case storageChannel := <-changes:
+			// the state written is used as is, because the state read from
+			// the storage may be cached and not updated yet, the blockchain
+			// state is read on the next poll only
+			channel = blockchainChannel
+			if storageChannel != nil && blockchainChannel != nil {
+				channel = MergeStorageAndBlockchainChannelState(storageChannel, blockchainChannel)
+			}
+		case <-ticker.C:
+			if blockchainChannel, err = service.blockchainChannel(key); err != nil {
+				zap.L().Debug("Unable to read channel to watch from blockchain", zap.Any("channelKey", key), zap.Error(err))
+			}
+			channel, _, err = service.channelService.PaymentChannel(key)
+			if err != nil {
+				zap.L().Debug("Unable to read channel to watch", zap.Any("channelKey", key), zap.Error(err))
+			}
+		}
+	}
+}
+
+// blockchainChannel returns the state of the channel in the blockchain
+func (service *PaymentChannelStateService) blockchainChannel(key *PaymentChannelKey) (channel *PaymentChannelData, err error) {
+	channel, ok, err := service.channelService.PaymentChannelFromBlockChain(key)
+	if err != nil {
+		return nil, errors.New("channel error:" + err.Error())
+	}
+	if !ok {
+		return nil, errors.New("unable to read channel details from blockchain.")
+	}
+	return channel, nil
+}
+
+// channelStateUpdate returns the state of the channel, nil is returned if
+// the state is not changed since the last update
+func (service *PaymentChannelStateService) channelStateUpdate(channel, blockchainChannel *PaymentChannelData, last *ChannelStateUpdate) (update *ChannelStateUpdate, err error) {
+	if channel == nil || blockchainChannel == nil {
+		return nil, errors.New("channel is not available")
+	}
+	blockchainNonce := blockchainChannel.Nonce
+	reply, err := service.channelStateWithNonce(channel, blockchainNonce)
+	if err != nil {
+		return nil, err
+	}
+	update = &ChannelStateUpdate{
+		State:           reply,
+		BlockchainNonce: bigIntToBytes(blockchainNonce),
+		ClaimInProgress: channel.Nonce.Cmp(blockchainNonce) != 0,
+	}
+	if channel.FullAmount != nil {
+		update.Value = bigIntToBytes(channel.FullAmount)
+	}
+	if channel.Expiration != nil {
+		update.Expiration = channel.Expiration.Uint64()
+	}
+
+	switch {
+	case last == nil:
+		update.Reason = ChannelStateUpdate_INITIAL
+	case !bytes.Equal(last.State.CurrentNonce, reply.CurrentNonce):
+		update.Reason = ChannelStateUpdate_CLAIM_STARTED
+	case !bytes.Equal(last.BlockchainNonce, update.BlockchainNonce):
+		update.Reason = ChannelStateUpdate_CLAIM_FINISHED
+	case !proto.Equal(last.State, reply):
+		update.Reason = ChannelStateUpdate_PAYMENT
+	case !bytes.Equal(last.Value, update.Value) || last.Expiration != update.Expiration:
+		update.Reason = ChannelStateUpdate_BLOCKCHAIN
+	default:
+		return nil, nil
+	}
+	return update, nil
+}
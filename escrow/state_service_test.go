@@ -2,18 +2,27 @@ package escrow
 
 import (
 	"bytes"
+	"context"
 	"crypto/ecdsa"
 	"encoding/hex"
 	"errors"
 	"math/big"
+	"net"
 	"testing"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/singnet/snet-daemon/v6/blockchain"
+	"github.com/singnet/snet-daemon/v6/handler"
 	"github.com/singnet/snet-daemon/v6/storage"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 )
 
 type stateServiceTestType struct {
@@ -359,3 +368,206 @@ func TestGetChannelStateNonceIncrementedInBlockchainNoOldPayment(t *testing.T) {
 }
 
 // Claim tests are already added to escrow_test.go
+
+type watchChannelStateStreamMock struct {
+	grpc.ServerStream
+	ctx     context.Context
+	updates chan *ChannelStateUpdate
+}
+
+func (stream *watchChannelStateStreamMock) Context() context.Context {
+	return stream.ctx
+}
+
+func (stream *watchChannelStateStreamMock) Send(update *ChannelStateUpdate) error {
+	stream.updates <- update
+	return nil
+}
+
+func (stream *watchChannelStateStreamMock) next(t *testing.T) *ChannelStateUpdate {
+	select {
+	case update := <-stream.updates:
+		return update
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "channel state update is not sent")
+		return nil
+	}
+}
+
+func TestWatchChannelState(t *testing.T) {
+	// the default channel data is changed by the other tests
+	channel := &PaymentChannelData{
+		ChannelID:        stateServiceTest.defaultChannelId,
+		Sender:           stateServiceTest.senderAddress,
+		Signer:           stateServiceTest.signerAddress,
+		Recipient:        stateServiceTest.receiverAddress,
+		Signature:        []byte{5, 4, 3, 2, 1},
+		Nonce:            big.NewInt(3),
+		AuthorizedAmount: big.NewInt(12345),
+	}
+	stateServiceTest.channelServiceMock.Put(stateServiceTest.defaultChannelKey, channel)
+	defer cleanup()
+	channelStorage := NewPaymentChannelStorage(storage.NewMemStorage())
+	service := stateServiceTest.service
+	service.watcher = newChannelStateWatcher(channelStorage, time.Hour)
+	service.watchInterval = time.Hour
+
+	ctx, cancel := context.WithCancel(context.Background())
+	stream := &watchChannelStateStreamMock{ctx: ctx, updates: make(chan *ChannelStateUpdate)}
+	done := make(chan error)
+	go func() {
+		done <- service.WatchChannelState(stateServiceTest.defaultRequest, stream)
+	}()
+
+	update := stream.next(t)
+	assert.Equal(t, ChannelStateUpdate_INITIAL, update.Reason)
+	assert.Equal(t, bigIntToBytes(big.NewInt(3)), update.State.CurrentNonce)
+	assert.Equal(t, bigIntToBytes(big.NewInt(12345)), update.State.CurrentSignedAmount)
+	assert.Equal(t, bigIntToBytes(big.NewInt(3)), update.BlockchainNonce)
+	assert.False(t, update.ClaimInProgress)
+
+	paid := *channel
+	paid.AuthorizedAmount = big.NewInt(12346)
+	require.NoError(t, channelStorage.Put(stateServiceTest.defaultChannelKey, &paid))
+	update = stream.next(t)
+	assert.Equal(t, ChannelStateUpdate_PAYMENT, update.Reason)
+	assert.Equal(t, bigIntToBytes(big.NewInt(12346)), update.State.CurrentSignedAmount)
+
+	require.NoError(t, service.paymentStorage.Put(getPaymentFromChannel(&paid)))
+	claimed := paid
+	claimed.Nonce = big.NewInt(4)
+	claimed.AuthorizedAmount = big.NewInt(0)
+	claimed.Signature = nil
+	require.NoError(t, channelStorage.Put(stateServiceTest.defaultChannelKey, &claimed))
+	update = stream.next(t)
+	assert.Equal(t, ChannelStateUpdate_CLAIM_STARTED, update.Reason)
+	assert.Equal(t, bigIntToBytes(big.NewInt(4)), update.State.CurrentNonce)
+	assert.Equal(t, bigIntToBytes(big.NewInt(12346)), update.State.OldNonceSignedAmount)
+	assert.True(t, update.ClaimInProgress)
+
+	cancel()
+	assert.NoError(t, <-done)
+}
+
+func TestWatchChannelStateIncorrectSignature(t *testing.T) {
+	stateServiceTest.channelServiceMock.Put(
+		stateServiceTest.defaultChannelKey,
+		stateServiceTest.defaultChannelData,
+	)
+	defer cleanup()
+	request := &ChannelStateRequest{
+		ChannelId: bigIntToBytes(stateServiceTest.defaultChannelId),
+		Signature: []byte{0x00},
+	}
+
+	err := stateServiceTest.service.WatchChannelState(request, &watchChannelStateStreamMock{ctx: context.Background()})
+
+	assert.Error(t, err)
+}
+
+// receiveAuthorizedAmount waits for the channel state with the authorized
+// amount, the states written before are skipped
+func receiveAuthorizedAmount(t *testing.T, states <-chan *PaymentChannelData, amount int64) {
+	for {
+		select {
+		case state := <-states:
+			if state.AuthorizedAmount.Int64() == amount {
+				return
+			}
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "channel state is not received", "authorized amount %v", amount)
+			return
+		}
+	}
+}
+
+func TestChannelStateWatcher(t *testing.T) {
+	channelStorage := NewPaymentChannelStorage(storage.NewMemStorage())
+	watcher := newChannelStateWatcher(channelStorage, time.Hour)
+	key := &PaymentChannelKey{ID: big.NewInt(42)}
+	otherKey := &PaymentChannelKey{ID: big.NewInt(43)}
+	channel := &PaymentChannelData{ChannelID: big.NewInt(42), Nonce: big.NewInt(3), AuthorizedAmount: big.NewInt(1)}
+
+	first, unsubscribeFirst := watcher.subscribe(key)
+	second, unsubscribeSecond := watcher.subscribe(key)
+	other, unsubscribeOther := watcher.subscribe(otherKey)
+	defer unsubscribeOther()
+	// the storage is watched asynchronously
+	require.Eventually(t, func() bool {
+		require.NoError(t, channelStorage.Put(key, channel))
+		return len(first) > 0
+	}, 5*time.Second, 10*time.Millisecond)
+
+	paid := *channel
+	paid.AuthorizedAmount = big.NewInt(2)
+	require.NoError(t, channelStorage.Put(key, &paid))
+	receiveAuthorizedAmount(t, first, 2)
+	receiveAuthorizedAmount(t, second, 2)
+	assert.Empty(t, other)
+
+	unsubscribeFirst()
+	unsubscribeSecond()
+	unsubscribeSecond()
+	assert.Equal(t, 1, watcher.count)
+	assert.NotContains(t, watcher.subscribers, "{ID: 42}")
+}
+
+// paymentRequiredHandler rejects all the calls which are not paid
+type paymentRequiredHandler struct{}
+
+func (h *paymentRequiredHandler) Type() string {
+	return EscrowPaymentType
+}
+
+func (h *paymentRequiredHandler) Payment(context *handler.GrpcStreamContext) (handler.Payment, *handler.GrpcError) {
+	return nil, handler.NewGrpcError(codes.Unauthenticated, "payment is required")
+}
+
+func (h *paymentRequiredHandler) Complete(payment handler.Payment) *handler.GrpcError {
+	return nil
+}
+
+func (h *paymentRequiredHandler) CompleteAfterError(payment handler.Payment, result error) *handler.GrpcError {
+	return nil
+}
+
+func TestWatchChannelStateIsNotPaid(t *testing.T) {
+	stateServiceTest.channelServiceMock.Put(
+		stateServiceTest.defaultChannelKey,
+		stateServiceTest.defaultChannelData,
+	)
+	defer cleanup()
+	service := stateServiceTest.service
+	service.watchInterval = time.Hour
+
+	server := grpc.NewServer(
+		grpc.UnknownServiceHandler(func(srv any, stream grpc.ServerStream) error { return nil }),
+		grpc.StreamInterceptor(handler.GrpcDaemonServicesInterceptor(
+			[]string{PaymentChannelStateService_ServiceDesc.ServiceName},
+			handler.GrpcPaymentValidationInterceptor(&blockchain.ServiceMetadata{}, &paymentRequiredHandler{}))),
+	)
+	RegisterPaymentChannelStateServiceServer(server, &service)
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	require.NoError(t, err)
+	go server.Serve(listener)
+	defer server.Stop()
+	conn, err := grpc.NewClient(listener.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	require.NoError(t, err)
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	watch, err := NewPaymentChannelStateServiceClient(conn).WatchChannelState(ctx, stateServiceTest.defaultRequest)
+	require.NoError(t, err)
+	update, err := watch.Recv()
+	require.NoError(t, err)
+	assert.Equal(t, ChannelStateUpdate_INITIAL, update.Reason)
+
+	// the calls of the service are still paid
+	call, err := conn.NewStream(ctx, &grpc.StreamDesc{ServerStreams: true}, "/example_service.Calculator/add")
+	require.NoError(t, err)
+	require.NoError(t, call.SendMsg(stateServiceTest.defaultRequest))
+	require.NoError(t, call.CloseSend())
+	err = call.RecvMsg(&ChannelStateUpdate{})
+	assert.Equal(t, codes.Unauthenticated, status.Code(err))
+}
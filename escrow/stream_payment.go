@@ -0,0 +1,418 @@
+package escrow
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+
+	"github.com/singnet/snet-daemon/v6/handler"
+	"github.com/singnet/snet-daemon/v6/storage"
+)
+
+// StreamPaymentConf contains settings of charging long-running streams
+// MessagesPerCharge - the price is charged for each MessagesPerCharge
+// messages received from the client, 0 disables charging per messages
+// ChargeInterval    - the price is charged for each ChargeInterval of the
+// stream, 0 disables charging per time
+// Methods           - full names of methods charged, all methods are charged
+// if empty
+type StreamPaymentConf struct {
+	MessagesPerCharge int
+	ChargeInterval    time.Duration
+	Methods           []string
+}
+
+// Enabled returns true if streams are charged while in progress
+func (conf StreamPaymentConf) Enabled() bool {
+	return conf.MessagesPerCharge > 0 || conf.ChargeInterval > 0
+}
+
+func (conf StreamPaymentConf) charges(method string) bool {
+	return conf.Enabled() && (len(conf.Methods) == 0 || slices.Contains(conf.Methods, method))
+}
+
+// units returns the number of units used by the stream, each unit costs
+// the price of the call. When both messages and time are charged the
+// greater number of units is used.
+func (conf StreamPaymentConf) units(messages int, elapsed time.Duration) int64 {
+	units := int64(1)
+	if conf.MessagesPerCharge > 0 && messages > 0 {
+		units = max(units, int64((messages+conf.MessagesPerCharge-1)/conf.MessagesPerCharge))
+	}
+	if conf.ChargeInterval > 0 {
+		units = max(units, int64(elapsed/conf.ChargeInterval)+1)
+	}
+	return units
+}
+
+// StreamPayments keeps payments of the streams in progress, so the client
+// can increase the payment of the stream using StreamPaymentService. When the
+// storage is set the payments are shared by the replicas: the payment of the
+// stream served by another replica is increased in the storage, and the
+// replica serving the stream reads it before the stream is terminated as
+// exhausted and before the payment is completed.
+type StreamPayments struct {
+	mutex    sync.Mutex
+	payments map[string]*meteredPayment
+	storage  storage.AtomicStorage
+	// validator validates the payments of the streams served by other
+	// replicas
+	validator *ChannelPaymentValidator
+}
+
+// sharedStreamPayment is the payment of the stream kept in the storage
+type sharedStreamPayment struct {
+	Payment *Payment
+	Channel *PaymentChannelData
+	// Charged is the amount charged for the stream when the replica serving
+	// it has read the payment last time
+	Charged *big.Int
+}
+
+// NewStreamPayments returns new empty StreamPayments instance which keeps the
+// payments of the streams served by this replica only
+func NewStreamPayments() *StreamPayments {
+	return NewStreamPaymentsWithStorage(nil, nil)
+}
+
+// NewStreamPaymentsWithStorage returns new empty StreamPayments instance
+// which shares the payments of the streams with other replicas using the
+// storage, validator is used to validate the payments of the streams served
+// by other replicas
+func NewStreamPaymentsWithStorage(atomicStorage storage.AtomicStorage, validator *ChannelPaymentValidator) *StreamPayments {
+	streams := &StreamPayments{payments: make(map[string]*meteredPayment), validator: validator}
+	if atomicStorage != nil {
+		streams.storage = storage.NewPrefixedAtomicStorage(atomicStorage, "/payment-channel/stream")
+	}
+	return streams
+}
+
+// add adds the payment of the stream. The stream keeps the channel locked,
+// so the payment left in the storage by the replica which failed can be
+// replaced.
+func (streams *StreamPayments) add(key string, payment *meteredPayment) (ok bool, err error) {
+	streams.mutex.Lock()
+	defer streams.mutex.Unlock()
+	if _, ok := streams.payments[key]; ok {
+		return false, nil
+	}
+	if streams.storage != nil {
+		value, err := serialize(&sharedStreamPayment{Payment: &payment.transaction.payment,
+			Channel: payment.transaction.Channel(), Charged: payment.base})
+		if err != nil {
+			return false, err
+		}
+		if err = streams.storage.Put(key, value); err != nil {
+			return false, err
+		}
+		payment.stored = value
+	}
+	streams.payments[key] = payment
+	return true, nil
+}
+
+func (streams *StreamPayments) remove(key string, payment *meteredPayment) {
+	streams.mutex.Lock()
+	defer streams.mutex.Unlock()
+	if streams.payments[key] != payment {
+		return
+	}
+	delete(streams.payments, key)
+	if streams.storage != nil {
+		if err := streams.storage.Delete(key); err != nil {
+			zap.L().Warn("Unable to delete stream payment", zap.String("channel", key), zap.Error(err))
+		}
+	}
+}
+
+func (streams *StreamPayments) get(key string) (payment *meteredPayment, ok bool) {
+	streams.mutex.Lock()
+	defer streams.mutex.Unlock()
+	payment, ok = streams.payments[key]
+	return
+}
+
+// load returns the payment of the stream kept in the storage and its
+// serialized value
+func (streams *StreamPayments) load(key string) (shared *sharedStreamPayment, value string, ok bool, err error) {
+	if streams.storage == nil {
+		return nil, "", false, nil
+	}
+	if value, ok, err = streams.storage.Get(key); err != nil || !ok {
+		return nil, "", false, err
+	}
+	shared = &sharedStreamPayment{}
+	if err = deserialize(value, shared); err != nil {
+		return nil, "", false, err
+	}
+	return shared, value, true, nil
+}
+
+// replace replaces the payment of the stream kept in the storage by
+// compare-and-swap, it returns the new serialized value
+func (streams *StreamPayments) replace(key, prevValue string, next *sharedStreamPayment) (value string, err error) {
+	value, err = serialize(next)
+	ok := false
+	if err == nil {
+		ok, err = streams.storage.CompareAndSwap(key, prevValue, value)
+	}
+	if err != nil {
+		zap.L().Error("Unable to store stream payment", zap.String("channel", key), zap.Error(err))
+		return "", NewPaymentError(Internal, "unable to store stream payment")
+	}
+	if !ok {
+		return "", NewPaymentError(FailedPrecondition, "stream payment on channel: %v was changed concurrently, please retry", key)
+	}
+	return value, nil
+}
+
+// increaseShared replaces the payment of the stream served by another
+// replica, the replica reads the payment before the stream is terminated
+func (streams *StreamPayments) increaseShared(key string, next *Payment) (authorized, charged *big.Int, err error) {
+	shared, value, ok, err := streams.load(key)
+	if err != nil {
+		zap.L().Error("Unable to read stream payment", zap.String("channel", key), zap.Error(err))
+		return nil, nil, NewPaymentError(Internal, "unable to read stream payment")
+	}
+	if !ok {
+		return nil, nil, errStreamFinished
+	}
+	if err = validateStreamPaymentIncrease(streams.validator, shared.Payment, next, shared.Channel); err != nil {
+		return nil, nil, err
+	}
+	if _, err = streams.replace(key, value, &sharedStreamPayment{Payment: next, Channel: shared.Channel, Charged: shared.Charged}); err != nil {
+		return nil, nil, err
+	}
+	return next.Amount, shared.Charged, nil
+}
+
+// validateStreamPaymentIncrease checks the next payment of the stream
+// authorizes a greater amount than the current one and is signed by the
+// client
+func validateStreamPaymentIncrease(validator *ChannelPaymentValidator, current, next *Payment, channel *PaymentChannelData) error {
+	if next.Amount.Cmp(current.Amount) <= 0 {
+		return NewPaymentError(Unauthenticated, "signed amount %v must be greater than the amount already authorized %v",
+			next.Amount, current.Amount)
+	}
+	next.MpeContractAddress = current.MpeContractAddress
+	return validator.Validate(next, channel)
+}
+
+// meteredPayment implements handler.MeteredPayment. The payment sent with the
+// call pays for the first unit of the stream, the client increases the
+// payment using StreamPaymentService while the stream is in progress.
+type meteredPayment struct {
+	transaction *paymentTransaction
+	conf        StreamPaymentConf
+	streams     *StreamPayments
+	key         string
+	// base is the amount authorized before the stream
+	base  *big.Int
+	price *big.Int
+
+	mutex    sync.Mutex
+	messages int
+	started  time.Time
+	// exhausted is the error which terminated the stream
+	exhausted *handler.GrpcError
+	finished  bool
+	cancel    context.CancelCauseFunc
+	stop      chan struct{}
+	// stored is the serialized payment kept in the storage of the streams
+	stored string
+}
+
+func newMeteredPayment(transaction *paymentTransaction, conf StreamPaymentConf, streams *StreamPayments, price *big.Int) (payment *meteredPayment, err error) {
+	payment = &meteredPayment{
+		transaction: transaction,
+		conf:        conf,
+		streams:     streams,
+		key:         (&PaymentChannelKey{ID: transaction.payment.ChannelID}).String(),
+		base:        transaction.Channel().AuthorizedAmount,
+		price:       price,
+		started:     time.Now(),
+		stop:        make(chan struct{}),
+	}
+	if transaction.pipelined {
+		return nil, NewPaymentError(FailedPrecondition, "stream payments can't be used with %v lock policy", LockPolicyPipeline)
+	}
+	if price.Sign() <= 0 {
+		return nil, NewPaymentError(FailedPrecondition, "stream payment requires a positive price")
+	}
+	ok, err := streams.add(payment.key, payment)
+	if err != nil {
+		zap.L().Error("Unable to store stream payment", zap.String("channel", payment.key), zap.Error(err))
+		return nil, NewPaymentError(Internal, "unable to store stream payment")
+	}
+	if !ok {
+		return nil, NewPaymentError(FailedPrecondition, "another paid stream on channel: %v is in progress", payment.key)
+	}
+	return payment, nil
+}
+
+func (payment *meteredPayment) GetSender() common.Address {
+	return payment.transaction.GetSender()
+}
+
+func (payment *meteredPayment) String() string {
+	return payment.transaction.String()
+}
+
+// Start starts charging the stream per time if enabled
+func (payment *meteredPayment) Start(ctx context.Context) context.Context {
+	ctx, payment.cancel = context.WithCancelCause(ctx)
+	if payment.conf.ChargeInterval > 0 {
+		go payment.chargeTime()
+	}
+	return ctx
+}
+
+func (payment *meteredPayment) chargeTime() {
+	ticker := time.NewTicker(payment.conf.ChargeInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-payment.stop:
+			return
+		case <-ticker.C:
+			payment.mutex.Lock()
+			err := payment.charge()
+			payment.mutex.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}
+}
+
+// Received charges the message received from the client
+func (payment *meteredPayment) Received() *handler.GrpcError {
+	payment.mutex.Lock()
+	defer payment.mutex.Unlock()
+	payment.messages++
+	return payment.charge()
+}
+
+// charge checks the units used are paid, it terminates the stream
+// otherwise. It is called under the mutex.
+func (payment *meteredPayment) charge() *handler.GrpcError {
+	if payment.exhausted != nil || payment.finished {
+		return payment.exhausted
+	}
+	charged := payment.charged()
+	if charged.Cmp(payment.transaction.payment.Amount) <= 0 {
+		return nil
+	}
+	if payment.refresh(charged) && charged.Cmp(payment.transaction.payment.Amount) <= 0 {
+		return nil
+	}
+	zap.L().Debug("Stream payment is exhausted", zap.String("channel", payment.key),
+		zap.Stringer("charged", charged), zap.Stringer("authorized", payment.transaction.payment.Amount))
+	payment.exhausted = handler.NewGrpcErrorf(codes.ResourceExhausted,
+		"stream payment is exhausted, charged: %v, authorized: %v, increase the payment using StreamPaymentService",
+		charged, payment.transaction.payment.Amount)
+	if payment.cancel != nil {
+		payment.cancel(payment.exhausted.Err())
+	}
+	return payment.exhausted
+}
+
+// charged returns the amount charged including the amount authorized before
+// the stream, it is called under the mutex
+func (payment *meteredPayment) charged() *big.Int {
+	units := payment.conf.units(payment.messages, time.Since(payment.started))
+	charged := new(big.Int).Mul(payment.price, big.NewInt(units))
+	return charged.Add(charged, payment.base)
+}
+
+// Stop stops charging the stream
+func (payment *meteredPayment) Stop() *handler.GrpcError {
+	payment.mutex.Lock()
+	defer payment.mutex.Unlock()
+	if payment.cancel != nil {
+		payment.cancel(nil)
+	}
+	payment.finish()
+	return payment.exhausted
+}
+
+// finish removes the payment from the streams in progress, the payment can't
+// be increased after that. It is called under the mutex.
+func (payment *meteredPayment) finish() {
+	if payment.finished {
+		return
+	}
+	payment.refresh(payment.charged())
+	payment.finished = true
+	close(payment.stop)
+	payment.streams.remove(payment.key, payment)
+}
+
+// refresh replaces the payment by the greater one which the client has sent
+// to another replica, it returns true if the payment is replaced. The amount
+// charged is reported to the storage. It is called under the mutex.
+func (payment *meteredPayment) refresh(charged *big.Int) bool {
+	shared, value, ok, err := payment.streams.load(payment.key)
+	if err != nil {
+		zap.L().Warn("Unable to read stream payment", zap.String("channel", payment.key), zap.Error(err))
+		return false
+	}
+	if !ok || value == payment.stored || shared.Payment.Amount.Cmp(payment.transaction.payment.Amount) <= 0 {
+		return false
+	}
+	payment.transaction.payment = *shared.Payment
+	payment.stored = value
+	shared.Charged = charged
+	if value, err = payment.streams.replace(payment.key, value, shared); err == nil {
+		payment.stored = value
+	}
+	zap.L().Debug("Stream payment is increased by another replica", zap.String("channel", payment.key),
+		zap.Stringer("authorized", shared.Payment.Amount))
+	return true
+}
+
+// complete returns the transaction to complete, the payment can't be
+// increased after that. The units used are charged, the rest of the amount
+// signed is not authorized.
+func (payment *meteredPayment) complete() *paymentTransaction {
+	payment.mutex.Lock()
+	defer payment.mutex.Unlock()
+	payment.finish()
+	payment.transaction.SetActualCost(new(big.Int).Sub(payment.charged(), payment.base))
+	return payment.transaction
+}
+
+// increase replaces the payment of the stream by the payment with a greater
+// amount, it returns the amounts authorized and charged
+func (payment *meteredPayment) increase(next *Payment) (authorized, charged *big.Int, err error) {
+	payment.mutex.Lock()
+	defer payment.mutex.Unlock()
+	if payment.finished {
+		return nil, nil, errStreamFinished
+	}
+	charged = payment.charged()
+	payment.refresh(charged)
+	current := &payment.transaction.payment
+	if err = validateStreamPaymentIncrease(payment.transaction.service.validator, current, next, payment.transaction.Channel()); err != nil {
+		return nil, nil, err
+	}
+	if payment.streams.storage != nil {
+		value, err := payment.streams.replace(payment.key, payment.stored,
+			&sharedStreamPayment{Payment: next, Channel: payment.transaction.Channel(), Charged: charged})
+		if err != nil {
+			return nil, nil, err
+		}
+		payment.stored = value
+	}
+	payment.transaction.payment = *next
+	return next.Amount, charged, nil
+}
+
+var errStreamFinished = errors.New("stream is finished")
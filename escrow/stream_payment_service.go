@@ -0,0 +1,66 @@
+//go:generate protoc -I . ./stream_payment_service.proto --go-grpc_out=. --go_out=.
+
+package escrow
+
+import (
+	"errors"
+	"math/big"
+
+	"go.uber.org/zap"
+	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// StreamPaymentService is an implementation of StreamPaymentServiceServer gRPC interface
+type StreamPaymentService struct {
+	UnimplementedStreamPaymentServiceServer
+	streams *StreamPayments
+}
+
+// NewStreamPaymentService returns new instance of StreamPaymentService which
+// increases payments of streams kept in streams
+func NewStreamPaymentService(streams *StreamPayments) *StreamPaymentService {
+	return &StreamPaymentService{streams: streams}
+}
+
+type BlockChainDisabledStreamPaymentService struct {
+	UnimplementedStreamPaymentServiceServer
+}
+
+func (service *BlockChainDisabledStreamPaymentService) IncreaseStreamPayment(context context.Context, request *StreamPaymentRequest) (reply *StreamPaymentReply, err error) {
+	return &StreamPaymentReply{}, nil
+}
+
+// IncreaseStreamPayment replaces the payment of the stream in progress on the
+// channel by the payment with a greater amount signed by the client.
+func (service *StreamPaymentService) IncreaseStreamPayment(context context.Context, request *StreamPaymentRequest) (reply *StreamPaymentReply, err error) {
+	channelID := bytesToBigInt(request.GetChannelId())
+	payment := &Payment{
+		ChannelID:    channelID,
+		ChannelNonce: bytesToBigInt(request.GetChannelNonce()),
+		Amount:       bytesToBigInt(request.GetSignedAmount()),
+		Signature:    request.GetSignature(),
+	}
+	zap.L().Debug("IncreaseStreamPayment called", zap.Any("payment", payment))
+
+	var authorized, charged *big.Int
+	key := (&PaymentChannelKey{ID: channelID}).String()
+	if stream, ok := service.streams.get(key); ok {
+		authorized, charged, err = stream.increase(payment)
+	} else {
+		// the stream is served by another replica or is not in progress
+		authorized, charged, err = service.streams.increaseShared(key, payment)
+	}
+	if errors.Is(err, errStreamFinished) {
+		return nil, status.Errorf(codes.NotFound, "no paid stream is in progress on channel: %v", channelID)
+	}
+	if err != nil {
+		return nil, paymentErrorToGrpcError(err).Err()
+	}
+
+	return &StreamPaymentReply{
+		AuthorizedAmount: bigIntToBytes(authorized),
+		ChargedAmount:    bigIntToBytes(charged),
+	}, nil
+}
@@ -0,0 +1,184 @@
+package escrow
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"github.com/stretchr/testify/suite"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestStreamPaymentConf_Units(t *testing.T) {
+	messages := StreamPaymentConf{MessagesPerCharge: 10}
+	assert.Equal(t, int64(1), messages.units(0, time.Hour))
+	assert.Equal(t, int64(1), messages.units(10, 0))
+	assert.Equal(t, int64(2), messages.units(11, 0))
+
+	both := StreamPaymentConf{MessagesPerCharge: 10, ChargeInterval: time.Minute}
+	assert.Equal(t, int64(3), both.units(11, 2*time.Minute))
+	assert.Equal(t, int64(4), both.units(31, time.Second))
+
+	assert.True(t, messages.charges("/service.Service/chat"))
+	assert.False(t, StreamPaymentConf{MessagesPerCharge: 10, Methods: []string{"/service.Service/chat"}}.charges("/service.Service/call"))
+	assert.False(t, StreamPaymentConf{}.charges("/service.Service/chat"))
+}
+
+type StreamPaymentSuite struct {
+	channelServiceTestSuite
+	streams       *StreamPayments
+	streamService *StreamPaymentService
+}
+
+func TestStreamPaymentSuite(t *testing.T) {
+	suite.Run(t, new(StreamPaymentSuite))
+}
+
+func (suite *StreamPaymentSuite) SetupTest() {
+	suite.channelServiceTestSuite.SetupTest()
+	suite.streams = NewStreamPayments()
+	suite.streamService = NewStreamPaymentService(suite.streams)
+	require.NoError(suite.T(), suite.otherReplica.Put(suite.key(), suite.channel(10)))
+}
+
+// start starts the stream paid by the payment of amount, the price is 5
+func (suite *StreamPaymentSuite) start(conf StreamPaymentConf, amount int64) *meteredPayment {
+	return suite.startOn(suite.streams, conf, amount)
+}
+
+func (suite *StreamPaymentSuite) startOn(streams *StreamPayments, conf StreamPaymentConf, amount int64) *meteredPayment {
+	transaction, err := suite.service().StartPaymentTransaction(context.Background(), suite.payment(amount))
+	require.NoError(suite.T(), err)
+	payment, err := newMeteredPayment(transaction.(*paymentTransaction), conf, streams, big.NewInt(5))
+	require.NoError(suite.T(), err)
+	return payment
+}
+
+func (suite *StreamPaymentSuite) increase(amount int64) (*StreamPaymentReply, error) {
+	return suite.increaseOn(suite.streamService, amount)
+}
+
+func (suite *StreamPaymentSuite) increaseOn(service *StreamPaymentService, amount int64) (*StreamPaymentReply, error) {
+	payment := suite.payment(amount)
+	return service.IncreaseStreamPayment(context.Background(), &StreamPaymentRequest{
+		ChannelId:    bigIntToBytes(payment.ChannelID),
+		ChannelNonce: bigIntToBytes(payment.ChannelNonce),
+		SignedAmount: bigIntToBytes(payment.Amount),
+		Signature:    payment.Signature,
+	})
+}
+
+func (suite *StreamPaymentSuite) TestChargedPerMessages() {
+	payment := suite.start(StreamPaymentConf{MessagesPerCharge: 2}, 15)
+	payment.Start(context.Background())
+
+	require.Nil(suite.T(), payment.Received())
+	require.Nil(suite.T(), payment.Received())
+	err := payment.Received()
+	require.NotNil(suite.T(), err)
+	assert.Equal(suite.T(), codes.ResourceExhausted, err.Status.Code())
+
+	_, _ = suite.increase(25)
+	assert.Equal(suite.T(), err, payment.Received(), "exhausted stream is not resumed")
+	assert.Equal(suite.T(), err, payment.Stop())
+}
+
+func (suite *StreamPaymentSuite) TestIncrease() {
+	payment := suite.start(StreamPaymentConf{MessagesPerCharge: 1}, 15)
+	payment.Start(context.Background())
+	require.Nil(suite.T(), payment.Received())
+
+	reply, err := suite.increase(25)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(25), bytesToBigInt(reply.AuthorizedAmount).Int64())
+	assert.Equal(suite.T(), int64(15), bytesToBigInt(reply.ChargedAmount).Int64())
+	require.Nil(suite.T(), payment.Received())
+	require.Nil(suite.T(), payment.Received())
+	assert.NotNil(suite.T(), payment.Received())
+
+	_, err = suite.increase(20)
+	assert.Equal(suite.T(), codes.Unauthenticated, status.Code(err), "amount must be increased")
+
+	require.NotNil(suite.T(), payment.Stop())
+	require.NoError(suite.T(), completedTransaction(payment).Commit())
+	channel, _, err := suite.otherReplica.Get(suite.key())
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(25), channel.AuthorizedAmount.Int64(), "latest payment is claimed")
+
+	_, err = suite.increase(30)
+	assert.Equal(suite.T(), codes.NotFound, status.Code(err))
+}
+
+func (suite *StreamPaymentSuite) TestStoppedBeforeIncreaseIsUsed() {
+	payment := suite.start(StreamPaymentConf{MessagesPerCharge: 1}, 15)
+	payment.Start(context.Background())
+	require.Nil(suite.T(), payment.Received())
+
+	_, err := suite.increase(30)
+	require.NoError(suite.T(), err)
+	require.Nil(suite.T(), payment.Received())
+
+	require.Nil(suite.T(), payment.Stop())
+	require.NoError(suite.T(), completedTransaction(payment).Commit())
+	channel := suite.storedChannel()
+	assert.Equal(suite.T(), int64(20), channel.AuthorizedAmount.Int64(), "units used are charged")
+	assert.Equal(suite.T(), int64(30), channel.SignedAmount.Int64(), "latest payment is claimed")
+}
+
+func (suite *StreamPaymentSuite) TestChargedPerTime() {
+	payment := suite.start(StreamPaymentConf{ChargeInterval: 20 * time.Millisecond}, 15)
+	ctx := payment.Start(context.Background())
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		suite.T().Fatal("stream is not canceled when payment is exhausted")
+	}
+	assert.Equal(suite.T(), codes.ResourceExhausted, status.Code(context.Cause(ctx)))
+	assert.NotNil(suite.T(), payment.Stop())
+	require.NoError(suite.T(), completedTransaction(payment).Commit())
+}
+
+func (suite *StreamPaymentSuite) TestOneStreamPerChannel() {
+	payment := suite.start(StreamPaymentConf{MessagesPerCharge: 1}, 15)
+	transaction := payment.transaction
+
+	_, err := newMeteredPayment(transaction, StreamPaymentConf{MessagesPerCharge: 1}, suite.streams, big.NewInt(5))
+	assert.Error(suite.T(), err)
+
+	payment.Stop()
+	require.NoError(suite.T(), completedTransaction(payment).Rollback())
+}
+
+func (suite *StreamPaymentSuite) TestIncreasedOnAnotherReplica() {
+	streams := NewStreamPaymentsWithStorage(suite.memoryStorage, suite.validator())
+	otherReplica := NewStreamPaymentService(NewStreamPaymentsWithStorage(suite.memoryStorage, suite.validator()))
+	payment := suite.startOn(streams, StreamPaymentConf{MessagesPerCharge: 1}, 15)
+	payment.Start(context.Background())
+	require.Nil(suite.T(), payment.Received())
+
+	reply, err := suite.increaseOn(otherReplica, 25)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(25), bytesToBigInt(reply.AuthorizedAmount).Int64())
+	assert.Equal(suite.T(), int64(10), bytesToBigInt(reply.ChargedAmount).Int64(), "charged before the stream")
+	_, err = suite.increaseOn(otherReplica, 20)
+	assert.Equal(suite.T(), codes.Unauthenticated, status.Code(err), "amount must be increased")
+
+	require.Nil(suite.T(), payment.Received(), "payment is read from the storage")
+	require.Nil(suite.T(), payment.Received())
+	reply, err = suite.increaseOn(otherReplica, 30)
+	require.NoError(suite.T(), err)
+	assert.Equal(suite.T(), int64(20), bytesToBigInt(reply.ChargedAmount).Int64(), "charged when the payment was read")
+
+	require.Nil(suite.T(), payment.Stop())
+	require.NoError(suite.T(), completedTransaction(payment).Commit())
+	assert.Equal(suite.T(), int64(25), suite.storedChannel().AuthorizedAmount.Int64(), "units used are charged")
+	assert.Equal(suite.T(), int64(30), suite.storedChannel().SignedAmount.Int64(), "latest payment is claimed")
+
+	_, err = suite.increaseOn(otherReplica, 35)
+	assert.Equal(suite.T(), codes.NotFound, status.Code(err))
+}
@@ -5,13 +5,18 @@ import (
 	"bytes"
 	"fmt"
 	"math/big"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/singnet/snet-daemon/v6/blockchain"
+	"github.com/singnet/snet-daemon/v6/config"
 	"github.com/singnet/snet-daemon/v6/token"
 	"github.com/singnet/snet-daemon/v6/utils"
+	"go.uber.org/zap"
 	"golang.org/x/net/context"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 type TokenService struct {
@@ -21,6 +26,12 @@ type TokenService struct {
 	validator               *ChannelPaymentValidator
 	serviceMetaData         blockchain.ServiceMetadata
 	allowedBlockNumberCheck func(blockNumber *big.Int) (err error)
+	// tokens records the tokens issued, so they can be listed and revoked,
+	// the tokens are not recorded if it is nil
+	tokens *PrePaidTokenStorage
+	// paymentAddress returns the address of the provider which is allowed to
+	// inspect the usage and revoke the tokens of any channel
+	paymentAddress func() common.Address
 }
 
 func (service *TokenService) mustEmbedUnimplementedTokenServiceServer() {
@@ -40,8 +51,24 @@ func (service BlockChainDisabledTokenService) GetToken(ctx context.Context, requ
 	return &TokenReply{}, nil
 }
 
+func (service BlockChainDisabledTokenService) GetPrePaidUsage(ctx context.Context, request *PrePaidUsageRequest) (reply *PrePaidUsageReply, err error) {
+	return &PrePaidUsageReply{}, nil
+}
+
+func (service BlockChainDisabledTokenService) ListTokens(ctx context.Context, request *ListTokensRequest) (reply *ListTokensReply, err error) {
+	return &ListTokensReply{}, nil
+}
+
+func (service BlockChainDisabledTokenService) RevokeToken(ctx context.Context, request *RevokeTokenRequest) (reply *ListTokensReply, err error) {
+	return &ListTokensReply{}, nil
+}
+
+// NewTokenService returns the token service which records the tokens issued to
+// tokens storage if it is not nil, the provider signs the requests to inspect
+// and revoke the tokens by the payment address of orgMetadata
 func NewTokenService(paymentChannelService PaymentChannelService,
-	usageService PrePaidService, tokenManager token.Manager, validator *ChannelPaymentValidator, metadata *blockchain.ServiceMetadata) *TokenService {
+	usageService PrePaidService, tokenManager token.Manager, validator *ChannelPaymentValidator, metadata *blockchain.ServiceMetadata,
+	orgMetadata *blockchain.OrganizationMetaData, tokens *PrePaidTokenStorage) *TokenService {
 
 	return &TokenService{
 		channelService:      paymentChannelService,
@@ -49,6 +76,13 @@ func NewTokenService(paymentChannelService PaymentChannelService,
 		tokenManager:        tokenManager,
 		validator:           validator,
 		serviceMetaData:     *metadata,
+		tokens:              tokens,
+		paymentAddress: func() common.Address {
+			if orgMetadata == nil {
+				return common.Address{}
+			}
+			return orgMetadata.GetPaymentAddress()
+		},
 		allowedBlockNumberCheck: func(blockNumber *big.Int) error {
 			currentBlockNumber, err := validator.currentBlock()
 			if err != nil {
@@ -63,7 +97,7 @@ func NewTokenService(paymentChannelService PaymentChannelService,
 	}
 }
 
-func (service *TokenService) verifySignatureAndSignedAmountEligibility(channelId *big.Int,
+func (service *TokenService) verifySignatureAndSignedAmountEligibility(ctx context.Context, channelId *big.Int,
 	latestAuthorizedAmount *big.Int, request *TokenRequest) (singer *common.Address, err error) {
 	channel, ok, err := service.channelService.PaymentChannel(&PaymentChannelKey{ID: channelId})
 
@@ -91,7 +125,7 @@ func (service *TokenService) verifySignatureAndSignedAmountEligibility(channelId
 	}
 	//update the channel Signature if you have a new Signed Amount received
 	if latestAuthorizedAmount.Cmp(channel.AuthorizedAmount) > 0 {
-		transaction, err := service.channelService.StartPaymentTransaction(payment)
+		transaction, err := service.channelService.StartPaymentTransaction(ctx, payment)
 		if err != nil {
 			return nil, err
 		}
@@ -144,7 +178,7 @@ func (service *TokenService) GetToken(ctx context.Context, request *TokenRequest
 	channelID := big.NewInt(0).SetUint64(request.ChannelId)
 	latestAuthorizedAmount := big.NewInt(0).SetUint64(request.SignedAmount)
 
-	signer, err := service.verifySignatureAndSignedAmountEligibility(channelID, latestAuthorizedAmount, request)
+	signer, err := service.verifySignatureAndSignedAmountEligibility(ctx, channelID, latestAuthorizedAmount, request)
 	if err != nil {
 		return nil, err
 	}
@@ -166,6 +200,148 @@ func (service *TokenService) GetToken(ctx context.Context, request *TokenRequest
 		return nil, err
 	}
 	tokenGenerated, err := service.tokenManager.CreateToken(channelID, signer.Hex())
+	if err == nil && service.tokens != nil {
+		if err = service.recordToken(fmt.Sprintf("%v", tokenGenerated), channelID, signer); err != nil {
+			return nil, err
+		}
+	}
 	return &TokenReply{ChannelId: request.ChannelId, Token: fmt.Sprintf("%v", tokenGenerated), PlannedAmount: plannedAmount.Amount.Uint64(),
 		UsedAmount: usageAmount.Uint64()}, err
 }
+
+func (service *TokenService) recordToken(tokenString string, channelID *big.Int, signer *common.Address) error {
+	issuedAt := time.Now().UTC()
+	token := &PrePaidToken{
+		ID:          PrePaidTokenID(tokenString),
+		ChannelID:   channelID,
+		UserAddress: signer.Hex(),
+		IssuedAt:    issuedAt,
+		ExpiresAt:   issuedAt.Add(time.Minute * time.Duration(config.GetInt(config.TokenExpiryInMinutes))),
+	}
+	ok, err := service.tokens.PutIfAbsent(token)
+	if err != nil {
+		zap.L().Error("Unable to record prepaid token", zap.Stringer("token", token), zap.Error(err))
+		return fmt.Errorf("unable to record token: %v", err)
+	}
+	if ok {
+		return nil
+	}
+	// the same token is issued again, its revocation is kept
+	revoked, err := service.tokens.IsRevoked(tokenString)
+	if err != nil {
+		return fmt.Errorf("unable to read token: %v", err)
+	}
+	if revoked {
+		return fmt.Errorf("token issued is revoked already, please request the token again in a second")
+	}
+	return nil
+}
+
+// GetPrePaidUsage returns planned, used and refunded amounts of the channel
+func (service *TokenService) GetPrePaidUsage(ctx context.Context, request *PrePaidUsageRequest) (reply *PrePaidUsageReply, err error) {
+	channelID := new(big.Int).SetUint64(request.GetChannelId())
+	if err = service.verifyChannelRequest("__get_prepaid_usage", channelID, nil, request.GetCurrentBlock(), request.GetSignature()); err != nil {
+		return nil, err
+	}
+	usage, err := service.prePaidUsageService.GetUsageData(channelID)
+	if err != nil {
+		zap.L().Error("Unable to read prepaid usage", zap.Any("channelID", channelID), zap.Error(err))
+		return nil, status.Error(codes.Internal, "unable to read prepaid usage")
+	}
+	return &PrePaidUsageReply{
+		ChannelId:       request.GetChannelId(),
+		PlannedAmount:   usage.PlannedAmount.Uint64(),
+		UsedAmount:      usage.UsedAmount.Uint64(),
+		RefundAmount:    usage.RefundAmount.Uint64(),
+		RemainingAmount: usage.RemainingAmount().Uint64(),
+	}, nil
+}
+
+// ListTokens returns the outstanding tokens of the channel
+func (service *TokenService) ListTokens(ctx context.Context, request *ListTokensRequest) (reply *ListTokensReply, err error) {
+	if service.tokens == nil {
+		return nil, status.Error(codes.FailedPrecondition, "prepaid tokens are not recorded")
+	}
+	channelID := new(big.Int).SetUint64(request.GetChannelId())
+	if err = service.verifyChannelRequest("__list_prepaid_tokens", channelID, nil, request.GetCurrentBlock(), request.GetSignature()); err != nil {
+		return nil, err
+	}
+	tokens, err := service.tokens.List(channelID)
+	if err != nil {
+		zap.L().Error("Unable to read prepaid tokens", zap.Any("channelID", channelID), zap.Error(err))
+		return nil, status.Error(codes.Internal, "unable to read prepaid tokens")
+	}
+	return prePaidTokensReply(tokens), nil
+}
+
+// RevokeToken revokes the token or all outstanding tokens of the channel
+func (service *TokenService) RevokeToken(ctx context.Context, request *RevokeTokenRequest) (reply *ListTokensReply, err error) {
+	if service.tokens == nil {
+		return nil, status.Error(codes.FailedPrecondition, "prepaid tokens are not recorded")
+	}
+	channelID := new(big.Int).SetUint64(request.GetChannelId())
+	if err = service.verifyChannelRequest("__revoke_prepaid_token", channelID, []byte(request.GetTokenId()),
+		request.GetCurrentBlock(), request.GetSignature()); err != nil {
+		return nil, err
+	}
+	revoked, err := service.tokens.Revoke(channelID, request.GetTokenId())
+	if err != nil {
+		return nil, status.Error(codes.NotFound, err.Error())
+	}
+	zap.L().Info("Prepaid tokens are revoked", zap.Any("channelID", channelID), zap.Int("count", len(revoked)))
+	return prePaidTokensReply(revoked), nil
+}
+
+// verifyChannelRequest checks that the message (prefix, mpe_address,
+// channel_id, params, current_block) is signed by the channel signer/sender
+// or by the provider payment address
+func (service *TokenService) verifyChannelRequest(prefix string, channelID *big.Int, params []byte,
+	currentBlock uint64, signature []byte) error {
+	message := bytes.Join([][]byte{
+		[]byte(prefix),
+		service.serviceMetaData.GetMpeAddress().Bytes(),
+		math.U256Bytes(new(big.Int).Set(channelID)),
+		params,
+		math.U256Bytes(new(big.Int).SetUint64(currentBlock)),
+	}, nil)
+	signer, err := utils.GetSignerAddressFromMessage(message, signature)
+	if err != nil {
+		return status.Error(codes.Unauthenticated, "incorrect signature")
+	}
+	if err = service.allowedBlockNumberCheck(new(big.Int).SetUint64(currentBlock)); err != nil {
+		return status.Error(codes.Unauthenticated, err.Error())
+	}
+	if *signer == service.paymentAddress() {
+		return nil
+	}
+	channel, ok, err := service.channelService.PaymentChannel(&PaymentChannelKey{ID: channelID})
+	if err != nil {
+		return status.Errorf(codes.Internal, "unable to read channel %v: %v", channelID, err)
+	}
+	if !ok {
+		return status.Errorf(codes.NotFound, "channel is not found, channelId: %v", channelID)
+	}
+	if *signer != channel.Signer && *signer != channel.Sender {
+		return status.Error(codes.PermissionDenied, "only channel signer/sender or service provider can manage prepaid tokens")
+	}
+	return nil
+}
+
+func prePaidTokensReply(tokens []*PrePaidToken) *ListTokensReply {
+	reply := &ListTokensReply{Tokens: make([]*PrePaidTokenInfo, 0, len(tokens))}
+	for _, token := range tokens {
+		info := &PrePaidTokenInfo{
+			TokenId:     token.ID,
+			ChannelId:   token.ChannelID.Uint64(),
+			UserAddress: token.UserAddress,
+			IssuedAt:    token.IssuedAt.Unix(),
+			ExpiresAt:   token.ExpiresAt.Unix(),
+			Revoked:     token.Revoked,
+		}
+		if token.Revoked {
+			info.RevokedAt = token.RevokedAt.Unix()
+		}
+		reply.Tokens = append(reply.Tokens, info)
+	}
+	return reply
+}
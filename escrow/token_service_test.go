@@ -106,10 +106,10 @@ func (suite *TokenServiceTestSuite) SetupSuite() {
 			return [32]byte{123}
 		})
 
-	tokenManager := token.NewJWTTokenService(*suite.orgMetaData)
+	tokenManager := token.NewJWTTokenService(*suite.orgMetaData, nil)
 	suite.service = NewTokenService(suite.channelService, NewPrePaidService(NewPrepaidStorage(storage.NewMemStorage()), nil, nil), tokenManager,
 		&ChannelPaymentValidator{currentBlock: func() (*big.Int, error) { return big.NewInt(99), nil },
-			paymentExpirationThreshold: func() *big.Int { return big.NewInt(0) }}, suite.serviceMetaData)
+			paymentExpirationThreshold: func() *big.Int { return big.NewInt(0) }}, suite.serviceMetaData, nil, nil)
 	suite.putChannel(big.NewInt(1))
 
 }
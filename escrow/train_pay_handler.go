@@ -39,7 +39,7 @@ func (t trainStreamPaymentHandler) Payment(context *handler.GrpcStreamContext) (
 		return
 	}
 
-	transaction, e := t.service.StartPaymentTransaction(internalPayment)
+	transaction, e := t.service.StartPaymentTransaction(streamContext(context), internalPayment)
 	if e != nil {
 		return nil, paymentErrorToGrpcError(e)
 	}
@@ -67,6 +67,12 @@ func (t trainStreamPaymentHandler) CompleteAfterError(payment handler.Payment, r
 	return paymentErrorToGrpcError(payment.(*paymentTransaction).Rollback())
 }
 
+// CompletePartially charges fraction of the price of the failed call
+func (t trainStreamPaymentHandler) CompletePartially(payment handler.Payment, fraction float64) (err *handler.GrpcError) {
+	payment.(*paymentTransaction).chargePartially(fraction)
+	return t.Complete(payment)
+}
+
 func (t trainStreamPaymentHandler) getPaymentFromContext(md metadata.MD) (payment *Payment, err *handler.GrpcError) {
 	channelID, err := handler.GetBigInt(md, handler.PaymentChannelIDHeader)
 	if err != nil {
@@ -133,7 +139,7 @@ func (h *trainUnaryPaymentHandler) Payment(context *handler.GrpcUnaryContext) (p
 		return
 	}
 
-	transaction, e := h.service.StartPaymentTransaction(internalPayment)
+	transaction, e := h.service.StartPaymentTransaction(unaryContext(context), internalPayment)
 	if e != nil {
 		return nil, paymentErrorToGrpcError(e)
 	}
@@ -191,3 +197,9 @@ func (h *trainUnaryPaymentHandler) Complete(payment handler.Payment) (err *handl
 func (h *trainUnaryPaymentHandler) CompleteAfterError(payment handler.Payment, result error) (err *handler.GrpcError) {
 	return paymentErrorToGrpcError(payment.(*paymentTransaction).Rollback())
 }
+
+// CompletePartially charges fraction of the price of the failed call
+func (h *trainUnaryPaymentHandler) CompletePartially(payment handler.Payment, fraction float64) (err *handler.GrpcError) {
+	payment.(*paymentTransaction).chargePartially(fraction)
+	return h.Complete(payment)
+}
@@ -31,17 +31,50 @@ type FreeCallPaymentValidator struct {
 	freeCallSignerAddress          common.Address
 	trustedFreeCallSignerAddresses []common.Address
 	freeCallSigner                 *ecdsa.PrivateKey
+	// revocations are not checked if nil
+	revocations *FreeCallRevocationStorage
 }
 
-func NewFreeCallPaymentValidator(funcCurrentBlock func() (currentBlock *big.Int, err error), signerAddress common.Address, signer *ecdsa.PrivateKey, trustedAddresses []common.Address) *FreeCallPaymentValidator {
+// NewFreeCallPaymentValidator returns the validator which rejects the free
+// calls with revoked tokens or of revoked addresses, revocations are not
+// checked if they are nil
+func NewFreeCallPaymentValidator(funcCurrentBlock func() (currentBlock *big.Int, err error), signerAddress common.Address,
+	signer *ecdsa.PrivateKey, trustedAddresses []common.Address, revocations *FreeCallRevocationStorage) *FreeCallPaymentValidator {
 	return &FreeCallPaymentValidator{
 		currentBlock:                   funcCurrentBlock,
 		freeCallSignerAddress:          signerAddress,
 		trustedFreeCallSignerAddresses: trustedAddresses,
 		freeCallSigner:                 signer,
+		revocations:                    revocations,
 	}
 }
 
+// IsTrustedSigner returns true if the address is one of trusted free call
+// signers
+func (validator *FreeCallPaymentValidator) IsTrustedSigner(addr common.Address) bool {
+	return slices.Contains(validator.trustedFreeCallSignerAddresses, addr)
+}
+
+// CheckRevocation returns an error if the free calls made with the token or
+// of the address and user are revoked
+func (validator *FreeCallPaymentValidator) CheckRevocation(token []byte, address, userID string) error {
+	if validator.revocations == nil {
+		return nil
+	}
+	tokenID := ""
+	if token != nil {
+		tokenID = FreeCallTokenID(token)
+	}
+	revocation, err := validator.revocations.Find(tokenID, address, userID)
+	if err != nil {
+		return NewPaymentError(Internal, "cannot check free call revocations: %v", err)
+	}
+	if revocation != nil {
+		return NewPaymentError(Unauthenticated, "%v", revocation.Message())
+	}
+	return nil
+}
+
 func (validator *FreeCallPaymentValidator) NewFreeCallToken(userAddress string, userID *string, tokenLifetimeBlocks *uint64) ([]byte, *big.Int) {
 
 	userAddr := common.HexToAddress(userAddress)
@@ -140,6 +173,10 @@ func (validator *FreeCallPaymentValidator) Validate(payment *FreeCallPayment) (e
 		return err
 	}
 
+	if err := validator.CheckRevocation(payment.AuthToken, payment.Address, payment.UserID); err != nil {
+		return err
+	}
+
 	//Check for the current block Number
 	if err := validator.compareWithLatestBlockNumber(payment.CurrentBlockNumber); err != nil {
 		return err
@@ -167,38 +204,44 @@ func NewChannelPaymentValidator(processor blockchain.Processor, metadata *blockc
 // Validate returns instance of PaymentError as error if validation fails, nil
 // otherwise.
 func (validator *ChannelPaymentValidator) Validate(payment *Payment, channel *PaymentChannelData) (err error) {
+	_, err = validator.validate(payment, channel)
+	return
+}
+
+// validate returns the block number the payment is validated against
+func (validator *ChannelPaymentValidator) validate(payment *Payment, channel *PaymentChannelData) (currentBlock *big.Int, err error) {
 	paymentFieldLog := zap.Any("payment", payment)
 	channelFieldLog := zap.Any("channel", channel)
 
 	if payment.ChannelNonce.Cmp(channel.Nonce) != 0 {
 		zap.L().Warn("Incorrect nonce is sent by client", paymentFieldLog, channelFieldLog)
-		return NewPaymentError(IncorrectNonce, "incorrect payment channel nonce, latest: %v, sent: %v", channel.Nonce, payment.ChannelNonce)
+		return nil, NewPaymentError(IncorrectNonce, "incorrect payment channel nonce, latest: %v, sent: %v", channel.Nonce, payment.ChannelNonce)
 	}
 
 	signerAddress, err := getSignerAddressFromPayment(payment)
 	if err != nil {
-		return NewPaymentError(Unauthenticated, "payment signature is not valid")
+		return nil, NewPaymentError(Unauthenticated, "payment signature is not valid")
 	}
 
 	signerAddressFieldLog := zap.String("signerAddress", utils.AddressToHex(signerAddress))
 	if *signerAddress != channel.Signer && *signerAddress != channel.Sender {
 		zap.L().Warn("Channel signer is not equal to payment signer/sender", signerAddressFieldLog)
-		return NewPaymentError(Unauthenticated, "payment is not signed by channel signer/sender")
+		return nil, NewPaymentError(Unauthenticated, "payment is not signed by channel signer/sender")
 	}
 	currentBlock, e := validator.currentBlock()
 	if e != nil {
-		return NewPaymentError(Internal, "cannot determine current block")
+		return nil, NewPaymentError(Internal, "cannot determine current block")
 	}
 	expirationThreshold := validator.paymentExpirationThreshold()
 	currentBlockWithThreshold := new(big.Int).Add(currentBlock, expirationThreshold)
 	if currentBlockWithThreshold.Cmp(channel.Expiration) >= 0 {
 		zap.L().Warn("Channel expiration time is after expiration threshold", zap.Any("currentBlock", currentBlock), zap.Any("expirationThreshold", expirationThreshold))
-		return NewPaymentError(Unauthenticated, "payment channel is near to be expired, expiration time: %v, current block: %v, expiration threshold: %v", channel.Expiration, currentBlock, expirationThreshold)
+		return nil, NewPaymentError(Unauthenticated, "payment channel is near to be expired, expiration time: %v, current block: %v, expiration threshold: %v", channel.Expiration, currentBlock, expirationThreshold)
 	}
 
 	if channel.FullAmount.Cmp(payment.Amount) < 0 {
 		zap.L().Warn("Not enough tokens on payment channel")
-		return NewPaymentError(Unauthenticated, "not enough tokens on payment channel, channel amount: %v, payment amount: %v", channel.FullAmount, payment.Amount)
+		return nil, NewPaymentError(Unauthenticated, "not enough tokens on payment channel, channel amount: %v, payment amount: %v", channel.FullAmount, payment.Amount)
 	}
 
 	return
@@ -290,6 +333,14 @@ func bigIntToBytes(value *big.Int) []byte {
 	return common.BigToHash(value).Bytes()
 }
 
+// optionalBigIntToBytes returns nil if value is nil
+func optionalBigIntToBytes(value *big.Int) []byte {
+	if value == nil {
+		return nil
+	}
+	return bigIntToBytes(value)
+}
+
 func bytesToBigInt(bytes []byte) *big.Int {
 	return (&big.Int{}).SetBytes(bytes)
 }
@@ -5,6 +5,7 @@ import (
 	"crypto/tls"
 	"crypto/x509"
 	"fmt"
+	"math"
 	"os"
 	"strings"
 	"time"
@@ -45,6 +46,7 @@ type EtcdClient struct {
 }
 
 var _ storage.AtomicStorage = (*EtcdClient)(nil)
+var _ storage.LeaseStorage = (*EtcdClient)(nil)
 
 // NewEtcdClient create new etcd storage client.
 func NewEtcdClient(metaData *blockchain.OrganizationMetaData) (client *EtcdClient, err error) {
@@ -235,6 +237,31 @@ func (client *EtcdClient) GetByKeyPrefix(key string) (values []string, err error
 	return
 }
 
+// GetKeyValuesByKeyPrefix gets all keys and values that have the same key prefix
+func (client *EtcdClient) GetKeyValuesByKeyPrefix(key string) (keyValues []storage.KeyValueData, err error) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), client.timeout)
+	defer cancel()
+
+	keyEnd := clientv3.GetPrefixRangeEnd(key)
+	response, err := client.etcd.Get(ctx, key, clientv3.WithRange(keyEnd), clientv3.WithSort(clientv3.SortByKey, clientv3.SortAscend))
+
+	if err != nil {
+		zap.L().Error("Unable to get key values by key prefix",
+			zap.Error(err),
+			zap.String("func", "GetKeyValuesByKeyPrefix"),
+			zap.String("key", key),
+			zap.Any("client", client))
+		return
+	}
+
+	for _, kv := range response.Kvs {
+		keyValues = append(keyValues, storage.KeyValueData{Key: string(kv.Key), Value: string(kv.Value), Present: true})
+	}
+
+	return
+}
+
 // Put puts key and value to etcd
 func (client *EtcdClient) Put(key string, value string) (err error) {
 
@@ -352,6 +379,87 @@ func (client *EtcdClient) PutIfAbsent(key string, value string) (ok bool, err er
 	})
 }
 
+// CompareAndSwapWithLease replaces the value (or puts it if prevPresent is
+// false and the key is absent) and attaches the key to a new lease, so etcd
+// removes the key when the lease is not kept alive.
+func (client *EtcdClient) CompareAndSwapWithLease(key string, prevValue string, prevPresent bool, newValue string,
+	ttl time.Duration) (lease storage.Lease, ok bool, err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), client.timeout)
+	defer cancel()
+
+	grant, err := client.etcd.Grant(ctx, int64(math.Ceil(ttl.Seconds())))
+	if err != nil {
+		zap.L().Error("Unable to grant lease", zap.Error(err), zap.String("key", key))
+		return nil, false, err
+	}
+
+	cmp := clientv3.Compare(clientv3.CreateRevision(key), "=", 0)
+	if prevPresent {
+		cmp = clientv3.Compare(clientv3.Value(key), "=", prevValue)
+	}
+	response, err := client.etcd.Txn(ctx).If(cmp).Then(clientv3.OpPut(key, newValue, clientv3.WithLease(grant.ID))).Commit()
+	if err == nil && response.Succeeded {
+		return &etcdLease{client: client, id: grant.ID}, true, nil
+	}
+
+	if _, e := client.etcd.Revoke(ctx, grant.ID); e != nil {
+		zap.L().Warn("Unable to revoke unused lease", zap.Error(e), zap.String("key", key))
+	}
+	if err != nil {
+		zap.L().Error("Unable to put value with lease", zap.Error(err), zap.String("key", key))
+	}
+	return nil, false, err
+}
+
+type etcdLease struct {
+	client *EtcdClient
+	id     clientv3.LeaseID
+}
+
+func (lease *etcdLease) KeepAlive() (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), lease.client.timeout)
+	defer cancel()
+	_, err = lease.client.etcd.KeepAliveOnce(ctx, lease.id)
+	return
+}
+
+func (lease *etcdLease) Revoke() (err error) {
+	ctx, cancel := context.WithTimeout(context.Background(), lease.client.timeout)
+	defer cancel()
+	_, err = lease.client.etcd.Revoke(ctx, lease.id)
+	return
+}
+
+// Watch is an implementation of AtomicStorage.Watch, it uses etcd watch, so
+// changes made by all replicas are sent. The channel is closed when the
+// watch is canceled by etcd, i.e. when the revision is compacted.
+func (client *EtcdClient) Watch(ctx context.Context, prefix string) (events <-chan storage.KeyValueData, err error) {
+	watchChan := client.etcd.Watch(clientv3.WithRequireLeader(ctx), prefix, clientv3.WithPrefix())
+	keyValues := make(chan storage.KeyValueData)
+	go func() {
+		defer close(keyValues)
+		for response := range watchChan {
+			if err := response.Err(); err != nil {
+				zap.L().Warn("etcd watch is canceled", zap.Error(err), zap.String("prefix", prefix))
+				return
+			}
+			for _, event := range response.Events {
+				keyValue := storage.KeyValueData{
+					Key:     string(event.Kv.Key),
+					Value:   string(event.Kv.Value),
+					Present: event.Type == clientv3.EventTypePut,
+				}
+				select {
+				case keyValues <- keyValue:
+				case <-ctx.Done():
+					return
+				}
+			}
+		}
+	}()
+	return keyValues, nil
+}
+
 // NewMutex Create a mutex for the given key
 func (client *EtcdClient) NewMutex(key string) (mutex *EtcdClientMutex, err error) {
 
@@ -172,6 +172,74 @@ func (suite *EtcdTestSuite) TestEtcdCAS() {
 	assert.Nil(t, err)
 	assert.False(t, ok)
 }
+
+func (suite *EtcdTestSuite) TestEtcdCompareAndSwapWithLease() {
+
+	t := suite.T()
+	client := suite.client
+
+	lease, ok, err := client.CompareAndSwapWithLease("lock", "", false, "owner-1", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+
+	_, ok, err = client.CompareAndSwapWithLease("lock", "", false, "owner-2", time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+	_, ok, err = client.CompareAndSwapWithLease("lock", "wrong", true, "owner-2", time.Minute)
+	assert.Nil(t, err)
+	assert.False(t, ok)
+
+	assert.Nil(t, lease.KeepAlive())
+	assertGet(suite, "lock", "owner-1")
+
+	assert.Nil(t, lease.Revoke())
+	_, ok, err = client.Get("lock")
+	assert.Nil(t, err)
+	assert.False(t, ok, "revoked lease removes the key")
+	assert.NotNil(t, lease.KeepAlive())
+
+	_ = client.Put("lock", "unlocked")
+	lease, ok, err = client.CompareAndSwapWithLease("lock", "unlocked", true, "owner-2", time.Minute)
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	assert.Nil(t, lease.Revoke())
+}
+
+func (suite *EtcdTestSuite) TestEtcdWatch() {
+
+	t := suite.T()
+	client := suite.client
+
+	ctx, cancel := context.WithCancel(context.Background())
+	events, err := client.Watch(ctx, "/watch/")
+	assert.Nil(t, err)
+
+	receive := func() storage.KeyValueData {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			assert.Fail(t, "no event received")
+			return storage.KeyValueData{}
+		}
+	}
+
+	_ = client.Put("/other/key", "ignored")
+	_ = client.Put("/watch/key", "value")
+	ok, err := client.CompareAndSwap("/watch/key", "value", "new-value")
+	assert.Nil(t, err)
+	assert.True(t, ok)
+	_ = client.Delete("/watch/key")
+
+	assert.Equal(t, storage.KeyValueData{Key: "/watch/key", Value: "value", Present: true}, receive())
+	assert.Equal(t, storage.KeyValueData{Key: "/watch/key", Value: "new-value", Present: true}, receive())
+	assert.Equal(t, storage.KeyValueData{Key: "/watch/key"}, receive())
+
+	cancel()
+	for range events {
+	}
+}
+
 func (suite *EtcdTestSuite) TestEtcdTransaction() {
 
 	t := suite.T()
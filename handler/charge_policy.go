@@ -0,0 +1,163 @@
+package handler
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ChargeOutcome is what happens to the payment of the call which the service
+// failed
+type ChargeOutcome string
+
+const (
+	// ChargeRefund rolls the payment back, the client is not charged
+	ChargeRefund ChargeOutcome = "refund"
+	// ChargeFull completes the payment as if the call succeeded
+	ChargeFull ChargeOutcome = "charge"
+	// ChargePartial charges the client for the fraction of the price
+	ChargePartial ChargeOutcome = "partial"
+)
+
+// DefaultChargeRuleKey is the key of the rule which is applied to the status
+// codes not listed in the policy
+const DefaultChargeRuleKey = "default"
+
+// ChargeRule is the outcome of the failed call, Fraction is a part of the
+// price charged by ChargePartial outcome
+type ChargeRule struct {
+	Outcome  ChargeOutcome
+	Fraction float64
+}
+
+func (rule ChargeRule) String() string {
+	if rule.Outcome == ChargePartial {
+		return fmt.Sprintf("%v(%v)", rule.Outcome, rule.Fraction)
+	}
+	return string(rule.Outcome)
+}
+
+// PartialPaymentHandler is implemented by payment handlers which are able to
+// charge a part of the payment: escrow, training and prepaid ones. The free
+// call can't be split, so the free-call and allowed-user handlers refund the
+// payment when ChargePartial outcome is applied.
+type PartialPaymentHandler interface {
+	// CompletePartially charges fraction of the payment and refunds the rest
+	CompletePartially(payment Payment, fraction float64) (err *GrpcError)
+}
+
+// ChargePolicy maps gRPC status codes returned by the service to the outcome
+// of the payment. The nil policy refunds the payment of each failed call.
+type ChargePolicy struct {
+	rules       map[codes.Code]ChargeRule
+	defaultRule ChargeRule
+}
+
+// NewChargePolicy parses the policy, the keys are gRPC status code names
+// (InvalidArgument or INVALID_ARGUMENT) or "default", the values are
+// "charge", "refund" or the fraction of the price charged, i.e.
+// {"InvalidArgument": "charge", "DeadlineExceeded": 0.5, "default": "refund"}
+func NewChargePolicy(rules map[string]any) (policy *ChargePolicy, err error) {
+	policy = &ChargePolicy{
+		rules:       make(map[codes.Code]ChargeRule),
+		defaultRule: ChargeRule{Outcome: ChargeRefund},
+	}
+	for name, value := range rules {
+		rule, err := parseChargeRule(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid charge rule for %q: %v", name, err)
+		}
+		if strings.EqualFold(name, DefaultChargeRuleKey) {
+			policy.defaultRule = rule
+			continue
+		}
+		code, ok := parseStatusCode(name)
+		if !ok {
+			return nil, fmt.Errorf("unknown gRPC status code %q", name)
+		}
+		if code == codes.OK {
+			return nil, fmt.Errorf("charge rule can't be set for %v status code", code)
+		}
+		policy.rules[code] = rule
+	}
+	return policy, nil
+}
+
+func parseChargeRule(value any) (rule ChargeRule, err error) {
+	var fraction float64
+	switch value := value.(type) {
+	case string:
+		switch outcome := ChargeOutcome(strings.ToLower(value)); outcome {
+		case ChargeRefund, ChargeFull:
+			return ChargeRule{Outcome: outcome}, nil
+		}
+		if _, err = fmt.Sscanf(value, "%g", &fraction); err != nil {
+			return rule, fmt.Errorf("expected one of '%v','%v' or fraction of the price, got %q", ChargeFull, ChargeRefund, value)
+		}
+	case float64:
+		fraction = value
+	case int:
+		fraction = float64(value)
+	default:
+		return rule, fmt.Errorf("expected one of '%v','%v' or fraction of the price, got %v", ChargeFull, ChargeRefund, value)
+	}
+	switch {
+	case fraction < 0 || fraction > 1:
+		return rule, fmt.Errorf("fraction of the price should be within [0, 1], got %v", fraction)
+	case fraction == 0:
+		return ChargeRule{Outcome: ChargeRefund}, nil
+	case fraction == 1:
+		return ChargeRule{Outcome: ChargeFull}, nil
+	}
+	return ChargeRule{Outcome: ChargePartial, Fraction: fraction}, nil
+}
+
+func parseStatusCode(name string) (codes.Code, bool) {
+	normalized := strings.ReplaceAll(name, "_", "")
+	for code := codes.OK; code <= codes.Unauthenticated; code++ {
+		if strings.EqualFold(code.String(), normalized) {
+			return code, true
+		}
+	}
+	return 0, false
+}
+
+// Rule returns the rule applied when the service returns result error
+func (policy *ChargePolicy) Rule(result error) ChargeRule {
+	if policy == nil {
+		return ChargeRule{Outcome: ChargeRefund}
+	}
+	if rule, ok := policy.rules[status.Code(result)]; ok {
+		return rule
+	}
+	return policy.defaultRule
+}
+
+// paymentCompleter is a part of UnaryPaymentHandler and StreamPaymentHandler
+// interfaces which completes the payment
+type paymentCompleter interface {
+	Complete(payment Payment) (err *GrpcError)
+	CompleteAfterError(payment Payment, result error) (err *GrpcError)
+}
+
+// completeAfterError completes the payment of the failed call according to
+// the policy
+func (policy *ChargePolicy) completeAfterError(paymentHandler paymentCompleter, payment Payment, result error) *GrpcError {
+	rule := policy.Rule(result)
+	if rule.Outcome != ChargeRefund {
+		zap.L().Debug("Failed call is charged", zap.Stringer("rule", rule), zap.Error(result))
+	}
+	switch rule.Outcome {
+	case ChargeFull:
+		return paymentHandler.Complete(payment)
+	case ChargePartial:
+		if partial, ok := paymentHandler.(PartialPaymentHandler); ok {
+			return partial.CompletePartially(payment, rule.Fraction)
+		}
+		zap.L().Debug("Payment handler can't charge part of the payment, payment is refunded")
+	}
+	return paymentHandler.CompleteAfterError(payment, result)
+}
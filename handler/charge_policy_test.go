@@ -0,0 +1,73 @@
+package handler
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+type partialPaymentHandlerMock struct {
+	paymentHandlerMock
+	fraction float64
+}
+
+func (handler *partialPaymentHandlerMock) CompletePartially(payment Payment, fraction float64) *GrpcError {
+	handler.fraction = fraction
+	return nil
+}
+
+func TestNewChargePolicy(t *testing.T) {
+	policy, err := NewChargePolicy(map[string]any{
+		"invalidargument":   "Charge",
+		"UNAVAILABLE":       "refund",
+		"DeadlineExceeded":  0.25,
+		"resourceexhausted": "0.5",
+		"default":           1,
+	})
+	require.NoError(t, err)
+
+	assert.Equal(t, ChargeRule{Outcome: ChargeFull}, policy.Rule(status.Error(codes.InvalidArgument, "")))
+	assert.Equal(t, ChargeRule{Outcome: ChargeRefund}, policy.Rule(status.Error(codes.Unavailable, "")))
+	assert.Equal(t, ChargeRule{Outcome: ChargePartial, Fraction: 0.25}, policy.Rule(status.Error(codes.DeadlineExceeded, "")))
+	assert.Equal(t, ChargeRule{Outcome: ChargePartial, Fraction: 0.5}, policy.Rule(status.Error(codes.ResourceExhausted, "")))
+	assert.Equal(t, ChargeRule{Outcome: ChargeFull}, policy.Rule(errors.New("unknown error")))
+}
+
+func TestNewChargePolicyErrors(t *testing.T) {
+	for _, rules := range []map[string]any{
+		{"NoSuchCode": "charge"},
+		{"OK": "charge"},
+		{"Internal": "sometimes"},
+		{"Internal": 1.5},
+		{"Internal": true},
+	} {
+		_, err := NewChargePolicy(rules)
+		assert.Error(t, err, "%v", rules)
+	}
+}
+
+func TestNilChargePolicyRefunds(t *testing.T) {
+	var policy *ChargePolicy
+	assert.Equal(t, ChargeRule{Outcome: ChargeRefund}, policy.Rule(status.Error(codes.InvalidArgument, "")))
+}
+
+func TestChargePolicyCompletePartially(t *testing.T) {
+	policy, err := NewChargePolicy(map[string]any{"DeadlineExceeded": 0.5})
+	require.NoError(t, err)
+	payment := &paymentMock{}
+	result := status.Error(codes.DeadlineExceeded, "")
+
+	partial := &partialPaymentHandlerMock{paymentHandlerMock: paymentHandlerMock{payment: payment}}
+	assert.Nil(t, policy.completeAfterError(partial, payment, result))
+	assert.Equal(t, 0.5, partial.fraction)
+	assert.False(t, partial.completeCalled)
+	assert.False(t, partial.completeAfterErrorCalled)
+
+	indivisible := &paymentHandlerMock{payment: payment}
+	assert.Nil(t, policy.completeAfterError(indivisible, payment, result))
+	assert.True(t, indivisible.completeAfterErrorCalled, "payment which can't be split is refunded")
+}
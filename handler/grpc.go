@@ -44,6 +44,9 @@ type grpcHandler struct {
 	executable         string
 	serviceMetaData    *blockchain.ServiceMetadata
 	serviceCredentials serviceCredentials
+	statusMapping      *httpStatusMapping
+	httpClient         *ServiceHTTPClient
+	processPool        *processPool
 }
 
 func (g grpcHandler) GrpcConn(isModelTraining bool) *grpc.ClientConn {
@@ -54,11 +57,37 @@ func (g grpcHandler) GrpcConn(isModelTraining bool) *grpc.ClientConn {
 	return g.grpcConn
 }
 
+// ServiceHandler passes the calls to the service, Close stops the worker
+// processes started for the service
+type ServiceHandler struct {
+	grpc.StreamHandler
+	processPool *processPool
+}
+
+// Close kills the worker processes of the service if any, it should be
+// called after the server is stopped
+func (h *ServiceHandler) Close() {
+	if h.processPool != nil {
+		h.processPool.close()
+	}
+}
+
 func NewGrpcHandler(serviceMetadata *blockchain.ServiceMetadata) grpc.StreamHandler {
+	return NewGrpcHandlerWithHTTPClient(serviceMetadata, nil).StreamHandler
+}
+
+// NewGrpcHandlerWithHTTPClient returns the handler which passes the calls
+// to HTTP and JSON-RPC services using httpClient
+func NewGrpcHandlerWithHTTPClient(serviceMetadata *blockchain.ServiceMetadata, httpClient *ServiceHTTPClient) *ServiceHandler {
+	streamHandler, pool := newGrpcStreamHandler(serviceMetadata, httpClient)
+	return &ServiceHandler{StreamHandler: streamHandler, processPool: pool}
+}
+
+func newGrpcStreamHandler(serviceMetadata *blockchain.ServiceMetadata, httpClient *ServiceHTTPClient) (grpc.StreamHandler, *processPool) {
 	passthroughEnabled := config.GetBool(config.PassthroughEnabledKey)
 
 	if !passthroughEnabled {
-		return grpcLoopback
+		return grpcLoopback, nil
 	}
 
 	h := grpcHandler{
@@ -67,6 +96,7 @@ func NewGrpcHandler(serviceMetadata *blockchain.ServiceMetadata) grpc.StreamHand
 		passthroughEndpoint: config.GetString(config.ServiceEndpointKey),
 		//modelTrainingEndpoint: config.GetString(config.ModelTrainingEndpoint),
 		executable: config.GetString(config.ExecutablePathKey),
+		httpClient: httpClient,
 		options: grpc.WithDefaultCallOptions(
 			grpc.MaxCallRecvMsgSize(config.GetInt(config.MaxMessageSizeInMB)*1024*1024),
 			grpc.MaxCallSendMsgSize(config.GetInt(config.MaxMessageSizeInMB)*1024*1024)),
@@ -78,9 +108,9 @@ func NewGrpcHandler(serviceMetadata *blockchain.ServiceMetadata) grpc.StreamHand
 		//if config.GetBool(config.ModelTrainingEnabled) {
 		//	h.grpcModelConn = h.getConnection(h.modelTrainingEndpoint)
 		//}
-		return h.grpcToGRPC
+		return h.grpcToGRPC, nil
 	case "jsonrpc":
-		return h.grpcToJSONRPC
+		return h.grpcToJSONRPC, nil
 	case "http":
 		h.serviceCredentials = serviceCredentials{}
 		err := config.Vip().UnmarshalKey(config.ServiceCredentialsKey, &h.serviceCredentials)
@@ -91,11 +121,27 @@ func NewGrpcHandler(serviceMetadata *blockchain.ServiceMetadata) grpc.StreamHand
 		if err != nil {
 			zap.L().Fatal("invalid config", zap.Error(fmt.Errorf("%v%v", err, errs.ErrDescURL(errs.InvalidServiceCredentials))))
 		}
-		return h.grpcToHTTP
+		h.statusMapping, err = newHTTPStatusMapping(config.GetStringMap(config.HTTPStatusMappingKey),
+			config.GetBool(config.HTTPErrorBodyEnabledKey))
+		if err != nil {
+			zap.L().Fatal("invalid "+config.HTTPStatusMappingKey+" config", zap.Error(fmt.Errorf("%v%v", err, errs.ErrDescURL(errs.InvalidConfig))))
+		}
+		return h.grpcToHTTP, nil
 	case "process":
-		return h.grpcToProcess
+		if workers := config.GetInt(config.ExecutableWorkersKey); workers > 0 {
+			var err error
+			h.processPool, err = newProcessPool(h.executable, ProcessPoolConf{
+				Size:           workers,
+				CallTimeout:    config.GetDuration(config.ExecutableCallTimeoutKey),
+				MaxMessageSize: config.GetInt(config.MaxMessageSizeInMB) * 1024 * 1024,
+			})
+			if err != nil {
+				zap.L().Fatal("can't start process workers", zap.Error(fmt.Errorf("%v%v", err, errs.ErrDescURL(errs.InvalidConfig))))
+			}
+		}
+		return h.grpcToProcess, h.processPool
 	}
-	return nil
+	return nil, nil
 }
 
 func (srvCreds serviceCredentials) validate() error {
@@ -303,23 +349,26 @@ func (g grpcHandler) grpcToHTTP(srv any, inStream grpc.ServerStream) error {
 		return status.Errorf(codes.Internal, "can't parse service_endpoint %v%v", err, errs.ErrDescURL(errs.InvalidConfig))
 	}
 
-	base.Path += method // method from proto should be the same as http handler path
+	// the request is posted to the path with the method name unless the
+	// method has google.api.http option
+	methodDescriptor := findMethodInProto(g.serviceMetaData.ProtoDescriptors, method)
+	route, err := newHTTPRoute(methodDescriptor, method, jsonBody)
+	if err != nil {
+		return status.Errorf(codes.InvalidArgument, "can't map request to HTTP service: %v%v", err, errs.ErrDescURL(errs.InvalidProto))
+	}
 
-	params := url.Values{}
 	headers := http.Header{}
-
-	var bodyMap = map[string]any{}
-	errJson := json.Unmarshal(jsonBody, &bodyMap)
+	bodyMap, isJsonObject := route.body.(map[string]any)
 
 	for _, cred := range g.serviceCredentials {
 		switch cred.Location {
 		case query:
 			v, ok := cred.Value.(string)
 			if ok {
-				params.Add(cred.Key, v)
+				route.query.Add(cred.Key, v)
 			}
 		case body:
-			if errJson == nil {
+			if isJsonObject {
 				bodyMap[cred.Key] = cred.Value
 			}
 		case header:
@@ -330,38 +379,80 @@ func (g grpcHandler) grpcToHTTP(srv any, inStream grpc.ServerStream) error {
 		}
 	}
 
-	if errJson == nil {
-		newJson, err := json.Marshal(bodyMap)
+	var reqBody io.Reader
+	if route.hasBody {
+		newJson, err := json.Marshal(route.body)
 		if err == nil {
 			jsonBody = newJson
 		} else {
 			zap.L().Debug("Can't marshal json", zap.Error(err))
 		}
+		reqBody = bytes.NewBuffer(jsonBody)
+	} else {
+		jsonBody = nil
 	}
 
-	base.RawQuery = params.Encode()
+	routeURL, err := route.url(base)
+	if err != nil {
+		return status.Errorf(codes.Internal, "can't build url of HTTP service: %v%v", err, errs.ErrDescURL(errs.HTTPRequestBuildError))
+	}
 	zap.L().Debug("Calling http service",
-		zap.String("url", base.String()),
+		zap.String("url", routeURL.String()),
 		zap.String("body", string(jsonBody)),
-		zap.String("method", "POST"))
+		zap.String("method", route.verb))
 
-	httpReq, err := http.NewRequest("POST", base.String(), bytes.NewBuffer(jsonBody))
+	// the request is canceled when the client cancels the call
+	httpReq, err := http.NewRequestWithContext(inStream.Context(), route.verb, routeURL.String(), reqBody)
 	if err != nil {
 		return status.Errorf(codes.Internal, "error creating http request: %+v%v", err, errs.ErrDescURL(errs.HTTPRequestBuildError))
 	}
 	httpReq.Header = headers
-	httpReq.Header.Set("content-type", "application/json")
+	if route.hasBody {
+		httpReq.Header.Set("content-type", "application/json")
+	}
+	isStreaming := methodDescriptor != nil && methodDescriptor.IsStreamingServer()
+	if isStreaming {
+		httpReq.Header.Set("accept", sseContentType+", "+ndjsonContentType)
+	}
 
-	httpResp, err := http.DefaultClient.Do(httpReq)
+	httpResp, err := g.httpClient.Do(httpReq)
 	if err != nil {
+		if ctxErr := inStream.Context().Err(); ctxErr != nil {
+			return status.FromContextError(ctxErr).Err()
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return status.Errorf(codes.DeadlineExceeded, "HTTP service timeout: %v", err)
+		}
 		return status.Errorf(codes.Internal, "error executing HTTP service: %+v%v", err, errs.ErrDescURL(errs.ServiceUnavailable))
 	}
+	defer httpResp.Body.Close()
+
+	// the error response isn't converted to the reply, so the call fails and
+	// its payment is completed by the charge policy
+	if httpResp.StatusCode >= http.StatusBadRequest {
+		return g.statusMapping.statusError(httpResp)
+	}
+
+	if isStreaming {
+		return g.sendHTTPStream(inStream, route, method, httpResp)
+	}
+
 	resp, err := io.ReadAll(httpResp.Body)
 	if err != nil {
 		return status.Errorf(codes.Internal, "error reading response from HTTP service: %+v%v", err, errs.ErrDescURL(errs.ServiceUnavailable))
 	}
 	zap.L().Debug("Response from HTTP service", zap.String("response", string(resp)))
 
+	return g.sendHTTPReply(inStream, route, method, resp)
+}
+
+// sendHTTPReply converts JSON response of HTTP service to the reply of the
+// method and sends it to the client
+func (g grpcHandler) sendHTTPReply(inStream grpc.ServerStream, route *httpRoute, method string, resp []byte) (err error) {
+	if resp, err = route.wrapResponseBody(resp); err != nil {
+		return status.Errorf(codes.Internal, "%v%v", err, errs.ErrDescURL(errs.InvalidProto))
+	}
+
 	protoMessage, errMarshal := jsonToProto(g.serviceMetaData.ProtoDescriptors, resp, method)
 	if errMarshal != nil {
 		return status.Errorf(codes.Internal, "jsonToProto error: %+v%v", errMarshal, errs.ErrDescURL(errs.InvalidProto))
@@ -467,17 +558,24 @@ func (g grpcHandler) grpcToJSONRPC(srv any, inStream grpc.ServerStream) error {
 		return status.Errorf(codes.Internal, "error encoding request; error: %+v", err)
 	}
 
-	httpReq, err := http.NewRequest("POST", g.passthroughEndpoint, bytes.NewBuffer(jsonRPCReq))
+	httpReq, err := http.NewRequestWithContext(inStream.Context(), "POST", g.passthroughEndpoint, bytes.NewBuffer(jsonRPCReq))
 	if err != nil {
 		return status.Errorf(codes.Internal, "error creating http request; error: %+v", err)
 	}
 
 	httpReq.Header.Set("content-type", "application/json")
-	httpResp, err := http.DefaultClient.Do(httpReq)
+	httpResp, err := g.httpClient.Do(httpReq)
 
 	if err != nil {
+		if ctxErr := inStream.Context().Err(); ctxErr != nil {
+			return status.FromContextError(ctxErr).Err()
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			return status.Errorf(codes.DeadlineExceeded, "HTTP service timeout: %v", err)
+		}
 		return status.Errorf(codes.Internal, "error executing http call; error: %+v", err)
 	}
+	defer httpResp.Body.Close()
 
 	result := new(any)
 
@@ -515,7 +613,19 @@ func (g grpcHandler) grpcToProcess(srv any, inStream grpc.ServerStream) error {
 		return status.Errorf(codes.Internal, "error receiving request; error: %+v", err)
 	}
 
-	cmd := exec.Command(g.executable, method)
+	if g.processPool != nil {
+		out, err := g.processPool.call(inStream.Context(), method, f.Data)
+		if err != nil {
+			return err
+		}
+		if err = inStream.SendMsg(&codec.GrpcFrame{Data: out}); err != nil {
+			return status.Errorf(codes.Internal, "error sending response; error: %+v", err)
+		}
+		return nil
+	}
+
+	// the process is killed when the call is canceled
+	cmd := exec.CommandContext(inStream.Context(), g.executable, method)
 	stdin, err := cmd.StdinPipe()
 
 	if err != nil {
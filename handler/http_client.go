@@ -0,0 +1,141 @@
+package handler
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/singnet/snet-daemon/v6/config"
+)
+
+// ServiceHTTPClientConf is the configuration of the client which passes the
+// calls to HTTP and JSON-RPC services
+// Timeout             - limits waiting for the response headers, the streamed body is not limited, 0 means no limit
+// DialTimeout         - timeout of establishing the connection
+// MaxIdleConns        - maximum number of idle connections kept open
+// MaxIdleConnsPerHost - maximum number of idle connections kept open to the service host
+// IdleConnTimeout     - time an idle connection is kept open
+// CACert              - PEM file with CA certificates of the service, system certificates are used if empty
+// ClientCert          - PEM file with the client certificate, requires ClientKey
+// ClientKey           - PEM file with the key of the client certificate
+// InsecureSkipVerify  - disables verification of the service certificate
+// Proxy               - proxy URL, "direct" disables proxy, HTTP_PROXY/HTTPS_PROXY/NO_PROXY are used if empty
+type ServiceHTTPClientConf struct {
+	Timeout             time.Duration `json:"timeout" mapstructure:"timeout"`
+	DialTimeout         time.Duration `json:"dial_timeout" mapstructure:"dial_timeout"`
+	MaxIdleConns        int           `json:"max_idle_conns" mapstructure:"max_idle_conns"`
+	MaxIdleConnsPerHost int           `json:"max_idle_conns_per_host" mapstructure:"max_idle_conns_per_host"`
+	IdleConnTimeout     time.Duration `json:"idle_conn_timeout" mapstructure:"idle_conn_timeout"`
+	CACert              string        `json:"ca_cert" mapstructure:"ca_cert"`
+	ClientCert          string        `json:"client_cert" mapstructure:"client_cert"`
+	ClientKey           string        `json:"client_key" mapstructure:"client_key"`
+	InsecureSkipVerify  bool          `json:"insecure_skip_verify" mapstructure:"insecure_skip_verify"`
+	Proxy               string        `json:"proxy" mapstructure:"proxy"`
+}
+
+// directProxy is the Proxy value which disables the proxy
+const directProxy = "direct"
+
+// GetServiceHTTPClientConf reads ServiceHTTPClientConf from the
+// service_http_client section of the configuration
+func GetServiceHTTPClientConf(vip *viper.Viper) (conf *ServiceHTTPClientConf, err error) {
+	conf = &ServiceHTTPClientConf{}
+	subVip := config.SubWithDefault(vip, config.ServiceHTTPClientKey)
+	if subVip == nil {
+		return conf, nil
+	}
+	if err = subVip.Unmarshal(conf); err != nil {
+		return nil, fmt.Errorf("invalid %v config: %v", config.ServiceHTTPClientKey, err)
+	}
+	return conf, nil
+}
+
+// ServiceHTTPClient passes the calls to HTTP and JSON-RPC services, it is
+// shared by the handlers to reuse the connections. The nil client uses
+// http.DefaultClient.
+type ServiceHTTPClient struct {
+	client *http.Client
+}
+
+// NewServiceHTTPClient returns the client configured by conf
+func NewServiceHTTPClient(conf *ServiceHTTPClientConf) (client *ServiceHTTPClient, err error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	if conf.DialTimeout > 0 {
+		transport.DialContext = (&net.Dialer{Timeout: conf.DialTimeout, KeepAlive: 30 * time.Second}).DialContext
+	}
+	if conf.MaxIdleConns > 0 {
+		transport.MaxIdleConns = conf.MaxIdleConns
+	}
+	if conf.MaxIdleConnsPerHost > 0 {
+		transport.MaxIdleConnsPerHost = conf.MaxIdleConnsPerHost
+	}
+	if conf.IdleConnTimeout > 0 {
+		transport.IdleConnTimeout = conf.IdleConnTimeout
+	}
+	if conf.Timeout > 0 {
+		// the body of the server streaming call can be read for a long time
+		transport.ResponseHeaderTimeout = conf.Timeout
+	}
+
+	switch conf.Proxy {
+	case "":
+		transport.Proxy = http.ProxyFromEnvironment
+	case directProxy:
+		transport.Proxy = nil
+	default:
+		proxyURL, err := url.Parse(conf.Proxy)
+		if err != nil || proxyURL.Host == "" {
+			return nil, fmt.Errorf("invalid proxy url %q", conf.Proxy)
+		}
+		transport.Proxy = http.ProxyURL(proxyURL)
+	}
+
+	if transport.TLSClientConfig, err = serviceTLSConfig(conf); err != nil {
+		return nil, err
+	}
+	return &ServiceHTTPClient{client: &http.Client{Transport: transport}}, nil
+}
+
+func serviceTLSConfig(conf *ServiceHTTPClientConf) (tlsConfig *tls.Config, err error) {
+	tlsConfig = &tls.Config{InsecureSkipVerify: conf.InsecureSkipVerify}
+	if conf.CACert != "" {
+		pem, err := os.ReadFile(conf.CACert)
+		if err != nil {
+			return nil, fmt.Errorf("can't read ca_cert: %v", err)
+		}
+		if tlsConfig.RootCAs, err = x509.SystemCertPool(); err != nil {
+			tlsConfig.RootCAs = x509.NewCertPool()
+		}
+		if !tlsConfig.RootCAs.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in ca_cert %v", conf.CACert)
+		}
+	}
+	if conf.ClientCert != "" || conf.ClientKey != "" {
+		if conf.ClientCert == "" || conf.ClientKey == "" {
+			return nil, fmt.Errorf("both client_cert and client_key should be set")
+		}
+		cert, err := tls.LoadX509KeyPair(conf.ClientCert, conf.ClientKey)
+		if err != nil {
+			return nil, fmt.Errorf("can't load client certificate: %v", err)
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// Do sends the request, the request is canceled when its context is done,
+// i.e. when the gRPC call is canceled or its deadline is exceeded, or if the
+// response headers are not received within the timeout of the client
+func (client *ServiceHTTPClient) Do(req *http.Request) (resp *http.Response, err error) {
+	if client == nil {
+		return http.DefaultClient.Do(req)
+	}
+	return client.client.Do(req)
+}
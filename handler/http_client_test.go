@@ -0,0 +1,91 @@
+package handler
+
+import (
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestGetServiceHTTPClientConf(t *testing.T) {
+	vip := viper.New()
+	vip.Set("service_http_client", map[string]any{"timeout": "5s", "max_idle_conns_per_host": 4, "proxy": "direct"})
+
+	conf, err := GetServiceHTTPClientConf(vip)
+	require.NoError(t, err)
+	assert.Equal(t, &ServiceHTTPClientConf{Timeout: 5 * time.Second, MaxIdleConnsPerHost: 4, Proxy: "direct"}, conf)
+
+	conf, err = GetServiceHTTPClientConf(viper.New())
+	require.NoError(t, err)
+	assert.Equal(t, &ServiceHTTPClientConf{}, conf)
+}
+
+func TestNewServiceHTTPClient(t *testing.T) {
+	_, err := NewServiceHTTPClient(&ServiceHTTPClientConf{Proxy: "localhost"})
+	assert.EqualError(t, err, "invalid proxy url \"localhost\"")
+	_, err = NewServiceHTTPClient(&ServiceHTTPClientConf{ClientCert: "client.pem"})
+	assert.EqualError(t, err, "both client_cert and client_key should be set")
+	_, err = NewServiceHTTPClient(&ServiceHTTPClientConf{CACert: "not-existing-ca.pem"})
+	assert.Error(t, err)
+
+	client, err := NewServiceHTTPClient(&ServiceHTTPClientConf{MaxIdleConnsPerHost: 4, Proxy: "direct"})
+	require.NoError(t, err)
+	transport := client.client.Transport.(*http.Transport)
+	assert.Equal(t, 4, transport.MaxIdleConnsPerHost)
+	assert.Nil(t, transport.Proxy)
+}
+
+func TestServiceHTTPClientTimeout(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/slow":
+			select {
+			case <-time.After(5 * time.Second):
+			case <-r.Context().Done():
+			}
+		case "/stream":
+			_, _ = w.Write([]byte("first\n"))
+			w.(http.Flusher).Flush()
+			time.Sleep(300 * time.Millisecond)
+		}
+		_, _ = w.Write([]byte("ok"))
+	}))
+	defer server.Close()
+	client, err := NewServiceHTTPClient(&ServiceHTTPClientConf{Timeout: 100 * time.Millisecond})
+	require.NoError(t, err)
+
+	req, _ := http.NewRequest("GET", server.URL+"/fast", nil)
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	body, err := io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, "ok", string(body))
+
+	req, _ = http.NewRequest("GET", server.URL+"/slow", nil)
+	_, err = client.Do(req)
+	var netErr net.Error
+	require.ErrorAs(t, err, &netErr)
+	assert.True(t, netErr.Timeout())
+
+	// the streamed body is read longer than the timeout
+	req, _ = http.NewRequest("GET", server.URL+"/stream", nil)
+	resp, err = client.Do(req)
+	require.NoError(t, err)
+	body, err = io.ReadAll(resp.Body)
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+	assert.Equal(t, "first\nok", string(body))
+
+	var defaultClient *ServiceHTTPClient
+	req, _ = http.NewRequest("GET", server.URL+"/fast", nil)
+	resp, err = defaultClient.Do(req)
+	require.NoError(t, err)
+	assert.NoError(t, resp.Body.Close())
+}
@@ -0,0 +1,292 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"google.golang.org/genproto/googleapis/api/annotations"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+)
+
+// httpRoute is the HTTP request the method call is passed to the service
+// with
+type httpRoute struct {
+	verb string
+	// path is the escaped path which is appended to service_endpoint
+	path  string
+	query url.Values
+	// body is sent as JSON when hasBody is true
+	body    any
+	hasBody bool
+	// responseBody is the field of the reply the HTTP response is mapped to,
+	// the whole reply if empty
+	responseBody string
+}
+
+// newHTTPRoute maps the request to the HTTP request using google.api.http
+// option of the method. The request is posted to the path with the method
+// name if the method has no option.
+func newHTTPRoute(method protoreflect.MethodDescriptor, methodName string, jsonBody []byte) (route *httpRoute, err error) {
+	var rule *annotations.HttpRule
+	if method != nil {
+		if rule, err = httpRule(method); err != nil {
+			return nil, err
+		}
+	}
+
+	fields, err := decodeJsonFields(jsonBody)
+	if rule == nil {
+		route = &httpRoute{verb: "POST", path: url.PathEscape(methodName), query: url.Values{}, hasBody: true}
+		if err != nil {
+			route.body = json.RawMessage(jsonBody)
+		} else {
+			route.body = fields
+		}
+		return route, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	route = &httpRoute{query: url.Values{}, responseBody: rule.GetResponseBody()}
+	var template string
+	if route.verb, template, err = httpRulePattern(rule); err != nil {
+		return nil, err
+	}
+	if route.path, err = expandPathTemplate(template, fields, method.Input()); err != nil {
+		return nil, err
+	}
+
+	switch rule.GetBody() {
+	case "":
+	case "*":
+		route.body, route.hasBody = fields, true
+		return route, nil
+	default:
+		route.body, route.hasBody = takeJsonField(fields, rule.GetBody())
+	}
+	addQueryParams(route.query, "", fields)
+	return route, nil
+}
+
+// httpRule returns google.api.http option of the method, nil is returned if
+// the method has no option
+func httpRule(method protoreflect.MethodDescriptor) (rule *annotations.HttpRule, err error) {
+	// the options of the compiled service proto are re-read to resolve the
+	// extension, it is unknown to the compiler if the proto imports own copy
+	// of google/api/annotations.proto
+	data, err := proto.Marshal(method.Options())
+	if err != nil {
+		return nil, fmt.Errorf("can't read options of method %v: %v", method.FullName(), err)
+	}
+	options := &descriptorpb.MethodOptions{}
+	if err = (proto.UnmarshalOptions{Resolver: protoregistry.GlobalTypes}).Unmarshal(data, options); err != nil {
+		return nil, fmt.Errorf("can't read options of method %v: %v", method.FullName(), err)
+	}
+	if !proto.HasExtension(options, annotations.E_Http) {
+		return nil, nil
+	}
+	return proto.GetExtension(options, annotations.E_Http).(*annotations.HttpRule), nil
+}
+
+// httpRulePattern returns HTTP verb and path template of the rule
+func httpRulePattern(rule *annotations.HttpRule) (verb string, template string, err error) {
+	switch pattern := rule.GetPattern().(type) {
+	case *annotations.HttpRule_Get:
+		verb, template = "GET", pattern.Get
+	case *annotations.HttpRule_Put:
+		verb, template = "PUT", pattern.Put
+	case *annotations.HttpRule_Post:
+		verb, template = "POST", pattern.Post
+	case *annotations.HttpRule_Delete:
+		verb, template = "DELETE", pattern.Delete
+	case *annotations.HttpRule_Patch:
+		verb, template = "PATCH", pattern.Patch
+	case *annotations.HttpRule_Custom:
+		verb, template = strings.ToUpper(pattern.Custom.GetKind()), pattern.Custom.GetPath()
+	}
+	if verb == "" || !strings.HasPrefix(template, "/") {
+		return "", "", fmt.Errorf("invalid google.api.http option: verb %q, path %q", verb, template)
+	}
+	return verb, template, nil
+}
+
+// expandPathTemplate replaces the variables of the path template, i.e.
+// "/v1/{name=shelves/*}/books/{book.id}", by the escaped values of the
+// request fields, the fields are removed from the request. The JSON request
+// omits the fields with default values, the zero value of the field of the
+// request message is used for them.
+func expandPathTemplate(template string, fields map[string]any, request protoreflect.MessageDescriptor) (path string, err error) {
+	var builder strings.Builder
+	for {
+		start := strings.Index(template, "{")
+		if start < 0 {
+			builder.WriteString(template)
+			return builder.String(), nil
+		}
+		end := strings.Index(template[start:], "}")
+		if end < 0 {
+			return "", fmt.Errorf("invalid path template %q", template)
+		}
+		end += start
+		builder.WriteString(template[:start])
+
+		fieldPath, pattern, _ := strings.Cut(template[start+1:end], "=")
+		var text string
+		if value, ok := takeJsonField(fields, fieldPath); ok {
+			if text, ok = jsonScalar(value); !ok {
+				return "", fmt.Errorf("field %v bound to the path is not a scalar", fieldPath)
+			}
+		} else if text, err = zeroPathValue(request, fieldPath); err != nil {
+			return "", err
+		}
+		if strings.Contains(pattern, "/") || strings.Contains(pattern, "**") {
+			// multi segment variables keep the slashes
+			segments := strings.Split(text, "/")
+			for i, segment := range segments {
+				segments[i] = url.PathEscape(segment)
+			}
+			builder.WriteString(strings.Join(segments, "/"))
+		} else {
+			builder.WriteString(url.PathEscape(text))
+		}
+		template = template[end+1:]
+	}
+}
+
+// zeroPathValue returns the text of the zero value of the scalar field given
+// by the dot separated path the same way protojson writes it
+func zeroPathValue(message protoreflect.MessageDescriptor, path string) (text string, err error) {
+	names := strings.Split(path, ".")
+	for i, name := range names {
+		field := message.Fields().ByName(protoreflect.Name(name))
+		if field == nil {
+			return "", fmt.Errorf("field %v bound to the path is not found in %v", path, message.FullName())
+		}
+		if i < len(names)-1 {
+			if field.Message() == nil || field.IsList() || field.IsMap() {
+				return "", fmt.Errorf("field %v bound to the path is not a scalar", path)
+			}
+			message = field.Message()
+			continue
+		}
+		if field.Message() != nil || field.IsList() || field.IsMap() {
+			return "", fmt.Errorf("field %v bound to the path is not a scalar", path)
+		}
+		switch field.Kind() {
+		case protoreflect.EnumKind:
+			if value := field.Enum().Values().ByNumber(0); value != nil {
+				return string(value.Name()), nil
+			}
+			return "0", nil
+		case protoreflect.StringKind, protoreflect.BytesKind:
+			return "", nil
+		default:
+			// bool and numbers
+			return field.Default().String(), nil
+		}
+	}
+	return "", fmt.Errorf("field %v bound to the path is not set", path)
+}
+
+// addQueryParams adds the fields as query parameters, the fields of the
+// nested messages are added as "parent.field" parameters
+func addQueryParams(query url.Values, prefix string, value any) {
+	switch value := value.(type) {
+	case map[string]any:
+		for name, field := range value {
+			if prefix != "" {
+				name = prefix + "." + name
+			}
+			addQueryParams(query, name, field)
+		}
+	case []any:
+		for _, item := range value {
+			addQueryParams(query, prefix, item)
+		}
+	default:
+		if text, ok := jsonScalar(value); ok {
+			query.Add(prefix, text)
+		}
+	}
+}
+
+// takeJsonField removes the field given by the dot separated path from the
+// fields and returns its value
+func takeJsonField(fields map[string]any, path string) (value any, ok bool) {
+	names := strings.Split(path, ".")
+	for _, name := range names[:len(names)-1] {
+		if fields, ok = fields[name].(map[string]any); !ok {
+			return nil, false
+		}
+	}
+	name := names[len(names)-1]
+	value, ok = fields[name]
+	delete(fields, name)
+	return
+}
+
+func jsonScalar(value any) (text string, ok bool) {
+	switch value := value.(type) {
+	case string:
+		return value, true
+	case json.Number:
+		return value.String(), true
+	case bool:
+		return strconv.FormatBool(value), true
+	}
+	return "", false
+}
+
+// decodeJsonFields decodes JSON object keeping the numbers as is
+func decodeJsonFields(jsonBody []byte) (fields map[string]any, err error) {
+	decoder := json.NewDecoder(bytes.NewReader(jsonBody))
+	decoder.UseNumber()
+	if err = decoder.Decode(&fields); err != nil {
+		return nil, fmt.Errorf("can't decode request json: %v", err)
+	}
+	if fields == nil {
+		fields = map[string]any{}
+	}
+	return fields, nil
+}
+
+// wrapResponseBody returns the reply which has the response of the service
+// as the field given by response_body of the rule
+func (route *httpRoute) wrapResponseBody(resp []byte) (reply []byte, err error) {
+	if route.responseBody == "" || len(bytes.TrimSpace(resp)) == 0 {
+		return resp, nil
+	}
+	reply = resp
+	names := strings.Split(route.responseBody, ".")
+	for i := len(names) - 1; i >= 0; i-- {
+		if reply, err = json.Marshal(map[string]json.RawMessage{names[i]: reply}); err != nil {
+			return nil, fmt.Errorf("invalid response of HTTP service: %v", err)
+		}
+	}
+	return reply, nil
+}
+
+// url returns the URL of the route relative to the service endpoint
+func (route *httpRoute) url(endpoint *url.URL) (routeURL *url.URL, err error) {
+	routeURL = &url.URL{}
+	*routeURL = *endpoint
+	path := endpoint.EscapedPath()
+	if strings.HasPrefix(route.path, "/") {
+		path = strings.TrimSuffix(path, "/")
+	}
+	path += route.path
+	if routeURL.Path, err = url.PathUnescape(path); err != nil {
+		return nil, err
+	}
+	routeURL.RawPath = path
+	routeURL.RawQuery = route.query.Encode()
+	return routeURL, nil
+}
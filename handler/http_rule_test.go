@@ -0,0 +1,112 @@
+package handler
+
+import (
+	"net/url"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+var httpRuleProto = map[string]string{
+	"library.proto": `
+		syntax = "proto3";
+		package library;
+		import "google/api/annotations.proto";
+		service Library {
+			rpc GetBook (GetBookRequest) returns (Book) {
+				option (google.api.http) = { get: "/v1/{name=shelves/*/books/*}:read" };
+			}
+			rpc UpdateBook (UpdateBookRequest) returns (Book) {
+				option (google.api.http) = { patch: "/v1/shelves/{shelf.id}/books/{book.id}" body: "book" response_body: "book" };
+			}
+			rpc CreateShelf (Shelf) returns (Shelf) {
+				option (google.api.http) = { post: "/v1/shelves" body: "*" };
+			}
+			rpc GetItem (GetItemRequest) returns (Shelf) {
+				option (google.api.http) = { get: "/v1/items/{id}/{archived}/{kind}/{shelf.theme}" };
+			}
+			rpc Plain (Shelf) returns (Shelf);
+		}
+		enum Kind { KIND_UNSPECIFIED = 0; KIND_BOOK = 1; }
+		message GetItemRequest { int64 id = 1; bool archived = 2; Kind kind = 3; Shelf shelf = 4; }
+		message Shelf { string id = 1; string theme = 2; }
+		message Book { string id = 1; string title = 2; }
+		message GetBookRequest { string name = 1; int64 version = 2; repeated string fields = 3; Shelf hint = 4; }
+		message UpdateBookRequest { Shelf shelf = 1; Book book = 2; bool validate = 3; }
+	`,
+}
+
+func TestNewHTTPRoute(t *testing.T) {
+	files := getDescriptors(t, httpRuleProto)
+	endpoint, _ := url.Parse("http://localhost:8080/api/")
+
+	route, err := newHTTPRoute(findMethodInProto(files, "GetBook"), "GetBook",
+		[]byte(`{"name":"shelves/a b/books/1","version":"7","fields":["title","id"],"hint":{"theme":"sci-fi"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "GET", route.verb)
+	assert.False(t, route.hasBody)
+	routeURL, err := route.url(endpoint)
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/api/v1/shelves/a%20b/books/1:read?fields=title&fields=id&hint.theme=sci-fi&version=7",
+		routeURL.String())
+
+	route, err = newHTTPRoute(findMethodInProto(files, "UpdateBook"), "UpdateBook",
+		[]byte(`{"shelf":{"id":"s/1"},"book":{"id":"b1","title":"Dune"},"validate":true}`))
+	require.NoError(t, err)
+	assert.Equal(t, "PATCH", route.verb)
+	assert.Equal(t, "/v1/shelves/s%2F1/books/b1", route.path)
+	assert.Equal(t, map[string]any{"title": "Dune"}, route.body)
+	assert.Equal(t, url.Values{"validate": {"true"}}, route.query)
+	reply, err := route.wrapResponseBody([]byte(`{"id":"b1"}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"book":{"id":"b1"}}`, string(reply))
+
+	route, err = newHTTPRoute(findMethodInProto(files, "CreateShelf"), "CreateShelf", []byte(`{"id":"1","theme":"sci-fi"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "POST", route.verb)
+	assert.Equal(t, map[string]any{"id": "1", "theme": "sci-fi"}, route.body)
+	assert.Empty(t, route.query)
+
+	route, err = newHTTPRoute(findMethodInProto(files, "UpdateBook"), "UpdateBook", []byte(`{"book":{"id":"b1"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "/v1/shelves//books/b1", route.path, "unset string is empty")
+}
+
+func TestNewHTTPRouteWithDefaultPathValues(t *testing.T) {
+	files := getDescriptors(t, httpRuleProto)
+
+	// protojson omits the fields with default values
+	route, err := newHTTPRoute(findMethodInProto(files, "GetItem"), "GetItem", []byte(`{}`))
+	require.NoError(t, err)
+	assert.Equal(t, "/v1/items/0/false/KIND_UNSPECIFIED/", route.path)
+	assert.Empty(t, route.query)
+
+	route, err = newHTTPRoute(findMethodInProto(files, "GetItem"), "GetItem",
+		[]byte(`{"id":"7","archived":true,"kind":"KIND_BOOK","shelf":{"theme":"sci-fi"}}`))
+	require.NoError(t, err)
+	assert.Equal(t, "/v1/items/7/true/KIND_BOOK/sci-fi", route.path)
+
+	method := findMethodInProto(files, "GetItem")
+	_, err = expandPathTemplate("/v1/items/{missing}", map[string]any{}, method.Input())
+	assert.EqualError(t, err, "field missing bound to the path is not found in library.GetItemRequest")
+	_, err = expandPathTemplate("/v1/items/{shelf}", map[string]any{}, method.Input())
+	assert.EqualError(t, err, "field shelf bound to the path is not a scalar")
+}
+
+func TestNewHTTPRouteWithoutRule(t *testing.T) {
+	files := getDescriptors(t, httpRuleProto)
+	endpoint, _ := url.Parse("http://localhost:8080/api/")
+
+	route, err := newHTTPRoute(findMethodInProto(files, "Plain"), "Plain", []byte(`{"id":"1"}`))
+	require.NoError(t, err)
+	assert.Equal(t, "POST", route.verb)
+	assert.True(t, route.hasBody)
+	assert.Equal(t, map[string]any{"id": "1"}, route.body)
+	routeURL, err := route.url(endpoint)
+	require.NoError(t, err)
+	assert.Equal(t, "http://localhost:8080/api/Plain", routeURL.String())
+	reply, err := route.wrapResponseBody([]byte(`{"id":"1"}`))
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":"1"}`, string(reply))
+}
@@ -0,0 +1,197 @@
+package handler
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"go.uber.org/zap"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/protoadapt"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+// maxHTTPErrorBodySize is the part of the error response of HTTP service
+// which is read to find the error message
+const maxHTTPErrorBodySize = 64 * 1024
+
+// maxHTTPErrorDetailSize is the part of the error response which is returned
+// to the client in the error details
+const maxHTTPErrorDetailSize = 1024
+
+// HTTPErrorReason is the reason of errdetails.ErrorInfo added to the status
+// of the call failed by HTTP service
+const HTTPErrorReason = "HTTP_SERVICE_ERROR"
+
+// HTTPErrorDomain is the domain of errdetails.ErrorInfo added to the status
+// of the call failed by HTTP service
+const HTTPErrorDomain = "snet-daemon"
+
+// defaultHTTPStatusCodes maps HTTP status codes to gRPC status codes the same
+// way as google.rpc.Code does, "4xx" and "5xx" keys are applied to the status
+// codes not listed
+var defaultHTTPStatusCodes = map[string]codes.Code{
+	"400": codes.InvalidArgument,
+	"401": codes.Unauthenticated,
+	"403": codes.PermissionDenied,
+	"404": codes.NotFound,
+	"408": codes.DeadlineExceeded,
+	"409": codes.Aborted,
+	"412": codes.FailedPrecondition,
+	"429": codes.ResourceExhausted,
+	"499": codes.Canceled,
+	"501": codes.Unimplemented,
+	"502": codes.Unavailable,
+	"503": codes.Unavailable,
+	"504": codes.DeadlineExceeded,
+	"4xx": codes.FailedPrecondition,
+	"5xx": codes.Internal,
+}
+
+var httpStatusKey = regexp.MustCompile(`^([45]\d\d|[45]xx)$`)
+
+// httpStatusMapping maps HTTP status codes of the failed HTTP service calls
+// to gRPC status codes. The nil mapping uses defaultHTTPStatusCodes.
+type httpStatusMapping struct {
+	codes map[string]codes.Code
+	// forwardBody adds the start of the error body to the status details
+	forwardBody bool
+}
+
+// newHTTPStatusMapping overrides defaultHTTPStatusCodes by the rules, the
+// keys are HTTP status codes or classes ("429" or "5xx"), the values are gRPC
+// status code names (ResourceExhausted or RESOURCE_EXHAUSTED). The error body
+// is returned to the client only if forwardBody is true.
+func newHTTPStatusMapping(rules map[string]any, forwardBody bool) (mapping *httpStatusMapping, err error) {
+	mapping = &httpStatusMapping{
+		codes:       make(map[string]codes.Code, len(defaultHTTPStatusCodes)+len(rules)),
+		forwardBody: forwardBody,
+	}
+	for key, code := range defaultHTTPStatusCodes {
+		mapping.codes[key] = code
+	}
+	for key, value := range rules {
+		key = strings.ToLower(key)
+		if !httpStatusKey.MatchString(key) {
+			return nil, fmt.Errorf("invalid HTTP status %q, expected 4xx or 5xx status code or class, i.e. \"429\" or \"5xx\"", key)
+		}
+		name, ok := value.(string)
+		if !ok {
+			return nil, fmt.Errorf("invalid gRPC status code for HTTP status %v: %v", key, value)
+		}
+		code, ok := parseStatusCode(name)
+		if !ok || code == codes.OK {
+			return nil, fmt.Errorf("invalid gRPC status code for HTTP status %v: %q", key, name)
+		}
+		mapping.codes[key] = code
+	}
+	return mapping, nil
+}
+
+// Code returns gRPC status code of the HTTP status code
+func (mapping *httpStatusMapping) Code(httpStatus int) codes.Code {
+	mappedCodes := defaultHTTPStatusCodes
+	if mapping != nil {
+		mappedCodes = mapping.codes
+	}
+	if code, ok := mappedCodes[strconv.Itoa(httpStatus)]; ok {
+		return code
+	}
+	if code, ok := mappedCodes[fmt.Sprintf("%dxx", httpStatus/100)]; ok {
+		return code
+	}
+	return codes.Unknown
+}
+
+// statusError returns the status of the call failed by HTTP service. The
+// message is taken from the JSON error body, the status code and, if
+// enabled, the start of the body are added as errdetails.ErrorInfo,
+// Retry-After header as errdetails.RetryInfo.
+func (mapping *httpStatusMapping) statusError(httpResp *http.Response) error {
+	body, err := io.ReadAll(io.LimitReader(httpResp.Body, maxHTTPErrorBodySize))
+	if err != nil {
+		zap.L().Debug("Unable to read error response of HTTP service", zap.Error(err))
+	}
+	zap.L().Debug("Error response from HTTP service", zap.Int("status", httpResp.StatusCode),
+		zap.String("response", string(body)))
+
+	message := fmt.Sprintf("HTTP service returned %v", httpResp.Status)
+	if httpResp.Status == "" {
+		message = fmt.Sprintf("HTTP service returned %v %v", httpResp.StatusCode, http.StatusText(httpResp.StatusCode))
+	}
+	if errorMessage := httpErrorMessage(body); errorMessage != "" {
+		message += ": " + errorMessage
+	}
+
+	errorInfo := &errdetails.ErrorInfo{
+		Reason:   HTTPErrorReason,
+		Domain:   HTTPErrorDomain,
+		Metadata: map[string]string{"http_status": strconv.Itoa(httpResp.StatusCode)},
+	}
+	if mapping != nil && mapping.forwardBody {
+		errorInfo.Metadata["body"], _ = truncateUTF8(string(body), maxHTTPErrorDetailSize)
+	}
+	details := []protoadapt.MessageV1{errorInfo}
+	if seconds, err := strconv.Atoi(httpResp.Header.Get("Retry-After")); err == nil && seconds >= 0 {
+		details = append(details, &errdetails.RetryInfo{RetryDelay: durationpb.New(time.Duration(seconds) * time.Second)})
+	}
+
+	st := status.New(mapping.Code(httpResp.StatusCode), message)
+	if withDetails, err := st.WithDetails(details...); err == nil {
+		st = withDetails
+	}
+	return st.Err()
+}
+
+// httpErrorMessage returns the error message of the JSON error body, i.e.
+// {"error": {"message": "..."}}, {"error": "..."} or {"detail": "..."}. The
+// start of the body is returned if the message is not found.
+func httpErrorMessage(body []byte) string {
+	body = bytes.TrimSpace(body)
+	var value any
+	if err := json.Unmarshal(body, &value); err == nil {
+		if message, ok := jsonErrorMessage(value); ok {
+			return message
+		}
+	}
+	message, truncated := truncateUTF8(string(body), maxHTTPErrorDetailSize)
+	if truncated {
+		message += "..."
+	}
+	return message
+}
+
+// truncateUTF8 cuts the text to at most size bytes without splitting a UTF-8
+// sequence
+func truncateUTF8(text string, size int) (truncated string, ok bool) {
+	if len(text) <= size {
+		return text, false
+	}
+	for size > 0 && !utf8.RuneStart(text[size]) {
+		size--
+	}
+	return text[:size], true
+}
+
+func jsonErrorMessage(value any) (message string, ok bool) {
+	switch value := value.(type) {
+	case string:
+		return value, value != ""
+	case map[string]any:
+		for _, key := range []string{"error", "message", "detail", "error_description", "title"} {
+			if message, ok = jsonErrorMessage(value[key]); ok {
+				return message, true
+			}
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,88 @@
+package handler
+
+import (
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestHTTPStatusMapping(t *testing.T) {
+	var defaultMapping *httpStatusMapping
+	assert.Equal(t, codes.ResourceExhausted, defaultMapping.Code(429))
+	assert.Equal(t, codes.FailedPrecondition, defaultMapping.Code(418))
+	assert.Equal(t, codes.Internal, defaultMapping.Code(500))
+	assert.Equal(t, codes.Unknown, defaultMapping.Code(302))
+
+	mapping, err := newHTTPStatusMapping(map[string]any{"429": "UNAVAILABLE", "5XX": "Unavailable"}, false)
+	require.NoError(t, err)
+	assert.Equal(t, codes.Unavailable, mapping.Code(429))
+	assert.Equal(t, codes.Unavailable, mapping.Code(500))
+	assert.Equal(t, codes.NotFound, mapping.Code(404))
+
+	_, err = newHTTPStatusMapping(map[string]any{"200": "Unknown"}, false)
+	assert.EqualError(t, err, "invalid HTTP status \"200\", expected 4xx or 5xx status code or class, i.e. \"429\" or \"5xx\"")
+	_, err = newHTTPStatusMapping(map[string]any{"500": "Broken"}, false)
+	assert.EqualError(t, err, "invalid gRPC status code for HTTP status 500: \"Broken\"")
+}
+
+func TestHTTPErrorMessage(t *testing.T) {
+	assert.Equal(t, "model not found", httpErrorMessage([]byte(`{"error":{"message":"model not found","code":404}}`)))
+	assert.Equal(t, "rate limited", httpErrorMessage([]byte(`{"error":"rate limited"}`)))
+	assert.Equal(t, "bad input", httpErrorMessage([]byte(`{"detail":"bad input"}`)))
+	assert.Equal(t, `{"detail":[{"loc":["text"]}]}`, httpErrorMessage([]byte(`{"detail":[{"loc":["text"]}]}`)))
+	assert.Equal(t, "Internal Server Error", httpErrorMessage([]byte("Internal Server Error\n")))
+	assert.Equal(t, "", httpErrorMessage(nil))
+
+	// the message is not cut in the middle of a UTF-8 sequence
+	message := httpErrorMessage([]byte("a" + strings.Repeat("я", maxHTTPErrorDetailSize)))
+	assert.True(t, utf8.ValidString(message))
+	assert.Equal(t, "a"+strings.Repeat("я", maxHTTPErrorDetailSize/2-1)+"...", message)
+}
+
+func TestHTTPStatusError(t *testing.T) {
+	var mapping *httpStatusMapping
+	request, _ := http.NewRequest("GET", "http://backend.internal:5000/v1/items", nil)
+	err := mapping.statusError(&http.Response{
+		Status:     "429 Too Many Requests",
+		StatusCode: 429,
+		Header:     http.Header{"Retry-After": {"30"}},
+		Body:       io.NopCloser(strings.NewReader(`{"error":{"message":"slow down"}}`)),
+		Request:    request,
+	})
+
+	st := status.Convert(err)
+	assert.Equal(t, codes.ResourceExhausted, st.Code())
+	assert.Equal(t, "HTTP service returned 429 Too Many Requests: slow down", st.Message())
+	require.Len(t, st.Details(), 2)
+	errorInfo := st.Details()[0].(*errdetails.ErrorInfo)
+	assert.Equal(t, HTTPErrorReason, errorInfo.Reason)
+	assert.Equal(t, HTTPErrorDomain, errorInfo.Domain, "backend host is not returned")
+	assert.Equal(t, "429", errorInfo.Metadata["http_status"])
+	assert.NotContains(t, errorInfo.Metadata, "body", "error body is not returned by default")
+	assert.Equal(t, 30*time.Second, st.Details()[1].(*errdetails.RetryInfo).RetryDelay.AsDuration())
+}
+
+func TestHTTPStatusErrorForwardsBody(t *testing.T) {
+	mapping, err := newHTTPStatusMapping(nil, true)
+	require.NoError(t, err)
+	body := strings.Repeat("€", maxHTTPErrorDetailSize)
+	err = mapping.statusError(&http.Response{
+		StatusCode: 500,
+		Body:       io.NopCloser(strings.NewReader(body)),
+	})
+
+	st := status.Convert(err)
+	assert.Equal(t, codes.Internal, st.Code())
+	errorInfo := st.Details()[0].(*errdetails.ErrorInfo)
+	assert.True(t, utf8.ValidString(errorInfo.Metadata["body"]))
+	assert.Equal(t, strings.Repeat("€", maxHTTPErrorDetailSize/3), errorInfo.Metadata["body"])
+}
@@ -0,0 +1,135 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	"github.com/singnet/snet-daemon/v6/errs"
+)
+
+const (
+	sseContentType    = "text/event-stream"
+	ndjsonContentType = "application/x-ndjson"
+	// sseDone is the data of the event which ends the stream of some LLM
+	// servers
+	sseDone = "[DONE]"
+	// sseErrorEvent is the type of the event which fails the call with its
+	// data as the error message
+	sseErrorEvent = "error"
+)
+
+// errStreamDone stops reading the stream without an error
+var errStreamDone = errors.New("stream is done")
+
+// sendHTTPStream sends the events of Server-Sent Events response or the JSON
+// values of newline delimited JSON response of HTTP service as the replies of
+// server-streaming method
+func (g grpcHandler) sendHTTPStream(inStream grpc.ServerStream, route *httpRoute, method string, httpResp *http.Response) (err error) {
+	send := func(data []byte) error {
+		zap.L().Debug("Stream message from HTTP service", zap.String("message", string(data)))
+		return g.sendHTTPReply(inStream, route, method, data)
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(httpResp.Header.Get("content-type"))
+	if mediaType == sseContentType {
+		err = readServerSentEvents(httpResp.Body, func(event string, data string) error {
+			switch {
+			case event == sseErrorEvent:
+				return status.Errorf(codes.Unknown, "HTTP service error: %v", data)
+			case data == sseDone:
+				return errStreamDone
+			case strings.TrimSpace(data) == "":
+				return nil
+			}
+			return send([]byte(data))
+		})
+	} else {
+		err = readJsonValues(httpResp.Body, send)
+	}
+
+	if err == nil || errors.Is(err, errStreamDone) {
+		return nil
+	}
+	if _, ok := status.FromError(err); ok {
+		return err
+	}
+	if ctxErr := inStream.Context().Err(); ctxErr != nil {
+		return status.FromContextError(ctxErr).Err()
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return status.Errorf(codes.DeadlineExceeded, "HTTP service timeout: %v", err)
+	}
+	return status.Errorf(codes.Internal, "error reading stream from HTTP service: %+v%v", err, errs.ErrDescURL(errs.ServiceUnavailable))
+}
+
+// readServerSentEvents calls onEvent for each event of the stream, the data
+// lines of the event are joined by "\n", see
+// https://html.spec.whatwg.org/multipage/server-sent-events.html
+func readServerSentEvents(reader io.Reader, onEvent func(event string, data string) error) error {
+	lines := bufio.NewReader(reader)
+	var event string
+	var data []string
+	for {
+		line, err := lines.ReadString('\n')
+		if err != nil && err != io.EOF {
+			return err
+		}
+		eof := err == io.EOF
+		line = strings.TrimRight(line, "\r\n")
+
+		if line == "" && (!eof || len(data) > 0) {
+			// empty line dispatches the event
+			if len(data) > 0 {
+				if err = onEvent(event, strings.Join(data, "\n")); err != nil {
+					return err
+				}
+			}
+			event, data = "", nil
+		} else if !strings.HasPrefix(line, ":") {
+			name, value, _ := strings.Cut(line, ":")
+			value = strings.TrimPrefix(value, " ")
+			switch name {
+			case "event":
+				event = value
+			case "data":
+				data = append(data, value)
+			}
+		}
+
+		if eof {
+			if len(data) > 0 {
+				return onEvent(event, strings.Join(data, "\n"))
+			}
+			return nil
+		}
+	}
+}
+
+// readJsonValues calls onValue for each JSON value of the stream, the values
+// can be separated by new lines or any other white space
+func readJsonValues(reader io.Reader, onValue func(value []byte) error) error {
+	decoder := json.NewDecoder(reader)
+	for {
+		var value json.RawMessage
+		if err := decoder.Decode(&value); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return err
+		}
+		if err := onValue(bytes.TrimSpace(value)); err != nil {
+			return err
+		}
+	}
+}
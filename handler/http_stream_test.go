@@ -0,0 +1,94 @@
+package handler
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+
+	"github.com/singnet/snet-daemon/v6/blockchain"
+)
+
+type sentMessagesStreamMock struct {
+	serverStreamMock
+	sent []string
+}
+
+func (m *sentMessagesStreamMock) SendMsg(message any) error {
+	json, err := protojson.Marshal(message.(proto.Message))
+	m.sent = append(m.sent, strings.ReplaceAll(string(json), " ", ""))
+	return err
+}
+
+func TestReadServerSentEvents(t *testing.T) {
+	stream := ": comment\r\nevent: token\r\ndata: {\"a\":\r\ndata: 1}\r\n\r\nid: 2\ndata:{\"a\":2}\n\ndata: last"
+	var events []string
+	err := readServerSentEvents(strings.NewReader(stream), func(event string, data string) error {
+		events = append(events, event+"|"+data)
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{"token|{\"a\":\n1}", "|{\"a\":2}", "|last"}, events)
+}
+
+func TestReadJsonValues(t *testing.T) {
+	var values []string
+	err := readJsonValues(strings.NewReader("{\"a\":1}\n{\"a\":\n2}\n\n"), func(value []byte) error {
+		values = append(values, string(value))
+		return nil
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []string{`{"a":1}`, "{\"a\":\n2}"}, values)
+
+	err = readJsonValues(strings.NewReader("{\"a\":1}\n{\"a\""), func(value []byte) error { return nil })
+	assert.Error(t, err)
+}
+
+func TestSendHTTPStream(t *testing.T) {
+	files := getDescriptors(t, map[string]string{
+		"chat.proto": `
+			syntax = "proto3";
+			package chat;
+			service Chat {
+				rpc Complete (Prompt) returns (stream Token);
+			}
+			message Prompt { string text = 1; }
+			message Token { string text = 1; }
+		`,
+	})
+	g := grpcHandler{serviceMetaData: &blockchain.ServiceMetadata{ProtoDescriptors: files}}
+	route := &httpRoute{}
+	response := func(contentType string, body string) *http.Response {
+		return &http.Response{
+			Header: http.Header{"Content-Type": {contentType}},
+			Body:   io.NopCloser(strings.NewReader(body)),
+		}
+	}
+
+	stream := &sentMessagesStreamMock{serverStreamMock: serverStreamMock{context: context.Background()}}
+	err := g.sendHTTPStream(stream, route, "Complete", response("text/event-stream; charset=utf-8",
+		"data: {\"text\":\"Hello\"}\n\ndata: {\"text\":\"world\"}\n\ndata: [DONE]\n\ndata: {\"text\":\"ignored\"}\n\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{`{"text":"Hello"}`, `{"text":"world"}`}, stream.sent)
+
+	stream = &sentMessagesStreamMock{serverStreamMock: serverStreamMock{context: context.Background()}}
+	err = g.sendHTTPStream(stream, route, "Complete", response("application/x-ndjson",
+		"{\"text\":\"Hello\"}\n{\"text\":\"world\"}\n"))
+	require.NoError(t, err)
+	assert.Equal(t, []string{`{"text":"Hello"}`, `{"text":"world"}`}, stream.sent)
+
+	stream = &sentMessagesStreamMock{serverStreamMock: serverStreamMock{context: context.Background()}}
+	err = g.sendHTTPStream(stream, route, "Complete", response("text/event-stream",
+		"data: {\"text\":\"Hello\"}\n\nevent: error\ndata: model is overloaded\n\n"))
+	assert.Equal(t, codes.Unknown, status.Code(err))
+	assert.Equal(t, "HTTP service error: model is overloaded", status.Convert(err).Message())
+	assert.Len(t, stream.sent, 1)
+}
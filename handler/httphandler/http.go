@@ -6,6 +6,7 @@ import (
 	"net/http"
 
 	"github.com/singnet/snet-daemon/v6/blockchain"
+	"github.com/singnet/snet-daemon/v6/handler"
 	"github.com/singnet/snet-daemon/v6/ratelimit"
 	"golang.org/x/time/rate"
 
@@ -16,13 +17,21 @@ type httpHandler struct {
 	passthroughEnabled  bool
 	passthroughEndpoint string
 	rateLimiter         rate.Limiter
+	httpClient          *handler.ServiceHTTPClient
 }
 
 func NewHTTPHandler(blockProc blockchain.Processor) http.Handler {
+	return NewHTTPHandlerWithClient(blockProc, nil)
+}
+
+// NewHTTPHandlerWithClient returns the handler which passes the requests to
+// the service using httpClient
+func NewHTTPHandlerWithClient(blockProc blockchain.Processor, httpClient *handler.ServiceHTTPClient) http.Handler {
 	return &httpHandler{
 		passthroughEnabled:  config.GetBool(config.PassthroughEnabledKey),
 		passthroughEndpoint: config.GetString(config.ServiceEndpointKey),
 		rateLimiter:         *ratelimit.NewRateLimiter(),
+		httpClient:          httpClient,
 	}
 }
 
@@ -34,13 +43,14 @@ func (h *httpHandler) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
 			http.Error(resp, http.StatusText(429), http.StatusTooManyRequests)
 			return
 		}
-		req2, err := http.NewRequest(req.Method, h.passthroughEndpoint, req.Body)
+		req2, err := http.NewRequestWithContext(req.Context(), req.Method, h.passthroughEndpoint, req.Body)
 		if err != nil {
 			http.Error(resp, err.Error(), http.StatusInternalServerError)
 			return
 		}
 		req2.Header = req.Header
-		if resp2, err := http.DefaultClient.Do(req2); err == nil {
+		if resp2, err := h.httpClient.Do(req2); err == nil {
+			defer resp2.Body.Close()
 			for k, l := range resp2.Header {
 				for _, v := range l {
 					resp.Header().Add(k, v)
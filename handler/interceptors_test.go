@@ -7,6 +7,7 @@ import (
 	"testing"
 
 	"github.com/singnet/snet-daemon/v6/blockchain"
+	"github.com/singnet/snet-daemon/v6/codec"
 
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/suite"
@@ -24,6 +25,43 @@ const (
 type paymentMock struct {
 }
 
+// meteredPaymentMock pays for paidMessages messages
+type meteredPaymentMock struct {
+	paidMessages int
+	received     int
+	stopped      bool
+}
+
+func (payment *meteredPaymentMock) Start(ctx context.Context) context.Context {
+	return ctx
+}
+
+func (payment *meteredPaymentMock) Received() *GrpcError {
+	payment.received++
+	return payment.exhausted()
+}
+
+func (payment *meteredPaymentMock) Stop() *GrpcError {
+	payment.stopped = true
+	return payment.exhausted()
+}
+
+func (payment *meteredPaymentMock) exhausted() *GrpcError {
+	if payment.received > payment.paidMessages {
+		return NewGrpcError(codes.ResourceExhausted, "payment is exhausted")
+	}
+	return nil
+}
+
+// actualCostPaymentMock keeps the cost reported by the service
+type actualCostPaymentMock struct {
+	cost *big.Int
+}
+
+func (payment *actualCostPaymentMock) SetActualCost(cost *big.Int) {
+	payment.cost = cost
+}
+
 type paymentHandlerMock struct {
 	typ                      string
 	completeAfterErrorCalled bool
@@ -31,7 +69,9 @@ type paymentHandlerMock struct {
 	completeResult           *GrpcError
 	completeAfterErrorResult *GrpcError
 	paymentResult            *GrpcError
-	payment                  *paymentMock
+	metered                  *meteredPaymentMock
+	actualCost               *actualCostPaymentMock
+	payment                  Payment
 }
 
 func (handler *paymentHandlerMock) reset() {
@@ -40,6 +80,8 @@ func (handler *paymentHandlerMock) reset() {
 	handler.completeResult = nil
 	handler.completeAfterErrorResult = nil
 	handler.paymentResult = nil
+	handler.metered = nil
+	handler.actualCost = nil
 	handler.payment = nil
 }
 
@@ -52,6 +94,12 @@ func (handler *paymentHandlerMock) Payment(context *GrpcStreamContext) (payment
 		return nil, handler.paymentResult
 	}
 	handler.payment = &paymentMock{}
+	if handler.metered != nil {
+		handler.payment = handler.metered
+	}
+	if handler.actualCost != nil {
+		handler.payment = handler.actualCost
+	}
 	return handler.payment, nil
 }
 
@@ -240,3 +288,91 @@ func (suite *InterceptorsSuite) TestPaymentReturnsError() {
 
 	assert.Equal(suite.T(), status.Newf(codes.Internal, "test error").Err(), err)
 }
+
+func (suite *InterceptorsSuite) TestMeteredPaymentIsCompletedWhenExhausted() {
+	suite.paymentHandler.metered = &meteredPaymentMock{paidMessages: 2}
+	receiveAll := func(srv any, stream grpc.ServerStream) error {
+		for {
+			if err := stream.RecvMsg(&codec.GrpcFrame{}); err != nil {
+				return err
+			}
+		}
+	}
+
+	err := suite.interceptor(nil, suite.serverStream, nil, receiveAll)
+
+	assert.Equal(suite.T(), codes.ResourceExhausted, status.Code(err))
+	assert.Equal(suite.T(), 3, suite.paymentHandler.metered.received)
+	assert.True(suite.T(), suite.paymentHandler.metered.stopped)
+	assert.True(suite.T(), suite.paymentHandler.completeCalled, "stream is paid until the payment is exhausted")
+	assert.False(suite.T(), suite.paymentHandler.completeAfterErrorCalled)
+}
+
+func (suite *InterceptorsSuite) TestMeteredPaymentPaid() {
+	suite.paymentHandler.metered = &meteredPaymentMock{paidMessages: 2}
+	receiveOne := func(srv any, stream grpc.ServerStream) error {
+		return stream.RecvMsg(&codec.GrpcFrame{})
+	}
+
+	err := suite.interceptor(nil, suite.serverStream, nil, receiveOne)
+
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), 1, suite.paymentHandler.metered.received)
+	assert.True(suite.T(), suite.paymentHandler.completeCalled)
+}
+
+func (suite *InterceptorsSuite) TestChargePolicyIsAppliedOnHandlerError() {
+	policy, err := NewChargePolicy(map[string]any{"INVALID_ARGUMENT": "charge"})
+	suite.Require().NoError(err)
+	interceptor := GrpcPaymentValidationInterceptorWithChargePolicy(policy, &blockchain.ServiceMetadata{},
+		suite.defaultPaymentHandler, suite.paymentHandler)
+	invalidArgument := func(srv any, stream grpc.ServerStream) error {
+		return status.Error(codes.InvalidArgument, "invalid input")
+	}
+
+	e := interceptor(nil, suite.serverStream, nil, invalidArgument)
+
+	assert.Equal(suite.T(), codes.InvalidArgument, status.Code(e), "service error is returned to the client")
+	assert.True(suite.T(), suite.paymentHandler.completeCalled)
+	assert.False(suite.T(), suite.paymentHandler.completeAfterErrorCalled)
+
+	suite.paymentHandler.reset()
+	interceptor(nil, suite.serverStream, nil, suite.returnErrorHandler)
+
+	assert.True(suite.T(), suite.paymentHandler.completeAfterErrorCalled, "other errors are refunded")
+	assert.False(suite.T(), suite.paymentHandler.completeCalled)
+}
+
+func (suite *InterceptorsSuite) TestActualCostIsReportedFromTrailer() {
+	suite.paymentHandler.actualCost = &actualCostPaymentMock{}
+	setTrailer := func(cost string) grpc.StreamHandler {
+		return func(srv any, stream grpc.ServerStream) error {
+			stream.SetTrailer(metadata.Pairs(ActualCostTrailer, cost))
+			return nil
+		}
+	}
+
+	err := suite.interceptor(nil, suite.serverStream, nil, setTrailer("7"))
+
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), big.NewInt(7), suite.paymentHandler.actualCost.cost)
+	assert.True(suite.T(), suite.paymentHandler.completeCalled)
+
+	suite.paymentHandler.actualCost.cost = nil
+	err = suite.interceptor(nil, suite.serverStream, nil, setTrailer("seven"))
+
+	assert.NoError(suite.T(), err)
+	assert.Nil(suite.T(), suite.paymentHandler.actualCost.cost, "invalid cost is ignored")
+}
+
+func (suite *InterceptorsSuite) TestDaemonServicesAreNotPaid() {
+	interceptor := GrpcDaemonServicesInterceptor([]string{"escrow.PaymentChannelStateService"}, suite.interceptor)
+	suite.paymentHandler.paymentResult = NewGrpcError(codes.Unauthenticated, "payment is required")
+
+	err := interceptor(nil, suite.serverStream, &grpc.StreamServerInfo{FullMethod: "/escrow.PaymentChannelStateService/WatchChannelState"}, suite.successHandler)
+	assert.NoError(suite.T(), err)
+	assert.False(suite.T(), suite.paymentHandler.completeCalled)
+
+	err = interceptor(nil, suite.serverStream, &grpc.StreamServerInfo{FullMethod: "/example_service.Calculator/add"}, suite.successHandler)
+	assert.Equal(suite.T(), codes.Unauthenticated, status.Code(err))
+}
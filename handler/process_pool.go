@@ -0,0 +1,292 @@
+package handler
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"go.uber.org/zap"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ProcessWorkerEnv is the environment variable set to "1" for the worker
+// processes, so the executable knows it should serve the calls using the
+// framed protocol instead of handling a single call
+const ProcessWorkerEnv = "SNETD_PROCESS_WORKER"
+
+// processWorkerRestartDelay is the minimal interval between the starts of the
+// worker which keeps crashing
+const processWorkerRestartDelay = time.Second
+
+// ProcessPoolConf is the configuration of the pool of worker processes
+// Size           - number of the worker processes, each worker handles one call at a time
+// CallTimeout    - limits the call if the gRPC call has no shorter deadline, 0 means no limit
+// MaxMessageSize - maximum size of the reply of the worker
+type ProcessPoolConf struct {
+	Size           int
+	CallTimeout    time.Duration
+	MaxMessageSize int
+}
+
+// processPool keeps the worker processes of the executable running and
+// passes the calls to them. The call is written to stdin of the worker as the
+// method name and the request, each is 4 bytes big endian length followed by
+// the bytes. The worker writes the reply to stdout as 1 byte gRPC status
+// code, 4 bytes big endian length and the reply, or the error message if the
+// status code is not OK. The worker which crashes is restarted, the worker
+// which exceeds the call timeout is killed and restarted.
+type processPool struct {
+	executable string
+	conf       ProcessPoolConf
+	// slots has a worker for each call which can be handled, the worker is
+	// nil if it is not started yet or failed to start
+	slots chan *processWorker
+}
+
+// newProcessPool starts conf.Size workers of the executable
+func newProcessPool(executable string, conf ProcessPoolConf) (pool *processPool, err error) {
+	if conf.Size <= 0 {
+		return nil, fmt.Errorf("process pool size should be positive, got %v", conf.Size)
+	}
+	pool = &processPool{executable: executable, conf: conf, slots: make(chan *processWorker, conf.Size)}
+	for i := 0; i < conf.Size; i++ {
+		worker, err := startProcessWorker(executable)
+		if err != nil {
+			pool.close()
+			return nil, err
+		}
+		pool.slots <- worker
+	}
+	zap.L().Info("Process workers are started", zap.String("executable", executable), zap.Int("workers", conf.Size))
+	return pool, nil
+}
+
+// call passes the request to a free worker and returns its reply, the call
+// waits for a free worker until ctx is done
+func (pool *processPool) call(ctx context.Context, method string, request []byte) (reply []byte, err error) {
+	var worker *processWorker
+	select {
+	case worker = <-pool.slots:
+	case <-ctx.Done():
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+	defer func() { pool.slots <- worker }()
+
+	if worker == nil || worker.exited() {
+		if worker != nil {
+			zap.L().Warn("Process worker exited, restarting", zap.Int("pid", worker.pid()), zap.Error(worker.exitErr))
+			worker.waitRestartDelay(ctx)
+		}
+		if worker, err = startProcessWorker(pool.executable); err != nil {
+			return nil, status.Errorf(codes.Unavailable, "can't start process worker: %v", err)
+		}
+	}
+
+	if pool.conf.CallTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, pool.conf.CallTimeout)
+		defer cancel()
+	}
+
+	type result struct {
+		reply []byte
+		err   error
+	}
+	results := make(chan result, 1)
+	go func() {
+		reply, err := worker.call(method, request, pool.conf.MaxMessageSize)
+		results <- result{reply, err}
+	}()
+
+	select {
+	case res := <-results:
+		if _, ok := status.FromError(res.err); ok && res.err != nil {
+			// the worker returned the error status
+			return nil, res.err
+		}
+		if res.err != nil {
+			// the worker can't be used if the call is not read completely
+			zap.L().Warn("Process worker failed, killing", zap.Int("pid", worker.pid()), zap.Error(res.err))
+			worker.kill()
+			return nil, status.Errorf(codes.Unavailable, "process worker failed: %v", res.err)
+		}
+		return res.reply, nil
+	case <-ctx.Done():
+		zap.L().Warn("Process worker call is not finished in time, killing", zap.Int("pid", worker.pid()),
+			zap.String("method", method), zap.Error(ctx.Err()))
+		worker.kill()
+		<-results
+		return nil, status.FromContextError(ctx.Err()).Err()
+	}
+}
+
+// close kills the started workers
+func (pool *processPool) close() {
+	for {
+		select {
+		case worker := <-pool.slots:
+			if worker != nil {
+				worker.kill()
+			}
+		default:
+			return
+		}
+	}
+}
+
+// processWorker is a running process of the executable
+type processWorker struct {
+	cmd     *exec.Cmd
+	stdin   io.WriteCloser
+	stdout  *bufio.Reader
+	started time.Time
+	// done is closed when the process exits, exitErr is the result of Wait
+	done    chan struct{}
+	exitErr error
+}
+
+func startProcessWorker(executable string) (worker *processWorker, err error) {
+	cmd := exec.Command(executable)
+	cmd.Env = append(os.Environ(), ProcessWorkerEnv+"=1")
+	// Wait doesn't hang on stderr kept open by the children of the killed
+	// worker
+	cmd.WaitDelay = processWorkerRestartDelay
+	stdin, err := cmd.StdinPipe()
+	if err != nil {
+		return nil, err
+	}
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	stderr := &processLogWriter{}
+	cmd.Stderr = stderr
+	if err = cmd.Start(); err != nil {
+		return nil, err
+	}
+	stderr.setPid(cmd.Process.Pid)
+
+	worker = &processWorker{
+		cmd:     cmd,
+		stdin:   stdin,
+		stdout:  bufio.NewReader(stdout),
+		started: time.Now(),
+		done:    make(chan struct{}),
+	}
+	go func() {
+		worker.exitErr = cmd.Wait()
+		close(worker.done)
+	}()
+	zap.L().Debug("Process worker is started", zap.String("executable", executable), zap.Int("pid", worker.pid()))
+	return worker, nil
+}
+
+func (worker *processWorker) pid() int {
+	return worker.cmd.Process.Pid
+}
+
+func (worker *processWorker) exited() bool {
+	select {
+	case <-worker.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// waitRestartDelay waits until processWorkerRestartDelay passes since the
+// worker start, so the worker which crashes on start isn't restarted in a
+// loop
+func (worker *processWorker) waitRestartDelay(ctx context.Context) {
+	delay := processWorkerRestartDelay - time.Since(worker.started)
+	if delay <= 0 {
+		return
+	}
+	select {
+	case <-time.After(delay):
+	case <-ctx.Done():
+	}
+}
+
+func (worker *processWorker) kill() {
+	if err := worker.cmd.Process.Kill(); err != nil && !worker.exited() {
+		zap.L().Warn("Can't kill process worker", zap.Int("pid", worker.pid()), zap.Error(err))
+	}
+	<-worker.done
+}
+
+// call writes the call to the worker and reads the reply, the error which
+// isn't a status means the worker can't be used anymore
+func (worker *processWorker) call(method string, request []byte, maxMessageSize int) (reply []byte, err error) {
+	var frame bytes.Buffer
+	writeProcessFrame(&frame, []byte(method))
+	writeProcessFrame(&frame, request)
+	if _, err = worker.stdin.Write(frame.Bytes()); err != nil {
+		return nil, fmt.Errorf("can't write request: %v", err)
+	}
+
+	code, err := worker.stdout.ReadByte()
+	if err != nil {
+		return nil, fmt.Errorf("can't read reply: %v", err)
+	}
+	var length uint32
+	if err = binary.Read(worker.stdout, binary.BigEndian, &length); err != nil {
+		return nil, fmt.Errorf("can't read reply: %v", err)
+	}
+	if maxMessageSize > 0 && int64(length) > int64(maxMessageSize) {
+		return nil, fmt.Errorf("reply size %v exceeds the maximum %v", length, maxMessageSize)
+	}
+	reply = make([]byte, length)
+	if _, err = io.ReadFull(worker.stdout, reply); err != nil {
+		return nil, fmt.Errorf("can't read reply: %v", err)
+	}
+
+	if codes.Code(code) != codes.OK {
+		return nil, status.Error(codes.Code(code), string(reply))
+	}
+	return reply, nil
+}
+
+func writeProcessFrame(buffer *bytes.Buffer, data []byte) {
+	_ = binary.Write(buffer, binary.BigEndian, uint32(len(data)))
+	buffer.Write(data)
+}
+
+// processLogWriter writes stderr of the worker to the log line by line
+type processLogWriter struct {
+	pid     int
+	mutex   sync.Mutex
+	partial []byte
+}
+
+func (writer *processLogWriter) setPid(pid int) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.pid = pid
+}
+
+func (writer *processLogWriter) Write(data []byte) (n int, err error) {
+	writer.mutex.Lock()
+	defer writer.mutex.Unlock()
+	writer.partial = append(writer.partial, data...)
+	for {
+		end := bytes.IndexByte(writer.partial, '\n')
+		if end < 0 {
+			return len(data), nil
+		}
+		line := strings.TrimRight(string(writer.partial[:end]), "\r")
+		writer.partial = writer.partial[end+1:]
+		if line != "" {
+			zap.L().Info("Process worker stderr", zap.Int("pid", writer.pid), zap.String("line", line))
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package handler
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// testProcessWorkerEnv makes the test binary run as the process worker
+const testProcessWorkerEnv = "SNETD_TEST_PROCESS_WORKER"
+
+func TestMain(m *testing.M) {
+	if os.Getenv(ProcessWorkerEnv) == "1" && os.Getenv(testProcessWorkerEnv) == "1" {
+		runTestProcessWorker()
+		os.Exit(0)
+	}
+	os.Exit(m.Run())
+}
+
+func readTestProcessFrame() ([]byte, error) {
+	var length uint32
+	if err := binary.Read(os.Stdin, binary.BigEndian, &length); err != nil {
+		return nil, err
+	}
+	data := make([]byte, length)
+	_, err := io.ReadFull(os.Stdin, data)
+	return data, err
+}
+
+func runTestProcessWorker() {
+	for {
+		method, err := readTestProcessFrame()
+		if err != nil {
+			return
+		}
+		request, err := readTestProcessFrame()
+		if err != nil {
+			return
+		}
+		fmt.Fprintf(os.Stderr, "calling %s\n", method)
+
+		code, reply := codes.OK, request
+		switch string(method) {
+		case "fail":
+			code, reply = codes.InvalidArgument, []byte("invalid request")
+		case "sleep":
+			time.Sleep(10 * time.Second)
+		case "crash":
+			os.Exit(1)
+		case "pid":
+			reply = []byte(fmt.Sprint(os.Getpid()))
+		}
+		var frame bytes.Buffer
+		frame.WriteByte(byte(code))
+		_ = binary.Write(&frame, binary.BigEndian, uint32(len(reply)))
+		frame.Write(reply)
+		_, _ = os.Stdout.Write(frame.Bytes())
+	}
+}
+
+func TestProcessPool(t *testing.T) {
+	t.Setenv(testProcessWorkerEnv, "1")
+	pool, err := newProcessPool(os.Args[0], ProcessPoolConf{Size: 1, CallTimeout: 500 * time.Millisecond, MaxMessageSize: 1024})
+	require.NoError(t, err)
+	defer pool.close()
+	ctx := context.Background()
+
+	reply, err := pool.call(ctx, "echo", []byte("request"))
+	require.NoError(t, err)
+	assert.Equal(t, "request", string(reply))
+	pid, err := pool.call(ctx, "pid", nil)
+	require.NoError(t, err)
+
+	_, err = pool.call(ctx, "fail", nil)
+	assert.Equal(t, status.Error(codes.InvalidArgument, "invalid request"), err)
+	reply, err = pool.call(ctx, "pid", nil)
+	require.NoError(t, err)
+	assert.Equal(t, pid, reply, "worker should be reused after error status")
+
+	_, err = pool.call(ctx, "sleep", nil)
+	assert.Equal(t, codes.DeadlineExceeded, status.Code(err))
+	reply, err = pool.call(ctx, "pid", nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, pid, reply, "worker should be restarted after timeout")
+	pid = reply
+
+	_, err = pool.call(ctx, "crash", nil)
+	assert.Equal(t, codes.Unavailable, status.Code(err))
+	reply, err = pool.call(ctx, "pid", nil)
+	require.NoError(t, err)
+	assert.NotEqual(t, pid, reply, "worker should be restarted after crash")
+
+	_, err = pool.call(ctx, "echo", make([]byte, 2048))
+	assert.Equal(t, codes.Unavailable, status.Code(err), "reply exceeds max message size")
+}
+
+func TestNewProcessPoolError(t *testing.T) {
+	_, err := newProcessPool("not-existing-executable", ProcessPoolConf{Size: 1})
+	assert.Error(t, err)
+	_, err = newProcessPool(os.Args[0], ProcessPoolConf{})
+	assert.EqualError(t, err, "process pool size should be positive, got 0")
+}
+
+func TestServiceHandlerClose(t *testing.T) {
+	t.Setenv(testProcessWorkerEnv, "1")
+	pool, err := newProcessPool(os.Args[0], ProcessPoolConf{Size: 2})
+	require.NoError(t, err)
+	workers := []*processWorker{<-pool.slots, <-pool.slots}
+	pool.slots <- workers[0]
+	pool.slots <- workers[1]
+
+	(&ServiceHandler{StreamHandler: grpcLoopback, processPool: pool}).Close()
+	for _, worker := range workers {
+		assert.True(t, worker.exited(), "worker %v should be killed", worker.pid())
+	}
+	(&ServiceHandler{StreamHandler: grpcLoopback}).Close()
+}
@@ -1,6 +1,7 @@
 package handler
 
 import (
+	"context"
 	"fmt"
 	"time"
 
@@ -74,6 +75,11 @@ const (
 
 	DynamicPriceDerived = "snet-derived-dynamic-price-cost"
 
+	// ActualCostTrailer is the trailer which the service sets to charge the
+	// actual cost of the call in cogs instead of the price signed by the
+	// client. The cost greater than the price signed is not charged.
+	ActualCostTrailer = "snet-actual-cost"
+
 	TrainingModelId = "snet-train-model-id"
 )
 
@@ -164,6 +170,32 @@ type StreamPaymentHandler interface {
 	CompleteAfterError(payment Payment, result error) (err *GrpcError)
 }
 
+// MeteredPayment is implemented by payments which are charged while the
+// stream is in progress. The interceptor reports each message received from
+// the client and terminates the stream when the payment is exhausted. The
+// payment of the terminated stream is completed, because the client has
+// used the service for the amount authorized.
+type MeteredPayment interface {
+	// Start is called before the service handler is called, the context
+	// returned is canceled when the payment is exhausted.
+	Start(ctx context.Context) context.Context
+	// Received is called for each message received from the client, it
+	// returns an error if the message is not paid.
+	Received() (err *GrpcError)
+	// Stop is called when the service handler returns, it returns the error
+	// which terminated the stream or nil if the stream was paid.
+	Stop() (err *GrpcError)
+}
+
+// ActualCostPayment is implemented by payments which are able to charge less
+// than the amount signed. The interceptor reports the cost returned by the
+// service in ActualCostTrailer before the payment is completed.
+type ActualCostPayment interface {
+	// SetActualCost sets the cost of the call, the payment charges the least
+	// of the cost and the price signed
+	SetActualCost(cost *big.Int)
+}
+
 type rateLimitInterceptor struct {
 	rateLimiter                   rate.Limiter
 	messageBroadcaster            *configuration_service.MessageBroadcaster
@@ -251,10 +283,19 @@ func (interceptor *rateLimitInterceptor) intercept(srv any, ss grpc.ServerStream
 // GrpcPaymentValidationInterceptor returns gRPC interceptor to validate payment.
 // If the blockchain is disabled, then noOpInterceptor is returned.
 func GrpcPaymentValidationInterceptor(serviceData *blockchain.ServiceMetadata, defaultPaymentHandler StreamPaymentHandler, paymentHandler ...StreamPaymentHandler) grpc.StreamServerInterceptor {
+	return GrpcPaymentValidationInterceptorWithChargePolicy(nil, serviceData, defaultPaymentHandler, paymentHandler...)
+}
+
+// GrpcPaymentValidationInterceptorWithChargePolicy returns the payment
+// validation interceptor which completes the payments of the failed calls
+// according to chargePolicy
+func GrpcPaymentValidationInterceptorWithChargePolicy(chargePolicy *ChargePolicy, serviceData *blockchain.ServiceMetadata,
+	defaultPaymentHandler StreamPaymentHandler, paymentHandler ...StreamPaymentHandler) grpc.StreamServerInterceptor {
 	interceptor := &paymentValidationInterceptor{
 		defaultPaymentHandler: defaultPaymentHandler,
 		paymentHandlers:       make(map[string]StreamPaymentHandler),
 		serviceMetadata:       serviceData,
+		chargePolicy:          chargePolicy,
 	}
 
 	interceptor.paymentHandlers[defaultPaymentHandler.Type()] = defaultPaymentHandler
@@ -270,6 +311,7 @@ type paymentValidationInterceptor struct {
 	serviceMetadata       *blockchain.ServiceMetadata
 	defaultPaymentHandler StreamPaymentHandler
 	paymentHandlers       map[string]StreamPaymentHandler
+	chargePolicy          *ChargePolicy
 }
 
 func (interceptor *paymentValidationInterceptor) streamIntercept(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) (e error) {
@@ -323,19 +365,30 @@ func (interceptor *paymentValidationInterceptor) streamIntercept(srv any, ss grp
 		}
 	}
 
+	metered, isMetered := payment.(MeteredPayment)
+	if isMetered {
+		if ws, ok := wrapperStream.(*WrapperServerStream); ok {
+			ws.Ctx = metered.Start(ws.Ctx)
+			ws.received = func() error { return errOrNil(metered.Received()) }
+		}
+	}
+	// exhausted is true when the metered stream is terminated because the
+	// payment is exhausted
+	exhausted := false
+
 	defer func() {
 		if r := recover(); r != nil {
 			zap.L().Warn("Service handler called panic(panicValue)", zap.Any("panicValue", r))
-			paymentHandler.CompleteAfterError(payment, fmt.Errorf("service handler called panic(%v)", r))
+			interceptor.chargePolicy.completeAfterError(paymentHandler, payment, fmt.Errorf("service handler called panic(%v)", r))
 			panic("re-panic after payment handler error handling")
-		} else if e == nil {
+		} else if e == nil || exhausted {
 			err = paymentHandler.Complete(payment)
 			if err != nil {
 				// return err.Err()
 				e = err.Err()
 			}
 		} else {
-			err = paymentHandler.CompleteAfterError(payment, e)
+			err = interceptor.chargePolicy.completeAfterError(paymentHandler, payment, e)
 			if err != nil {
 				// return err.Err()
 				e = err.Err()
@@ -346,6 +399,18 @@ func (interceptor *paymentValidationInterceptor) streamIntercept(srv any, ss grp
 	zap.L().Debug("[streamIntercept] New payment received", zap.Any("payment", payment))
 
 	e = handler(srv, wrapperStream)
+	if postPaid, ok := payment.(ActualCostPayment); ok {
+		if ws, ok := wrapperStream.(*WrapperServerStream); ok {
+			setActualCost(postPaid, ws.Trailer())
+		}
+	}
+	if isMetered {
+		if err := metered.Stop(); err != nil {
+			zap.L().Info("[streamIntercept] stream is terminated because payment is exhausted", zap.Error(err))
+			exhausted = true
+			return err.Err()
+		}
+	}
 	if e != nil {
 		zap.L().Warn("[streamIntercept] gRPC handler returned error", zap.Error(e))
 		return e
@@ -354,6 +419,22 @@ func (interceptor *paymentValidationInterceptor) streamIntercept(srv any, ss grp
 	return nil
 }
 
+// setActualCost reports the cost from the service trailer to the payment
+func setActualCost(payment ActualCostPayment, trailer metadata.MD) {
+	values := trailer.Get(ActualCostTrailer)
+	if len(values) == 0 {
+		return
+	}
+	cost, ok := new(big.Int).SetString(values[0], 10)
+	if !ok || cost.Sign() < 0 {
+		zap.L().Warn("[streamIntercept] invalid actual cost is returned by service, price signed is charged",
+			zap.Strings(ActualCostTrailer, values))
+		return
+	}
+	zap.L().Debug("[streamIntercept] actual cost is returned by service", zap.Stringer("cost", cost))
+	payment.SetActualCost(cost)
+}
+
 func getGrpcContext(
 	serverStream grpc.ServerStream,
 	info *grpc.StreamServerInfo,
@@ -399,6 +480,13 @@ func (interceptor *paymentValidationInterceptor) getPaymentHandler(context *Grpc
 	return paymentHandler, nil
 }
 
+func errOrNil(err *GrpcError) error {
+	if err == nil {
+		return nil
+	}
+	return err.Err()
+}
+
 // GetBigInt gets big.Int value from gRPC metadata
 func GetBigInt(md metadata.MD, key string) (value *big.Int, err *GrpcError) {
 	str, err := GetSingleValue(md, key)
@@ -455,6 +543,27 @@ func GetSingleValue(md metadata.MD, key string) (value string, err *GrpcError) {
 	return array[0], nil
 }
 
+// GrpcDaemonServicesInterceptor returns the interceptor which passes the
+// calls of daemonServices, the services served by the daemon itself, to the
+// handler as is, because they are neither paid nor rate limited. The calls of
+// the other services are passed to interceptor.
+func GrpcDaemonServicesInterceptor(daemonServices []string, interceptor grpc.StreamServerInterceptor) grpc.StreamServerInterceptor {
+	services := make(map[string]bool, len(daemonServices))
+	for _, service := range daemonServices {
+		services[service] = true
+	}
+	return func(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+		if info != nil {
+			// FullMethod is "/package.Service/Method"
+			service, _, _ := strings.Cut(strings.TrimPrefix(info.FullMethod, "/"), "/")
+			if services[service] {
+				return handler(srv, ss)
+			}
+		}
+		return interceptor(srv, ss, info, handler)
+	}
+}
+
 // NoOpInterceptor is a gRPC interceptor which doesn't do payment checking.
 func NoOpInterceptor(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo,
 	handler grpc.StreamHandler) error {
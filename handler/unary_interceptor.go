@@ -17,6 +17,8 @@ import (
 type GrpcUnaryContext struct {
 	MD   metadata.MD
 	Info *grpc.UnaryServerInfo
+	// Context is the context of the call, it is nil if unknown
+	Context context.Context
 }
 
 // SenderProvider allows retrieving the sender's Ethereum address,
@@ -43,6 +45,7 @@ type paymentValidationUnaryInterceptor struct {
 	serviceMetadata       *blockchain.ServiceMetadata
 	defaultPaymentHandler UnaryPaymentHandler
 	paymentHandlers       map[string]UnaryPaymentHandler
+	chargePolicy          *ChargePolicy
 }
 
 func (interceptor *paymentValidationUnaryInterceptor) unaryIntercept(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp any, e error) {
@@ -70,7 +73,7 @@ func (interceptor *paymentValidationUnaryInterceptor) unaryIntercept(ctx context
 		return resp, e
 	}
 
-	c := &GrpcUnaryContext{MD: md.Copy(), Info: info}
+	c := &GrpcUnaryContext{MD: md.Copy(), Info: info, Context: ctx}
 
 	zap.L().Debug("[unaryIntercept] grpc metadata", zap.Any("md", c.MD))
 	zap.L().Debug("[unaryIntercept] New gRPC call received", zap.Any("context", c))
@@ -96,7 +99,7 @@ func (interceptor *paymentValidationUnaryInterceptor) unaryIntercept(ctx context
 	defer func() {
 		if r := recover(); r != nil {
 			zap.L().Warn("Service handler called panic(panicValue)", zap.Any("panicValue", r))
-			paymentHandler.CompleteAfterError(payment, fmt.Errorf("service handler called panic(%v)", r))
+			interceptor.chargePolicy.completeAfterError(paymentHandler, payment, fmt.Errorf("service handler called panic(%v)", r))
 			panic("re-panic after payment handler error handling")
 		} else if e == nil {
 			err = paymentHandler.Complete(payment)
@@ -105,7 +108,7 @@ func (interceptor *paymentValidationUnaryInterceptor) unaryIntercept(ctx context
 				e = err.Err()
 			}
 		} else {
-			err = paymentHandler.CompleteAfterError(payment, e)
+			err = interceptor.chargePolicy.completeAfterError(paymentHandler, payment, e)
 			if err != nil {
 				// return err.Err()
 				e = err.Err()
@@ -145,10 +148,19 @@ func (interceptor *paymentValidationUnaryInterceptor) getPaymentHandler(context
 }
 
 func GrpcPaymentValidationUnaryInterceptor(serviceData *blockchain.ServiceMetadata, defaultPaymentHandler UnaryPaymentHandler, paymentHandler ...UnaryPaymentHandler) grpc.UnaryServerInterceptor {
+	return GrpcPaymentValidationUnaryInterceptorWithChargePolicy(nil, serviceData, defaultPaymentHandler, paymentHandler...)
+}
+
+// GrpcPaymentValidationUnaryInterceptorWithChargePolicy returns the unary
+// payment validation interceptor which completes the payments of the failed
+// calls according to chargePolicy
+func GrpcPaymentValidationUnaryInterceptorWithChargePolicy(chargePolicy *ChargePolicy, serviceData *blockchain.ServiceMetadata,
+	defaultPaymentHandler UnaryPaymentHandler, paymentHandler ...UnaryPaymentHandler) grpc.UnaryServerInterceptor {
 	interceptor := &paymentValidationUnaryInterceptor{
 		defaultPaymentHandler: defaultPaymentHandler,
 		paymentHandlers:       make(map[string]UnaryPaymentHandler),
 		serviceMetadata:       serviceData,
+		chargePolicy:          chargePolicy,
 	}
 
 	interceptor.paymentHandlers[defaultPaymentHandler.Type()] = defaultPaymentHandler
@@ -17,6 +17,8 @@ type WrapperServerStream struct {
 	firstMsg         *codec.GrpcFrame  // Initial message read during stream construction
 	firstMsgPending  bool              // Flag indicating first message hasn't been delivered via RecvMsg
 	Ctx              context.Context   // Context with additional metadata for request processing
+	received         func() error      // Called for each message received from the client, see MeteredPayment
+	trailer          metadata.MD       // Trailer set by the service, see ActualCostTrailer
 }
 
 // NewWrapperServerStream creates a wrapped stream that pre-reads the first message
@@ -48,11 +50,21 @@ func (w *WrapperServerStream) RecvMsg(m any) error {
 			return fmt.Errorf("WrapperServerStream: unexpected message type %T, want *codec.GrpcFrame", m)
 		}
 		*dst = *w.firstMsg
-		return nil
+		return w.onReceived()
 	}
 
 	// Subsequent calls delegate to the original stream
-	return w.stream.RecvMsg(m)
+	if err := w.stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return w.onReceived()
+}
+
+func (w *WrapperServerStream) onReceived() error {
+	if w.received == nil {
+		return nil
+	}
+	return w.received()
 }
 
 func (w *WrapperServerStream) SendMsg(m any) error {
@@ -64,9 +76,15 @@ func (w *WrapperServerStream) SetHeader(md metadata.MD) error {
 }
 
 func (w *WrapperServerStream) SetTrailer(md metadata.MD) {
+	w.trailer = metadata.Join(w.trailer, md)
 	w.stream.SetTrailer(md)
 }
 
+// Trailer returns the trailer set by the service
+func (w *WrapperServerStream) Trailer() metadata.MD {
+	return w.trailer
+}
+
 // SendHeader implements dynamic pricing support by intercepting header transmission
 // First call is suppressed (contains backend pricing headers in cogs)
 // Subsequent calls are forwarded to the client
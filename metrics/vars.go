@@ -0,0 +1,37 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// servedVars are names of the variables published by NewVarsMap, only they
+// are served, the variables published by the standard library (i.e. cmdline
+// and memstats) disclose the daemon internals
+var servedVars sync.Map
+
+// NewVarsMap publishes the expvar map which is served by VarsHandler
+func NewVarsMap(name string) *expvar.Map {
+	vars := expvar.NewMap(name)
+	servedVars.Store(name, true)
+	return vars
+}
+
+// VarsHandler writes variables published using NewVarsMap (i.e. payment
+// channel cache hits and misses) as a JSON object
+func VarsHandler(rw http.ResponseWriter) {
+	vars := make(map[string]json.RawMessage)
+	expvar.Do(func(kv expvar.KeyValue) {
+		if _, ok := servedVars.Load(kv.Key); ok {
+			vars[kv.Key] = json.RawMessage(kv.Value.String())
+		}
+	})
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(vars); err != nil {
+		zap.L().Warn("unable to write metrics", zap.Error(err))
+	}
+}
@@ -0,0 +1,27 @@
+package metrics
+
+import (
+	"encoding/json"
+	"expvar"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestVarsHandler(t *testing.T) {
+	NewVarsMap("test_vars_handler").Add("calls", 42)
+	expvar.NewInt("test_vars_hidden").Set(1)
+
+	recorder := httptest.NewRecorder()
+	VarsHandler(recorder)
+
+	assert.Equal(t, "application/json", recorder.Header().Get("Content-Type"))
+	var vars map[string]json.RawMessage
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &vars))
+	assert.JSONEq(t, `{"calls": 42}`, string(vars["test_vars_handler"]))
+	assert.NotContains(t, vars, "test_vars_hidden", "only daemon variables are served")
+	assert.NotContains(t, vars, "memstats")
+	assert.NotContains(t, vars, "cmdline")
+}
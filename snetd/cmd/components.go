@@ -9,6 +9,7 @@ import (
 	"net/http"
 	"os"
 	"strings"
+	"time"
 
 	"github.com/ethereum/go-ethereum/common"
 	"github.com/singnet/snet-daemon/v6/utils"
@@ -32,6 +33,7 @@ import (
 	"github.com/spf13/pflag"
 	"go.uber.org/zap"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/health/grpc_health_v1"
 )
 
 type Components struct {
@@ -40,15 +42,29 @@ type Components struct {
 	blockchain                 blockchain.Processor
 	etcdClient                 *etcddb.EtcdClient
 	etcdServer                 *etcddb.EtcdServer
+	boltStorage                *storage.BoltStorage
+	sqlStorage                 *storage.SQLStorage
 	atomicStorage              storage.AtomicStorage
 	paymentChannelService      escrow.PaymentChannelService
+	paymentChannelStorage      *escrow.PaymentChannelStorage
+	stopChannelCache           context.CancelFunc
 	escrowPaymentHandler       handler.StreamPaymentHandler
+	streamPayments             *escrow.StreamPayments
+	streamPaymentService       *escrow.StreamPaymentService
+	incomeLedger               *escrow.IncomeLedger
+	incomeService              *escrow.IncomeService
+	chargePolicy               *handler.ChargePolicy
+	serviceHTTPClient          *handler.ServiceHTTPClient
 	grpcStreamInterceptor      grpc.StreamServerInterceptor
 	grpcUnaryInterceptor       grpc.UnaryServerInterceptor
 	paymentChannelStateService *escrow.PaymentChannelStateService
 	etcdLockerStorage          *storage.PrefixedAtomicStorage
 	mpeSpecificStorage         *storage.PrefixedAtomicStorage
 	providerControlService     *escrow.ProviderControlService
+	claimScheduler             *escrow.ClaimScheduler
+	stopClaimScheduler         context.CancelFunc
+	expiryWatchdog             *escrow.ExpiryWatchdog
+	stopExpiryWatchdog         context.CancelFunc
 	freeCallStateService       *escrow.FreeCallStateService
 	daemonHeartbeat            *metrics.DaemonHeartbeat
 	paymentStorage             *escrow.PaymentStorage
@@ -57,15 +73,22 @@ type Components struct {
 	configurationBroadcaster   *configuration_service.MessageBroadcaster
 	organizationMetaData       *blockchain.OrganizationMetaData
 	prepaidPaymentHandler      handler.StreamPaymentHandler
+	prepaidPaymentValidator    *escrow.PrePaidPaymentValidator
 	prepaidUserStorage         storage.TypedAtomicStorage
+	prepaidTokenStorage        *escrow.PrePaidTokenStorage
 	prepaidUserService         escrow.PrePaidService
 	freeCallPaymentHandler     handler.StreamPaymentHandler
 	trainUnaryPaymentHandler   handler.UnaryPaymentHandler
 	trainStreamPaymentHandler  handler.StreamPaymentHandler
 	freeCallUserService        escrow.FreeCallUserService
+	freeCallQuotas             *escrow.FreeCallQuotas
 	freeCallUserStorage        *escrow.FreeCallUserStorage
+	freeCallRevocationStorage  *escrow.FreeCallRevocationStorage
+	freeCallIssuanceStorage    *escrow.FreeCallIssuanceStorage
 	freeCallLockerStorage      *storage.PrefixedAtomicStorage
 	tokenManager               token.Manager
+	tokenKeyRing               *token.KeyRing
+	stopTokenKeyRotation       context.CancelFunc
 	tokenService               *escrow.TokenService
 	trainingService            training.DaemonServer
 	modelUserStorage           *training.ModelUserStorage
@@ -111,12 +134,30 @@ func isFileExist(fileName string) bool {
 }
 
 func (components *Components) Close() {
+	if components.stopClaimScheduler != nil {
+		components.stopClaimScheduler()
+	}
+	if components.stopExpiryWatchdog != nil {
+		components.stopExpiryWatchdog()
+	}
+	if components.stopTokenKeyRotation != nil {
+		components.stopTokenKeyRotation()
+	}
+	if components.stopChannelCache != nil {
+		components.stopChannelCache()
+	}
 	if components.etcdClient != nil {
 		components.etcdClient.Close()
 	}
 	if components.etcdServer != nil {
 		components.etcdServer.Close()
 	}
+	if components.boltStorage != nil {
+		components.boltStorage.Close()
+	}
+	if components.sqlStorage != nil {
+		components.sqlStorage.Close()
+	}
 	if components.blockchain != nil {
 		components.blockchain.Close()
 	}
@@ -193,6 +234,44 @@ func (components *Components) EtcdClient() *etcddb.EtcdClient {
 	return components.etcdClient
 }
 
+func (components *Components) BoltStorage() *storage.BoltStorage {
+	if components.boltStorage != nil {
+		return components.boltStorage
+	}
+
+	conf, err := storage.GetBoltStorageConf(config.Vip(), config.PaymentChannelStorageBoltKey)
+	if err != nil {
+		zap.L().Panic("error during bolt storage config parsing", zap.Error(err))
+	}
+
+	boltStorage, err := storage.NewBoltStorage(conf)
+	if err != nil {
+		zap.L().Panic("unable to open bolt storage", zap.Error(err))
+	}
+
+	components.boltStorage = boltStorage
+	return components.boltStorage
+}
+
+func (components *Components) SQLStorage() *storage.SQLStorage {
+	if components.sqlStorage != nil {
+		return components.sqlStorage
+	}
+
+	conf, err := storage.GetSQLStorageConf(config.Vip(), config.PaymentChannelStorageSQLKey)
+	if err != nil {
+		zap.L().Panic("error during sql storage config parsing", zap.Error(err))
+	}
+
+	sqlStorage, err := storage.NewSQLStorage(conf)
+	if err != nil {
+		zap.L().Panic("unable to open sql storage", zap.Error(err))
+	}
+
+	components.sqlStorage = sqlStorage
+	return components.sqlStorage
+}
+
 func (components *Components) FreeCallLockerStorage() *storage.PrefixedAtomicStorage {
 	if components.freeCallLockerStorage != nil {
 		return components.freeCallLockerStorage
@@ -220,17 +299,27 @@ func (components *Components) AtomicStorage() storage.AtomicStorage {
 		return components.atomicStorage
 	}
 
-	if config.GetString(config.PaymentChannelStorageTypeKey) == "etcd" {
+	switch config.GetString(config.PaymentChannelStorageTypeKey) {
+	case "etcd":
 		store = components.EtcdClient()
-	} else {
+	case "bolt":
+		store = components.BoltStorage()
+	case "sql":
+		store = components.SQLStorage()
+	default:
 		store = storage.NewMemStorage()
 	}
 	//by default set the network selected in the storage path
-	components.atomicStorage = storage.NewPrefixedAtomicStorage(store, config.GetString(config.BlockChainNetworkSelected)+"/"+config.GetString(config.OrganizationId)+"/"+components.OrganizationMetaData().GetGroupIdString())
+	components.atomicStorage = storage.NewPrefixedAtomicStorage(store, components.AtomicStoragePrefix())
 
 	return components.atomicStorage
 }
 
+// AtomicStoragePrefix returns the <network_name>/<org_id>/<group_id> prefix of the AtomicStorage keys
+func (components *Components) AtomicStoragePrefix() string {
+	return config.GetString(config.BlockChainNetworkSelected) + "/" + config.GetString(config.OrganizationId) + "/" + components.OrganizationMetaData().GetGroupIdString()
+}
+
 /*
 MPESpecificStorage it is also instance of PrefixedStorage using /<mpe_contract_address> as a prefix; as it is also based on storage from previous item the effective prefix is /<network_id>/<mpe_contract_address>; this guarantees that storages which are specific for MPE contract version don't intersect;
 use MPESpecificStorage as base for PaymentChannelStorage, PaymentStorage, LockStorage for channels;
@@ -263,6 +352,37 @@ func (components *Components) FreeCallUserStorage() *escrow.FreeCallUserStorage
 	return components.freeCallUserStorage
 }
 
+// FreeCallRevocationStorage keeps the free call tokens and addresses revoked
+func (components *Components) FreeCallRevocationStorage() *escrow.FreeCallRevocationStorage {
+	if components.freeCallRevocationStorage != nil {
+		return components.freeCallRevocationStorage
+	}
+
+	components.freeCallRevocationStorage = escrow.NewFreeCallRevocationStorage(components.AtomicStorage())
+
+	return components.freeCallRevocationStorage
+}
+
+// FreeCallIssuanceStorage keeps the free call tokens issued to the trusted
+// signers, the number of tokens is limited by free_call_signer_quotas
+func (components *Components) FreeCallIssuanceStorage() *escrow.FreeCallIssuanceStorage {
+	if components.freeCallIssuanceStorage != nil {
+		return components.freeCallIssuanceStorage
+	}
+
+	var conf []escrow.FreeCallSignerQuotaConf
+	if err := config.Vip().UnmarshalKey(config.FreeCallSignerQuotasKey, &conf); err != nil {
+		zap.L().Panic("Invalid free call signer quotas", zap.String("key", config.FreeCallSignerQuotasKey), zap.Error(err))
+	}
+	quotas, err := escrow.NewFreeCallSignerQuotas(conf)
+	if err != nil {
+		zap.L().Panic("Invalid free call signer quotas", zap.String("key", config.FreeCallSignerQuotasKey), zap.Error(err))
+	}
+	components.freeCallIssuanceStorage = escrow.NewFreeCallIssuanceStorage(components.AtomicStorage(), quotas)
+
+	return components.freeCallIssuanceStorage
+}
+
 func (components *Components) PrepaidUserStorage() storage.TypedAtomicStorage {
 	if components.prepaidUserStorage != nil {
 		return components.prepaidUserStorage
@@ -273,24 +393,96 @@ func (components *Components) PrepaidUserStorage() storage.TypedAtomicStorage {
 	return components.prepaidUserStorage
 }
 
+// PrePaidTokenStorage keeps the prepaid tokens issued and revoked
+func (components *Components) PrePaidTokenStorage() *escrow.PrePaidTokenStorage {
+	if components.prepaidTokenStorage != nil {
+		return components.prepaidTokenStorage
+	}
+
+	components.prepaidTokenStorage = escrow.NewPrePaidTokenStorage(components.AtomicStorage())
+
+	return components.prepaidTokenStorage
+}
+
 func (components *Components) PaymentChannelService() escrow.PaymentChannelService {
 	if components.paymentChannelService != nil {
 		return components.paymentChannelService
 	}
 
-	components.paymentChannelService = escrow.NewPaymentChannelService(
-		escrow.NewPaymentChannelStorage(components.MPESpecificStorage()),
+	var channelStorage *escrow.PaymentChannelStorage
+	var blockchainReader *escrow.BlockchainChannelReader
+	if ttl := config.GetDuration(config.PaymentChannelCacheTTLKey); ttl > 0 {
+		var ctx context.Context
+		ctx, components.stopChannelCache = context.WithCancel(context.Background())
+		cache := escrow.NewChannelCache(ttl)
+		channelStorage = escrow.NewCachedPaymentChannelStorage(ctx, components.MPESpecificStorage(), cache)
+		blockchainReader = escrow.NewCachedBlockchainChannelReader(components.Blockchain(), config.Vip(),
+			components.OrganizationMetaData(), cache)
+	} else {
+		channelStorage = escrow.NewPaymentChannelStorage(components.MPESpecificStorage())
+		blockchainReader = escrow.NewBlockchainChannelReader(components.Blockchain(), config.Vip(), components.OrganizationMetaData())
+	}
+	components.paymentChannelStorage = channelStorage
+
+	lockPolicy, err := escrow.ParseChannelLockPolicy(config.GetString(config.PaymentChannelLockPolicyKey))
+	if err != nil {
+		zap.L().Panic("error during payment channel lock config parsing", zap.Error(err))
+	}
+
+	components.paymentChannelService = escrow.NewPaymentChannelServiceWithIncomeLedger(
+		channelStorage,
 		components.PaymentStorage(),
-		escrow.NewBlockchainChannelReader(components.Blockchain(), config.Vip(), components.OrganizationMetaData()),
-		escrow.NewEtcdLocker(components.LockerStorage()),
+		blockchainReader,
+		escrow.NewEtcdLockerWithTTL(components.LockerStorage(), config.GetDuration(config.LockTTLKey)),
 		escrow.NewChannelPaymentValidator(components.Blockchain(), components.OrganizationMetaData()), func() [32]byte {
 			return components.OrganizationMetaData().GetGroupId()
 		},
+		escrow.ChannelLockConf{
+			Policy:      lockPolicy,
+			QueueSize:   config.GetInt(config.PaymentChannelLockQueueKey),
+			WaitTimeout: config.GetDuration(config.PaymentChannelLockWaitKey),
+		},
+		components.IncomeLedger(),
 	)
 
 	return components.paymentChannelService
 }
 
+// IncomeLedger returns nil when recording the income is disabled
+func (components *Components) IncomeLedger() *escrow.IncomeLedger {
+	if !config.GetBool(config.IncomeLedgerEnabledKey) {
+		return nil
+	}
+	if components.incomeLedger != nil {
+		return components.incomeLedger
+	}
+
+	components.incomeLedger = escrow.NewIncomeLedger(components.MPESpecificStorage(), config.GetDuration(config.IncomeLedgerRetentionKey))
+	return components.incomeLedger
+}
+
+// IncomeReader returns the ledger to read the income even if recording is
+// disabled
+func (components *Components) IncomeReader() *escrow.IncomeLedger {
+	if ledger := components.IncomeLedger(); ledger != nil {
+		return ledger
+	}
+	return escrow.NewIncomeLedger(components.MPESpecificStorage(), config.GetDuration(config.IncomeLedgerRetentionKey))
+}
+
+func (components *Components) IncomeService() escrow.IncomeServiceServer {
+	if !config.GetBool(config.BlockchainEnabledKey) {
+		return &escrow.BlockChainDisabledIncomeService{}
+	}
+	if components.incomeService != nil {
+		return components.incomeService
+	}
+
+	control := components.ProviderControlService().(*escrow.ProviderControlService)
+	components.incomeService = escrow.NewIncomeService(components.IncomeReader(), control)
+	return components.incomeService
+}
+
 func (components *Components) FreeCallUserService() escrow.FreeCallUserService {
 	if components.freeCallUserService != nil {
 		return components.freeCallUserService
@@ -298,29 +490,81 @@ func (components *Components) FreeCallUserService() escrow.FreeCallUserService {
 
 	components.freeCallUserService = escrow.NewFreeCallUserService(
 		components.FreeCallUserStorage(),
-		escrow.NewEtcdLocker(components.FreeCallLockerStorage()),
+		escrow.NewEtcdLockerWithTTL(components.FreeCallLockerStorage(), config.GetDuration(config.LockTTLKey)),
 		func() ([32]byte, error) {
 			s := components.OrganizationMetaData().GetGroupId()
 			return s, nil
-		}, components.ServiceMetaData())
+		}, components.ServiceMetaData(), components.FreeCallQuotas())
 
 	return components.freeCallUserService
 }
 
+// FreeCallQuotas returns nil when free_call_quotas are not configured
+func (components *Components) FreeCallQuotas() *escrow.FreeCallQuotas {
+	if components.freeCallQuotas != nil {
+		return components.freeCallQuotas
+	}
+
+	var conf []escrow.FreeCallQuotaConf
+	if err := config.Vip().UnmarshalKey(config.FreeCallQuotasKey, &conf); err != nil {
+		zap.L().Panic("Invalid free call quotas", zap.String("key", config.FreeCallQuotasKey), zap.Error(err))
+	}
+	quotas, err := escrow.NewFreeCallQuotas(conf)
+	if err != nil {
+		zap.L().Panic("Invalid free call quotas", zap.String("key", config.FreeCallQuotasKey), zap.Error(err))
+	}
+	components.freeCallQuotas = quotas
+	return components.freeCallQuotas
+}
+
 func (components *Components) EscrowPaymentHandler() handler.StreamPaymentHandler {
 	if components.escrowPaymentHandler != nil {
 		return components.escrowPaymentHandler
 	}
 
-	components.escrowPaymentHandler = escrow.NewPaymentHandler(
+	components.escrowPaymentHandler = escrow.NewPaymentHandlerWithStreamPayments(
 		components.PaymentChannelService(),
 		components.Blockchain(),
 		escrow.NewIncomeStreamValidator(components.PricingStrategy(), components.ModelStorage()),
+		components.StreamPayments(),
+		components.StreamPaymentConf(),
 	)
 
 	return components.escrowPaymentHandler
 }
 
+func (components *Components) StreamPayments() *escrow.StreamPayments {
+	if components.streamPayments != nil {
+		return components.streamPayments
+	}
+
+	components.streamPayments = escrow.NewStreamPaymentsWithStorage(components.MPESpecificStorage(),
+		escrow.NewChannelPaymentValidator(components.Blockchain(), components.OrganizationMetaData()))
+	return components.streamPayments
+}
+
+func (components *Components) StreamPaymentConf() escrow.StreamPaymentConf {
+	conf := escrow.StreamPaymentConf{
+		MessagesPerCharge: config.GetInt(config.StreamPaymentMessagesKey),
+		ChargeInterval:    config.GetDuration(config.StreamPaymentIntervalKey),
+		Methods:           config.GetStringSlice(config.StreamPaymentMethodsKey),
+	}
+	return conf
+}
+
+func (components *Components) StreamPaymentService() escrow.StreamPaymentServiceServer {
+	if !config.GetBool(config.BlockchainEnabledKey) {
+		return &escrow.BlockChainDisabledStreamPaymentService{}
+	}
+
+	if components.streamPaymentService != nil {
+		return components.streamPaymentService
+	}
+
+	components.streamPaymentService = escrow.NewStreamPaymentService(components.StreamPayments())
+	return components.streamPaymentService
+}
+
 func (components *Components) TrainUnaryPaymentHandler() handler.UnaryPaymentHandler {
 	if components.trainUnaryPaymentHandler != nil {
 		return components.trainUnaryPaymentHandler
@@ -355,7 +599,8 @@ func (components *Components) FreeCallPaymentHandler() handler.StreamPaymentHand
 	}
 
 	components.freeCallPaymentHandler = escrow.FreeCallPaymentHandler(components.FreeCallUserService(),
-		components.Blockchain(), components.OrganizationMetaData(), components.ServiceMetaData())
+		components.Blockchain(), components.OrganizationMetaData(), components.ServiceMetaData(),
+		components.FreeCallRevocationStorage())
 
 	return components.freeCallPaymentHandler
 }
@@ -378,7 +623,7 @@ func (components *Components) PrePaidPaymentHandler() handler.StreamPaymentHandl
 
 	components.prepaidPaymentHandler = escrow.
 		NewPrePaidPaymentHandler(components.PrePaidService(), components.OrganizationMetaData(), components.ServiceMetaData(),
-			components.PricingStrategy(), components.TokenManager())
+			components.PrePaidPaymentValidator())
 
 	return components.prepaidPaymentHandler
 }
@@ -388,13 +633,26 @@ func (components *Components) PrePaidService() escrow.PrePaidService {
 		return components.prepaidUserService
 	}
 	components.prepaidUserService = escrow.NewPrePaidService(components.PrepaidUserStorage(),
-		escrow.NewPrePaidPaymentValidator(components.PricingStrategy(), components.TokenManager()), func() ([32]byte, error) {
+		components.PrePaidPaymentValidator(), func() ([32]byte, error) {
 			s := components.OrganizationMetaData().GetGroupId()
 			return s, nil
 		})
 	return components.prepaidUserService
 }
 
+// PrePaidPaymentValidator validates the prepaid tokens and rejects the
+// revoked ones
+func (components *Components) PrePaidPaymentValidator() *escrow.PrePaidPaymentValidator {
+	if components.prepaidPaymentValidator != nil {
+		return components.prepaidPaymentValidator
+	}
+
+	components.prepaidPaymentValidator = escrow.NewPrePaidPaymentValidator(components.PricingStrategy(),
+		components.TokenManager(), components.PrePaidTokenStorage())
+
+	return components.prepaidPaymentValidator
+}
+
 // GrpcStreamInterceptor - Add a chain of interceptors
 func (components *Components) GrpcStreamInterceptor() grpc.StreamServerInterceptor {
 	if components.grpcStreamInterceptor != nil {
@@ -417,9 +675,24 @@ func (components *Components) GrpcStreamInterceptor() grpc.StreamServerIntercept
 		components.grpcStreamInterceptor = grpcMiddleware.ChainStreamServer(handler.GrpcRateLimitInterceptor(components.ChannelBroadcast()),
 			components.GrpcStreamPaymentValidationInterceptor())
 	}
+	components.grpcStreamInterceptor = handler.GrpcDaemonServicesInterceptor(daemonServices, components.grpcStreamInterceptor)
 	return components.grpcStreamInterceptor
 }
 
+// daemonServices are the services served by the daemon itself which are
+// neither paid nor rate limited, training service is paid for the models
+// uploaded so it is not listed
+var daemonServices = []string{
+	escrow.PaymentChannelStateService_ServiceDesc.ServiceName,
+	escrow.ProviderControlService_ServiceDesc.ServiceName,
+	escrow.FreeCallStateService_ServiceDesc.ServiceName,
+	escrow.TokenService_ServiceDesc.ServiceName,
+	escrow.StreamPaymentService_ServiceDesc.ServiceName,
+	escrow.IncomeService_ServiceDesc.ServiceName,
+	configuration_service.ConfigurationService_ServiceDesc.ServiceName,
+	grpc_health_v1.Health_ServiceDesc.ServiceName,
+}
+
 func (components *Components) GrpcUnaryInterceptor() grpc.UnaryServerInterceptor {
 	if components.grpcUnaryInterceptor != nil {
 		return components.grpcUnaryInterceptor
@@ -499,25 +772,72 @@ type VerifyMeteringResponse struct {
 	Data string `json:"data"`
 }
 
+// ChargePolicy returns the policy which decides whether the failed calls are
+// charged. The rules published in the service metadata are overridden by the
+// daemon configuration.
+func (components *Components) ChargePolicy() *handler.ChargePolicy {
+	if components.chargePolicy != nil {
+		return components.chargePolicy
+	}
+
+	// the configuration keys are lower case, both INVALID_ARGUMENT and
+	// InvalidArgument names are accepted
+	rules := make(map[string]any)
+	normalize := func(code string) string { return strings.ToLower(strings.ReplaceAll(code, "_", "")) }
+	for code, rule := range components.ServiceMetaData().ChargeOnError {
+		rules[normalize(code)] = rule
+	}
+	for code, rule := range config.GetStringMap(config.ChargeOnErrorKey) {
+		rules[normalize(code)] = rule
+	}
+	policy, err := handler.NewChargePolicy(rules)
+	if err != nil {
+		zap.L().Panic("Invalid "+config.ChargeOnErrorKey+" configuration", zap.Error(err))
+	}
+	components.chargePolicy = policy
+	return components.chargePolicy
+}
+
+// ServiceHTTPClient returns the client shared by the handlers which pass the
+// calls to HTTP and JSON-RPC services
+func (components *Components) ServiceHTTPClient() *handler.ServiceHTTPClient {
+	if components.serviceHTTPClient != nil {
+		return components.serviceHTTPClient
+	}
+
+	conf, err := handler.GetServiceHTTPClientConf(config.Vip())
+	if err != nil {
+		zap.L().Panic("Invalid "+config.ServiceHTTPClientKey+" configuration", zap.Error(err))
+	}
+	components.serviceHTTPClient, err = handler.NewServiceHTTPClient(conf)
+	if err != nil {
+		zap.L().Panic("Invalid "+config.ServiceHTTPClientKey+" configuration", zap.Error(err))
+	}
+	return components.serviceHTTPClient
+}
+
 func (components *Components) GrpcStreamPaymentValidationInterceptor() grpc.StreamServerInterceptor {
 	if !components.Blockchain().Enabled() {
 		if config.GetBool(config.AllowedUserFlag) {
 			zap.L().Info("Blockchain is disabled And AllowedUserFlag is enabled")
-			return handler.GrpcPaymentValidationInterceptor(components.ServiceMetaData(), components.AllowedUserPaymentHandler())
+			return handler.GrpcPaymentValidationInterceptorWithChargePolicy(components.ChargePolicy(), components.ServiceMetaData(),
+				components.AllowedUserPaymentHandler())
 		}
 		zap.L().Info("Blockchain is disabled: no payment validation")
 		return handler.NoOpInterceptor
 	} else {
 		zap.L().Info("Blockchain is enabled: instantiate payment validation interceptor")
-		return handler.GrpcPaymentValidationInterceptor(components.ServiceMetaData(), components.EscrowPaymentHandler(),
-			components.FreeCallPaymentHandler(), components.PrePaidPaymentHandler(), components.TrainStreamPaymentHandler())
+		return handler.GrpcPaymentValidationInterceptorWithChargePolicy(components.ChargePolicy(), components.ServiceMetaData(),
+			components.EscrowPaymentHandler(), components.FreeCallPaymentHandler(), components.PrePaidPaymentHandler(),
+			components.TrainStreamPaymentHandler())
 	}
 }
 
 func (components *Components) GrpcUnaryPaymentValidationInterceptor() grpc.UnaryServerInterceptor {
 	if components.Blockchain().Enabled() {
 		zap.L().Info("Blockchain is enabled: instantiate payment validation interceptor")
-		return handler.GrpcPaymentValidationUnaryInterceptor(components.ServiceMetaData(), components.TrainUnaryPaymentHandler())
+		return handler.GrpcPaymentValidationUnaryInterceptorWithChargePolicy(components.ChargePolicy(), components.ServiceMetaData(),
+			components.TrainUnaryPaymentHandler())
 	}
 	zap.L().Info("Blockchain is disabled: no payment validation")
 	return handler.NoOpUnaryInterceptor
@@ -532,10 +852,17 @@ func (components *Components) PaymentChannelStateService() (service escrow.Payme
 		return components.paymentChannelStateService
 	}
 
-	components.paymentChannelStateService = escrow.NewPaymentChannelStateService(
-		components.PaymentChannelService(),
+	watchInterval := config.GetDuration(config.ChannelStateWatchIntervalKey)
+	if watchInterval <= 0 {
+		watchInterval = escrow.DefaultChannelStateWatchInterval
+	}
+	channelService := components.PaymentChannelService()
+	components.paymentChannelStateService = escrow.NewPaymentChannelStateServiceWithWatch(
+		channelService,
 		components.PaymentStorage(),
-		components.Blockchain())
+		components.paymentChannelStorage,
+		components.Blockchain(),
+		watchInterval)
 
 	return components.paymentChannelStateService
 }
@@ -554,6 +881,83 @@ func (components *Components) ProviderControlService() (service escrow.ProviderC
 	return components.providerControlService
 }
 
+// ClaimScheduler returns nil when claiming by the daemon is disabled
+func (components *Components) ClaimScheduler() *escrow.ClaimScheduler {
+	if !config.GetBool(config.BlockchainEnabledKey) || !config.GetBool(config.ClaimEnabledKey) {
+		return nil
+	}
+	if components.claimScheduler != nil {
+		return components.claimScheduler
+	}
+
+	threshold, err := config.GetBigIntFromViper(config.Vip(), config.ClaimAmountThresholdKey)
+	if err != nil && config.GetString(config.ClaimAmountThresholdKey) != "" {
+		zap.L().Panic("error during claim amount threshold parsing", zap.Error(err))
+	}
+	privateKey := utils.ParsePrivateKey(config.GetString(config.PvtKeyForClaims))
+	if privateKey == nil {
+		zap.L().Panic("invalid " + config.PvtKeyForClaims)
+	}
+
+	control := components.ProviderControlService().(*escrow.ProviderControlService)
+	components.claimScheduler, err = escrow.NewClaimScheduler(control, components.Blockchain(), components.MPESpecificStorage(), privateKey,
+		escrow.ClaimSchedulerConf{
+			Interval:         config.GetDuration(config.ClaimIntervalKey),
+			AmountThreshold:  threshold,
+			ExpirationBlocks: uint64(config.GetInt(config.ClaimExpirationBlocksKey)),
+			BatchSize:        config.GetInt(config.ClaimBatchSizeKey),
+			DryRun:           config.GetBool(config.ClaimDryRunKey),
+		})
+	if err != nil {
+		zap.L().Panic("unable to initialize claim scheduler", zap.Error(err))
+	}
+	return components.claimScheduler
+}
+
+// StartClaimScheduler starts claiming payments in background if enabled, it
+// is stopped on Close()
+func (components *Components) StartClaimScheduler() {
+	scheduler := components.ClaimScheduler()
+	if scheduler == nil {
+		return
+	}
+	var ctx context.Context
+	ctx, components.stopClaimScheduler = context.WithCancel(context.Background())
+	go scheduler.Run(ctx)
+}
+
+func (components *Components) ExpiryWatchdog() *escrow.ExpiryWatchdog {
+	if components.expiryWatchdog != nil {
+		return components.expiryWatchdog
+	}
+
+	var horizons []uint64
+	for _, blocks := range config.Vip().GetIntSlice(config.ChannelExpiryAlertBlocksKey) {
+		if blocks <= 0 {
+			zap.L().Panic("invalid "+config.ChannelExpiryAlertBlocksKey+", number of blocks should be positive", zap.Int("blocks", blocks))
+		}
+		horizons = append(horizons, uint64(blocks))
+	}
+	components.expiryWatchdog = escrow.NewExpiryWatchdog(components.PaymentChannelService(), components.MPESpecificStorage(),
+		components.Blockchain().CurrentBlock,
+		escrow.ExpiryWatchdogConf{
+			Interval: config.GetDuration(config.ChannelExpiryIntervalKey),
+			Horizons: horizons,
+		})
+	return components.expiryWatchdog
+}
+
+// StartExpiryWatchdog starts checking channels expiration in background if
+// enabled, it is stopped on Close()
+func (components *Components) StartExpiryWatchdog() {
+	if !config.GetBool(config.BlockchainEnabledKey) || config.GetDuration(config.ChannelExpiryIntervalKey) <= 0 {
+		return
+	}
+	var ctx context.Context
+	ctx, components.stopExpiryWatchdog = context.WithCancel(context.Background())
+	go components.ExpiryWatchdog().Run(ctx)
+}
+
 func (components *Components) FreeCallStateService() (service escrow.FreeCallStateServiceServer) {
 
 	if !config.GetBool(config.BlockchainEnabledKey) {
@@ -598,9 +1002,12 @@ func (components *Components) FreeCallStateService() (service escrow.FreeCallSta
 			components.Blockchain().CurrentBlock,
 			components.ServiceMetaData().FreeCallSignerAddress(),
 			privateKey,
-			config.GetTrustedFreeCallSignersAddresses()),
+			config.GetTrustedFreeCallSignersAddresses(),
+			components.FreeCallRevocationStorage()),
 		tokenInstance,
-		config.GetBigInt(config.MinBalanceForFreeCall))
+		config.GetBigInt(config.MinBalanceForFreeCall),
+		components.FreeCallQuotas(),
+		components.FreeCallIssuanceStorage())
 	return components.freeCallStateService
 }
 
@@ -711,11 +1118,45 @@ func (components *Components) TokenManager() token.Manager {
 		return components.tokenManager
 	}
 
-	components.tokenManager = token.NewJWTTokenService(*components.OrganizationMetaData())
+	components.tokenManager = token.NewJWTTokenService(*components.OrganizationMetaData(), components.TokenKeyRing())
 
 	return components.tokenManager
 }
 
+// TokenKeyRing returns the keys which sign the prepaid tokens, it is nil when
+// the tokens are signed by token_secret_key
+func (components *Components) TokenKeyRing() *token.KeyRing {
+	if components.tokenKeyRing != nil {
+		return components.tokenKeyRing
+	}
+	algorithm := config.GetString(config.TokenSigningAlgorithmKey)
+	if algorithm == "" || algorithm == token.AlgorithmHS256 {
+		return nil
+	}
+
+	keyRing, err := token.NewKeyRing(components.AtomicStorage(), token.KeyRingConf{
+		Algorithm:        algorithm,
+		RotationInterval: config.GetDuration(config.TokenKeyRotationIntervalKey),
+		TokenLifetime:    time.Duration(config.Vip().GetFloat64(config.TokenExpiryInMinutes) * float64(time.Minute)),
+	})
+	if err != nil {
+		zap.L().Panic("Unable to initialize token signing keys", zap.Error(err))
+	}
+	components.tokenKeyRing = keyRing
+	return components.tokenKeyRing
+}
+
+// StartTokenKeyRotation rotates the token signing key in background if the
+// tokens are signed by the key ring, it is stopped on Close()
+func (components *Components) StartTokenKeyRotation() {
+	if components.TokenKeyRing() == nil {
+		return
+	}
+	var ctx context.Context
+	ctx, components.stopTokenKeyRotation = context.WithCancel(context.Background())
+	go components.TokenKeyRing().Run(ctx)
+}
+
 func (components *Components) TokenService() escrow.TokenServiceServer {
 	if components.tokenService != nil {
 		return components.tokenService
@@ -727,7 +1168,7 @@ func (components *Components) TokenService() escrow.TokenServiceServer {
 	components.tokenService = escrow.NewTokenService(components.PaymentChannelService(),
 		components.PrePaidService(), components.TokenManager(),
 		escrow.NewChannelPaymentValidator(components.Blockchain(), components.OrganizationMetaData()),
-		components.ServiceMetaData())
+		components.ServiceMetaData(), components.OrganizationMetaData(), components.PrePaidTokenStorage())
 
 	return components.tokenService
 }
@@ -7,6 +7,7 @@ import (
 	"github.com/ethereum/go-ethereum/common/hexutil"
 	"github.com/ethereum/go-ethereum/crypto"
 	"github.com/singnet/snet-daemon/v6/config"
+	"github.com/singnet/snet-daemon/v6/storage"
 	"github.com/spf13/cobra"
 	"go.uber.org/zap"
 )
@@ -52,12 +53,22 @@ var ListCmd = &cobra.Command{
 	Long:  "List command prints lists of objects from the shared storage; each object type has separate subcommand",
 }
 
+// ReportCmd command to report the income, etc
+var ReportCmd = &cobra.Command{
+	Use:   "report",
+	Short: "Report the income, etc",
+	Long:  "Report command prints aggregated data from the shared storage; each report has separate subcommand",
+}
+
 var FreeCallUserCmd = &cobra.Command{
 	Use:   "freecall",
 	Short: "Manage operations on free call users",
 	Long: "List commands prints a list of all free call users for the given service," +
 		"reset will set the counter to zero on free calls used, " +
-		"unlock will release the lock on the given user ",
+		"unlock will release the lock on the given user, " +
+		"revoke and restore manage the revoked free call tokens and addresses, " +
+		"tokens lists the free call tokens issued to trusted signers, " +
+		"export, import and grant update the free calls of many users at once",
 }
 
 var GenerateEvmKeys = &cobra.Command{
@@ -116,6 +127,35 @@ var (
 	paymentChannelId string
 	freeCallUserId   string
 	freeCallAddress  string
+	freeCallTokenId  string
+	freeCallReason   string
+	freeCallSigner   string
+
+	freeCallExportFormat string
+	freeCallImportFormat string
+	freeCallGrantFormat  string
+	freeCallOutput       string
+	freeCallInput        string
+	freeCallMinCalls     int
+	freeCallMaxCalls     int
+	freeCallGrantCalls   int
+	freeCallDryRun       bool
+
+	storageExportOutput   string
+	storageArchiveFormat  string
+	storageImportInput    string
+	storageConflictPolicy string
+	storageDryRun         bool
+
+	expiringBlocks uint64
+
+	reportFrom    string
+	reportTo      string
+	reportGroupBy string
+	reportFormat  string
+
+	prepaidChannelId string
+	prepaidTokenId   string
 )
 
 func init() {
@@ -127,23 +167,81 @@ func init() {
 	RootCmd.AddCommand(ServeCmd)
 
 	RootCmd.AddCommand(ListCmd)
+	RootCmd.AddCommand(ReportCmd)
 	RootCmd.AddCommand(ChannelCmd)
 	RootCmd.AddCommand(VersionCmd)
 	RootCmd.AddCommand(FreeCallUserCmd)
 	RootCmd.AddCommand(GenerateEvmKeys)
+	RootCmd.AddCommand(StorageCmd)
+	RootCmd.AddCommand(PrePaidCmd)
 
 	FreeCallUserCmd.AddCommand(FreeCallUserUnLockCmd)
 	FreeCallUserCmd.AddCommand(FreeCallUserResetCmd)
 	FreeCallUserCmd.AddCommand(ListFreeCallUserCmd)
+	FreeCallUserCmd.AddCommand(FreeCallRevokeCmd)
+	FreeCallUserCmd.AddCommand(FreeCallRestoreCmd)
+	FreeCallUserCmd.AddCommand(ListFreeCallRevocationsCmd)
+	FreeCallUserCmd.AddCommand(ListFreeCallTokensCmd)
+	FreeCallUserCmd.AddCommand(FreeCallExportCmd)
+	FreeCallUserCmd.AddCommand(FreeCallImportCmd)
+	FreeCallUserCmd.AddCommand(FreeCallGrantCmd)
 
 	ListCmd.AddCommand(ListChannelsCmd)
 	ListCmd.AddCommand(ListClaimsCmd)
+	ListCmd.AddCommand(ListExpiringCmd)
+
+	ReportCmd.AddCommand(ReportIncomeCmd)
+
+	PrePaidCmd.AddCommand(PrePaidListCmd)
+	PrePaidCmd.AddCommand(PrePaidRevokeCmd)
+
+	StorageCmd.AddCommand(StorageExportCmd)
+	StorageCmd.AddCommand(StorageImportCmd)
+	StorageCmd.AddCommand(StorageMigrateCmd)
 
 	ChannelCmd.Flags().StringVarP(&paymentChannelId, UnlockChannelFlag, "u", "", "unlocks the payment channel with the given ID, see \"list channels\"")
 	FreeCallUserResetCmd.Flags().StringVarP(&freeCallUserId, UserIdFlag, "u", "", "resets the free call usage count to zero for the user with the given ID")
 	FreeCallUserResetCmd.Flags().StringVarP(&freeCallAddress, AddressFlag, "a", "", "resets the free call usage count to zero for the user with the given address")
 	FreeCallUserUnLockCmd.Flags().StringVarP(&freeCallUserId, UserIdFlag, "u", "", "unlocks the free call user with the given ID")
 	FreeCallUserUnLockCmd.Flags().StringVarP(&freeCallAddress, AddressFlag, "a", "", "unlocks the free call user with the given address")
+	FreeCallRevokeCmd.Flags().StringVar(&freeCallTokenId, "token-id", "", "revokes the free call token with the given ID, see \"freecall tokens\"")
+	FreeCallRevokeCmd.Flags().StringVarP(&freeCallAddress, AddressFlag, "a", "", "revokes the free calls of the given address")
+	FreeCallRevokeCmd.Flags().StringVarP(&freeCallUserId, UserIdFlag, "u", "", "revokes the free calls of the address made on behalf of the user with the given ID only")
+	FreeCallRevokeCmd.Flags().StringVar(&freeCallReason, "reason", "", "reason kept with the revocation")
+	FreeCallRestoreCmd.Flags().StringVar(&freeCallTokenId, "token-id", "", "restores the free call token with the given ID")
+	FreeCallRestoreCmd.Flags().StringVarP(&freeCallAddress, AddressFlag, "a", "", "restores the free calls of the given address")
+	FreeCallRestoreCmd.Flags().StringVarP(&freeCallUserId, UserIdFlag, "u", "", "restores the free calls of the address made on behalf of the user with the given ID")
+	ListFreeCallTokensCmd.Flags().StringVar(&freeCallSigner, "signer", "", "lists the tokens issued to the trusted signer with the given address only")
+	for _, command := range []*cobra.Command{ListFreeCallUserCmd, FreeCallExportCmd} {
+		command.Flags().IntVar(&freeCallMinCalls, "min-calls", -1, "selects the users who have made at least the given number of free calls")
+		command.Flags().IntVar(&freeCallMaxCalls, "max-calls", -1, "selects the users who have made at most the given number of free calls")
+	}
+	FreeCallExportCmd.Flags().StringVarP(&freeCallOutput, "output", "o", "-", "file to write, \"-\" means stdout")
+	FreeCallExportCmd.Flags().StringVar(&freeCallExportFormat, "format", "json", "output format: one of 'json','csv'")
+	FreeCallImportCmd.Flags().StringVarP(&freeCallInput, "input", "i", "", "file to read, \"-\" means stdin")
+	FreeCallImportCmd.Flags().StringVar(&freeCallImportFormat, "format", "json", "input format: one of 'json','csv'")
+	FreeCallImportCmd.Flags().BoolVar(&freeCallDryRun, "dry-run", false, "print changes without writing them")
+	FreeCallGrantCmd.Flags().IntVar(&freeCallGrantCalls, "calls", 0, "number of free calls granted to each user")
+	FreeCallGrantCmd.Flags().StringVarP(&freeCallInput, "input", "i", "", "file with the users to grant the free calls, \"-\" means stdin")
+	FreeCallGrantCmd.Flags().StringVar(&freeCallGrantFormat, "format", "csv", "input format: one of 'json','csv'")
+	FreeCallGrantCmd.Flags().StringVarP(&freeCallAddress, AddressFlag, "a", "", "grants the free calls to the user with the given address")
+	FreeCallGrantCmd.Flags().StringVarP(&freeCallUserId, UserIdFlag, "u", "", "grants the free calls to the user with the given ID of the address")
+	FreeCallGrantCmd.Flags().BoolVar(&freeCallDryRun, "dry-run", false, "print changes without writing them")
+	StorageExportCmd.Flags().StringVarP(&storageExportOutput, "output", "o", "-", "archive file to write, \"-\" means stdout")
+	StorageExportCmd.Flags().StringVar(&storageArchiveFormat, "format", "jsonl", "archive format: one of 'json','jsonl'")
+	StorageImportCmd.Flags().StringVarP(&storageImportInput, "input", "i", "", "archive file to read, \"-\" means stdin")
+	StorageImportCmd.Flags().StringVar(&storageConflictPolicy, "on-conflict", string(storage.ConflictSkip),
+		"what to do with keys which exist with a different value: one of 'skip','overwrite','fail'")
+	StorageImportCmd.Flags().BoolVar(&storageDryRun, "dry-run", false, "print changes without writing them")
+	StorageMigrateCmd.Flags().BoolVar(&storageDryRun, "dry-run", false, "print keys to migrate without writing them")
+	ReportIncomeCmd.Flags().StringVar(&reportFrom, "from", "", "report the income received since the date (YYYY-MM-DD) or RFC3339 time")
+	ReportIncomeCmd.Flags().StringVar(&reportTo, "to", "", "report the income received before the date (YYYY-MM-DD) or RFC3339 time")
+	ReportIncomeCmd.Flags().StringVar(&reportGroupBy, "group-by", "", "group the income by: one of 'day','month','sender','method','channel'")
+	ReportIncomeCmd.Flags().StringVar(&reportFormat, "format", "json", "output format: one of 'json','csv'")
+	PrePaidListCmd.Flags().StringVar(&prepaidChannelId, "channel-id", "", "list the usage and tokens of the channel with the given ID")
+	PrePaidRevokeCmd.Flags().StringVar(&prepaidChannelId, "channel-id", "", "revoke the tokens of the channel with the given ID")
+	PrePaidRevokeCmd.Flags().StringVar(&prepaidTokenId, "token-id", "", "revoke the token with the given ID, all tokens of the channel are revoked by default")
+	ListExpiringCmd.Flags().Uint64Var(&expiringBlocks, "blocks", 0, "list channels which expire within the given number of blocks, the greatest alert horizon is used by default")
 
 	vip.BindPFlag(config.AutoSSLDomainKey, serveCmdFlags.Lookup("auto-ssl-domain"))
 	vip.BindPFlag(config.AutoSSLCacheDirKey, serveCmdFlags.Lookup("auto-ssl-cache"))
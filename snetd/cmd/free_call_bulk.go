@@ -0,0 +1,438 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/singnet/snet-daemon/v6/config"
+	"github.com/singnet/snet-daemon/v6/escrow"
+)
+
+// FreeCallExportCmd writes the free call users to JSON or CSV
+var FreeCallExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export free call users to JSON or CSV",
+	Long: "Export the free calls made and granted of each free call user, the users can be filtered by the number of" +
+		" free calls made, i.e. 'snetd freecall export --format csv --min-calls 10 -o users.csv'",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RunAndCleanup(cmd, args, newFreeCallExportCommand)
+	},
+}
+
+// FreeCallImportCmd sets the free calls made and granted of the users from
+// JSON or CSV
+var FreeCallImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import free call users from JSON or CSV",
+	Long: "Set the free calls made and granted of each user listed in the file written by 'snetd freecall export'," +
+		" organization, service and group of this daemon are used if they are not set." +
+		" The users are updated with compare-and-swap, so the command can be run while the daemon is serving.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RunAndCleanup(cmd, args, newFreeCallImportCommand)
+	},
+}
+
+// FreeCallGrantCmd grants extra free calls to the users
+var FreeCallGrantCmd = &cobra.Command{
+	Use:   "grant",
+	Short: "Grant extra free calls to the users",
+	Long: "Grant --calls extra free calls to the user given by -a and -u or to each user listed in the JSON or CSV" +
+		" file given by -i (CSV file has a header with 'address' and optional 'user_id' columns)," +
+		" i.e. 'snetd freecall grant --calls 10 -i cohort.csv'. The granted calls are used once the free call limits" +
+		" are exhausted. The users are updated with compare-and-swap, so the command can be run while the daemon is serving.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RunAndCleanup(cmd, args, newFreeCallGrantCommand)
+	},
+}
+
+// freeCallUserRecord is a free call user written by export and read by
+// import and grant
+type freeCallUserRecord struct {
+	Address        string `json:"address"`
+	UserID         string `json:"user_id,omitempty"`
+	OrganizationId string `json:"organization_id,omitempty"`
+	ServiceId      string `json:"service_id,omitempty"`
+	GroupID        string `json:"group_id,omitempty"`
+	// FreeCallsMade and FreeCallsGranted are nil if the file doesn't set
+	// them, import keeps the stored values then
+	FreeCallsMade    *int `json:"free_calls_made,omitempty"`
+	FreeCallsGranted *int `json:"free_calls_granted,omitempty"`
+}
+
+var freeCallUserCsvHeader = []string{"address", "user_id", "organization_id", "service_id", "group_id",
+	"free_calls_made", "free_calls_granted"}
+
+// freeCallUserFilter selects the users by the number of free calls made,
+// negative bound is not applied
+type freeCallUserFilter struct {
+	minCalls int
+	maxCalls int
+}
+
+func newFreeCallUserFilter() freeCallUserFilter {
+	return freeCallUserFilter{minCalls: freeCallMinCalls, maxCalls: freeCallMaxCalls}
+}
+
+func (filter freeCallUserFilter) matches(user *escrow.FreeCallUserData) bool {
+	return (filter.minCalls < 0 || user.FreeCallsMade >= filter.minCalls) &&
+		(filter.maxCalls < 0 || user.FreeCallsMade <= filter.maxCalls)
+}
+
+type freeCallExportCommand struct {
+	userStorage *escrow.FreeCallUserStorage
+	filter      freeCallUserFilter
+	format      string
+	output      string
+}
+
+type freeCallImportCommand struct {
+	userStorage *escrow.FreeCallUserStorage
+	defaultKey  *escrow.FreeCallUserKey
+	format      string
+	input       string
+	dryRun      bool
+}
+
+type freeCallGrantCommand struct {
+	userStorage *escrow.FreeCallUserStorage
+	defaultKey  *escrow.FreeCallUserKey
+	format      string
+	input       string
+	address     string
+	userID      string
+	calls       int
+	dryRun      bool
+}
+
+func checkFreeCallFormat(format string) error {
+	if format != "json" && format != "csv" {
+		return fmt.Errorf("unknown format %q, expected one of 'json','csv'", format)
+	}
+	return nil
+}
+
+// freeCallDefaultKey returns the key with organization, service and group of
+// this daemon
+func freeCallDefaultKey(components *Components) *escrow.FreeCallUserKey {
+	return &escrow.FreeCallUserKey{
+		OrganizationId: config.GetString(config.OrganizationId),
+		ServiceId:      config.GetString(config.ServiceId),
+		GroupID:        components.OrganizationMetaData().GetGroupIdString(),
+	}
+}
+
+func newFreeCallExportCommand(cmd *cobra.Command, args []string, components *Components) (command Command, err error) {
+	if err = checkFreeCallFormat(freeCallExportFormat); err != nil {
+		return
+	}
+	command = &freeCallExportCommand{
+		userStorage: components.FreeCallUserStorage(),
+		filter:      newFreeCallUserFilter(),
+		format:      freeCallExportFormat,
+		output:      freeCallOutput,
+	}
+	return
+}
+
+func newFreeCallImportCommand(cmd *cobra.Command, args []string, components *Components) (command Command, err error) {
+	if freeCallInput == "" {
+		return nil, fmt.Errorf("--input must be set")
+	}
+	if err = checkFreeCallFormat(freeCallImportFormat); err != nil {
+		return
+	}
+	command = &freeCallImportCommand{
+		userStorage: components.FreeCallUserStorage(),
+		defaultKey:  freeCallDefaultKey(components),
+		format:      freeCallImportFormat,
+		input:       freeCallInput,
+		dryRun:      freeCallDryRun,
+	}
+	return
+}
+
+func newFreeCallGrantCommand(cmd *cobra.Command, args []string, components *Components) (command Command, err error) {
+	if freeCallGrantCalls <= 0 {
+		return nil, fmt.Errorf("--calls must be > 0")
+	}
+	if (freeCallInput == "") == (freeCallAddress == "") {
+		return nil, fmt.Errorf("either --input or --address must be set")
+	}
+	if err = checkFreeCallFormat(freeCallGrantFormat); err != nil {
+		return
+	}
+	command = &freeCallGrantCommand{
+		userStorage: components.FreeCallUserStorage(),
+		defaultKey:  freeCallDefaultKey(components),
+		format:      freeCallGrantFormat,
+		input:       freeCallInput,
+		address:     freeCallAddress,
+		userID:      freeCallUserId,
+		calls:       freeCallGrantCalls,
+		dryRun:      freeCallDryRun,
+	}
+	return
+}
+
+func (command *freeCallExportCommand) Run() (err error) {
+	users, err := command.userStorage.GetAll()
+	if err != nil {
+		return
+	}
+	records := make([]freeCallUserRecord, 0, len(users))
+	for _, user := range users {
+		if command.filter.matches(user) {
+			records = append(records, freeCallUserRecord{Address: user.Address, UserID: user.UserID,
+				OrganizationId: user.OrganizationId, ServiceId: user.ServiceId, GroupID: user.GroupID,
+				FreeCallsMade: &user.FreeCallsMade, FreeCallsGranted: &user.FreeCallsGranted})
+		}
+	}
+
+	output := io.Writer(os.Stdout)
+	if command.output != "-" {
+		file, err := os.Create(command.output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		output = file
+	}
+	return writeFreeCallUsers(output, command.format, records)
+}
+
+func (command *freeCallImportCommand) Run() (err error) {
+	records, err := readFreeCallUsersFile(command.input, command.format)
+	if err != nil {
+		return
+	}
+	keys, err := resolveFreeCallUserKeys(command.userStorage, command.defaultKey, records)
+	if err != nil {
+		return
+	}
+	for i, record := range records {
+		if record.FreeCallsMade == nil && record.FreeCallsGranted == nil {
+			return fmt.Errorf("user %v: neither free_calls_made nor free_calls_granted is set", keys[i])
+		}
+		if (record.FreeCallsMade != nil && *record.FreeCallsMade < 0) ||
+			(record.FreeCallsGranted != nil && *record.FreeCallsGranted < 0) {
+			return fmt.Errorf("user %v: free calls should be >= 0", keys[i])
+		}
+	}
+	for i, record := range records {
+		if command.dryRun {
+			fmt.Printf("set %v: made %v, granted %v\n", keys[i], freeCallsValue(record.FreeCallsMade),
+				freeCallsValue(record.FreeCallsGranted))
+			continue
+		}
+		user, err := command.userStorage.Update(keys[i], newFreeCallUserData(keys[i]), func(user *escrow.FreeCallUserData) {
+			if record.FreeCallsMade != nil {
+				user.FreeCallsMade = *record.FreeCallsMade
+			}
+			if record.FreeCallsGranted != nil {
+				user.FreeCallsGranted = *record.FreeCallsGranted
+			}
+		})
+		if err != nil {
+			return fmt.Errorf("unable to import user %v: %v", keys[i], err)
+		}
+		fmt.Println(user)
+	}
+	printFreeCallBulkResult("imported", len(records), command.dryRun)
+	return
+}
+
+func (command *freeCallGrantCommand) Run() (err error) {
+	records := []freeCallUserRecord{{Address: command.address, UserID: command.userID}}
+	if command.input != "" {
+		if records, err = readFreeCallUsersFile(command.input, command.format); err != nil {
+			return
+		}
+	}
+	keys, err := resolveFreeCallUserKeys(command.userStorage, command.defaultKey, records)
+	if err != nil {
+		return
+	}
+	for _, key := range keys {
+		if command.dryRun {
+			fmt.Printf("grant %v free calls to %v\n", command.calls, key)
+			continue
+		}
+		user, err := command.userStorage.Update(key, newFreeCallUserData(key), func(user *escrow.FreeCallUserData) {
+			user.FreeCallsGranted += command.calls
+		})
+		if err != nil {
+			return fmt.Errorf("unable to grant free calls to %v: %v", key, err)
+		}
+		fmt.Printf("%v, granted: %v\n", user, user.FreeCallsGranted)
+	}
+	printFreeCallBulkResult("granted free calls", len(keys), command.dryRun)
+	return
+}
+
+// freeCallsValue returns the number of free calls to import, the calls which
+// are not set are unchanged
+func freeCallsValue(calls *int) string {
+	if calls == nil {
+		return "unchanged"
+	}
+	return strconv.Itoa(*calls)
+}
+
+func printFreeCallBulkResult(action string, users int, dryRun bool) {
+	if dryRun {
+		fmt.Printf("Dry run: %v users would be %v\n", users, action)
+		return
+	}
+	fmt.Printf("Success: %v users %v\n", users, action)
+}
+
+func newFreeCallUserData(key *escrow.FreeCallUserKey) *escrow.FreeCallUserData {
+	return &escrow.FreeCallUserData{Address: key.Address, UserID: key.UserId, OrganizationId: key.OrganizationId,
+		ServiceId: key.ServiceId, GroupID: key.GroupID}
+}
+
+// resolveFreeCallUserKeys returns the key of each record, the organization,
+// service and group of defaultKey are used if they are not set. The address
+// is normalized like the daemon does for the free calls, but the address of
+// the stored user is used if it differs in case only, as the users stored
+// before were kept under the address in the form the client sent it.
+func resolveFreeCallUserKeys(userStorage *escrow.FreeCallUserStorage, defaultKey *escrow.FreeCallUserKey,
+	records []freeCallUserRecord) (keys []*escrow.FreeCallUserKey, err error) {
+	users, err := userStorage.GetAll()
+	if err != nil {
+		return
+	}
+	stored := make(map[string]string, len(users))
+	for _, user := range users {
+		key := &escrow.FreeCallUserKey{Address: user.Address, UserId: user.UserID, OrganizationId: user.OrganizationId,
+			ServiceId: user.ServiceId, GroupID: user.GroupID}
+		stored[strings.ToLower(key.String())] = user.Address
+	}
+
+	keys = make([]*escrow.FreeCallUserKey, 0, len(records))
+	for i, record := range records {
+		if record.Address == "" {
+			return nil, fmt.Errorf("user %v: address must be set", i)
+		}
+		key := &escrow.FreeCallUserKey{Address: escrow.NormalizeFreeCallUserAddress(record.Address), UserId: record.UserID,
+			OrganizationId: record.OrganizationId, ServiceId: record.ServiceId, GroupID: record.GroupID}
+		if key.OrganizationId == "" {
+			key.OrganizationId = defaultKey.OrganizationId
+		}
+		if key.ServiceId == "" {
+			key.ServiceId = defaultKey.ServiceId
+		}
+		if key.GroupID == "" {
+			key.GroupID = defaultKey.GroupID
+		}
+		if address, ok := stored[strings.ToLower(key.String())]; ok {
+			key.Address = address
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func writeFreeCallUsers(output io.Writer, format string, records []freeCallUserRecord) error {
+	if format == "json" {
+		encoder := json.NewEncoder(output)
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(records)
+	}
+	writer := csv.NewWriter(output)
+	if err := writer.Write(freeCallUserCsvHeader); err != nil {
+		return err
+	}
+	for _, record := range records {
+		err := writer.Write([]string{record.Address, record.UserID, record.OrganizationId, record.ServiceId,
+			record.GroupID, csvFreeCalls(record.FreeCallsMade), csvFreeCalls(record.FreeCallsGranted)})
+		if err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+func csvFreeCalls(calls *int) string {
+	if calls == nil {
+		return ""
+	}
+	return strconv.Itoa(*calls)
+}
+
+func readFreeCallUsersFile(path, format string) (records []freeCallUserRecord, err error) {
+	input := io.Reader(os.Stdin)
+	if path != "-" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		input = file
+	}
+	return readFreeCallUsers(input, format)
+}
+
+// readFreeCallUsers reads the records written by writeFreeCallUsers, the CSV
+// columns are matched by the header, so any of them except address can be
+// omitted. The free calls of the omitted column or empty cell are nil.
+func readFreeCallUsers(input io.Reader, format string) (records []freeCallUserRecord, err error) {
+	if format == "json" {
+		err = json.NewDecoder(input).Decode(&records)
+		return
+	}
+	reader := csv.NewReader(input)
+	reader.TrimLeadingSpace = true
+	rows, err := reader.ReadAll()
+	if err != nil {
+		return nil, err
+	}
+	if len(rows) == 0 {
+		return nil, fmt.Errorf("csv header is missing")
+	}
+	columns := make(map[string]int, len(rows[0]))
+	for i, name := range rows[0] {
+		columns[strings.ToLower(strings.TrimSpace(name))] = i
+	}
+	if _, ok := columns["address"]; !ok {
+		return nil, fmt.Errorf("csv header should have 'address' column")
+	}
+	value := func(row []string, name string) string {
+		if i, ok := columns[name]; ok && i < len(row) {
+			return strings.TrimSpace(row[i])
+		}
+		return ""
+	}
+	number := func(row []string, line int, name string) (*int, error) {
+		if value(row, name) == "" {
+			return nil, nil
+		}
+		n, err := strconv.Atoi(value(row, name))
+		if err != nil {
+			return nil, fmt.Errorf("line %v: invalid %v %q", line, name, value(row, name))
+		}
+		return &n, nil
+	}
+
+	records = make([]freeCallUserRecord, 0, len(rows)-1)
+	for i, row := range rows[1:] {
+		record := freeCallUserRecord{Address: value(row, "address"), UserID: value(row, "user_id"),
+			OrganizationId: value(row, "organization_id"), ServiceId: value(row, "service_id"), GroupID: value(row, "group_id")}
+		if record.FreeCallsMade, err = number(row, i+2, "free_calls_made"); err != nil {
+			return nil, err
+		}
+		if record.FreeCallsGranted, err = number(row, i+2, "free_calls_granted"); err != nil {
+			return nil, err
+		}
+		records = append(records, record)
+	}
+	return records, nil
+}
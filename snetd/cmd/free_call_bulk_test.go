@@ -0,0 +1,99 @@
+package cmd
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/singnet/snet-daemon/v6/escrow"
+	"github.com/singnet/snet-daemon/v6/storage"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func freeCalls(calls int) *int {
+	return &calls
+}
+
+func TestFreeCallUsersCsv(t *testing.T) {
+	records := []freeCallUserRecord{
+		{Address: "0x3b2b3C2e2E7C93db335E69D827F3CC4bC2A2A2cB", OrganizationId: "org", ServiceId: "service",
+			GroupID: "group", FreeCallsMade: freeCalls(7), FreeCallsGranted: freeCalls(2)},
+		{Address: "0x3b2b3C2e2E7C93db335E69D827F3CC4bC2A2A2cB", UserID: "user@example.com", FreeCallsMade: freeCalls(1),
+			FreeCallsGranted: freeCalls(0)},
+	}
+	for _, format := range []string{"json", "csv"} {
+		var buffer bytes.Buffer
+		require.NoError(t, writeFreeCallUsers(&buffer, format, records))
+		read, err := readFreeCallUsers(&buffer, format)
+		require.NoError(t, err)
+		assert.Equal(t, records, read, format)
+	}
+
+	read, err := readFreeCallUsers(strings.NewReader("Address, user_id\n0x1,user\n0x2,\n"), "csv")
+	require.NoError(t, err)
+	assert.Equal(t, []freeCallUserRecord{{Address: "0x1", UserID: "user"}, {Address: "0x2"}}, read)
+
+	read, err = readFreeCallUsers(strings.NewReader("address,free_calls_granted\n0x1,3\n"), "csv")
+	require.NoError(t, err)
+	assert.Equal(t, []freeCallUserRecord{{Address: "0x1", FreeCallsGranted: freeCalls(3)}}, read,
+		"free calls made are not set")
+
+	_, err = readFreeCallUsers(strings.NewReader("user_id\nuser\n"), "csv")
+	assert.EqualError(t, err, "csv header should have 'address' column")
+	_, err = readFreeCallUsers(strings.NewReader("address,free_calls_made\n0x1,many\n"), "csv")
+	assert.EqualError(t, err, "line 2: invalid free_calls_made \"many\"")
+}
+
+func TestResolveFreeCallUserKeys(t *testing.T) {
+	userStorage := escrow.NewFreeCallUserStorage(storage.NewMemStorage())
+	stored := &escrow.FreeCallUserKey{Address: "0x3b2b3c2e2e7c93db335e69d827f3cc4bc2a2a2cb", OrganizationId: "org",
+		ServiceId: "service", GroupID: "group"}
+	require.NoError(t, userStorage.Put(stored, newFreeCallUserData(stored)))
+	defaultKey := &escrow.FreeCallUserKey{OrganizationId: "org", ServiceId: "service", GroupID: "group"}
+
+	keys, err := resolveFreeCallUserKeys(userStorage, defaultKey, []freeCallUserRecord{
+		{Address: "0x3b2b3C2e2E7C93db335E69D827F3CC4bC2A2A2cB"},
+		{Address: "0x3b2b3c2e2e7c93db335e69d827f3cc4bc2a2a2cb", UserID: "user", GroupID: "another"},
+	})
+	require.NoError(t, err)
+	assert.Equal(t, []*escrow.FreeCallUserKey{stored, {Address: "0x3b2b3C2e2E7C93db335E69D827F3CC4bC2A2A2cB",
+		UserId: "user", OrganizationId: "org", ServiceId: "service", GroupID: "another"}}, keys,
+		"stored user is matched, new user is keyed by the checksum address")
+
+	_, err = resolveFreeCallUserKeys(userStorage, defaultKey, []freeCallUserRecord{{UserID: "user"}})
+	assert.EqualError(t, err, "user 0: address must be set")
+}
+
+func TestFreeCallUserFilter(t *testing.T) {
+	user := &escrow.FreeCallUserData{FreeCallsMade: 5}
+	assert.True(t, freeCallUserFilter{minCalls: -1, maxCalls: -1}.matches(user))
+	assert.True(t, freeCallUserFilter{minCalls: 5, maxCalls: 5}.matches(user))
+	assert.False(t, freeCallUserFilter{minCalls: 6, maxCalls: -1}.matches(user))
+	assert.False(t, freeCallUserFilter{minCalls: -1, maxCalls: 4}.matches(user))
+}
+
+func TestFreeCallImportKeepsOmittedFreeCalls(t *testing.T) {
+	userStorage := escrow.NewFreeCallUserStorage(storage.NewMemStorage())
+	key := &escrow.FreeCallUserKey{Address: "0x3b2b3C2e2E7C93db335E69D827F3CC4bC2A2A2cB", OrganizationId: "org",
+		ServiceId: "service", GroupID: "group"}
+	user := newFreeCallUserData(key)
+	user.FreeCallsMade, user.FreeCallsGranted = 7, 2
+	require.NoError(t, userStorage.Put(key, user))
+	defaultKey := &escrow.FreeCallUserKey{OrganizationId: "org", ServiceId: "service", GroupID: "group"}
+
+	input := filepath.Join(t.TempDir(), "users.csv")
+	require.NoError(t, os.WriteFile(input, []byte("address,free_calls_granted\n"+key.Address+",5\n"), 0600))
+	command := &freeCallImportCommand{userStorage: userStorage, defaultKey: defaultKey, format: "csv", input: input}
+	require.NoError(t, command.Run())
+	imported, ok, err := userStorage.Get(key)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, 7, imported.FreeCallsMade, "free calls made are not in the file")
+	assert.Equal(t, 5, imported.FreeCallsGranted)
+
+	require.NoError(t, os.WriteFile(input, []byte("address\n"+key.Address+"\n"), 0600))
+	assert.EqualError(t, command.Run(), "user "+key.String()+": neither free_calls_made nor free_calls_granted is set")
+}
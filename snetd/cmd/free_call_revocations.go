@@ -0,0 +1,192 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/spf13/cobra"
+
+	"github.com/singnet/snet-daemon/v6/escrow"
+)
+
+// FreeCallRevokeCmd revokes the free call token or the free calls of the
+// address
+var FreeCallRevokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "Revoke free call token or free calls of the address",
+	Long: "Revoke the token given by --token-id or the free calls of the address given by -a, the calls made on behalf" +
+		" of the user given by -u only if it is set, i.e. 'snetd freecall revoke --token-id {id} --reason abuse'." +
+		" Token ids are printed by 'snetd freecall tokens'.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RunAndCleanup(cmd, args, newFreeCallRevokeCommand)
+	},
+}
+
+// FreeCallRestoreCmd removes the revocation made by FreeCallRevokeCmd
+var FreeCallRestoreCmd = &cobra.Command{
+	Use:   "restore",
+	Short: "Restore revoked free calls",
+	Long: "Remove the revocation of the token given by --token-id or of the free calls of the address given by -a" +
+		" and -u, see 'snetd freecall revocations'",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RunAndCleanup(cmd, args, newFreeCallRestoreCommand)
+	},
+}
+
+// ListFreeCallRevocationsCmd prints the revoked free call tokens and
+// addresses
+var ListFreeCallRevocationsCmd = &cobra.Command{
+	Use:   "revocations",
+	Short: "List revoked free call tokens and addresses",
+	Long:  "List the free call tokens and addresses revoked by 'snetd freecall revoke' or RevokeFreeCalls requests",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RunAndCleanup(cmd, args, newListFreeCallRevocationsCommand)
+	},
+}
+
+// ListFreeCallTokensCmd prints the audit records of the free call tokens
+// issued to the trusted signers
+var ListFreeCallTokensCmd = &cobra.Command{
+	Use:   "tokens",
+	Short: "List free call tokens issued to trusted signers",
+	Long: "List the number of free call tokens issued to each trusted signer, the state of its quotas and the tokens" +
+		" which are not expired yet, the tokens of the signer given by --signer only if it is set",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RunAndCleanup(cmd, args, newListFreeCallTokensCommand)
+	},
+}
+
+type freeCallRevokeCommand struct {
+	revocations *escrow.FreeCallRevocationStorage
+	issuance    *escrow.FreeCallIssuanceStorage
+	revocation  *escrow.FreeCallRevocation
+}
+
+type freeCallRestoreCommand struct {
+	revocations *escrow.FreeCallRevocationStorage
+	revocation  *escrow.FreeCallRevocation
+}
+
+type listFreeCallRevocationsCommand struct {
+	revocations *escrow.FreeCallRevocationStorage
+}
+
+type listFreeCallTokensCommand struct {
+	issuance *escrow.FreeCallIssuanceStorage
+	signer   string
+}
+
+func newFreeCallRevokeCommand(cmd *cobra.Command, args []string, components *Components) (command Command, err error) {
+	revocation, err := escrow.NewFreeCallRevocation(freeCallTokenId, freeCallAddress, freeCallUserId, freeCallReason)
+	if err != nil {
+		return
+	}
+	command = &freeCallRevokeCommand{
+		revocations: components.FreeCallRevocationStorage(),
+		issuance:    components.FreeCallIssuanceStorage(),
+		revocation:  revocation,
+	}
+	return
+}
+
+func newFreeCallRestoreCommand(cmd *cobra.Command, args []string, components *Components) (command Command, err error) {
+	revocation, err := escrow.NewFreeCallRevocation(freeCallTokenId, freeCallAddress, freeCallUserId, "")
+	if err != nil {
+		return
+	}
+	command = &freeCallRestoreCommand{
+		revocations: components.FreeCallRevocationStorage(),
+		revocation:  revocation,
+	}
+	return
+}
+
+func newListFreeCallRevocationsCommand(cmd *cobra.Command, args []string, components *Components) (command Command, err error) {
+	command = &listFreeCallRevocationsCommand{
+		revocations: components.FreeCallRevocationStorage(),
+	}
+	return
+}
+
+func newListFreeCallTokensCommand(cmd *cobra.Command, args []string, components *Components) (command Command, err error) {
+	command = &listFreeCallTokensCommand{
+		issuance: components.FreeCallIssuanceStorage(),
+		signer:   freeCallSigner,
+	}
+	return
+}
+
+func (command *freeCallRevokeCommand) Run() (err error) {
+	if command.revocation.TokenID != "" {
+		record, ok, err := command.issuance.Get(command.revocation.TokenID)
+		if err != nil {
+			return err
+		}
+		if ok {
+			command.revocation.ExpirationBlock = record.ExpirationBlock
+		} else {
+			fmt.Printf("Warning: free call token %v is not found in the issued tokens\n", command.revocation.TokenID)
+		}
+	}
+	if err = command.revocations.Revoke(command.revocation); err != nil {
+		return
+	}
+	fmt.Println(command.revocation)
+	fmt.Printf("Success: %v\n", command.revocation.Message())
+	return
+}
+
+func (command *freeCallRestoreCommand) Run() (err error) {
+	removed, err := command.revocations.Restore(command.revocation)
+	if err != nil {
+		return
+	}
+	fmt.Println(removed)
+	fmt.Printf("Success: revocation %v removed\n", removed.Key())
+	return
+}
+
+func (command *listFreeCallRevocationsCommand) Run() (err error) {
+	revocations, err := command.revocations.List(nil)
+	if err != nil {
+		return
+	}
+
+	if len(revocations) == 0 {
+		fmt.Println("no free call revocations")
+	}
+
+	for _, revocation := range revocations {
+		fmt.Println(revocation)
+	}
+	return
+}
+
+func (command *listFreeCallTokensCommand) Run() (err error) {
+	signers, err := command.issuance.Signers(command.signer)
+	if err != nil {
+		return
+	}
+	records, err := command.issuance.Records(command.signer, nil)
+	if err != nil {
+		return
+	}
+
+	if len(signers) == 0 {
+		fmt.Println("no free call tokens issued to trusted signers")
+		return
+	}
+	for _, signer := range signers {
+		fmt.Println(signer)
+		for _, state := range command.issuance.Quotas().States(signer) {
+			window := string(state.Rule.Window)
+			if state.Rule.Window == escrow.FreeCallWindowRolling {
+				window = state.Rule.Period.String()
+			}
+			fmt.Printf("  quota: %v tokens per %v, tokens available: %v\n", state.Rule.Tokens, window, state.Available)
+		}
+	}
+	for _, record := range records {
+		fmt.Println(record)
+	}
+	return
+}
@@ -2,6 +2,7 @@ package cmd
 
 import (
 	"fmt"
+	"slices"
 
 	"github.com/singnet/snet-daemon/v6/blockchain"
 	"github.com/singnet/snet-daemon/v6/config"
@@ -157,11 +158,13 @@ var ListFreeCallUserCmd = &cobra.Command{
 
 type listFreeCallUsersCommand struct {
 	freeCallService escrow.FreeCallUserService
+	filter          freeCallUserFilter
 }
 
 func newListFreeCallUserCommand(cmd *cobra.Command, args []string, components *Components) (command Command, err error) {
 	command = &listFreeCallUsersCommand{
 		freeCallService: components.FreeCallUserService(),
+		filter:          newFreeCallUserFilter(),
 	}
 
 	return
@@ -172,6 +175,9 @@ func (command *listFreeCallUsersCommand) Run() (err error) {
 	if err != nil {
 		return
 	}
+	users = slices.DeleteFunc(users, func(user *escrow.FreeCallUserData) bool {
+		return !command.filter.matches(user)
+	})
 
 	if len(users) == 0 {
 		fmt.Println("no users of free calls, yet in storage")
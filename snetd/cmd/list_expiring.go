@@ -0,0 +1,62 @@
+package cmd
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/spf13/cobra"
+
+	"github.com/singnet/snet-daemon/v6/escrow"
+)
+
+// ListExpiringCmd shows channels with unclaimed income which expire soon
+var ListExpiringCmd = &cobra.Command{
+	Use:   "expiring",
+	Short: "List channels with unclaimed income which expire soon",
+	Long: "List channels which have unclaimed payments and expire within the given number of blocks or are" +
+		" expired already. The sender can withdraw the funds of the expired channel, so the payments should be" +
+		" claimed before, see 'snetd claim'.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RunAndCleanup(cmd, args, newListExpiringCommand)
+	},
+}
+
+type listExpiringCommand struct {
+	watchdog *escrow.ExpiryWatchdog
+	blocks   uint64
+}
+
+func newListExpiringCommand(cmd *cobra.Command, args []string, components *Components) (command Command, err error) {
+	watchdog := components.ExpiryWatchdog()
+	blocks := expiringBlocks
+	if blocks == 0 {
+		blocks = watchdog.Horizon()
+	}
+	command = &listExpiringCommand{
+		watchdog: watchdog,
+		blocks:   blocks,
+	}
+
+	return
+}
+
+func (command *listExpiringCommand) Run() (err error) {
+	channels, currentBlock, err := command.watchdog.Report(command.blocks)
+	if err != nil {
+		return
+	}
+
+	if len(channels) == 0 {
+		fmt.Printf("no channels with unclaimed income expire within %v blocks\n", command.blocks)
+		return
+	}
+
+	total := big.NewInt(0)
+	for _, channel := range channels {
+		total.Add(total, channel.Unclaimed())
+		fmt.Println(channel)
+	}
+	fmt.Printf("current block: %v, unclaimed income at risk: %v cogs in %v channels\n", currentBlock, total, len(channels))
+
+	return
+}
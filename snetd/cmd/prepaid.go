@@ -0,0 +1,141 @@
+package cmd
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/spf13/cobra"
+
+	"github.com/singnet/snet-daemon/v6/escrow"
+)
+
+// PrePaidCmd command to inspect the prepaid usage and revoke the tokens
+var PrePaidCmd = &cobra.Command{
+	Use:   "prepaid",
+	Short: "Manage prepaid channels and tokens",
+	Long: "List command prints planned, used and refunded amounts and the outstanding tokens of the prepaid channels," +
+		" revoke command revokes the token so the calls with it are rejected",
+}
+
+// PrePaidListCmd prints the usage and the tokens of the prepaid channels
+var PrePaidListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List usage and outstanding tokens of prepaid channels",
+	Long: "List planned, used and refunded amounts and the outstanding tokens of the channel given by --channel-id," +
+		" all channels with outstanding tokens are listed by default",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RunAndCleanup(cmd, args, newPrePaidListCommand)
+	},
+}
+
+// PrePaidRevokeCmd revokes the prepaid tokens
+var PrePaidRevokeCmd = &cobra.Command{
+	Use:   "revoke",
+	Short: "Revoke prepaid tokens",
+	Long: "Revoke the token given by --token-id of the channel given by --channel-id, all outstanding tokens of the" +
+		" channel are revoked if --token-id is not set, i.e. 'snetd prepaid revoke --channel-id 1 --token-id {id}'." +
+		" Token ids are printed by 'snetd prepaid list'.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RunAndCleanup(cmd, args, newPrePaidRevokeCommand)
+	},
+}
+
+type prePaidListCommand struct {
+	usage     escrow.PrePaidService
+	tokens    *escrow.PrePaidTokenStorage
+	channelID *big.Int
+}
+
+type prePaidRevokeCommand struct {
+	tokens    *escrow.PrePaidTokenStorage
+	channelID *big.Int
+	tokenID   string
+}
+
+func newPrePaidListCommand(cmd *cobra.Command, args []string, components *Components) (command Command, err error) {
+	var channelID *big.Int
+	if prepaidChannelId != "" {
+		if channelID, err = parsePrePaidChannelId(); err != nil {
+			return
+		}
+	}
+	command = &prePaidListCommand{
+		usage:     components.PrePaidService(),
+		tokens:    components.PrePaidTokenStorage(),
+		channelID: channelID,
+	}
+	return
+}
+
+func newPrePaidRevokeCommand(cmd *cobra.Command, args []string, components *Components) (command Command, err error) {
+	if prepaidChannelId == "" {
+		return nil, fmt.Errorf("--channel-id must be set")
+	}
+	channelID, err := parsePrePaidChannelId()
+	if err != nil {
+		return
+	}
+	command = &prePaidRevokeCommand{
+		tokens:    components.PrePaidTokenStorage(),
+		channelID: channelID,
+		tokenID:   prepaidTokenId,
+	}
+	return
+}
+
+func parsePrePaidChannelId() (*big.Int, error) {
+	channelID, ok := new(big.Int).SetString(prepaidChannelId, 10)
+	if !ok {
+		return nil, fmt.Errorf("incorrect channel id: %v", prepaidChannelId)
+	}
+	return channelID, nil
+}
+
+func (command *prePaidListCommand) Run() (err error) {
+	tokens, err := command.tokens.List(command.channelID)
+	if err != nil {
+		return
+	}
+
+	channels := make([]*big.Int, 0)
+	tokensByChannel := make(map[string][]*escrow.PrePaidToken)
+	if command.channelID != nil {
+		channels = append(channels, command.channelID)
+	}
+	for _, token := range tokens {
+		key := token.ChannelID.String()
+		if _, ok := tokensByChannel[key]; !ok && command.channelID == nil {
+			channels = append(channels, token.ChannelID)
+		}
+		tokensByChannel[key] = append(tokensByChannel[key], token)
+	}
+
+	if len(channels) == 0 {
+		fmt.Println("no prepaid channels with outstanding tokens")
+		return
+	}
+	for _, channelID := range channels {
+		usage, err := command.usage.GetUsageData(channelID)
+		if err != nil {
+			return err
+		}
+		fmt.Printf("channel: %v, planned: %v, used: %v, refunded: %v, remaining: %v cogs\n",
+			channelID, usage.PlannedAmount, usage.UsedAmount, usage.RefundAmount, usage.RemainingAmount())
+		for _, token := range tokensByChannel[channelID.String()] {
+			fmt.Println(token)
+		}
+	}
+	return
+}
+
+func (command *prePaidRevokeCommand) Run() (err error) {
+	revoked, err := command.tokens.Revoke(command.channelID, command.tokenID)
+	if err != nil {
+		return
+	}
+	for _, token := range revoked {
+		fmt.Println(token)
+	}
+	fmt.Printf("Success: %v tokens of channel %v revoked\n", len(revoked), command.channelID)
+	return
+}
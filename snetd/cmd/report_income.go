@@ -0,0 +1,127 @@
+package cmd
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/singnet/snet-daemon/v6/escrow"
+)
+
+// ReportIncomeCmd prints the income recorded by the daemons
+var ReportIncomeCmd = &cobra.Command{
+	Use:   "income",
+	Short: "Report the income received",
+	Long: "Report the income recorded to the shared storage when income_ledger_enabled is true." +
+		" The income can be limited by --from and --to dates and grouped by day, month, sender, method or channel," +
+		" i.e. 'snetd report income --from 2025-01-01 --group-by month --format csv'.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RunAndCleanup(cmd, args, newReportIncomeCommand)
+	},
+}
+
+type reportIncomeCommand struct {
+	ledger *escrow.IncomeLedger
+	query  *escrow.IncomeQuery
+	format string
+	output io.Writer
+}
+
+func newReportIncomeCommand(cmd *cobra.Command, args []string, components *Components) (command Command, err error) {
+	query := &escrow.IncomeQuery{}
+	if query.From, err = parseReportTime(reportFrom); err != nil {
+		return nil, fmt.Errorf("invalid --from: %v", err)
+	}
+	if query.To, err = parseReportTime(reportTo); err != nil {
+		return nil, fmt.Errorf("invalid --to: %v", err)
+	}
+	if query.GroupBy, err = escrow.ParseIncomeGroupBy(reportGroupBy); err != nil {
+		return nil, err
+	}
+	if reportFormat != "json" && reportFormat != "csv" {
+		return nil, fmt.Errorf("unknown format %q, expected one of 'json','csv'", reportFormat)
+	}
+
+	command = &reportIncomeCommand{
+		ledger: components.IncomeReader(),
+		query:  query,
+		format: reportFormat,
+		output: os.Stdout,
+	}
+	return
+}
+
+// parseReportTime parses a date or RFC3339 time, empty value means the time
+// is not limited
+func parseReportTime(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if date, err := time.Parse(time.DateOnly, value); err == nil {
+		return date, nil
+	}
+	return time.Parse(time.RFC3339, value)
+}
+
+type incomeReportGroup struct {
+	Key      string `json:"key"`
+	Amount   string `json:"amount"`
+	Payments int    `json:"payments"`
+}
+
+type incomeReport struct {
+	From    *time.Time          `json:"from,omitempty"`
+	To      *time.Time          `json:"to,omitempty"`
+	GroupBy string              `json:"group_by,omitempty"`
+	Groups  []incomeReportGroup `json:"groups"`
+	Total   string              `json:"total"`
+}
+
+func (command *reportIncomeCommand) Run() (err error) {
+	groups, err := command.ledger.Income(command.query)
+	if err != nil {
+		return
+	}
+
+	report := incomeReport{GroupBy: string(command.query.GroupBy), Groups: make([]incomeReportGroup, 0, len(groups))}
+	if !command.query.From.IsZero() {
+		report.From = &command.query.From
+	}
+	if !command.query.To.IsZero() {
+		report.To = &command.query.To
+	}
+	total := big.NewInt(0)
+	for _, group := range groups {
+		total.Add(total, group.Amount)
+		report.Groups = append(report.Groups, incomeReportGroup{Key: group.Key, Amount: group.Amount.String(), Payments: group.Payments})
+	}
+	report.Total = total.String()
+
+	if command.format == "csv" {
+		return writeIncomeCsv(command.output, &report)
+	}
+	encoder := json.NewEncoder(command.output)
+	encoder.SetIndent("", "  ")
+	return encoder.Encode(&report)
+}
+
+func writeIncomeCsv(output io.Writer, report *incomeReport) error {
+	writer := csv.NewWriter(output)
+	if err := writer.Write([]string{"key", "amount", "payments"}); err != nil {
+		return err
+	}
+	for _, group := range report.Groups {
+		if err := writer.Write([]string{group.Key, group.Amount, strconv.Itoa(group.Payments)}); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
@@ -24,6 +24,7 @@ import (
 	"github.com/singnet/snet-daemon/v6/handler"
 	"github.com/singnet/snet-daemon/v6/logger"
 	"github.com/singnet/snet-daemon/v6/metrics"
+	"github.com/singnet/snet-daemon/v6/token"
 	"github.com/singnet/snet-daemon/v6/training"
 
 	"github.com/gorilla/handlers"
@@ -69,6 +70,10 @@ var ServeCmd = &cobra.Command{
 		d.start()
 		defer d.stop()
 
+		components.StartClaimScheduler()
+		components.StartExpiryWatchdog()
+		components.StartTokenKeyRotation()
+
 		// Check if the payment storage client is etcd by verifying if d.components.etcdClient exists.
 		// If etcdClient is not nil and hot reload is enabled, initialize a ContractEventListener
 		// to listen for changes in the organization metadata.
@@ -93,10 +98,13 @@ type daemon struct {
 	autoSSLDomain string
 	acmeListener  net.Listener
 	grpcServer    *grpc.Server
-	blockProc     blockchain.Processor
-	lis           net.Listener
-	sslCert       *tls.Certificate
-	components    *Components
+	// serviceHandler passes the calls to the service, it is closed after the
+	// server is stopped
+	serviceHandler *handler.ServiceHandler
+	blockProc      blockchain.Processor
+	lis            net.Listener
+	sslCert        *tls.Certificate
+	components     *Components
 }
 
 func newDaemon(components *Components) (daemon, error) {
@@ -213,13 +221,21 @@ func (d *daemon) start() {
 
 	if config.GetString(config.DaemonTypeKey) != "grpc" {
 		zap.L().Debug("starting simple HTTP daemon")
-		go http.Serve(d.lis, handlers.CORS(corsOptionsHTTP...)(httphandler.NewHTTPHandler(d.blockProc)))
+		go http.Serve(d.lis, handlers.CORS(corsOptionsHTTP...)(httphandler.NewHTTPHandlerWithClient(d.blockProc, d.components.ServiceHTTPClient())))
 		return
 	}
 
+	// the client is built for the services called over HTTP only, so its
+	// configuration doesn't affect the other services
+	var httpClient *handler.ServiceHTTPClient
+	switch d.components.ServiceMetaData().GetServiceType() {
+	case "http", "jsonrpc":
+		httpClient = d.components.ServiceHTTPClient()
+	}
 	maxsizeOpt := grpc.MaxRecvMsgSize(config.GetInt(config.MaxMessageSizeInMB) * 1024 * 1024)
+	d.serviceHandler = handler.NewGrpcHandlerWithHTTPClient(d.components.ServiceMetaData(), httpClient)
 	d.grpcServer = grpc.NewServer(
-		grpc.UnknownServiceHandler(handler.NewGrpcHandler(d.components.ServiceMetaData())),
+		grpc.UnknownServiceHandler(d.serviceHandler.StreamHandler),
 		grpc.StreamInterceptor(d.components.GrpcStreamInterceptor()),
 		grpc.UnaryInterceptor(d.components.GrpcUnaryInterceptor()),
 		maxsizeOpt,
@@ -228,6 +244,8 @@ func (d *daemon) start() {
 	escrow.RegisterProviderControlServiceServer(d.grpcServer, d.components.ProviderControlService())
 	escrow.RegisterFreeCallStateServiceServer(d.grpcServer, d.components.FreeCallStateService())
 	escrow.RegisterTokenServiceServer(d.grpcServer, d.components.TokenService())
+	escrow.RegisterStreamPaymentServiceServer(d.grpcServer, d.components.StreamPaymentService())
+	escrow.RegisterIncomeServiceServer(d.grpcServer, d.components.IncomeService())
 	training.RegisterDaemonServer(d.grpcServer, d.components.TrainingService())
 	grpc_health_v1.RegisterHealthServer(d.grpcServer, d.components.DaemonHeartBeat())
 	configuration_service.RegisterConfigurationServiceServer(d.grpcServer, d.components.ConfigurationService())
@@ -332,7 +350,7 @@ func (d *daemon) newGRPCWebServer() *grpcweb.WrappedGrpcServer {
 // and in traffic_split mode. It handles:
 //   - CORS preflight (OPTIONS),
 //   - gRPC-Web requests,
-//   - /encoding and /heartbeat endpoints,
+//   - /encoding, /heartbeat, /metrics and /.well-known/jwks.json endpoints,
 //   - 404 for everything else.
 func (d *daemon) newHTTPHandler(grpcWebServer *grpcweb.WrappedGrpcServer) http.Handler {
 	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
@@ -357,7 +375,7 @@ func (d *daemon) newHTTPHandler(grpcWebServer *grpcweb.WrappedGrpcServer) http.H
 			return
 		}
 
-		// Simple HTTP endpoints (encoding / heartbeat)
+		// Simple HTTP endpoints (encoding / heartbeat / metrics)
 		var path string
 		if parts := strings.Split(req.URL.Path, "/"); len(parts) > 1 {
 			path = parts[1]
@@ -375,6 +393,14 @@ func (d *daemon) newHTTPHandler(grpcWebServer *grpcweb.WrappedGrpcServer) http.H
 				d.components.DaemonHeartBeat().DynamicPricing,
 				d.components.Blockchain().CurrentBlock,
 			)
+		case "metrics":
+			metrics.VarsHandler(resp)
+		case ".well-known":
+			if req.URL.Path != token.JWKSPath {
+				http.NotFound(resp, req)
+				return
+			}
+			token.JWKSHandler(d.components.TokenKeyRing(), resp)
 		default:
 			http.NotFound(resp, req)
 			return
@@ -389,6 +415,10 @@ func (d *daemon) stop() {
 		d.grpcServer.GracefulStop()
 	}
 
+	if d.serviceHandler != nil {
+		d.serviceHandler.Close()
+	}
+
 	if d.lis != nil {
 		d.lis.Close()
 	}
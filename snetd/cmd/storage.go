@@ -0,0 +1,311 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"reflect"
+	"strings"
+
+	"github.com/singnet/snet-daemon/v6/escrow"
+	"github.com/singnet/snet-daemon/v6/storage"
+	"github.com/singnet/snet-daemon/v6/token"
+	"github.com/singnet/snet-daemon/v6/training"
+	"github.com/singnet/snet-daemon/v6/utils"
+	"github.com/spf13/cobra"
+)
+
+// StorageCmd groups commands which operate on the whole daemon storage
+var StorageCmd = &cobra.Command{
+	Use:   "storage",
+	Short: "Backup, restore and migrate the daemon storage",
+	Long: "Export command dumps payment channels, payments, locks, free call users, prepaid usage and" +
+		" training models into the archive, import command restores the archive into the configured storage",
+}
+
+// StorageExportCmd dumps all keys of the storage into the archive
+var StorageExportCmd = &cobra.Command{
+	Use:   "export",
+	Short: "Export all storage keys into the archive",
+	Long: "Export all keys of the configured storage into a versioned JSON or JSONL archive." +
+		" Values are kept as is and additionally decoded to be human-readable." +
+		" Use 'snetd storage export -o backup.jsonl --format jsonl' to create a backup.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RunAndCleanup(cmd, args, newStorageExportCommand)
+	},
+}
+
+// StorageMigrateCmd rewrites values which are not in the current encoding
+var StorageMigrateCmd = &cobra.Command{
+	Use:   "migrate",
+	Short: "Rewrite values stored in the legacy gob encoding",
+	Long: "Rewrite payment channels, payments, free call users, prepaid usage and training models which are not" +
+		" stored in the current storage_value_encoding, i.e. in the legacy gob encoding. Values already in the" +
+		" current encoding are skipped. Values are replaced using compare-and-swap, so the command can be run while" +
+		" daemons are serving requests.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RunAndCleanup(cmd, args, newStorageMigrateCommand)
+	},
+}
+
+// StorageImportCmd restores the archive into the storage
+var StorageImportCmd = &cobra.Command{
+	Use:   "import",
+	Short: "Import the archive into the storage",
+	Long: "Import keys from the archive created by 'snetd storage export' into the configured storage." +
+		" Use --dry-run to see the changes without writing them and --on-conflict to choose what happens" +
+		" with keys which already exist with different values.",
+	RunE: func(cmd *cobra.Command, args []string) error {
+		return RunAndCleanup(cmd, args, newStorageImportCommand)
+	},
+}
+
+// storageValueSections lists prefixes of the values written by the typed
+// storages, keys are matched by the prefix of the storage they are written by
+var storageValueSections = []storage.ArchiveSection{
+	{Name: "payment-channel", Marker: "/payment-channel/storage/", Decode: archiveDecoder(escrow.PaymentChannelData{})},
+	{Name: "payment", Marker: "/payment/storage/", Decode: archiveDecoder(escrow.Payment{})},
+	{Name: "free-call-user", Marker: "/free-call-user/storage/", Decode: archiveDecoder(escrow.FreeCallUserData{})},
+	{Name: "free-call-revocation", Marker: "/free-call-user/revocations/", Decode: archiveDecoder(escrow.FreeCallRevocation{})},
+	{Name: "free-call-token", Marker: "/free-call-user/tokens/", Decode: archiveDecoder(escrow.FreeCallTokenRecord{})},
+	{Name: "free-call-issuance", Marker: "/free-call-user/issuance/", Decode: archiveDecoder(escrow.FreeCallSignerIssuance{})},
+	{Name: "prepaid", Marker: "/PrePaid/storage/", Decode: archiveDecoder(escrow.PrePaidData{})},
+	{Name: "prepaid-token", Marker: "/PrePaid/tokens/", Decode: archiveDecoder(escrow.PrePaidToken{})},
+	{Name: "income", Marker: "/income/ledger/", Decode: archiveDecoder(escrow.IncomeRecord{})},
+	{Name: "token-key", Marker: "/token/keys/", Decode: archiveDecoder(token.VerificationKey{})},
+	{Name: "training-user-model", Marker: "/model-user/userModelStorage/", Decode: archiveDecoder(training.ModelUserData{})},
+	{Name: "training-model", Marker: "/model-user/modelStorage/", Decode: archiveDecoder(training.ModelData{})},
+	{Name: "training-pending-model", Marker: "/model-user/pendingModelStorage/", Decode: archiveDecoder(training.PendingModelData{})},
+	{Name: "training-public-model", Marker: "/model-user/publicModelStorage/", Decode: archiveDecoder(training.PublicModelData{})},
+}
+
+// storageLockSections lists prefixes of the locks, they are stored as plain
+// strings
+var storageLockSections = []storage.ArchiveSection{
+	{Name: "payment-channel-lock", Marker: "/payment-channel/lock/", Decode: decodeLock},
+	{Name: "free-call-lock", Marker: "/freecall/lock/", Decode: decodeLock},
+}
+
+var storageArchiveSections = append(append([]storage.ArchiveSection{}, storageValueSections...), storageLockSections...)
+
+func archiveDecoder(valueType any) func(raw string) (any, error) {
+	t := reflect.TypeOf(valueType)
+	return func(raw string) (any, error) {
+		value := reflect.New(t).Interface()
+		if err := utils.Deserialize(raw, value); err != nil {
+			return nil, err
+		}
+		return value, nil
+	}
+}
+
+func decodeLock(raw string) (any, error) {
+	return raw, nil
+}
+
+type storageExportCommand struct {
+	storage   storage.AtomicStorage
+	prefix    string
+	output    string
+	jsonLines bool
+}
+
+func newStorageExportCommand(cmd *cobra.Command, args []string, components *Components) (command Command, err error) {
+	if storageArchiveFormat != "json" && storageArchiveFormat != "jsonl" {
+		return nil, fmt.Errorf("unknown archive format: %q, expected json or jsonl", storageArchiveFormat)
+	}
+	command = &storageExportCommand{
+		storage:   components.AtomicStorage(),
+		prefix:    components.AtomicStoragePrefix(),
+		output:    storageExportOutput,
+		jsonLines: storageArchiveFormat == "jsonl",
+	}
+	return
+}
+
+func (command *storageExportCommand) Run() (err error) {
+	archive, err := storage.NewArchive(command.storage, command.prefix, storageArchiveSections)
+	if err != nil {
+		return fmt.Errorf("can't read storage: %w", err)
+	}
+
+	var writer io.Writer = os.Stdout
+	if command.output != "" && command.output != "-" {
+		file, err := os.Create(command.output)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writer = file
+	}
+
+	if err = storage.WriteArchive(writer, archive, command.jsonLines); err != nil {
+		return fmt.Errorf("can't write archive: %w", err)
+	}
+	if writer != os.Stdout {
+		fmt.Printf("Success: %v keys exported to %v\n", len(archive.Entries), command.output)
+	}
+	return nil
+}
+
+type storageImportCommand struct {
+	storage storage.AtomicStorage
+	prefix  string
+	input   string
+	policy  storage.ConflictPolicy
+	dryRun  bool
+}
+
+func newStorageImportCommand(cmd *cobra.Command, args []string, components *Components) (command Command, err error) {
+	policy, err := storage.ParseConflictPolicy(storageConflictPolicy)
+	if err != nil {
+		return
+	}
+	if storageImportInput == "" {
+		return nil, fmt.Errorf("--input archive file must be set")
+	}
+	command = &storageImportCommand{
+		storage: components.AtomicStorage(),
+		prefix:  components.AtomicStoragePrefix(),
+		input:   storageImportInput,
+		policy:  policy,
+		dryRun:  storageDryRun,
+	}
+	return
+}
+
+func (command *storageImportCommand) Run() (err error) {
+	var reader io.Reader = os.Stdin
+	if command.input != "-" {
+		file, err := os.Open(command.input)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		reader = file
+	}
+
+	archive, err := storage.ReadArchive(reader)
+	if err != nil {
+		return err
+	}
+	if archive.Prefix != command.prefix {
+		return fmt.Errorf("archive was exported from %q, but the configured storage is %q;"+
+			" check blockchain_network_selected, organization_id and daemon_group_name", archive.Prefix, command.prefix)
+	}
+
+	result, err := storage.ImportArchive(command.storage, archive, command.policy, command.dryRun)
+	if result != nil {
+		printImportResult(result, command.dryRun)
+	}
+	if err != nil {
+		return err
+	}
+	if command.dryRun {
+		fmt.Println("Dry run: nothing was written to the storage")
+	} else {
+		fmt.Printf("Success: %v keys imported\n", len(result.Created)+len(result.Overwritten))
+	}
+	return nil
+}
+
+func printImportResult(result *storage.ImportResult, dryRun bool) {
+	groups := []struct {
+		title string
+		keys  []string
+	}{
+		{"created", result.Created},
+		{"overwritten", result.Overwritten},
+		{"skipped (conflict)", result.Skipped},
+		{"unchanged", result.Unchanged},
+	}
+	for _, group := range groups {
+		for _, key := range group.keys {
+			if dryRun || group.title != "unchanged" {
+				fmt.Printf("%v: %v\n", group.title, key)
+			}
+		}
+	}
+	fmt.Printf("created: %v, overwritten: %v, skipped: %v, unchanged: %v\n",
+		len(result.Created), len(result.Overwritten), len(result.Skipped), len(result.Unchanged))
+}
+
+type storageMigrateCommand struct {
+	storage storage.AtomicStorage
+	dryRun  bool
+}
+
+func newStorageMigrateCommand(cmd *cobra.Command, args []string, components *Components) (command Command, err error) {
+	command = &storageMigrateCommand{
+		storage: components.AtomicStorage(),
+		dryRun:  storageDryRun,
+	}
+	return
+}
+
+func (command *storageMigrateCommand) Run() (err error) {
+	migrated, err := migrateStorageValues(command.storage, command.dryRun)
+	for _, key := range migrated {
+		fmt.Printf("migrated: %v\n", key)
+	}
+	if err != nil {
+		return err
+	}
+	if command.dryRun {
+		fmt.Printf("Dry run: %v keys to migrate, nothing was written to the storage\n", len(migrated))
+	} else {
+		fmt.Printf("Success: %v keys migrated\n", len(migrated))
+	}
+	return nil
+}
+
+// migrateStorageValues re-encodes values which are not in the current
+// encoding. A value changed by a daemon between read and write is read again,
+// the daemon may have already written it in the current encoding.
+func migrateStorageValues(store storage.AtomicStorage, dryRun bool) (migrated []string, err error) {
+	keyValues, err := store.GetKeyValuesByKeyPrefix("")
+	if err != nil {
+		return nil, err
+	}
+	for _, keyValue := range keyValues {
+		section := findStorageSection(storageValueSections, keyValue.Key)
+		if section == nil {
+			continue
+		}
+		for raw, present := keyValue.Value, true; present && !utils.IsCurrentEncoding(raw); {
+			value, err := section.Decode(raw)
+			if err != nil {
+				return migrated, fmt.Errorf("can't decode value of key %v: %w", keyValue.Key, err)
+			}
+			if dryRun {
+				migrated = append(migrated, keyValue.Key)
+				break
+			}
+			encoded, err := utils.Serialize(value)
+			if err != nil {
+				return migrated, err
+			}
+			ok, err := store.CompareAndSwap(keyValue.Key, raw, encoded)
+			if err != nil {
+				return migrated, err
+			}
+			if ok {
+				migrated = append(migrated, keyValue.Key)
+				break
+			}
+			if raw, present, err = store.Get(keyValue.Key); err != nil {
+				return migrated, err
+			}
+		}
+	}
+	return migrated, nil
+}
+
+func findStorageSection(sections []storage.ArchiveSection, key string) *storage.ArchiveSection {
+	for i := range sections {
+		if strings.Contains(key, sections[i].Marker) {
+			return &sections[i]
+		}
+	}
+	return nil
+}
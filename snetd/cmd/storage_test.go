@@ -0,0 +1,125 @@
+package cmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/singnet/snet-daemon/v6/escrow"
+	"github.com/singnet/snet-daemon/v6/storage"
+	"github.com/singnet/snet-daemon/v6/utils"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestStorageArchiveSections(t *testing.T) {
+	source := storage.NewPrefixedAtomicStorage(storage.NewMemStorage(), "sepolia/org/group")
+	mpeStorage := storage.NewPrefixedAtomicStorage(source, "0x5e592F9b1d303183d963635f895f0f0C48284f4e")
+
+	channelKey := &escrow.PaymentChannelKey{ID: big.NewInt(42)}
+	channel := &escrow.PaymentChannelData{
+		ChannelID:        big.NewInt(42),
+		Nonce:            big.NewInt(3),
+		Sender:           common.HexToAddress("0x3b2b3C2e2E7C93db335E69D827F3CC4bC2A2A2cB"),
+		FullAmount:       big.NewInt(100),
+		Expiration:       big.NewInt(1000),
+		AuthorizedAmount: big.NewInt(10),
+	}
+	require.NoError(t, escrow.NewPaymentChannelStorage(mpeStorage).Put(channelKey, channel))
+	require.NoError(t, storage.NewPrefixedAtomicStorage(mpeStorage, "/payment-channel/lock").Put(channelKey.String(), "locked"))
+	freeCallKey := &escrow.FreeCallUserKey{UserId: "user@example.com", OrganizationId: "org", ServiceId: "service", GroupID: "group"}
+	require.NoError(t, escrow.NewFreeCallUserStorage(source).Put(freeCallKey,
+		&escrow.FreeCallUserData{UserID: "user@example.com", FreeCallsMade: 7}))
+
+	archive, err := storage.NewArchive(source, "sepolia/org/group", storageArchiveSections)
+	require.NoError(t, err)
+	require.Len(t, archive.Entries, 3)
+
+	sections := map[string]storage.ArchiveEntry{}
+	for _, entry := range archive.Entries {
+		sections[entry.Section] = entry
+	}
+	assert.Equal(t, channel, sections["payment-channel"].Value)
+	assert.Equal(t, "locked", sections["payment-channel-lock"].Value)
+	assert.Equal(t, 7, sections["free-call-user"].Value.(*escrow.FreeCallUserData).FreeCallsMade)
+
+	var buffer bytes.Buffer
+	require.NoError(t, storage.WriteArchive(&buffer, archive, true))
+	assert.Contains(t, buffer.String(), `"ChannelID":42`, "values must be human-readable in the archive")
+
+	read, err := storage.ReadArchive(&buffer)
+	require.NoError(t, err)
+
+	target := storage.NewPrefixedAtomicStorage(storage.NewMemStorage(), "sepolia/org/group")
+	result, err := storage.ImportArchive(target, read, storage.ConflictFail, false)
+	require.NoError(t, err)
+	assert.Len(t, result.Created, 3)
+
+	restored, ok, err := escrow.NewPaymentChannelStorage(storage.NewPrefixedAtomicStorage(target,
+		"0x5e592F9b1d303183d963635f895f0f0C48284f4e")).Get(channelKey)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, channel, restored)
+
+	freeCallUser, ok, err := escrow.NewFreeCallUserStorage(target).Get(freeCallKey)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, 7, freeCallUser.FreeCallsMade)
+}
+
+func TestStorageArchiveDecoder_InvalidValue(t *testing.T) {
+	_, err := archiveDecoder(escrow.PaymentChannelData{})("not a gob value")
+	assert.Error(t, err)
+
+	entry := storage.ArchiveEntry{Key: "k", Raw: []byte("not a gob value")}
+	data, err := json.Marshal(entry)
+	require.NoError(t, err)
+	assert.NotContains(t, string(data), `"value"`)
+}
+
+func TestMigrateStorageValues(t *testing.T) {
+	store := storage.NewMemStorage()
+	channelKey := &escrow.PaymentChannelKey{ID: big.NewInt(7)}
+	channel := &escrow.PaymentChannelData{ChannelID: big.NewInt(7), Nonce: big.NewInt(1), AuthorizedAmount: big.NewInt(5)}
+
+	require.NoError(t, utils.SetValueEncoding(utils.ValueEncodingGob))
+	require.NoError(t, escrow.NewPaymentChannelStorage(store).Put(channelKey, channel))
+	require.NoError(t, utils.SetValueEncoding(utils.ValueEncodingJSON))
+	require.NoError(t, escrow.NewFreeCallUserStorage(store).Put(&escrow.FreeCallUserKey{UserId: "user"},
+		&escrow.FreeCallUserData{UserID: "user", FreeCallsMade: 1}))
+	require.NoError(t, store.Put("/payment-channel/lock/{ID: 7}", "locked"))
+
+	migrated, err := migrateStorageValues(store, true)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/payment-channel/storage/{ID: 7}"}, migrated)
+	raw, _, _ := store.Get("/payment-channel/storage/{ID: 7}")
+	assert.False(t, utils.IsEnveloped(raw), "dry run must not write to the storage")
+
+	migrated, err = migrateStorageValues(store, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"/payment-channel/storage/{ID: 7}"}, migrated)
+	raw, _, _ = store.Get("/payment-channel/storage/{ID: 7}")
+	assert.True(t, utils.IsEnveloped(raw))
+	lock, _, _ := store.Get("/payment-channel/lock/{ID: 7}")
+	assert.Equal(t, "locked", lock, "locks are not migrated")
+
+	restored, ok, err := escrow.NewPaymentChannelStorage(store).Get(channelKey)
+	require.NoError(t, err)
+	require.True(t, ok)
+	assert.Equal(t, channel, restored)
+
+	migrated, err = migrateStorageValues(store, false)
+	require.NoError(t, err)
+	assert.Empty(t, migrated)
+
+	// gob values are in the current encoding if gob is configured
+	require.NoError(t, utils.SetValueEncoding(utils.ValueEncodingGob))
+	defer utils.SetValueEncoding(utils.ValueEncodingJSON)
+	require.NoError(t, escrow.NewPaymentChannelStorage(store).Put(channelKey, channel))
+	migrated, err = migrateStorageValues(store, true)
+	require.NoError(t, err)
+	assert.NotContains(t, migrated, "/payment-channel/storage/{ID: 7}", "gob to gob is not a migration")
+	assert.Len(t, migrated, 1, "only the free call user is stored in json")
+}
@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"time"
+)
+
+const (
+	// ArchiveFormat is a value of the format field of the storage archive header
+	ArchiveFormat = "snetd-storage-archive"
+	// ArchiveVersion is the version of the archive layout written by this daemon
+	ArchiveVersion = 1
+)
+
+// ArchiveHeader describes the storage archive. Keys of the entries are
+// relative to Prefix, which is the prefix of the storage the archive was
+// exported from.
+type ArchiveHeader struct {
+	Format    string    `json:"format"`
+	Version   int       `json:"version"`
+	CreatedAt time.Time `json:"created_at"`
+	Prefix    string    `json:"prefix"`
+}
+
+// ArchiveEntry is a single key of the storage. Raw keeps the value exactly as
+// it is stored and is the only field used to restore the key, Value is the
+// decoded representation of the value for humans and external tools.
+type ArchiveEntry struct {
+	Key     string `json:"key"`
+	Section string `json:"section,omitempty"`
+	Value   any    `json:"value,omitempty"`
+	Raw     []byte `json:"raw"`
+}
+
+// Archive is the content of the storage archive. In the JSON format it is a
+// single document, in the JSONL format the first line is the header and each
+// next line is an entry.
+type Archive struct {
+	ArchiveHeader
+	Entries []ArchiveEntry `json:"entries,omitempty"`
+}
+
+// ArchiveSection describes a group of keys the daemon stores under the same
+// key prefix and knows how to decode. Marker is a part of the key which
+// identifies the section, i.e. "/payment-channel/storage/".
+type ArchiveSection struct {
+	Name   string
+	Marker string
+	Decode func(raw string) (value any, err error)
+}
+
+// NewArchive reads all keys of the storage and decodes their values using the
+// first section which marker is found in the key. Keys which don't belong to
+// any section or can't be decoded are exported as raw values only.
+func NewArchive(storage AtomicStorage, prefix string, sections []ArchiveSection) (archive *Archive, err error) {
+	keyValues, err := storage.GetKeyValuesByKeyPrefix("")
+	if err != nil {
+		return nil, err
+	}
+
+	archive = &Archive{
+		ArchiveHeader: ArchiveHeader{
+			Format:    ArchiveFormat,
+			Version:   ArchiveVersion,
+			CreatedAt: time.Now().UTC(),
+			Prefix:    prefix,
+		},
+		Entries: make([]ArchiveEntry, 0, len(keyValues)),
+	}
+	for _, keyValue := range keyValues {
+		entry := ArchiveEntry{Key: keyValue.Key, Raw: []byte(keyValue.Value)}
+		for _, section := range sections {
+			if !strings.Contains(keyValue.Key, section.Marker) {
+				continue
+			}
+			entry.Section = section.Name
+			if section.Decode != nil {
+				if value, err := section.Decode(keyValue.Value); err == nil {
+					entry.Value = value
+				}
+			}
+			break
+		}
+		archive.Entries = append(archive.Entries, entry)
+	}
+	return archive, nil
+}
+
+// WriteArchive writes the archive either as a single JSON document or as
+// JSONL when jsonLines is true.
+func WriteArchive(writer io.Writer, archive *Archive, jsonLines bool) (err error) {
+	encoder := json.NewEncoder(writer)
+	if !jsonLines {
+		encoder.SetIndent("", "  ")
+		return encoder.Encode(archive)
+	}
+	if err = encoder.Encode(archive.ArchiveHeader); err != nil {
+		return err
+	}
+	for _, entry := range archive.Entries {
+		if err = encoder.Encode(entry); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// ReadArchive reads the archive written by WriteArchive, both JSON and JSONL
+// formats are accepted.
+func ReadArchive(reader io.Reader) (archive *Archive, err error) {
+	decoder := json.NewDecoder(reader)
+	archive = &Archive{}
+	if err = decoder.Decode(archive); err != nil {
+		return nil, fmt.Errorf("can't read archive header: %w", err)
+	}
+	if archive.Format != ArchiveFormat {
+		return nil, fmt.Errorf("unexpected archive format: %q", archive.Format)
+	}
+	if archive.Version < 1 || archive.Version > ArchiveVersion {
+		return nil, fmt.Errorf("unsupported archive version: %v, max supported version: %v", archive.Version, ArchiveVersion)
+	}
+	for {
+		var entry ArchiveEntry
+		err = decoder.Decode(&entry)
+		if errors.Is(err, io.EOF) {
+			return archive, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("can't read archive entry %v: %w", len(archive.Entries), err)
+		}
+		archive.Entries = append(archive.Entries, entry)
+	}
+}
+
+// ConflictPolicy defines what happens when a key from the archive already
+// exists in the storage with a different value
+type ConflictPolicy string
+
+const (
+	// ConflictSkip keeps the value which is in the storage
+	ConflictSkip ConflictPolicy = "skip"
+	// ConflictOverwrite replaces the value in the storage by the archived one
+	ConflictOverwrite ConflictPolicy = "overwrite"
+	// ConflictFail aborts the import before anything is written
+	ConflictFail ConflictPolicy = "fail"
+)
+
+// ParseConflictPolicy converts the string value of the command line flag into
+// ConflictPolicy
+func ParseConflictPolicy(value string) (policy ConflictPolicy, err error) {
+	switch policy = ConflictPolicy(value); policy {
+	case ConflictSkip, ConflictOverwrite, ConflictFail:
+		return policy, nil
+	}
+	return "", fmt.Errorf("unknown conflict policy: %q, expected one of: %v, %v, %v",
+		value, ConflictSkip, ConflictOverwrite, ConflictFail)
+}
+
+// ImportResult contains keys of the archive grouped by the action taken (or
+// planned in dry-run mode) during the import
+type ImportResult struct {
+	Created     []string
+	Overwritten []string
+	Skipped     []string
+	Unchanged   []string
+}
+
+// ImportArchive writes archive entries into the storage. In dry-run mode the
+// storage is only read and the result shows what would be done. A key which
+// is created by another writer during the import is handled according to the
+// policy as well.
+func ImportArchive(storage AtomicStorage, archive *Archive, policy ConflictPolicy, dryRun bool) (result *ImportResult, err error) {
+	existing := make([]bool, len(archive.Entries))
+	var conflicts []string
+	for i, entry := range archive.Entries {
+		value, ok, err := storage.Get(entry.Key)
+		if err != nil {
+			return nil, err
+		}
+		existing[i] = ok
+		if ok && value != string(entry.Raw) {
+			conflicts = append(conflicts, entry.Key)
+		}
+	}
+	if policy == ConflictFail && len(conflicts) > 0 {
+		return nil, fmt.Errorf("%v key(s) already exist with different values: %v",
+			len(conflicts), strings.Join(conflicts, ", "))
+	}
+
+	result = &ImportResult{}
+	for i, entry := range archive.Entries {
+		if existing[i] {
+			err = importExisting(storage, entry, policy, dryRun, result)
+		} else {
+			err = importAbsent(storage, entry, policy, dryRun, result)
+		}
+		if err != nil {
+			return result, fmt.Errorf("can't import key %v: %w", entry.Key, err)
+		}
+	}
+	return result, nil
+}
+
+func importAbsent(storage AtomicStorage, entry ArchiveEntry, policy ConflictPolicy, dryRun bool, result *ImportResult) (err error) {
+	if dryRun {
+		result.Created = append(result.Created, entry.Key)
+		return nil
+	}
+	ok, err := storage.PutIfAbsent(entry.Key, string(entry.Raw))
+	if err != nil {
+		return err
+	}
+	if ok {
+		result.Created = append(result.Created, entry.Key)
+		return nil
+	}
+	return importExisting(storage, entry, policy, dryRun, result)
+}
+
+func importExisting(storage AtomicStorage, entry ArchiveEntry, policy ConflictPolicy, dryRun bool, result *ImportResult) (err error) {
+	value, ok, err := storage.Get(entry.Key)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return importAbsent(storage, entry, policy, dryRun, result)
+	}
+	if value == string(entry.Raw) {
+		result.Unchanged = append(result.Unchanged, entry.Key)
+		return nil
+	}
+	switch policy {
+	case ConflictOverwrite:
+		if !dryRun {
+			ok, err = storage.CompareAndSwap(entry.Key, value, string(entry.Raw))
+			if err != nil {
+				return err
+			}
+			if !ok {
+				return importExisting(storage, entry, policy, dryRun, result)
+			}
+		}
+		result.Overwritten = append(result.Overwritten, entry.Key)
+	case ConflictFail:
+		return errors.New("key was changed by another writer during import")
+	default:
+		result.Skipped = append(result.Skipped, entry.Key)
+	}
+	return nil
+}
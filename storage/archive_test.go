@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestArchiveStorage() *PrefixedAtomicStorage {
+	base := NewMemStorage()
+	prefixed := NewPrefixedAtomicStorage(base, "sepolia/org/group")
+	_ = prefixed.Put("mpe/payment-channel/storage/{ID: 1}", "channel")
+	_ = prefixed.Put("mpe/payment-channel/lock/{ID: 1}", "locked")
+	_ = prefixed.Put("/unknown/key", "raw\x00value")
+	_ = base.Put("mainnet/org/group/mpe/payment-channel/storage/{ID: 2}", "other network")
+	return prefixed
+}
+
+var testArchiveSections = []ArchiveSection{
+	{Name: "payment-channel", Marker: "/payment-channel/storage/", Decode: func(raw string) (any, error) {
+		return strings.ToUpper(raw), nil
+	}},
+	{Name: "payment-channel-lock", Marker: "/payment-channel/lock/"},
+}
+
+func TestNewArchive(t *testing.T) {
+	archive, err := NewArchive(newTestArchiveStorage(), "sepolia/org/group", testArchiveSections)
+	require.NoError(t, err)
+
+	assert.Equal(t, ArchiveFormat, archive.Format)
+	assert.Equal(t, ArchiveVersion, archive.Version)
+	assert.Equal(t, "sepolia/org/group", archive.Prefix)
+	assert.Equal(t, []ArchiveEntry{
+		{Key: "/unknown/key", Raw: []byte("raw\x00value")},
+		{Key: "mpe/payment-channel/lock/{ID: 1}", Section: "payment-channel-lock", Raw: []byte("locked")},
+		{Key: "mpe/payment-channel/storage/{ID: 1}", Section: "payment-channel", Value: "CHANNEL", Raw: []byte("channel")},
+	}, archive.Entries)
+}
+
+func TestWriteAndReadArchive(t *testing.T) {
+	archive, err := NewArchive(newTestArchiveStorage(), "sepolia/org/group", testArchiveSections)
+	require.NoError(t, err)
+
+	for _, jsonLines := range []bool{false, true} {
+		var buffer bytes.Buffer
+		require.NoError(t, WriteArchive(&buffer, archive, jsonLines))
+		if jsonLines {
+			assert.Equal(t, len(archive.Entries)+1, strings.Count(buffer.String(), "\n"))
+		}
+
+		read, err := ReadArchive(&buffer)
+		require.NoError(t, err)
+		assert.Equal(t, archive.ArchiveHeader.Prefix, read.Prefix)
+		assert.True(t, archive.CreatedAt.Equal(read.CreatedAt))
+		require.Len(t, read.Entries, len(archive.Entries))
+		for i, entry := range read.Entries {
+			assert.Equal(t, archive.Entries[i].Key, entry.Key)
+			assert.Equal(t, archive.Entries[i].Raw, entry.Raw)
+			assert.Equal(t, archive.Entries[i].Value, entry.Value)
+		}
+	}
+}
+
+func TestReadArchive_Invalid(t *testing.T) {
+	_, err := ReadArchive(strings.NewReader(`{"format":"other","version":1}`))
+	assert.ErrorContains(t, err, "unexpected archive format")
+
+	_, err = ReadArchive(strings.NewReader(`{"format":"snetd-storage-archive","version":2}`))
+	assert.ErrorContains(t, err, "unsupported archive version")
+
+	_, err = ReadArchive(strings.NewReader(`{"format":"snetd-storage-archive","version":1}` + "\n{broken"))
+	assert.ErrorContains(t, err, "can't read archive entry 0")
+}
+
+func TestParseConflictPolicy(t *testing.T) {
+	policy, err := ParseConflictPolicy("overwrite")
+	assert.NoError(t, err)
+	assert.Equal(t, ConflictOverwrite, policy)
+
+	_, err = ParseConflictPolicy("merge")
+	assert.Error(t, err)
+}
+
+func newTestImportArchive() *Archive {
+	return &Archive{Entries: []ArchiveEntry{
+		{Key: "a", Raw: []byte("1")},
+		{Key: "b", Raw: []byte("2")},
+		{Key: "c", Raw: []byte("3")},
+	}}
+}
+
+func TestImportArchive_DryRun(t *testing.T) {
+	s := NewMemStorage()
+	_ = s.Put("b", "2")
+	_ = s.Put("c", "old")
+
+	result, err := ImportArchive(s, newTestImportArchive(), ConflictOverwrite, true)
+	require.NoError(t, err)
+	assert.Equal(t, &ImportResult{Created: []string{"a"}, Unchanged: []string{"b"}, Overwritten: []string{"c"}}, result)
+
+	_, ok, _ := s.Get("a")
+	assert.False(t, ok, "dry run must not write to the storage")
+	c, _, _ := s.Get("c")
+	assert.Equal(t, "old", c)
+}
+
+func TestImportArchive_ConflictPolicies(t *testing.T) {
+	s := NewMemStorage()
+	_ = s.Put("c", "old")
+
+	result, err := ImportArchive(s, newTestImportArchive(), ConflictSkip, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a", "b"}, result.Created)
+	assert.Equal(t, []string{"c"}, result.Skipped)
+	c, _, _ := s.Get("c")
+	assert.Equal(t, "old", c)
+
+	_ = s.Delete("a")
+	_, err = ImportArchive(s, newTestImportArchive(), ConflictFail, false)
+	assert.ErrorContains(t, err, "1 key(s) already exist with different values: c")
+	_, ok, _ := s.Get("a")
+	assert.False(t, ok, "nothing is written when the import fails on conflict")
+
+	result, err = ImportArchive(s, newTestImportArchive(), ConflictOverwrite, false)
+	require.NoError(t, err)
+	assert.Equal(t, []string{"a"}, result.Created)
+	assert.Equal(t, []string{"b"}, result.Unchanged)
+	assert.Equal(t, []string{"c"}, result.Overwritten)
+	c, _, _ = s.Get("c")
+	assert.Equal(t, "3", c)
+}
+
+func TestGetKeyValuesByKeyPrefix(t *testing.T) {
+	base := NewMemStorage()
+	prefixed := NewPrefixedAtomicStorage(base, "/prefix")
+	_ = prefixed.Put("b", "2")
+	_ = prefixed.Put("a", "1")
+	_ = base.Put("/other/c", "3")
+
+	keyValues, err := prefixed.GetKeyValuesByKeyPrefix("")
+	assert.NoError(t, err)
+	assert.Equal(t, []KeyValueData{{Key: "a", Value: "1", Present: true}, {Key: "b", Value: "2", Present: true}}, keyValues)
+
+	keyValues, err = base.GetKeyValuesByKeyPrefix("/")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"/other/c", "/prefix/a", "/prefix/b"}, []string{keyValues[0].Key, keyValues[1].Key, keyValues[2].Key})
+}
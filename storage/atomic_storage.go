@@ -1,8 +1,11 @@
 package storage
 
 import (
+	"context"
 	"reflect"
 	"strings"
+
+	"go.uber.org/zap"
 )
 
 // AtomicStorage is an interface to key-value storage with atomic operations.
@@ -12,6 +15,9 @@ type AtomicStorage interface {
 	Get(key string) (value string, ok bool, err error)
 	// GetByKeyPrefix returns a list of values which keys have given prefix.
 	GetByKeyPrefix(prefix string) (values []string, err error)
+	// GetKeyValuesByKeyPrefix returns keys and values which keys have given
+	// prefix, ordered by key.
+	GetKeyValuesByKeyPrefix(prefix string) (keyValues []KeyValueData, err error)
 	// Put unconditionally writes value by key in storage, err is not nil in
 	// case of storage error.
 	Put(key string, value string) (err error)
@@ -29,6 +35,11 @@ type AtomicStorage interface {
 	StartTransaction(conditionKeys []string) (transaction Transaction, err error)
 	CompleteTransaction(transaction Transaction, update []KeyValueData) (ok bool, err error)
 	ExecuteTransaction(request CASRequest) (ok bool, err error)
+	// Watch returns changes of the keys which have given prefix made after the
+	// call. Present is false for the deleted keys. The channel is closed when
+	// ctx is done or when the watcher doesn't read events fast enough, in the
+	// latter case the caller should re-read the state and watch again.
+	Watch(ctx context.Context, prefix string) (events <-chan KeyValueData, err error)
 }
 
 type Transaction interface {
@@ -73,6 +84,17 @@ func (storage *PrefixedAtomicStorage) GetByKeyPrefix(prefix string) (values []st
 	return storage.delegate.GetByKeyPrefix(storage.keyPrefix + "/" + prefix)
 }
 
+// GetKeyValuesByKeyPrefix is an implementation of
+// AtomicStorage.GetKeyValuesByKeyPrefix, returned keys don't contain the
+// storage prefix
+func (storage *PrefixedAtomicStorage) GetKeyValuesByKeyPrefix(prefix string) (keyValues []KeyValueData, err error) {
+	keyValues, err = storage.delegate.GetKeyValuesByKeyPrefix(storage.keyPrefix + "/" + prefix)
+	if err != nil {
+		return nil, err
+	}
+	return storage.removeKeyValuePrefix(keyValues), nil
+}
+
 // Put is an implementation of AtomicStorage.Put
 func (storage *PrefixedAtomicStorage) Put(key string, value string) (err error) {
 	return storage.delegate.Put(storage.keyPrefix+"/"+key, value)
@@ -168,6 +190,27 @@ func (storage *PrefixedAtomicStorage) ExecuteTransaction(request CASRequest) (ok
 	return storage.delegate.ExecuteTransaction(prefixedRequest)
 }
 
+// Watch is an implementation of AtomicStorage.Watch, keys of the events
+// don't contain the storage prefix
+func (storage *PrefixedAtomicStorage) Watch(ctx context.Context, prefix string) (events <-chan KeyValueData, err error) {
+	delegateEvents, err := storage.delegate.Watch(ctx, storage.keyPrefix+"/"+prefix)
+	if err != nil {
+		return nil, err
+	}
+	unPrefixedEvents := make(chan KeyValueData)
+	go func() {
+		defer close(unPrefixedEvents)
+		for event := range delegateEvents {
+			select {
+			case unPrefixedEvents <- storage.removeKeyValuePrefix([]KeyValueData{event})[0]:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return unPrefixedEvents, nil
+}
+
 // TypedAtomicStorage is an atomic storage that automatically
 // serializes/deserializes values and keys
 type TypedAtomicStorage interface {
@@ -185,6 +228,11 @@ type TypedAtomicStorage interface {
 	// Delete removes value by key
 	Delete(key any) (err error)
 	ExecuteTransaction(request TypedCASRequest) (ok bool, err error)
+	// Watch returns changes of the values made after the call. Key of the
+	// event is the serialized key, Value is nil for the deleted keys. The
+	// channel is closed the same way as the one returned by
+	// AtomicStorage.Watch.
+	Watch(ctx context.Context) (events <-chan TypedKeyValueData, err error)
 }
 
 type TypedTransaction interface {
@@ -331,7 +379,54 @@ func (storage *TypedAtomicStorageImpl) CompareAndSwap(key any, prevValue any, ne
 		return
 	}
 
-	return storage.atomicStorage.CompareAndSwap(keyString, prevValueString, newValueString)
+	ok, err = storage.atomicStorage.CompareAndSwap(keyString, prevValueString, newValueString)
+	if ok || err != nil {
+		return
+	}
+
+	// the stored value may be equal to prevValue but written in another
+	// encoding (i.e. before the encoding was changed), compare decoded values
+	currentString, present, err := storage.atomicStorage.Get(keyString)
+	if err != nil || !present || currentString == prevValueString {
+		return false, err
+	}
+	current, err := storage.deserializeValue(currentString)
+	if err != nil {
+		return false, nil
+	}
+	reencoded, err := storage.valueSerializer(current)
+	if err != nil || reencoded != prevValueString {
+		return false, err
+	}
+	return storage.atomicStorage.CompareAndSwap(keyString, currentString, newValueString)
+}
+
+// Watch implements TypedAtomicStorage.Watch, values which can't be
+// deserialized are skipped
+func (storage *TypedAtomicStorageImpl) Watch(ctx context.Context) (events <-chan TypedKeyValueData, err error) {
+	stringEvents, err := storage.atomicStorage.Watch(ctx, "")
+	if err != nil {
+		return nil, err
+	}
+	typedEvents := make(chan TypedKeyValueData)
+	go func() {
+		defer close(typedEvents)
+		for event := range stringEvents {
+			typed := TypedKeyValueData{Key: event.Key, Present: event.Present}
+			if event.Present {
+				if typed.Value, err = storage.deserializeValue(event.Value); err != nil {
+					zap.L().Warn("unable to deserialize watched value", zap.String("key", event.Key), zap.Error(err))
+					continue
+				}
+			}
+			select {
+			case typedEvents <- typed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+	return typedEvents, nil
 }
 
 func (storage *TypedAtomicStorageImpl) Delete(key any) (err error) {
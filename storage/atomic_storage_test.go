@@ -3,6 +3,7 @@ package storage
 import (
 	"fmt"
 	"reflect"
+	"strings"
 	"testing"
 
 	"github.com/stretchr/testify/assert"
@@ -281,3 +282,25 @@ func TestPrefixRoundTrip_AppendThenRemove(t *testing.T) {
 	back := s.removeKeyValuePrefix(with)
 	assert.Equal(t, orig, back)
 }
+
+func TestTypedCompareAndSwap_PreviousEncoding(t *testing.T) {
+	memStorage := NewMemStorage()
+	typed := NewTypedAtomicStorageImpl(memStorage, dummyKeySerializer, reflect.TypeOf(""),
+		func(value any) (string, error) { return "v2:" + *value.(*string), nil },
+		func(serialized string, value any) error {
+			*value.(*string) = strings.TrimPrefix(strings.TrimPrefix(serialized, "v1:"), "v2:")
+			return nil
+		}, reflect.TypeOf(""))
+	_ = memStorage.Put("key", "v1:old")
+
+	prev, next, wrong := "old", "new", "other"
+	ok, err := typed.CompareAndSwap("key", &wrong, &next)
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = typed.CompareAndSwap("key", &prev, &next)
+	assert.NoError(t, err)
+	assert.True(t, ok, "value in the previous encoding must be compared by its decoded content")
+	raw, _, _ := memStorage.Get("key")
+	assert.Equal(t, "v2:new", raw)
+}
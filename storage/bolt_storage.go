@@ -0,0 +1,324 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/spf13/viper"
+	bolt "go.etcd.io/bbolt"
+	"go.uber.org/zap"
+)
+
+var (
+	boltDataBucket     = []byte("data")
+	boltRevisionBucket = []byte("revision")
+)
+
+// BoltStorageConf contains settings of the embedded file storage
+// Path    - path to the database file, created if absent
+// Timeout - how long to wait for the file lock held by another process
+type BoltStorageConf struct {
+	Path    string        `json:"path" mapstructure:"path"`
+	Timeout time.Duration `json:"timeout" mapstructure:"timeout"`
+}
+
+// GetBoltStorageConf reads BoltStorageConf from the given sub-config key of
+// viper, missing fields are filled with defaults.
+func GetBoltStorageConf(vip *viper.Viper, key string) (conf *BoltStorageConf, err error) {
+	conf = &BoltStorageConf{
+		Path:    "snetd-storage.db",
+		Timeout: 5 * time.Second,
+	}
+	if vip == nil || !vip.IsSet(key) {
+		return
+	}
+	err = vip.UnmarshalKey(key, conf)
+	return
+}
+
+// BoltStorage is an AtomicStorage implementation which keeps all keys in a
+// single bbolt file. It is intended for daemons running as a single replica
+// which need payments to survive restarts without operating etcd. Each key
+// carries a revision which is used to implement CAS transactions the same
+// way etcd ModRevision is used by EtcdClient.
+type BoltStorage struct {
+	db       *bolt.DB
+	watchers watchHub
+}
+
+var _ AtomicStorage = (*BoltStorage)(nil)
+
+// NewBoltStorage opens (or creates) the bbolt database described by conf.
+func NewBoltStorage(conf *BoltStorageConf) (storage *BoltStorage, err error) {
+	if dir := filepath.Dir(conf.Path); dir != "" {
+		if err = os.MkdirAll(dir, 0700); err != nil {
+			return nil, fmt.Errorf("can't create storage directory: %w", err)
+		}
+	}
+
+	db, err := bolt.Open(conf.Path, 0600, &bolt.Options{Timeout: conf.Timeout})
+	if err != nil {
+		return nil, fmt.Errorf("can't open storage file %v: %w", conf.Path, err)
+	}
+
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(boltDataBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(boltRevisionBucket)
+		return err
+	})
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("can't initialize storage file %v: %w", conf.Path, err)
+	}
+
+	zap.L().Info("Payment storage opened (bolt)", zap.String("path", conf.Path))
+	return &BoltStorage{db: db}, nil
+}
+
+// Close releases the database file
+func (storage *BoltStorage) Close() {
+	if err := storage.db.Close(); err != nil {
+		zap.L().Error("close bolt storage failed", zap.Error(err))
+	}
+}
+
+func (storage *BoltStorage) Get(key string) (value string, ok bool, err error) {
+	err = storage.db.View(func(tx *bolt.Tx) error {
+		value, ok = boltGet(tx, key)
+		return nil
+	})
+	return
+}
+
+func (storage *BoltStorage) GetByKeyPrefix(prefix string) (values []string, err error) {
+	err = storage.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltDataBucket).Cursor()
+		p := []byte(prefix)
+		for k, v := cursor.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = cursor.Next() {
+			values = append(values, string(v))
+		}
+		return nil
+	})
+	return
+}
+
+func (storage *BoltStorage) GetKeyValuesByKeyPrefix(prefix string) (keyValues []KeyValueData, err error) {
+	err = storage.db.View(func(tx *bolt.Tx) error {
+		cursor := tx.Bucket(boltDataBucket).Cursor()
+		p := []byte(prefix)
+		for k, v := cursor.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = cursor.Next() {
+			keyValues = append(keyValues, KeyValueData{Key: string(k), Value: string(v), Present: true})
+		}
+		return nil
+	})
+	return
+}
+
+func (storage *BoltStorage) Put(key string, value string) (err error) {
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		return storage.put(tx, key, value)
+	})
+}
+
+func (storage *BoltStorage) PutIfAbsent(key string, value string) (ok bool, err error) {
+	err = storage.db.Update(func(tx *bolt.Tx) error {
+		if _, present := boltGet(tx, key); present {
+			return nil
+		}
+		ok = true
+		return storage.put(tx, key, value)
+	})
+	return ok && err == nil, err
+}
+
+func (storage *BoltStorage) CompareAndSwap(key string, prevValue string, newValue string) (ok bool, err error) {
+	err = storage.db.Update(func(tx *bolt.Tx) error {
+		current, present := boltGet(tx, key)
+		if !present || current != prevValue {
+			return nil
+		}
+		ok = true
+		return storage.put(tx, key, newValue)
+	})
+	return ok && err == nil, err
+}
+
+func (storage *BoltStorage) Delete(key string) (err error) {
+	return storage.db.Update(func(tx *bolt.Tx) error {
+		if _, present := boltGet(tx, key); !present {
+			return nil
+		}
+		if err := tx.Bucket(boltDataBucket).Delete([]byte(key)); err != nil {
+			return err
+		}
+		tx.OnCommit(func() { storage.watchers.notify(KeyValueData{Key: key}) })
+		return tx.Bucket(boltRevisionBucket).Delete([]byte(key))
+	})
+}
+
+func (storage *BoltStorage) StartTransaction(conditionKeys []string) (transaction Transaction, err error) {
+	boltTransaction := &boltStorageTransaction{ConditionKeys: conditionKeys}
+	err = storage.db.View(func(tx *bolt.Tx) error {
+		boltTransaction.ConditionValues = boltReadRevisions(tx, conditionKeys)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return boltTransaction, nil
+}
+
+// CompleteTransaction applies update if none of the condition keys has been
+// changed since the transaction was started or last completed. On conflict,
+// ok is false and the condition values are refreshed for the next attempt.
+func (storage *BoltStorage) CompleteTransaction(_transaction Transaction, update []KeyValueData) (ok bool, err error) {
+	transaction, okType := _transaction.(*boltStorageTransaction)
+	if !okType {
+		return false, fmt.Errorf("unexpected transaction type: %T", _transaction)
+	}
+
+	err = storage.db.Update(func(tx *bolt.Tx) error {
+		for _, condition := range transaction.ConditionValues {
+			revision, present := boltRevision(tx, condition.Key)
+			if present != condition.Present || revision != condition.Revision {
+				transaction.ConditionValues = boltReadRevisions(tx, transaction.ConditionKeys)
+				return nil
+			}
+		}
+		for _, keyValue := range update {
+			if err := storage.put(tx, keyValue.Key, keyValue.Value); err != nil {
+				return err
+			}
+		}
+		ok = true
+		return nil
+	})
+	return ok && err == nil, err
+}
+
+// ExecuteTransaction executes a transaction on the storage
+func (storage *BoltStorage) ExecuteTransaction(request CASRequest) (ok bool, err error) {
+	transaction, err := storage.StartTransaction(request.ConditionKeys)
+	if err != nil {
+		return false, err
+	}
+
+	maxRetries := 100
+	for attempts := 0; attempts < maxRetries; attempts++ {
+		oldValues, err := transaction.GetConditionValues()
+		if err != nil {
+			return false, err
+		}
+		newValues, ok, err := request.Update(oldValues)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			if request.RetryTillSuccessOrError {
+				continue
+			}
+			return false, nil
+		}
+		ok, err = storage.CompleteTransaction(transaction, newValues)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+		if !request.RetryTillSuccessOrError {
+			return false, nil
+		}
+	}
+	return false, nil
+}
+
+// Watch is an implementation of AtomicStorage.Watch, only changes made
+// through this storage instance are sent
+func (storage *BoltStorage) Watch(ctx context.Context, prefix string) (events <-chan KeyValueData, err error) {
+	return storage.watchers.watch(ctx, prefix), nil
+}
+
+// put writes the key and notifies watchers when the transaction is committed
+func (storage *BoltStorage) put(tx *bolt.Tx, key string, value string) error {
+	if err := boltPut(tx, key, value); err != nil {
+		return err
+	}
+	tx.OnCommit(func() {
+		storage.watchers.notify(KeyValueData{Key: key, Value: value, Present: true})
+	})
+	return nil
+}
+
+func boltGet(tx *bolt.Tx, key string) (value string, ok bool) {
+	v := tx.Bucket(boltDataBucket).Get([]byte(key))
+	if v == nil {
+		return "", false
+	}
+	return string(v), true
+}
+
+func boltRevision(tx *bolt.Tx, key string) (revision uint64, ok bool) {
+	v := tx.Bucket(boltRevisionBucket).Get([]byte(key))
+	if v == nil {
+		return 0, false
+	}
+	return binary.BigEndian.Uint64(v), true
+}
+
+func boltPut(tx *bolt.Tx, key string, value string) error {
+	data := tx.Bucket(boltDataBucket)
+	revision, err := data.NextSequence()
+	if err != nil {
+		return err
+	}
+	if err = data.Put([]byte(key), []byte(value)); err != nil {
+		return err
+	}
+	encoded := make([]byte, 8)
+	binary.BigEndian.PutUint64(encoded, revision)
+	return tx.Bucket(boltRevisionBucket).Put([]byte(key), encoded)
+}
+
+func boltReadRevisions(tx *bolt.Tx, keys []string) []boltKeyValueRevision {
+	values := make([]boltKeyValueRevision, len(keys))
+	for i, key := range keys {
+		values[i].Key = key
+		values[i].Value, values[i].Present = boltGet(tx, key)
+		values[i].Revision, _ = boltRevision(tx, key)
+	}
+	return values
+}
+
+type boltKeyValueRevision struct {
+	Key      string
+	Value    string
+	Present  bool
+	Revision uint64
+}
+
+type boltStorageTransaction struct {
+	ConditionValues []boltKeyValueRevision
+	ConditionKeys   []string
+}
+
+var _ Transaction = (*boltStorageTransaction)(nil)
+
+func (transaction *boltStorageTransaction) GetConditionValues() ([]KeyValueData, error) {
+	values := make([]KeyValueData, len(transaction.ConditionValues))
+	for i, value := range transaction.ConditionValues {
+		values[i] = KeyValueData{
+			Key:     value.Key,
+			Value:   value.Value,
+			Present: value.Present,
+		}
+	}
+	return values, nil
+}
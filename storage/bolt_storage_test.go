@@ -0,0 +1,162 @@
+package storage
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestBoltStorage(t *testing.T) *BoltStorage {
+	s, err := NewBoltStorage(&BoltStorageConf{Path: filepath.Join(t.TempDir(), "test.db")})
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestBoltPutGetAndPrefix(t *testing.T) {
+	s := newTestBoltStorage(t)
+
+	assert.NoError(t, s.Put("user:1", "Alice"))
+	assert.NoError(t, s.Put("user:2", "Bob"))
+	assert.NoError(t, s.Put("order:1", "XYZ"))
+
+	val, ok, err := s.Get("user:1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "Alice", val)
+
+	_, ok, err = s.Get("user:3")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	users, err := s.GetByKeyPrefix("user:")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Alice", "Bob"}, users)
+
+	assert.NoError(t, s.Delete("user:1"))
+	_, ok, _ = s.Get("user:1")
+	assert.False(t, ok)
+}
+
+func TestBoltPutIfAbsentAndCompareAndSwap(t *testing.T) {
+	s := newTestBoltStorage(t)
+
+	ok, err := s.PutIfAbsent("k", "old")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = s.PutIfAbsent("k", "other")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = s.CompareAndSwap("k", "wrong", "new")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = s.CompareAndSwap("k", "old", "new")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	val, _, _ := s.Get("k")
+	assert.Equal(t, "new", val)
+}
+
+func TestBoltCompleteTransactionConflict(t *testing.T) {
+	s := newTestBoltStorage(t)
+	_ = s.Put("x", "1")
+
+	transaction, err := s.StartTransaction([]string{"x", "y"})
+	require.NoError(t, err)
+	values, _ := transaction.GetConditionValues()
+	assert.Equal(t, []KeyValueData{{Key: "x", Value: "1", Present: true}, {Key: "y"}}, values)
+
+	// the same value written again still bumps the revision
+	_ = s.Put("x", "1")
+
+	ok, err := s.CompleteTransaction(transaction, []KeyValueData{{Key: "x", Value: "2", Present: true}})
+	assert.NoError(t, err)
+	assert.False(t, ok, "Transaction should fail when condition key was modified")
+
+	ok, err = s.CompleteTransaction(transaction, []KeyValueData{{Key: "x", Value: "2", Present: true},
+		{Key: "y", Value: "3", Present: true}})
+	assert.NoError(t, err)
+	assert.True(t, ok, "Transaction should succeed with refreshed condition values")
+
+	x, _, _ := s.Get("x")
+	y, _, _ := s.Get("y")
+	assert.Equal(t, "2", x)
+	assert.Equal(t, "3", y)
+}
+
+func TestBoltExecuteTransaction_RetryUntilSuccess(t *testing.T) {
+	s := newTestBoltStorage(t)
+	_ = s.Put("x", "1")
+
+	attempts := 0
+	req := CASRequest{
+		ConditionKeys:           []string{"x"},
+		RetryTillSuccessOrError: true,
+		Update: func(old []KeyValueData) ([]KeyValueData, bool, error) {
+			attempts++
+			if attempts == 1 {
+				// concurrent writer changes the key between read and write
+				_ = s.Put("x", "5")
+			}
+			return []KeyValueData{{Key: "x", Value: old[0].Value + "0", Present: true}}, true, nil
+		},
+	}
+
+	ok, err := s.ExecuteTransaction(req)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 2, attempts)
+
+	value, _, _ := s.Get("x")
+	assert.Equal(t, "50", value)
+}
+
+func TestBoltStoragePersistence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "persist.db")
+
+	s, err := NewBoltStorage(&BoltStorageConf{Path: path})
+	require.NoError(t, err)
+	_ = s.Put("channel", "state")
+	s.Close()
+
+	s, err = NewBoltStorage(&BoltStorageConf{Path: path})
+	require.NoError(t, err)
+	defer s.Close()
+	value, ok, err := s.Get("channel")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "state", value)
+}
+
+func TestGetBoltStorageConf(t *testing.T) {
+	vip := viper.New()
+	conf, err := GetBoltStorageConf(vip, "payment_channel_storage_bolt")
+	assert.NoError(t, err)
+	assert.Equal(t, "snetd-storage.db", conf.Path)
+
+	vip.Set("payment_channel_storage_bolt", map[string]any{"path": "/data/snetd.db", "timeout": "1s"})
+	conf, err = GetBoltStorageConf(vip, "payment_channel_storage_bolt")
+	assert.NoError(t, err)
+	assert.Equal(t, "/data/snetd.db", conf.Path)
+	assert.Equal(t, "1s", conf.Timeout.String())
+}
+
+func TestBoltGetKeyValuesByKeyPrefix(t *testing.T) {
+	s := newTestBoltStorage(t)
+	_ = s.Put("user:2", "Bob")
+	_ = s.Put("user:1", "Alice")
+	_ = s.Put("order:1", "XYZ")
+
+	keyValues, err := s.GetKeyValuesByKeyPrefix("user:")
+	assert.NoError(t, err)
+	assert.Equal(t, []KeyValueData{{Key: "user:1", Value: "Alice", Present: true}, {Key: "user:2", Value: "Bob", Present: true}}, keyValues)
+}
+
+func TestBoltWatch(t *testing.T) {
+	testStorageWatch(t, newTestBoltStorage(t))
+}
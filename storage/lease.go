@@ -0,0 +1,56 @@
+package storage
+
+import (
+	"errors"
+	"time"
+)
+
+// Lease is a lease of the key written by LeaseStorage. The storage removes
+// the key when the lease is neither kept alive nor revoked in time.
+type Lease interface {
+	// KeepAlive extends the lease by its TTL, it returns an error if the lease
+	// is expired already.
+	KeepAlive() (err error)
+	// Revoke removes the lease and the key attached to it.
+	Revoke() (err error)
+}
+
+// LeaseStorage is implemented by storages which can expire keys natively,
+// for example etcd.
+type LeaseStorage interface {
+	// CompareAndSwapWithLease atomically replaces prevValue by newValue and
+	// attaches the key to a new lease with given ttl. If prevPresent is false
+	// the key is expected to be absent. ok is false if the current value
+	// doesn't match.
+	CompareAndSwapWithLease(key string, prevValue string, prevPresent bool, newValue string,
+		ttl time.Duration) (lease Lease, ok bool, err error)
+}
+
+var errLeasesNotSupported = errors.New("storage doesn't support leases")
+
+// LeaseStorageOf returns LeaseStorage if the storage or the storage it
+// decorates supports leases and nil otherwise.
+func LeaseStorageOf(storage AtomicStorage) LeaseStorage {
+	switch s := storage.(type) {
+	case *PrefixedAtomicStorage:
+		if LeaseStorageOf(s.delegate) == nil {
+			return nil
+		}
+		return s
+	case LeaseStorage:
+		return s
+	}
+	return nil
+}
+
+// CompareAndSwapWithLease is an implementation of
+// LeaseStorage.CompareAndSwapWithLease, it fails if the decorated storage
+// doesn't support leases.
+func (storage *PrefixedAtomicStorage) CompareAndSwapWithLease(key string, prevValue string, prevPresent bool,
+	newValue string, ttl time.Duration) (lease Lease, ok bool, err error) {
+	delegate := LeaseStorageOf(storage.delegate)
+	if delegate == nil {
+		return nil, false, errLeasesNotSupported
+	}
+	return delegate.CompareAndSwapWithLease(storage.keyPrefix+"/"+key, prevValue, prevPresent, newValue, ttl)
+}
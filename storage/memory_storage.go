@@ -1,13 +1,16 @@
 package storage
 
 import (
+	"context"
+	"sort"
 	"strings"
 	"sync"
 )
 
 type MemoryStorage struct {
-	data  map[string]string
-	mutex *sync.RWMutex
+	data     map[string]string
+	mutex    *sync.RWMutex
+	watchers watchHub
 }
 
 // NewMemStorage returns a new in-memory atomic storage implementation
@@ -27,6 +30,7 @@ func (storage *MemoryStorage) Put(key, value string) (err error) {
 
 func (storage *MemoryStorage) unsafePut(key, value string) (err error) {
 	storage.data[key] = value
+	storage.watchers.notify(KeyValueData{Key: key, Value: value, Present: true})
 	return nil
 }
 
@@ -50,6 +54,20 @@ func (storage *MemoryStorage) GetByKeyPrefix(prefix string) (values []string, er
 	return
 }
 
+func (storage *MemoryStorage) GetKeyValuesByKeyPrefix(prefix string) (keyValues []KeyValueData, err error) {
+	storage.mutex.RLock()
+	defer storage.mutex.RUnlock()
+
+	for key, value := range storage.data {
+		if strings.HasPrefix(key, prefix) {
+			keyValues = append(keyValues, KeyValueData{Key: key, Value: value, Present: true})
+		}
+	}
+	sort.Slice(keyValues, func(i, j int) bool { return keyValues[i].Key < keyValues[j].Key })
+
+	return
+}
+
 func (storage *MemoryStorage) unsafeGet(key string) (value string, ok bool, err error) {
 	value, ok = storage.data[key]
 	if !ok {
@@ -94,7 +112,10 @@ func (storage *MemoryStorage) Delete(key string) (err error) {
 	storage.mutex.Lock()
 	defer storage.mutex.Unlock()
 
-	delete(storage.data, key)
+	if _, ok := storage.data[key]; ok {
+		delete(storage.data, key)
+		storage.watchers.notify(KeyValueData{Key: key})
+	}
 
 	return
 }
@@ -220,3 +241,9 @@ func (transaction *memoryStorageTransaction) GetConditionValues() ([]KeyValueDat
 	}
 	return values, nil
 }
+
+// Watch is an implementation of AtomicStorage.Watch, events are sent under
+// the storage lock, so watchers see changes in the order they are made
+func (storage *MemoryStorage) Watch(ctx context.Context, prefix string) (events <-chan KeyValueData, err error) {
+	return storage.watchers.watch(ctx, prefix), nil
+}
@@ -0,0 +1,501 @@
+package storage
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/spf13/viper"
+	"go.uber.org/zap"
+
+	"github.com/lib/pq"
+	"modernc.org/sqlite"
+	sqlite3 "modernc.org/sqlite/lib"
+)
+
+// SQLStorageConf contains settings of the database/sql based storage
+// Driver - "sqlite" (pure Go, for local usage and tests) or "postgres"
+// DSN    - data source name passed to the driver as is
+type SQLStorageConf struct {
+	Driver string `json:"driver" mapstructure:"driver"`
+	DSN    string `json:"dsn" mapstructure:"dsn"`
+}
+
+// GetSQLStorageConf reads SQLStorageConf from the given sub-config key of viper
+func GetSQLStorageConf(vip *viper.Viper, key string) (conf *SQLStorageConf, err error) {
+	conf = &SQLStorageConf{
+		Driver: "sqlite",
+		DSN:    "snetd-storage.sqlite",
+	}
+	if vip == nil || !vip.IsSet(key) {
+		return
+	}
+	err = vip.UnmarshalKey(key, conf)
+	return
+}
+
+type sqlDialect struct {
+	blobType string
+	// key column type, keys are compared byte by byte to select them by prefix
+	keyType string
+	// numbered placeholders ($1, $2, ...) instead of ?
+	numbered bool
+	// row locking clause used to read condition keys inside a transaction
+	forUpdate string
+}
+
+var sqlDialects = map[string]sqlDialect{
+	"sqlite":   {blobType: "BLOB", keyType: "TEXT"},
+	"postgres": {blobType: "BYTEA", keyType: `TEXT COLLATE "C"`, numbered: true, forUpdate: " FOR UPDATE"},
+}
+
+// SQLStorage is an AtomicStorage implementation on top of database/sql. All
+// keys are kept in a single table, each row has a version which is
+// incremented on every write, CAS transactions compare versions of condition
+// keys the same way EtcdClient compares ModRevision.
+type SQLStorage struct {
+	db       *sql.DB
+	dialect  sqlDialect
+	watchers watchHub
+}
+
+var _ AtomicStorage = (*SQLStorage)(nil)
+
+// NewSQLStorage connects to the database and creates the storage table if it
+// doesn't exist.
+func NewSQLStorage(conf *SQLStorageConf) (storage *SQLStorage, err error) {
+	dialect, ok := sqlDialects[conf.Driver]
+	if !ok {
+		return nil, fmt.Errorf("unsupported sql storage driver: %v", conf.Driver)
+	}
+
+	db, err := sql.Open(conf.Driver, conf.DSN)
+	if err != nil {
+		return nil, fmt.Errorf("can't open sql storage: %w", err)
+	}
+	if conf.Driver == "sqlite" {
+		// sqlite allows only one writer, a single connection serializes
+		// transactions instead of failing them with SQLITE_BUSY
+		db.SetMaxOpenConns(1)
+	}
+
+	storage = &SQLStorage{db: db, dialect: dialect}
+	_, err = db.Exec(fmt.Sprintf(`CREATE TABLE IF NOT EXISTS snetd_storage (
+		storage_key %s PRIMARY KEY,
+		storage_value %s NOT NULL,
+		version BIGINT NOT NULL)`, dialect.keyType, dialect.blobType))
+	if err != nil {
+		_ = db.Close()
+		return nil, fmt.Errorf("can't create sql storage table: %w", err)
+	}
+
+	zap.L().Info("Payment storage opened (sql)", zap.String("driver", conf.Driver))
+	return storage, nil
+}
+
+// Close closes the database connections
+func (storage *SQLStorage) Close() {
+	if err := storage.db.Close(); err != nil {
+		zap.L().Error("close sql storage failed", zap.Error(err))
+	}
+}
+
+// rebind replaces ? placeholders by the ones supported by the driver
+func (storage *SQLStorage) rebind(query string) string {
+	if !storage.dialect.numbered {
+		return query
+	}
+	var builder strings.Builder
+	n := 0
+	for _, r := range query {
+		if r == '?' {
+			n++
+			builder.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		builder.WriteRune(r)
+	}
+	return builder.String()
+}
+
+// sqlExecutor is implemented by both *sql.DB and *sql.Tx
+type sqlExecutor interface {
+	Exec(query string, args ...any) (sql.Result, error)
+	QueryRow(query string, args ...any) *sql.Row
+}
+
+func (storage *SQLStorage) get(executor sqlExecutor, key string) (value string, version int64, ok bool, err error) {
+	return storage.query(executor, "SELECT storage_value, version FROM snetd_storage WHERE storage_key = ?", key)
+}
+
+func (storage *SQLStorage) query(executor sqlExecutor, query string, key string) (value string, version int64, ok bool, err error) {
+	var raw []byte
+	err = executor.QueryRow(storage.rebind(query), key).Scan(&raw, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", 0, false, nil
+	}
+	if err != nil {
+		zap.L().Error("Unable to get value by key", zap.Error(err), zap.String("key", key))
+		return "", 0, false, err
+	}
+	return string(raw), version, true, nil
+}
+
+func (storage *SQLStorage) Get(key string) (value string, ok bool, err error) {
+	value, _, ok, err = storage.get(storage.db, key)
+	return
+}
+
+func (storage *SQLStorage) GetByKeyPrefix(prefix string) (values []string, err error) {
+	condition, args := keyPrefixCondition(prefix)
+	rows, err := storage.db.Query(storage.rebind(
+		"SELECT storage_value FROM snetd_storage WHERE "+condition+" ORDER BY storage_key"), args...)
+	if err != nil {
+		zap.L().Error("Unable to get value by key prefix", zap.Error(err), zap.String("prefix", prefix))
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var raw []byte
+		if err = rows.Scan(&raw); err != nil {
+			return nil, err
+		}
+		values = append(values, string(raw))
+	}
+	return values, rows.Err()
+}
+
+func (storage *SQLStorage) GetKeyValuesByKeyPrefix(prefix string) (keyValues []KeyValueData, err error) {
+	condition, args := keyPrefixCondition(prefix)
+	rows, err := storage.db.Query(storage.rebind(
+		"SELECT storage_key, storage_value FROM snetd_storage WHERE "+condition+" ORDER BY storage_key"), args...)
+	if err != nil {
+		zap.L().Error("Unable to get key values by key prefix", zap.Error(err), zap.String("prefix", prefix))
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var key string
+		var raw []byte
+		if err = rows.Scan(&key, &raw); err != nil {
+			return nil, err
+		}
+		keyValues = append(keyValues, KeyValueData{Key: key, Value: string(raw), Present: true})
+	}
+	return keyValues, rows.Err()
+}
+
+func (storage *SQLStorage) put(executor sqlExecutor, key string, value string) (err error) {
+	_, err = executor.Exec(storage.rebind(`INSERT INTO snetd_storage (storage_key, storage_value, version) VALUES (?, ?, 1)
+		ON CONFLICT (storage_key) DO UPDATE SET storage_value = excluded.storage_value, version = snetd_storage.version + 1`),
+		key, []byte(value))
+	return
+}
+
+func (storage *SQLStorage) Put(key string, value string) (err error) {
+	if err = storage.put(storage.db, key, value); err != nil {
+		zap.L().Error("Unable to put value by key", zap.Error(err), zap.String("key", key))
+		return
+	}
+	storage.watchers.notify(KeyValueData{Key: key, Value: value, Present: true})
+	return
+}
+
+func (storage *SQLStorage) insert(executor sqlExecutor, key string, value string) (ok bool, err error) {
+	result, err := executor.Exec(storage.rebind(`INSERT INTO snetd_storage (storage_key, storage_value, version) VALUES (?, ?, 1)
+		ON CONFLICT (storage_key) DO NOTHING`), key, []byte(value))
+	if isKeyConflict(err) {
+		return false, nil
+	}
+	return affectedOne(result, err)
+}
+
+// lockAbsent inserts the placeholder of the absent key with version 0, a
+// concurrent transaction inserting the key waits until the placeholder is
+// removed. ok is false if the key is inserted already.
+func (storage *SQLStorage) lockAbsent(tx *sql.Tx, key string) (ok bool, err error) {
+	result, err := tx.Exec(storage.rebind(
+		"INSERT INTO snetd_storage (storage_key, storage_value, version) VALUES (?, ?, 0)"), key, []byte{})
+	if isKeyConflict(err) {
+		return false, nil
+	}
+	return affectedOne(result, err)
+}
+
+// isKeyConflict returns true if err is a violation of the storage_key
+// primary key, i.e. the key is inserted by a concurrent transaction
+func isKeyConflict(err error) bool {
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return pqErr.Code == "23505"
+	}
+	var sqliteErr *sqlite.Error
+	if errors.As(err, &sqliteErr) {
+		return sqliteErr.Code() == sqlite3.SQLITE_CONSTRAINT_PRIMARYKEY
+	}
+	return false
+}
+
+func (storage *SQLStorage) PutIfAbsent(key string, value string) (ok bool, err error) {
+	if ok, err = storage.insert(storage.db, key, value); ok {
+		storage.watchers.notify(KeyValueData{Key: key, Value: value, Present: true})
+	}
+	return
+}
+
+func (storage *SQLStorage) CompareAndSwap(key string, prevValue string, newValue string) (ok bool, err error) {
+	result, err := storage.db.Exec(storage.rebind(
+		"UPDATE snetd_storage SET storage_value = ?, version = version + 1 WHERE storage_key = ? AND storage_value = ?"),
+		[]byte(newValue), key, []byte(prevValue))
+	if ok, err = affectedOne(result, err); ok {
+		storage.watchers.notify(KeyValueData{Key: key, Value: newValue, Present: true})
+	}
+	return
+}
+
+func (storage *SQLStorage) Delete(key string) (err error) {
+	result, err := storage.db.Exec(storage.rebind("DELETE FROM snetd_storage WHERE storage_key = ?"), key)
+	deleted, err := affectedOne(result, err)
+	if deleted && err == nil {
+		storage.watchers.notify(KeyValueData{Key: key})
+	}
+	return
+}
+
+// keyPrefixCondition selects keys starting with the prefix by the key range,
+// so the primary key index is used
+func keyPrefixCondition(prefix string) (condition string, args []any) {
+	if upper, ok := keyPrefixEnd(prefix); ok {
+		return "storage_key >= ? AND storage_key < ?", []any{prefix, upper}
+	}
+	return "storage_key >= ?", []any{prefix}
+}
+
+// keyPrefixEnd returns the least key which is greater than all keys starting
+// with the prefix. The last rune is incremented instead of the last byte to
+// keep the key valid UTF-8, the order of UTF-8 strings is the same as the
+// order of their runes. ok is false if there is no such key, i.e. the prefix
+// is empty.
+func keyPrefixEnd(prefix string) (end string, ok bool) {
+	for len(prefix) > 0 {
+		last, size := utf8.DecodeLastRuneInString(prefix)
+		if last == utf8.RuneError && size == 1 {
+			// not valid UTF-8, the last byte is incremented
+			if b := prefix[len(prefix)-1]; b < 0xff {
+				return prefix[:len(prefix)-1] + string([]byte{b + 1}), true
+			}
+		}
+		prefix = prefix[:len(prefix)-size]
+		switch {
+		case last == utf8.MaxRune || (last == utf8.RuneError && size == 1):
+			continue
+		case last == 0xd7ff:
+			// surrogates can't be encoded in UTF-8
+			return prefix + "\ue000", true
+		default:
+			return prefix + string(last+1), true
+		}
+	}
+	return "", false
+}
+
+// Watch is an implementation of AtomicStorage.Watch, only changes made
+// through this storage instance are sent
+func (storage *SQLStorage) Watch(ctx context.Context, prefix string) (events <-chan KeyValueData, err error) {
+	return storage.watchers.watch(ctx, prefix), nil
+}
+
+func affectedOne(result sql.Result, err error) (ok bool, _ error) {
+	if err != nil {
+		return false, err
+	}
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+	return affected == 1, nil
+}
+
+func (storage *SQLStorage) readVersions(executor sqlExecutor, keys []string) ([]sqlKeyValueVersion, error) {
+	values := make([]sqlKeyValueVersion, len(keys))
+	for i, key := range keys {
+		value, version, ok, err := storage.get(executor, key)
+		if err != nil {
+			return nil, err
+		}
+		values[i] = sqlKeyValueVersion{Key: key, Value: value, Present: ok, Version: version}
+	}
+	return values, nil
+}
+
+func (storage *SQLStorage) StartTransaction(conditionKeys []string) (transaction Transaction, err error) {
+	values, err := storage.readVersions(storage.db, conditionKeys)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStorageTransaction{ConditionKeys: conditionKeys, ConditionValues: values}, nil
+}
+
+// CompleteTransaction applies update in a single database transaction if
+// versions of all condition keys are the same as they were read. Updated keys
+// are written with version checks, so a concurrent writer makes the
+// transaction fail instead of being overwritten. Absent condition keys are
+// locked by a placeholder, so a concurrent insert of the key makes one of the
+// transactions fail. On conflict, ok is false and the condition values are
+// refreshed for the next attempt.
+func (storage *SQLStorage) CompleteTransaction(_transaction Transaction, update []KeyValueData) (ok bool, err error) {
+	transaction, okType := _transaction.(*sqlStorageTransaction)
+	if !okType {
+		return false, fmt.Errorf("unexpected transaction type: %T", _transaction)
+	}
+
+	tx, err := storage.db.Begin()
+	if err != nil {
+		return false, err
+	}
+
+	ok, err = storage.applyUpdate(tx, transaction, update)
+	if err != nil || !ok {
+		_ = tx.Rollback()
+		if err != nil {
+			return false, err
+		}
+		transaction.ConditionValues, err = storage.readVersions(storage.db, transaction.ConditionKeys)
+		return false, err
+	}
+	if err = tx.Commit(); err != nil {
+		return false, err
+	}
+	for _, keyValue := range update {
+		storage.watchers.notify(KeyValueData{Key: keyValue.Key, Value: keyValue.Value, Present: true})
+	}
+	return true, nil
+}
+
+func (storage *SQLStorage) applyUpdate(tx *sql.Tx, transaction *sqlStorageTransaction, update []KeyValueData) (ok bool, err error) {
+	conditions := make(map[string]sqlKeyValueVersion, len(transaction.ConditionValues))
+	for _, condition := range transaction.ConditionValues {
+		conditions[condition.Key] = condition
+	}
+
+	updated := make(map[string]bool, len(update))
+	for _, keyValue := range update {
+		updated[keyValue.Key] = true
+		condition, isCondition := conditions[keyValue.Key]
+		switch {
+		case !isCondition:
+			err = storage.put(tx, keyValue.Key, keyValue.Value)
+			ok = err == nil
+		case condition.Present:
+			var result sql.Result
+			result, err = tx.Exec(storage.rebind(
+				"UPDATE snetd_storage SET storage_value = ?, version = version + 1 WHERE storage_key = ? AND version = ?"),
+				[]byte(keyValue.Value), keyValue.Key, condition.Version)
+			ok, err = affectedOne(result, err)
+		default:
+			ok, err = storage.insert(tx, keyValue.Key, keyValue.Value)
+		}
+		if err != nil || !ok {
+			return
+		}
+	}
+
+	// SELECT ... FOR UPDATE doesn't lock the key which is absent, so the
+	// placeholder is inserted instead and removed before the commit
+	var placeholders []string
+	for _, condition := range transaction.ConditionValues {
+		if updated[condition.Key] {
+			continue
+		}
+		if !condition.Present {
+			if ok, err = storage.lockAbsent(tx, condition.Key); err != nil || !ok {
+				return
+			}
+			placeholders = append(placeholders, condition.Key)
+			continue
+		}
+		_, version, present, err := storage.query(tx,
+			"SELECT storage_value, version FROM snetd_storage WHERE storage_key = ?"+storage.dialect.forUpdate, condition.Key)
+		if err != nil {
+			return false, err
+		}
+		if present != condition.Present || version != condition.Version {
+			return false, nil
+		}
+	}
+	for _, key := range placeholders {
+		if _, err = tx.Exec(storage.rebind("DELETE FROM snetd_storage WHERE storage_key = ? AND version = 0"), key); err != nil {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// ExecuteTransaction executes a transaction on the storage
+func (storage *SQLStorage) ExecuteTransaction(request CASRequest) (ok bool, err error) {
+	transaction, err := storage.StartTransaction(request.ConditionKeys)
+	if err != nil {
+		return false, err
+	}
+
+	maxRetries := 100
+	for attempts := 0; attempts < maxRetries; attempts++ {
+		oldValues, err := transaction.GetConditionValues()
+		if err != nil {
+			return false, err
+		}
+		newValues, ok, err := request.Update(oldValues)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			if request.RetryTillSuccessOrError {
+				continue
+			}
+			return false, nil
+		}
+		ok, err = storage.CompleteTransaction(transaction, newValues)
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			return true, nil
+		}
+		if !request.RetryTillSuccessOrError {
+			return false, nil
+		}
+	}
+	return false, nil
+}
+
+type sqlKeyValueVersion struct {
+	Key     string
+	Value   string
+	Present bool
+	Version int64
+}
+
+type sqlStorageTransaction struct {
+	ConditionValues []sqlKeyValueVersion
+	ConditionKeys   []string
+}
+
+var _ Transaction = (*sqlStorageTransaction)(nil)
+
+func (transaction *sqlStorageTransaction) GetConditionValues() ([]KeyValueData, error) {
+	values := make([]KeyValueData, len(transaction.ConditionValues))
+	for i, value := range transaction.ConditionValues {
+		values[i] = KeyValueData{
+			Key:     value.Key,
+			Value:   value.Value,
+			Present: value.Present,
+		}
+	}
+	return values, nil
+}
@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func newTestSQLStorage(t *testing.T) *SQLStorage {
+	s, err := NewSQLStorage(&SQLStorageConf{Driver: "sqlite", DSN: filepath.Join(t.TempDir(), "test.sqlite")})
+	require.NoError(t, err)
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestSQLPutGetAndPrefix(t *testing.T) {
+	s := newTestSQLStorage(t)
+
+	assert.NoError(t, s.Put("user:1", "Alice"))
+	assert.NoError(t, s.Put("user:2", "Bob"))
+	assert.NoError(t, s.Put("order:1", "XYZ"))
+	assert.NoError(t, s.Put("user:1", "Alice\x00binary"))
+	assert.NoError(t, s.Put("empty", ""))
+
+	val, ok, err := s.Get("user:1")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "Alice\x00binary", val)
+
+	val, ok, err = s.Get("empty")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "", val)
+
+	_, ok, err = s.Get("user:3")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	users, err := s.GetByKeyPrefix("user:")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"Alice\x00binary", "Bob"}, users)
+
+	assert.NoError(t, s.Delete("user:1"))
+	_, ok, _ = s.Get("user:1")
+	assert.False(t, ok)
+}
+
+func TestSQLPutIfAbsentAndCompareAndSwap(t *testing.T) {
+	s := newTestSQLStorage(t)
+
+	ok, err := s.PutIfAbsent("k", "old")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	ok, err = s.PutIfAbsent("k", "other")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = s.CompareAndSwap("k", "wrong", "new")
+	assert.NoError(t, err)
+	assert.False(t, ok)
+	ok, err = s.CompareAndSwap("k", "old", "new")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	val, _, _ := s.Get("k")
+	assert.Equal(t, "new", val)
+}
+
+func TestSQLCompleteTransactionConflict(t *testing.T) {
+	s := newTestSQLStorage(t)
+	_ = s.Put("x", "1")
+	_ = s.Put("z", "1")
+
+	transaction, err := s.StartTransaction([]string{"x", "y", "z"})
+	require.NoError(t, err)
+	values, _ := transaction.GetConditionValues()
+	assert.Equal(t, []KeyValueData{{Key: "x", Value: "1", Present: true}, {Key: "y"},
+		{Key: "z", Value: "1", Present: true}}, values)
+
+	// condition key which is not updated is changed by another writer
+	_ = s.Put("z", "1")
+
+	ok, err := s.CompleteTransaction(transaction, []KeyValueData{{Key: "x", Value: "2", Present: true}})
+	assert.NoError(t, err)
+	assert.False(t, ok, "Transaction should fail when condition key was modified")
+	x, _, _ := s.Get("x")
+	assert.Equal(t, "1", x, "failed transaction must be rolled back")
+
+	// absent key is created by another writer
+	_ = s.Put("y", "0")
+	transaction, _ = s.StartTransaction([]string{"y"})
+	_ = s.Delete("y")
+	ok, err = s.CompleteTransaction(transaction, []KeyValueData{{Key: "y", Value: "3", Present: true}})
+	assert.NoError(t, err)
+	assert.False(t, ok)
+
+	ok, err = s.CompleteTransaction(transaction, []KeyValueData{{Key: "y", Value: "3", Present: true}})
+	assert.NoError(t, err)
+	assert.True(t, ok, "Transaction should succeed with refreshed condition values")
+	y, _, _ := s.Get("y")
+	assert.Equal(t, "3", y)
+}
+
+func TestSQLCompleteTransactionAbsentConditionKey(t *testing.T) {
+	s := newTestSQLStorage(t)
+
+	transaction, err := s.StartTransaction([]string{"absent"})
+	require.NoError(t, err)
+	_ = s.Put("absent", "1")
+	ok, err := s.CompleteTransaction(transaction, []KeyValueData{{Key: "x", Value: "1", Present: true}})
+	assert.NoError(t, err)
+	assert.False(t, ok, "Transaction should fail when absent condition key was inserted")
+	_, present, _ := s.Get("x")
+	assert.False(t, present)
+
+	_ = s.Delete("absent")
+	transaction, _ = s.StartTransaction([]string{"absent"})
+	ok, err = s.CompleteTransaction(transaction, []KeyValueData{{Key: "x", Value: "1", Present: true}})
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	_, present, _ = s.Get("absent")
+	assert.False(t, present, "placeholder of absent condition key must be removed")
+}
+
+func TestSQLExecuteTransaction_ConcurrentPutIfAbsent(t *testing.T) {
+	s := newTestSQLStorage(t)
+
+	var wg sync.WaitGroup
+	var winners sync.Map
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			// the second condition key is absent and is not updated
+			ok, err := s.ExecuteTransaction(CASRequest{
+				ConditionKeys: []string{"key", "other"},
+				Update: func(old []KeyValueData) ([]KeyValueData, bool, error) {
+					if old[0].Present {
+						return nil, false, nil
+					}
+					return []KeyValueData{{Key: "key", Value: strconv.Itoa(i), Present: true}}, true, nil
+				},
+			})
+			assert.NoError(t, err)
+			if ok {
+				winners.Store(i, true)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	count := 0
+	winners.Range(func(winner, _ any) bool {
+		count++
+		value, _, _ := s.Get("key")
+		assert.Equal(t, strconv.Itoa(winner.(int)), value)
+		return true
+	})
+	assert.Equal(t, 1, count, "only one transaction inserts the absent key")
+	_, present, _ := s.Get("other")
+	assert.False(t, present)
+}
+
+func TestSQLExecuteTransaction_RetryUntilSuccess(t *testing.T) {
+	s := newTestSQLStorage(t)
+	_ = s.Put("x", "1")
+
+	attempts := 0
+	req := CASRequest{
+		ConditionKeys:           []string{"x", "new"},
+		RetryTillSuccessOrError: true,
+		Update: func(old []KeyValueData) ([]KeyValueData, bool, error) {
+			attempts++
+			if attempts == 1 {
+				_ = s.Put("x", "5")
+			}
+			return []KeyValueData{{Key: "x", Value: old[0].Value + "0", Present: true},
+				{Key: "new", Value: "created", Present: true}}, true, nil
+		},
+	}
+
+	ok, err := s.ExecuteTransaction(req)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, 2, attempts)
+
+	value, _, _ := s.Get("x")
+	assert.Equal(t, "50", value)
+	value, _, _ = s.Get("new")
+	assert.Equal(t, "created", value)
+}
+
+func TestSQLTypedStorage(t *testing.T) {
+	s := newTestSQLStorage(t)
+	typed := NewTypedAtomicStorageImpl(NewPrefixedAtomicStorage(s, "/typed"), dummyKeySerializer, reflect.TypeOf(""),
+		func(value any) (string, error) { return *value.(*string), nil }, dummyValueDeserializer, reflect.TypeOf(""))
+
+	value := "value"
+	ok, err := typed.PutIfAbsent("key", &value)
+	assert.NoError(t, err)
+	assert.True(t, ok)
+
+	raw, ok, err := s.Get("/typed/key")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value", raw)
+
+	stored, ok, err := typed.Get("key")
+	assert.NoError(t, err)
+	assert.True(t, ok)
+	assert.Equal(t, "value", *stored.(*string))
+}
+
+func TestSQLUnsupportedDriver(t *testing.T) {
+	_, err := NewSQLStorage(&SQLStorageConf{Driver: "mysql"})
+	assert.Error(t, err)
+}
+
+func TestSQLRebind(t *testing.T) {
+	s := &SQLStorage{dialect: sqlDialects["postgres"]}
+	assert.Equal(t, "UPDATE t SET a = $1 WHERE b = $2", s.rebind("UPDATE t SET a = ? WHERE b = ?"))
+	s = &SQLStorage{dialect: sqlDialects["sqlite"]}
+	assert.Equal(t, "UPDATE t SET a = ? WHERE b = ?", s.rebind("UPDATE t SET a = ? WHERE b = ?"))
+}
+
+func TestGetSQLStorageConf(t *testing.T) {
+	vip := viper.New()
+	conf, err := GetSQLStorageConf(vip, "payment_channel_storage_sql")
+	assert.NoError(t, err)
+	assert.Equal(t, "sqlite", conf.Driver)
+
+	vip.Set("payment_channel_storage_sql", map[string]any{"driver": "postgres", "dsn": "postgres://localhost/snetd"})
+	conf, err = GetSQLStorageConf(vip, "payment_channel_storage_sql")
+	assert.NoError(t, err)
+	assert.Equal(t, "postgres", conf.Driver)
+	assert.Equal(t, "postgres://localhost/snetd", conf.DSN)
+}
+
+func TestSQLGetKeyValuesByKeyPrefix(t *testing.T) {
+	s := newTestSQLStorage(t)
+	_ = s.Put("user:2", "Bob")
+	_ = s.Put("user:1", "Alice")
+	_ = s.Put("order:1", "XYZ")
+
+	keyValues, err := s.GetKeyValuesByKeyPrefix("user:")
+	assert.NoError(t, err)
+	assert.Equal(t, []KeyValueData{{Key: "user:1", Value: "Alice", Present: true}, {Key: "user:2", Value: "Bob", Present: true}}, keyValues)
+}
+
+func TestSQLGetByKeyPrefixRange(t *testing.T) {
+	s := newTestSQLStorage(t)
+	for _, key := range []string{"user", "user:", "user:1", "user:\U0010ffff", "user;", "user:\ud7ff", "userz"} {
+		require.NoError(t, s.Put(key, key))
+	}
+
+	values, err := s.GetByKeyPrefix("user:")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user:", "user:1", "user:\ud7ff", "user:\U0010ffff"}, values)
+	values, err = s.GetByKeyPrefix("user:\U0010ffff")
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"user:\U0010ffff"}, values)
+	values, err = s.GetByKeyPrefix("")
+	assert.NoError(t, err)
+	assert.Len(t, values, 7)
+}
+
+func TestKeyPrefixEnd(t *testing.T) {
+	for prefix, expected := range map[string]string{
+		"user:":                "user;",
+		"a\U0010ffff":          "b",
+		"a\ud7ff":              "a\ue000",
+		"a\xff":                "b",
+		"a\xfe":                "a\xff",
+		"\U0010ffff\U0010ffff": "",
+		"":                     "",
+	} {
+		end, ok := keyPrefixEnd(prefix)
+		assert.Equal(t, expected != "", ok, prefix)
+		assert.Equal(t, expected, end, prefix)
+	}
+}
+
+func TestSQLWatch(t *testing.T) {
+	testStorageWatch(t, newTestSQLStorage(t))
+}
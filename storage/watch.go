@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"strings"
+	"sync"
+
+	"go.uber.org/zap"
+)
+
+// watchBufferSize is a number of events which can be queued for a watcher,
+// a watcher which falls behind is closed and has to watch again
+const watchBufferSize = 256
+
+// watchHub delivers changes made through the storage instance to the
+// watchers in the same process. Zero value is ready to use.
+type watchHub struct {
+	mutex    sync.Mutex
+	watchers map[*watcher]struct{}
+}
+
+type watcher struct {
+	prefix string
+	events chan KeyValueData
+}
+
+func (hub *watchHub) watch(ctx context.Context, prefix string) <-chan KeyValueData {
+	w := &watcher{prefix: prefix, events: make(chan KeyValueData, watchBufferSize)}
+
+	hub.mutex.Lock()
+	if hub.watchers == nil {
+		hub.watchers = make(map[*watcher]struct{})
+	}
+	hub.watchers[w] = struct{}{}
+	hub.mutex.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		hub.mutex.Lock()
+		defer hub.mutex.Unlock()
+		hub.unsafeRemove(w)
+	}()
+	return w.events
+}
+
+func (hub *watchHub) unsafeRemove(w *watcher) {
+	if _, ok := hub.watchers[w]; ok {
+		delete(hub.watchers, w)
+		close(w.events)
+	}
+}
+
+// notify sends events to the watchers which prefix matches the key, it never
+// blocks the writer
+func (hub *watchHub) notify(events ...KeyValueData) {
+	hub.mutex.Lock()
+	defer hub.mutex.Unlock()
+
+	for w := range hub.watchers {
+		for _, event := range events {
+			if !strings.HasPrefix(event.Key, w.prefix) {
+				continue
+			}
+			select {
+			case w.events <- event:
+			default:
+				zap.L().Warn("storage watcher is too slow, closing it", zap.String("prefix", w.prefix))
+				hub.unsafeRemove(w)
+			}
+			if _, ok := hub.watchers[w]; !ok {
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package storage
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func receiveEvent(t *testing.T, events <-chan KeyValueData) KeyValueData {
+	select {
+	case event, ok := <-events:
+		require.True(t, ok, "events channel is closed")
+		return event
+	case <-time.After(5 * time.Second):
+		require.Fail(t, "no event received")
+		return KeyValueData{}
+	}
+}
+
+func assertNoEvent(t *testing.T, events <-chan KeyValueData) {
+	select {
+	case event := <-events:
+		assert.Fail(t, "unexpected event", "%v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+}
+
+func assertClosed(t *testing.T, events <-chan KeyValueData) {
+	timeout := time.After(5 * time.Second)
+	for {
+		select {
+		case _, ok := <-events:
+			if !ok {
+				return
+			}
+		case <-timeout:
+			require.Fail(t, "events channel is not closed")
+		}
+	}
+}
+
+// testStorageWatch checks watch semantics common for all storages
+func testStorageWatch(t *testing.T, s AtomicStorage) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	events, err := s.Watch(ctx, "/channel/")
+	require.NoError(t, err)
+	all, err := s.Watch(ctx, "")
+	require.NoError(t, err)
+
+	require.NoError(t, s.Put("/channel/1", "a"))
+	require.NoError(t, s.Put("/other/1", "b"))
+	ok, err := s.PutIfAbsent("/channel/2", "c")
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = s.CompareAndSwap("/channel/1", "a", "d")
+	require.NoError(t, err)
+	require.True(t, ok)
+	ok, err = s.CompareAndSwap("/channel/1", "wrong", "e")
+	require.NoError(t, err)
+	require.False(t, ok)
+	require.NoError(t, s.Delete("/channel/2"))
+
+	assert.Equal(t, KeyValueData{Key: "/channel/1", Value: "a", Present: true}, receiveEvent(t, events))
+	assert.Equal(t, KeyValueData{Key: "/channel/2", Value: "c", Present: true}, receiveEvent(t, events))
+	assert.Equal(t, KeyValueData{Key: "/channel/1", Value: "d", Present: true}, receiveEvent(t, events))
+	assert.Equal(t, KeyValueData{Key: "/channel/2"}, receiveEvent(t, events))
+	assertNoEvent(t, events)
+
+	assert.Equal(t, "/channel/1", receiveEvent(t, all).Key)
+	assert.Equal(t, KeyValueData{Key: "/other/1", Value: "b", Present: true}, receiveEvent(t, all))
+
+	cancel()
+	assertClosed(t, events)
+	assertClosed(t, all)
+}
+
+func TestMemoryStorageWatch(t *testing.T) {
+	testStorageWatch(t, NewMemStorage())
+}
+
+func TestMemoryStorageWatch_TransactionAndDeleteAbsent(t *testing.T) {
+	s := NewMemStorage()
+	events, err := s.Watch(t.Context(), "")
+	require.NoError(t, err)
+
+	require.NoError(t, s.Delete("absent"))
+	ok, err := s.ExecuteTransaction(CASRequest{
+		ConditionKeys: []string{"a", "b"},
+		Update: func(oldValues []KeyValueData) ([]KeyValueData, bool, error) {
+			return []KeyValueData{{Key: "a", Value: "1"}, {Key: "b", Value: "2"}}, true, nil
+		},
+	})
+	require.NoError(t, err)
+	require.True(t, ok)
+
+	assert.Equal(t, KeyValueData{Key: "a", Value: "1", Present: true}, receiveEvent(t, events))
+	assert.Equal(t, KeyValueData{Key: "b", Value: "2", Present: true}, receiveEvent(t, events))
+	assertNoEvent(t, events)
+}
+
+func TestMemoryStorageWatch_SlowWatcherIsClosed(t *testing.T) {
+	s := NewMemStorage()
+	slow, err := s.Watch(t.Context(), "")
+	require.NoError(t, err)
+
+	for i := 0; i <= watchBufferSize; i++ {
+		require.NoError(t, s.Put(fmt.Sprintf("key-%v", i), "value"))
+	}
+
+	count := 0
+	for range slow {
+		count++
+	}
+	assert.Equal(t, watchBufferSize, count, "buffered events are delivered before the channel is closed")
+
+	fast, err := s.Watch(t.Context(), "")
+	require.NoError(t, err)
+	require.NoError(t, s.Put("key", "value"))
+	assert.Equal(t, "key", receiveEvent(t, fast).Key, "writes are not blocked by the closed watcher")
+}
+
+func TestPrefixedAtomicStorageWatch(t *testing.T) {
+	base := NewMemStorage()
+	prefixed := NewPrefixedAtomicStorage(base, "/prefix")
+
+	events, err := prefixed.Watch(t.Context(), "channel/")
+	require.NoError(t, err)
+
+	require.NoError(t, base.Put("/other/channel/1", "ignored"))
+	require.NoError(t, prefixed.Put("channel/1", "a"))
+	require.NoError(t, prefixed.Delete("channel/1"))
+
+	assert.Equal(t, KeyValueData{Key: "channel/1", Value: "a", Present: true}, receiveEvent(t, events))
+	assert.Equal(t, KeyValueData{Key: "channel/1"}, receiveEvent(t, events))
+	assertNoEvent(t, events)
+}
+
+func TestTypedAtomicStorageWatch(t *testing.T) {
+	base := NewMemStorage()
+	typed := NewTypedAtomicStorageImpl(base, dummyKeySerializer, reflect.TypeOf(""),
+		func(value any) (string, error) { return *value.(*string), nil },
+		dummyValueDeserializer, reflect.TypeOf(""))
+
+	events, err := typed.Watch(t.Context())
+	require.NoError(t, err)
+
+	value := "value"
+	require.NoError(t, typed.Put("key", &value))
+	require.NoError(t, base.Put("broken", ""))
+	require.NoError(t, typed.Delete("key"))
+
+	receive := func() TypedKeyValueData {
+		select {
+		case event := <-events:
+			return event
+		case <-time.After(5 * time.Second):
+			require.Fail(t, "no event received")
+			return TypedKeyValueData{}
+		}
+	}
+	event := receive()
+	assert.Equal(t, "key", event.Key)
+	assert.True(t, event.Present)
+	assert.Equal(t, "value", *event.Value.(*string))
+	assert.Equal(t, TypedKeyValueData{Key: "key"}, receive(), "value which can't be decoded is skipped")
+}
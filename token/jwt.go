@@ -12,14 +12,20 @@ import (
 
 type customJWTokenServiceImpl struct {
 	getGroupId func() string
+	// keyRing signs and verifies tokens, the tokens are signed by
+	// config.TokenSecretKey if it is nil
+	keyRing *KeyRing
 }
 
-// NewJWTTokenService service to Create and Validate JWT tokens
-func NewJWTTokenService(data blockchain.OrganizationMetaData) Manager {
+// NewJWTTokenService service to Create and Validate JWT tokens, the tokens are
+// signed by the keys of keyRing if it is not nil, so the replicas don't share
+// the secret
+func NewJWTTokenService(data blockchain.OrganizationMetaData, keyRing *KeyRing) Manager {
 	return &customJWTokenServiceImpl{
 		getGroupId: func() string {
 			return data.GetGroupIdString()
 		},
+		keyRing: keyRing,
 	}
 }
 
@@ -32,6 +38,9 @@ func (service customJWTokenServiceImpl) CreateToken(payLoad PayLoad, userAddress
 	//set the Expiry of the Token generated
 	atClaims["exp"] = time.Now().UTC().
 		Add(time.Minute * time.Duration(config.GetInt(config.TokenExpiryInMinutes))).Unix()
+	if service.keyRing != nil {
+		return service.keyRing.sign(atClaims)
+	}
 	jwtToken := jwt.NewWithClaims(jwt.SigningMethodHS256, atClaims)
 	return jwtToken.SignedString([]byte(config.GetString(config.TokenSecretKey)))
 }
@@ -39,6 +48,9 @@ func (service customJWTokenServiceImpl) CreateToken(payLoad PayLoad, userAddress
 func (service customJWTokenServiceImpl) VerifyToken(receivedToken CustomToken, payLoad PayLoad) (userAddress string, err error) {
 	tokenString := fmt.Sprintf("%v", receivedToken)
 	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (any, error) {
+		if service.keyRing != nil {
+			return service.keyRing.verificationKey(token)
+		}
 		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok {
 			return nil, fmt.Errorf("unexpected signing method: %v", token.Header["alg"])
 		}
@@ -0,0 +1,367 @@
+package token
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"go.uber.org/zap"
+
+	"github.com/singnet/snet-daemon/v6/storage"
+	"github.com/singnet/snet-daemon/v6/utils"
+)
+
+// Token signing algorithms
+const (
+	// AlgorithmHS256 signs tokens by the secret shared by the replicas, see
+	// config.TokenSecretKey
+	AlgorithmHS256 = "HS256"
+	// AlgorithmES256 signs tokens by ECDSA P-256 key
+	AlgorithmES256 = "ES256"
+	// AlgorithmEdDSA signs tokens by Ed25519 key
+	AlgorithmEdDSA = "EdDSA"
+)
+
+// JWKSPath is the HTTP path of the JSON Web Key Set which verifies the tokens
+const JWKSPath = "/.well-known/jwks.json"
+
+// KeyRingConf contains settings of the token signing keys
+// Algorithm - one of AlgorithmES256, AlgorithmEdDSA
+// RotationInterval - how often the signing key is replaced by the new one
+// TokenLifetime - the longest lifetime of the token, the key verifies tokens
+// during RotationInterval + TokenLifetime after it is created
+type KeyRingConf struct {
+	Algorithm        string
+	RotationInterval time.Duration
+	TokenLifetime    time.Duration
+}
+
+// keyRefreshInterval limits how often the keys are read from the storage
+// when a token is signed by an unknown key, so the tokens with random key ids
+// don't load the storage. The key of a new replica is seen by the others
+// within the interval.
+const keyRefreshInterval = 5 * time.Second
+
+// DefaultKeyRotationInterval is used when KeyRingConf.RotationInterval is not
+// set
+const DefaultKeyRotationInterval = 24 * time.Hour
+
+// JSONWebKey is a public key in RFC 7517 format
+type JSONWebKey struct {
+	KeyID     string `json:"kid"`
+	KeyType   string `json:"kty"`
+	Algorithm string `json:"alg"`
+	Use       string `json:"use"`
+	Curve     string `json:"crv"`
+	X         string `json:"x"`
+	Y         string `json:"y,omitempty"`
+}
+
+// JSONWebKeySet is a set of public keys in RFC 7517 format
+type JSONWebKeySet struct {
+	Keys []JSONWebKey `json:"keys"`
+}
+
+// VerificationKey is a public key published to the shared storage, so each
+// replica can verify the tokens signed by others
+type VerificationKey struct {
+	Key JSONWebKey
+	// ExpiresAt is the time when the tokens signed by the key are expired
+	ExpiresAt time.Time
+}
+
+// publicKey returns the key used by jwt package to verify the signature
+func (key *VerificationKey) publicKey() (any, error) {
+	x, err := base64.RawURLEncoding.DecodeString(key.Key.X)
+	if err != nil {
+		return nil, fmt.Errorf("invalid key %v: %v", key.Key.KeyID, err)
+	}
+	switch key.Key.Algorithm {
+	case AlgorithmES256:
+		y, err := base64.RawURLEncoding.DecodeString(key.Key.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid key %v: %v", key.Key.KeyID, err)
+		}
+		return &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}, nil
+	case AlgorithmEdDSA:
+		if len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid key %v: unexpected size %v", key.Key.KeyID, len(x))
+		}
+		return ed25519.PublicKey(x), nil
+	default:
+		return nil, fmt.Errorf("unsupported algorithm %v of key %v", key.Key.Algorithm, key.Key.KeyID)
+	}
+}
+
+type signingKey struct {
+	id         string
+	method     jwt.SigningMethod
+	privateKey crypto.Signer
+}
+
+// KeyRing signs tokens by the key of this replica and verifies tokens signed
+// by the keys of all replicas. The private key never leaves the replica,
+// the public keys are published to the shared storage until the tokens they
+// signed are expired.
+type KeyRing struct {
+	conf    KeyRingConf
+	storage storage.TypedAtomicStorage
+
+	mutex   sync.RWMutex
+	signing *signingKey
+	// verification keeps the keys read from the storage by the key id
+	verification map[string]*VerificationKey
+	// refreshed is the time the keys were read from the storage last time
+	refreshed time.Time
+}
+
+// NewKeyRing returns new key ring with the signing key published to the
+// storage
+func NewKeyRing(atomicStorage storage.AtomicStorage, conf KeyRingConf) (*KeyRing, error) {
+	if conf.Algorithm != AlgorithmES256 && conf.Algorithm != AlgorithmEdDSA {
+		return nil, fmt.Errorf("unsupported token signing algorithm %q, expected one of '%v','%v'",
+			conf.Algorithm, AlgorithmES256, AlgorithmEdDSA)
+	}
+	if conf.RotationInterval <= 0 {
+		conf.RotationInterval = DefaultKeyRotationInterval
+	}
+	prefixedStorage := storage.NewPrefixedAtomicStorage(atomicStorage, "/token/keys")
+	ring := &KeyRing{
+		conf: conf,
+		storage: storage.NewTypedAtomicStorageImpl(prefixedStorage, serializeKeyID, reflect.TypeOf(""),
+			utils.Serialize, utils.Deserialize, reflect.TypeOf(VerificationKey{})),
+		verification: make(map[string]*VerificationKey),
+	}
+	if err := ring.Rotate(); err != nil {
+		return nil, err
+	}
+	return ring, nil
+}
+
+func serializeKeyID(key any) (string, error) {
+	return fmt.Sprintf("%v", key), nil
+}
+
+// Run rotates the signing key each conf.RotationInterval until ctx is done
+func (ring *KeyRing) Run(ctx context.Context) {
+	ticker := time.NewTicker(ring.conf.RotationInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		if err := ring.Rotate(); err != nil {
+			zap.L().Error("Unable to rotate token signing key, previous key is used", zap.Error(err))
+		}
+	}
+}
+
+// Rotate generates new signing key, publishes it and removes the expired
+// keys from the storage
+func (ring *KeyRing) Rotate() error {
+	key, verification, err := ring.generate()
+	if err != nil {
+		return fmt.Errorf("unable to generate token signing key: %v", err)
+	}
+	if err = ring.storage.Put(key.id, verification); err != nil {
+		return fmt.Errorf("unable to publish token verification key: %v", err)
+	}
+
+	ring.mutex.Lock()
+	ring.signing = key
+	ring.verification[key.id] = verification
+	ring.mutex.Unlock()
+	zap.L().Info("Token signing key is rotated", zap.String("kid", key.id), zap.String("alg", ring.conf.Algorithm))
+
+	ring.removeExpired()
+	return nil
+}
+
+func (ring *KeyRing) generate() (key *signingKey, verification *VerificationKey, err error) {
+	id := make([]byte, 16)
+	if _, err = rand.Read(id); err != nil {
+		return
+	}
+	key = &signingKey{id: hex.EncodeToString(id)}
+	jwk := JSONWebKey{KeyID: key.id, Algorithm: ring.conf.Algorithm, Use: "sig"}
+	switch ring.conf.Algorithm {
+	case AlgorithmES256:
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		key.method, key.privateKey = jwt.SigningMethodES256, privateKey
+		jwk.KeyType, jwk.Curve = "EC", "P-256"
+		jwk.X = base64.RawURLEncoding.EncodeToString(privateKey.X.FillBytes(make([]byte, 32)))
+		jwk.Y = base64.RawURLEncoding.EncodeToString(privateKey.Y.FillBytes(make([]byte, 32)))
+	case AlgorithmEdDSA:
+		publicKey, privateKey, err := ed25519.GenerateKey(rand.Reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		key.method, key.privateKey = jwt.SigningMethodEdDSA, privateKey
+		jwk.KeyType, jwk.Curve = "OKP", "Ed25519"
+		jwk.X = base64.RawURLEncoding.EncodeToString(publicKey)
+	}
+	verification = &VerificationKey{
+		Key:       jwk,
+		ExpiresAt: time.Now().UTC().Add(ring.conf.RotationInterval + ring.conf.TokenLifetime),
+	}
+	return key, verification, nil
+}
+
+func (ring *KeyRing) removeExpired() {
+	keys, err := ring.keys()
+	if err != nil {
+		zap.L().Warn("Unable to read token verification keys", zap.Error(err))
+		return
+	}
+	now := time.Now()
+	for _, key := range keys {
+		if now.Before(key.ExpiresAt) {
+			continue
+		}
+		if err = ring.storage.Delete(key.Key.KeyID); err != nil {
+			zap.L().Warn("Unable to remove expired token verification key", zap.String("kid", key.Key.KeyID), zap.Error(err))
+		}
+	}
+
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	for id, key := range ring.verification {
+		if !now.Before(key.ExpiresAt) {
+			delete(ring.verification, id)
+		}
+	}
+}
+
+func (ring *KeyRing) keys() ([]*VerificationKey, error) {
+	values, err := ring.storage.GetAll()
+	if err != nil {
+		return nil, err
+	}
+	return values.([]*VerificationKey), nil
+}
+
+// sign returns the token signed by the current key, the key id is set in
+// the "kid" header
+func (ring *KeyRing) sign(claims jwt.Claims) (string, error) {
+	ring.mutex.RLock()
+	key := ring.signing
+	ring.mutex.RUnlock()
+
+	token := jwt.NewWithClaims(key.method, claims)
+	token.Header["kid"] = key.id
+	return token.SignedString(key.privateKey)
+}
+
+// verificationKey is jwt.Keyfunc which returns the key of the replica which
+// signed the token
+func (ring *KeyRing) verificationKey(token *jwt.Token) (any, error) {
+	id, ok := token.Header["kid"].(string)
+	if !ok || id == "" {
+		return nil, fmt.Errorf("token key id is not set")
+	}
+
+	key, ok := ring.verificationKeyByID(id)
+	if !ok {
+		if err := ring.refresh(); err != nil {
+			return nil, fmt.Errorf("unable to read token verification keys: %v", err)
+		}
+		if key, ok = ring.verificationKeyByID(id); !ok {
+			return nil, fmt.Errorf("unknown token key id %v", id)
+		}
+	}
+
+	if token.Method.Alg() != key.Key.Algorithm {
+		return nil, fmt.Errorf("unexpected signing method: %v", token.Method.Alg())
+	}
+	if !time.Now().Before(key.ExpiresAt) {
+		return nil, fmt.Errorf("token key %v is expired", id)
+	}
+	return key.publicKey()
+}
+
+func (ring *KeyRing) verificationKeyByID(id string) (key *VerificationKey, ok bool) {
+	ring.mutex.RLock()
+	defer ring.mutex.RUnlock()
+	key, ok = ring.verification[id]
+	return
+}
+
+// refresh reads all keys from the storage unless they were read less than
+// keyRefreshInterval ago
+func (ring *KeyRing) refresh() error {
+	ring.mutex.Lock()
+	if time.Since(ring.refreshed) < keyRefreshInterval {
+		ring.mutex.Unlock()
+		return nil
+	}
+	ring.refreshed = time.Now()
+	ring.mutex.Unlock()
+
+	keys, err := ring.keys()
+	if err != nil {
+		return err
+	}
+	ring.mutex.Lock()
+	defer ring.mutex.Unlock()
+	for _, key := range keys {
+		ring.verification[key.Key.KeyID] = key
+	}
+	return nil
+}
+
+// JWKS returns the keys which verify the tokens, sorted by the key id
+func (ring *KeyRing) JWKS() (*JSONWebKeySet, error) {
+	set := &JSONWebKeySet{Keys: []JSONWebKey{}}
+	if ring == nil {
+		return set, nil
+	}
+	keys, err := ring.keys()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	for _, key := range keys {
+		if now.Before(key.ExpiresAt) {
+			set.Keys = append(set.Keys, key.Key)
+		}
+	}
+	slices.SortFunc(set.Keys, func(a, b JSONWebKey) int {
+		return strings.Compare(a.KeyID, b.KeyID)
+	})
+	return set, nil
+}
+
+// JWKSHandler writes the key set of the ring, the set is empty when the ring
+// is nil because the tokens are signed by the shared secret
+func JWKSHandler(ring *KeyRing, resp http.ResponseWriter) {
+	set, err := ring.JWKS()
+	if err != nil {
+		zap.L().Error("Unable to read token verification keys", zap.Error(err))
+		http.Error(resp, "unable to read token verification keys", http.StatusInternalServerError)
+		return
+	}
+	resp.Header().Set("Content-Type", "application/json")
+	if err = json.NewEncoder(resp).Encode(set); err != nil {
+		zap.L().Warn("Unable to write token verification keys", zap.Error(err))
+	}
+}
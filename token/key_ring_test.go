@@ -0,0 +1,157 @@
+package token
+
+import (
+	"context"
+	"encoding/json"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"github.com/singnet/snet-daemon/v6/config"
+	"github.com/singnet/snet-daemon/v6/storage"
+)
+
+func newTokenService(t *testing.T, memoryStorage *storage.MemoryStorage, conf KeyRingConf) *customJWTokenServiceImpl {
+	keyRing, err := NewKeyRing(memoryStorage, conf)
+	require.NoError(t, err)
+	return &customJWTokenServiceImpl{
+		getGroupId: func() string { return "GroupID" },
+		keyRing:    keyRing,
+	}
+}
+
+func TestKeyRing_ReplicasVerifyEachOtherTokens(t *testing.T) {
+	config.Vip().Set(config.TokenExpiryInMinutes, 1)
+	for _, algorithm := range []string{AlgorithmES256, AlgorithmEdDSA} {
+		t.Run(algorithm, func(t *testing.T) {
+			memoryStorage := storage.NewMemStorage()
+			conf := KeyRingConf{Algorithm: algorithm, RotationInterval: time.Hour, TokenLifetime: time.Minute}
+			first := newTokenService(t, memoryStorage, conf)
+			second := newTokenService(t, memoryStorage, conf)
+
+			token, err := first.CreateToken("payload", "0x1")
+			require.NoError(t, err)
+			parsed, _, err := jwt.NewParser().ParseUnverified(token.(string), jwt.MapClaims{})
+			require.NoError(t, err)
+			assert.Equal(t, algorithm, parsed.Method.Alg())
+			assert.Equal(t, first.keyRing.signing.id, parsed.Header["kid"])
+
+			address, err := second.VerifyToken(token, "payload")
+			require.NoError(t, err)
+			assert.Equal(t, "0x1", address)
+		})
+	}
+}
+
+func TestKeyRing_RotatedKeyVerifiesOutstandingTokens(t *testing.T) {
+	config.Vip().Set(config.TokenExpiryInMinutes, 1)
+	memoryStorage := storage.NewMemStorage()
+	service := newTokenService(t, memoryStorage, KeyRingConf{Algorithm: AlgorithmES256, TokenLifetime: time.Minute})
+	oldKeyID := service.keyRing.signing.id
+
+	token, err := service.CreateToken("payload", "0x1")
+	require.NoError(t, err)
+	require.NoError(t, service.keyRing.Rotate())
+	assert.NotEqual(t, oldKeyID, service.keyRing.signing.id)
+
+	_, err = service.VerifyToken(token, "payload")
+	assert.NoError(t, err)
+
+	set, err := service.keyRing.JWKS()
+	require.NoError(t, err)
+	assert.Len(t, set.Keys, 2)
+}
+
+func TestKeyRing_ExpiredKeyIsRemoved(t *testing.T) {
+	config.Vip().Set(config.TokenExpiryInMinutes, 1)
+	memoryStorage := storage.NewMemStorage()
+	service := newTokenService(t, memoryStorage, KeyRingConf{Algorithm: AlgorithmEdDSA, RotationInterval: time.Hour})
+	token, err := service.CreateToken("payload", "0x1")
+	require.NoError(t, err)
+
+	expired := service.keyRing.verification[service.keyRing.signing.id]
+	expired.ExpiresAt = time.Now().Add(-time.Second)
+	require.NoError(t, service.keyRing.storage.Put(expired.Key.KeyID, expired))
+	require.NoError(t, service.keyRing.Rotate())
+
+	_, err = service.VerifyToken(token, "payload")
+	assert.ErrorContains(t, err, "unknown token key id")
+	set, err := service.keyRing.JWKS()
+	require.NoError(t, err)
+	require.Len(t, set.Keys, 1)
+	assert.Equal(t, service.keyRing.signing.id, set.Keys[0].KeyID)
+}
+
+func TestKeyRing_UnknownKeysAreReadOncePerInterval(t *testing.T) {
+	config.Vip().Set(config.TokenExpiryInMinutes, 1)
+	memoryStorage := storage.NewMemStorage()
+	conf := KeyRingConf{Algorithm: AlgorithmEdDSA, RotationInterval: time.Hour, TokenLifetime: time.Minute}
+	service := newTokenService(t, memoryStorage, conf)
+
+	unknown := jwt.NewWithClaims(jwt.SigningMethodEdDSA, jwt.MapClaims{})
+	unknown.Header["kid"] = "unknown"
+	_, err := service.keyRing.verificationKey(unknown)
+	assert.ErrorContains(t, err, "unknown token key id")
+
+	replica := newTokenService(t, memoryStorage, conf)
+	token, err := replica.CreateToken("payload", "0x1")
+	require.NoError(t, err)
+	_, err = service.VerifyToken(token, "payload")
+	assert.ErrorContains(t, err, "unknown token key id", "keys are read once per interval")
+
+	service.keyRing.refreshed = time.Now().Add(-keyRefreshInterval)
+	_, err = service.VerifyToken(token, "payload")
+	assert.NoError(t, err)
+}
+
+func TestKeyRing_RejectsSharedSecretTokens(t *testing.T) {
+	config.Vip().Set(config.TokenExpiryInMinutes, 1)
+	hmacService := &customJWTokenServiceImpl{getGroupId: func() string { return "GroupID" }}
+	token, err := hmacService.CreateToken("payload", "0x1")
+	require.NoError(t, err)
+
+	service := newTokenService(t, storage.NewMemStorage(), KeyRingConf{Algorithm: AlgorithmES256})
+	_, err = service.VerifyToken(token, "payload")
+	assert.Error(t, err)
+}
+
+func TestNewKeyRing_UnsupportedAlgorithm(t *testing.T) {
+	_, err := NewKeyRing(storage.NewMemStorage(), KeyRingConf{Algorithm: "RS256"})
+	assert.EqualError(t, err, "unsupported token signing algorithm \"RS256\", expected one of 'ES256','EdDSA'")
+}
+
+func TestJWKSHandler(t *testing.T) {
+	keyRing, err := NewKeyRing(storage.NewMemStorage(), KeyRingConf{Algorithm: AlgorithmES256})
+	require.NoError(t, err)
+
+	recorder := httptest.NewRecorder()
+	JWKSHandler(keyRing, recorder)
+	var set JSONWebKeySet
+	require.NoError(t, json.Unmarshal(recorder.Body.Bytes(), &set))
+	require.Len(t, set.Keys, 1)
+	assert.Equal(t, "EC", set.Keys[0].KeyType)
+	assert.Equal(t, "P-256", set.Keys[0].Curve)
+	assert.Equal(t, "sig", set.Keys[0].Use)
+	assert.NotEmpty(t, set.Keys[0].Y)
+
+	recorder = httptest.NewRecorder()
+	JWKSHandler(nil, recorder)
+	assert.JSONEq(t, `{"keys": []}`, recorder.Body.String())
+}
+
+func TestKeyRing_Run(t *testing.T) {
+	keyRing, err := NewKeyRing(storage.NewMemStorage(), KeyRingConf{Algorithm: AlgorithmEdDSA, RotationInterval: 10 * time.Millisecond})
+	require.NoError(t, err)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go keyRing.Run(ctx)
+
+	require.Eventually(t, func() bool {
+		set, err := keyRing.JWKS()
+		return err == nil && len(set.Keys) > 1
+	}, 5*time.Second, 10*time.Millisecond)
+}
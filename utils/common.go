@@ -4,6 +4,9 @@ import (
 	"bytes"
 	"crypto/ecdsa"
 	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/url"
 	"strings"
 
@@ -13,7 +16,52 @@ import (
 	"github.com/ethereum/go-ethereum/common"
 )
 
+// Values written by Serialize start with the envelope marker followed by the
+// encoding version. A gob stream always starts with a non-zero message length,
+// so values written before the envelope was introduced are told apart by the
+// first byte and still can be read.
+const (
+	envelopeMarker byte = 0x00
+	// EncodingVersionJSON is a JSON document in the envelope
+	EncodingVersionJSON byte = 0x01
+)
+
+const (
+	ValueEncodingJSON = "json"
+	ValueEncodingGob  = "gob"
+)
+
+var valueEncoding = ValueEncodingJSON
+
+// SetValueEncoding sets the encoding used by Serialize. JSON is the default,
+// gob is only kept to write values which daemons of previous versions can
+// read during a rolling upgrade. Deserialize accepts both encodings
+// regardless of this setting.
+func SetValueEncoding(encoding string) error {
+	switch encoding {
+	case "":
+		valueEncoding = ValueEncodingJSON
+	case ValueEncodingJSON, ValueEncodingGob:
+		valueEncoding = encoding
+	default:
+		return fmt.Errorf("unknown value encoding: %q, expected one of: %v, %v", encoding, ValueEncodingJSON, ValueEncodingGob)
+	}
+	return nil
+}
+
 func Serialize(value any) (slice string, err error) {
+	if valueEncoding == ValueEncodingGob {
+		return serializeGob(value)
+	}
+
+	data, err := json.Marshal(value)
+	if err != nil {
+		return
+	}
+	return string([]byte{envelopeMarker, EncodingVersionJSON}) + string(data), nil
+}
+
+func serializeGob(value any) (slice string, err error) {
 	var b bytes.Buffer
 	e := gob.NewEncoder(&b)
 	err = e.Encode(value)
@@ -26,9 +74,31 @@ func Serialize(value any) (slice string, err error) {
 }
 
 func Deserialize(slice string, value any) (err error) {
-	b := bytes.NewBuffer([]byte(slice))
-	d := gob.NewDecoder(b)
-	return d.Decode(value)
+	if !IsEnveloped(slice) {
+		b := bytes.NewBuffer([]byte(slice))
+		d := gob.NewDecoder(b)
+		return d.Decode(value)
+	}
+	if len(slice) < 2 {
+		return errors.New("value envelope has no encoding version")
+	}
+	switch slice[1] {
+	case EncodingVersionJSON:
+		return json.Unmarshal([]byte(slice[2:]), value)
+	default:
+		return fmt.Errorf("unsupported value encoding version: %v", slice[1])
+	}
+}
+
+// IsEnveloped returns false for values written in the legacy gob encoding
+func IsEnveloped(slice string) bool {
+	return len(slice) > 0 && slice[0] == envelopeMarker
+}
+
+// IsCurrentEncoding returns true if the value is written in the encoding set
+// by SetValueEncoding
+func IsCurrentEncoding(slice string) bool {
+	return IsEnveloped(slice) == (valueEncoding == ValueEncodingJSON)
 }
 
 func ParsePrivateKey(privateKeyString string) (privateKey *ecdsa.PrivateKey) {
@@ -22,6 +22,36 @@ func TestSerializeDeserialize(t *testing.T) {
 	assert.Equal(t, original, decoded)
 }
 
+func TestSerialize_Envelope(t *testing.T) {
+	serialized, err := Serialize(map[string]int{"foo": 42})
+	assert.NoError(t, err)
+	assert.True(t, IsEnveloped(serialized))
+	assert.Equal(t, "\x00\x01{\"foo\":42}", serialized)
+
+	err = Deserialize("\x00\x7f{}", &map[string]int{})
+	assert.ErrorContains(t, err, "unsupported value encoding version")
+}
+
+func TestDeserialize_LegacyGob(t *testing.T) {
+	type value struct {
+		Name  string
+		Count int
+	}
+	assert.NoError(t, SetValueEncoding(ValueEncodingGob))
+	legacy, err := Serialize(&value{Name: "channel", Count: 3})
+	assert.True(t, IsCurrentEncoding(legacy))
+	assert.NoError(t, SetValueEncoding(""))
+	assert.NoError(t, err)
+	assert.False(t, IsEnveloped(legacy))
+	assert.False(t, IsCurrentEncoding(legacy))
+
+	decoded := &value{}
+	assert.NoError(t, Deserialize(legacy, decoded))
+	assert.Equal(t, &value{Name: "channel", Count: 3}, decoded)
+
+	assert.Error(t, SetValueEncoding("xml"))
+}
+
 func TestParsePrivateKey(t *testing.T) {
 	validKey, err := crypto.GenerateKey()
 	assert.NoError(t, err)